@@ -22,16 +22,37 @@ import (
 const PgbackupResourcePlural = "pgbackups"
 
 type PgbackupSpec struct {
-	Name          string        `json:"name"`
-	StorageSpec   PgStorageSpec `json:"storagespec"`
-	CCP_IMAGE_TAG string        `json:"ccpimagetag"`
-	BACKUP_HOST   string        `json:"backuphost"`
-	BACKUP_USER   string        `json:"backupuser"`
-	BACKUP_PASS   string        `json:"backuppass"`
-	BACKUP_PORT   string        `json:"backupport"`
-	BACKUP_STATUS string        `json:"backupstatus"`
+	Name              string              `json:"name"`
+	StorageSpec       PgStorageSpec       `json:"storagespec"`
+	BACKUP_TARGET     string              `json:"backuptarget"`
+	ObjectStorage     PgObjectStorageSpec `json:"objectstorage"`
+	RETENTION_DAYS    string              `json:"retentiondays"`
+	CCP_IMAGE_TAG     string              `json:"ccpimagetag"`
+	BACKUP_HOST       string              `json:"backuphost"`
+	BACKUP_USER       string              `json:"backupuser"`
+	BACKUP_SECRET     string              `json:"backupsecret"`
+	BACKUP_PORT       string              `json:"backupport"`
+	BACKUP_STATUS     string              `json:"backupstatus"`
+	BACKUP_PATH       string              `json:"backuppath"`
+	COMPRESSION       string              `json:"compression"`
+	COMPRESSION_LEVEL string              `json:"compressionlevel"`
+	ENCRYPTION_SECRET string              `json:"encryptionsecret"`
+	BACKUP_SIZE       string              `json:"backupsize"`
+	STORED_SIZE       string              `json:"storedsize"`
+	COMPRESSION_RATIO string              `json:"compressionratio"`
+	VERIFY            bool                `json:"verify"`
+	VerifyAsserts     []string            `json:"verifyasserts"`
+	VERIFY_TIMEOUT    string              `json:"verifytimeout"`
+	VERIFY_STATUS     string              `json:"verifystatus"`
+	VERIFY_MESSAGE    string              `json:"verifymessage"`
+	VERIFY_DATE       string              `json:"verifydate"`
+	VERIFY_TIME       string              `json:"verifytime"`
 }
 
+const BACKUP_VERIFY_RUNNING = "running"
+const BACKUP_VERIFY_PASSED = "passed"
+const BACKUP_VERIFY_FAILED = "failed"
+
 type Pgbackup struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata"`
@@ -31,32 +31,43 @@ type Pgcluster struct {
 //anonymous struct field usage somecluster.metav1.ObjectMeta = foo
 
 type PgclusterSpec struct {
-	Name                 string            `json:"name"`
-	ClusterName          string            `json:"clustername"`
-	Policies             string            `json:"policies"`
-	CCP_IMAGE_TAG        string            `json:"ccpimagetag"`
-	Port                 string            `json:"port"`
-	NodeName             string            `json:"nodename"`
-	MasterStorage        PgStorageSpec     `json:masterstorage`
-	ReplicaStorage       PgStorageSpec     `json:replicastorage`
-	PG_MASTER_HOST       string            `json:"pgmasterhost"`
-	PG_MASTER_USER       string            `json:"pgmasteruser"`
-	PG_MASTER_PASSWORD   string            `json:"pgmasterpassword"`
-	PG_USER              string            `json:"pguser"`
-	PG_PASSWORD          string            `json:"pgpassword"`
-	PG_DATABASE          string            `json:"pgdatabase"`
-	PG_ROOT_PASSWORD     string            `json:"pgrootpassword"`
-	REPLICAS             string            `json:"replicas"`
-	STRATEGY             string            `json:"strategy"`
-	SECRET_FROM          string            `json:"secretfrom"`
-	BACKUP_PVC_NAME      string            `json:"backuppvcname"`
-	BACKUP_PATH          string            `json:"backuppath"`
-	PGUSER_SECRET_NAME   string            `json:"pgusersecretname"`
-	PGROOT_SECRET_NAME   string            `json:"pgrootsecretname"`
-	PGMASTER_SECRET_NAME string            `json:"pgmastersecretname"`
-	STATUS               string            `json:"status"`
-	PSW_LAST_UPDATE      string            `json:"pswlastupdate"`
-	UserLabels           map[string]string `json:"userlabels"`
+	Name                     string                 `json:"name"`
+	ClusterName              string                 `json:"clustername"`
+	Policies                 string                 `json:"policies"`
+	CCP_IMAGE_TAG            string                 `json:"ccpimagetag"`
+	Port                     string                 `json:"port"`
+	NodeName                 string                 `json:"nodename"`
+	MasterStorage            PgStorageSpec          `json:masterstorage`
+	ReplicaStorage           PgStorageSpec          `json:replicastorage`
+	PG_MASTER_HOST           string                 `json:"pgmasterhost"`
+	PG_MASTER_USER           string                 `json:"pgmasteruser"`
+	PG_MASTER_PASSWORD       string                 `json:"pgmasterpassword"`
+	PG_USER                  string                 `json:"pguser"`
+	PG_PASSWORD              string                 `json:"pgpassword"`
+	PG_DATABASE              string                 `json:"pgdatabase"`
+	PG_ROOT_PASSWORD         string                 `json:"pgrootpassword"`
+	REPLICAS                 string                 `json:"replicas"`
+	STRATEGY                 string                 `json:"strategy"`
+	SECRET_FROM              string                 `json:"secretfrom"`
+	BACKUP_PVC_NAME          string                 `json:"backuppvcname"`
+	BACKUP_TARGET            string                 `json:"backuptarget"`
+	BackupObjectStorage      PgObjectStorageSpec    `json:"backupobjectstorage"`
+	BACKUP_COMPRESSION       string                 `json:"backupcompression"`
+	BACKUP_ENCRYPTION_SECRET string                 `json:"backupencryptionsecret"`
+	Archive                  PgArchiveSpec          `json:"archive"`
+	RESTORE_FROM             string                 `json:"restorefrom"`
+	PITR_TARGET              string                 `json:"pitrtarget"`
+	RecoveryArchive          PgArchiveSpec          `json:"recoveryarchive"`
+	BACKUP_PATH              string                 `json:"backuppath"`
+	PGUSER_SECRET_NAME       string                 `json:"pgusersecretname"`
+	PGROOT_SECRET_NAME       string                 `json:"pgrootsecretname"`
+	PGMASTER_SECRET_NAME     string                 `json:"pgmastersecretname"`
+	STATUS                   string                 `json:"status"`
+	PSW_LAST_UPDATE          string                 `json:"pswlastupdate"`
+	PasswordRotation         PgPasswordRotationSpec `json:"passwordrotation"`
+	PasswordPolicy           PgPasswordPolicySpec   `json:"passwordpolicy"`
+	Auth                     PgAuthSpec             `json:"auth"`
+	UserLabels               map[string]string      `json:"userlabels"`
 }
 
 type PgclusterList struct {
@@ -67,8 +78,26 @@ type PgclusterList struct {
 }
 
 type PgclusterStatus struct {
-	State   PgclusterState `json:"state,omitempty"`
-	Message string         `json:"message,omitempty"`
+	State     PgclusterState       `json:"state,omitempty"`
+	Message   string               `json:"message,omitempty"`
+	Archive   *PgArchiveStatus     `json:"archive,omitempty"`
+	Rotations []PgPasswordRotation `json:"rotations,omitempty"`
+	Auth      *PgAuthStatus        `json:"auth,omitempty"`
+	Bindings  *PgBindingStatus     `json:"bindings,omitempty"`
+	Roles     *PgRolesStatus       `json:"roles,omitempty"`
+}
+
+// PgArchiveStatus is the last pg_stat_archiver reading of the
+// cluster master
+type PgArchiveStatus struct {
+	ArchivedCount    int64  `json:"archivedcount"`
+	LastArchivedWal  string `json:"lastarchivedwal"`
+	LastArchivedTime string `json:"lastarchivedtime"`
+	FailedCount      int64  `json:"failedcount"`
+	LastFailedWal    string `json:"lastfailedwal"`
+	LastFailedTime   string `json:"lastfailedtime"`
+	CheckTime        string `json:"checktime"`
+	Message          string `json:"message"`
 }
 
 type PgclusterState string
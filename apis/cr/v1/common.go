@@ -11,6 +11,73 @@ const STORAGE_CREATE = "create"
 const STORAGE_EMPTYDIR = "emptydir"
 const STORAGE_DYNAMIC = "dynamic"
 
+const BACKUP_TARGET_PVC = "pvc"
+const BACKUP_TARGET_S3 = "s3"
+
+const S3_ACCESS_KEY_ID = "aws-access-key-id"
+const S3_SECRET_ACCESS_KEY = "aws-secret-access-key"
+
+const COMPRESSION_NONE = "none"
+const COMPRESSION_GZIP = "gzip"
+const COMPRESSION_ZSTD = "zstd"
+
+// key of the backup encryption passphrase in its Secret
+const BACKUP_ENCRYPTION_KEY = "encryption-key"
+
+// label naming the secret a deployment reads credentials from, such a
+// deployment is restarted when the password in the secret is rotated
+const SECRET_CONSUMER_LABEL = "pg-secret-consumer"
+
+// label of a pgcluster whose secrets are kept when it is deleted, set
+// by an in-place restore so the restored cluster reuses them
+const KEEP_SECRETS_LABEL = "pg-keep-secrets"
+
+// how long a rotated password is valid and how close to expiry a
+// password is rotated when the rotation settings do not say
+const ROTATION_DEFAULT_PERIOD_DAYS = 30
+const ROTATION_DEFAULT_WINDOW_DAYS = 7
+
+// outcome of a password rotation
+const ROTATION_SUCCEEDED = "succeeded"
+const ROTATION_FAILED = "failed"
+
+// character classes a password policy can require
+const PASSWORD_CLASS_LOWER = "lower"
+const PASSWORD_CLASS_UPPER = "upper"
+const PASSWORD_CLASS_DIGIT = "digit"
+const PASSWORD_CLASS_SYMBOL = "symbol"
+
+// length of generated passwords and the shortest password accepted
+// when the password policy does not say
+const PASSWORD_DEFAULT_LENGTH = 16
+const PASSWORD_DEFAULT_MIN_LENGTH = 12
+
+// suffix of the ConfigMap holding the pg_hba.conf of a cluster, it is
+// mounted at /pgconf in the database containers
+const PGCONF_CONFIGMAP_SUFFIX = "-pgconf"
+
+// outcome of applying the authentication settings of a cluster
+const AUTH_APPLIED = "applied"
+const AUTH_FAILED = "failed"
+
+// suffix of the service binding secret of a role of a cluster, named
+// <cluster>-<role>-binding, and the label naming its cluster, binding
+// secrets are not labelled pg-database so they are not taken for the
+// credentials the operator manages
+const BINDING_SECRET_SUFFIX = "-binding"
+const BINDING_LABEL = "pg-binding"
+
+// type of the binding secrets following the service binding
+// specification and the provider entry they carry
+const BINDING_SECRET_TYPE = "servicebinding.io/postgresql"
+const BINDING_TYPE = "postgresql"
+const BINDING_PROVIDER = "crunchydata"
+
+// outcome of refreshing the binding secrets of a cluster, they are
+// skipped with the vault secret store
+const BINDING_APPLIED = "applied"
+const BINDING_SKIPPED = "skipped"
+
 type PgStorageSpec struct {
 	PvcName             string `json:"pvcname"`
 	StorageClass        string `json:"storageclass"`
@@ -20,3 +87,97 @@ type PgStorageSpec struct {
 	FSGROUP             string `json:"fsgroup"`
 	SUPPLEMENTAL_GROUPS string `json:"supplementalgroups"`
 }
+
+// PgObjectStorageSpec describes an S3-compatible bucket used as a
+// backup destination, credentials are read from the named Secret
+// using the S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY keys
+type PgObjectStorageSpec struct {
+	Endpoint   string `json:"endpoint"`
+	Bucket     string `json:"bucket"`
+	Prefix     string `json:"prefix"`
+	Region     string `json:"region"`
+	SecretName string `json:"secretname"`
+	Insecure   bool   `json:"insecure"`
+}
+
+// PgPasswordRotationSpec asks the operator to rotate passwords that
+// expire within WindowDays, a rotated password is valid for PeriodDays
+// and when RestartConsumers is set the deployments labelled with the
+// SECRET_CONSUMER_LABEL of the rotated secret are restarted
+type PgPasswordRotationSpec struct {
+	Enabled          bool `json:"enabled"`
+	PeriodDays       int  `json:"perioddays"`
+	WindowDays       int  `json:"windowdays"`
+	RestartConsumers bool `json:"restartconsumers"`
+}
+
+// PgPasswordRotation records the rotation of the password of a role
+type PgPasswordRotation struct {
+	Role       string   `json:"role"`
+	SecretName string   `json:"secretname"`
+	Date       string   `json:"date"`
+	ValidUntil string   `json:"validuntil"`
+	Status     string   `json:"status"`
+	Message    string   `json:"message"`
+	Restarted  []string `json:"restarted"`
+}
+
+// PgPasswordPolicySpec describes the passwords of a cluster, generated
+// passwords are Length characters long and hold a character of each of
+// Classes, a password shorter than MinLength is refused, characters that
+// are easily mistaken for one another are left out unless AllowAmbiguous
+// is set, unset fields take the PASSWORD_DEFAULT values and the lower,
+// upper and digit classes
+type PgPasswordPolicySpec struct {
+	Length         int      `json:"length"`
+	MinLength      int      `json:"minlength"`
+	Classes        []string `json:"classes"`
+	AllowAmbiguous bool     `json:"allowambiguous"`
+}
+
+// PgAuthSpec describes how a cluster authenticates clients,
+// PasswordEncryption is md5 (the default) or scram-sha-256 and
+// HbaRules are the pg_hba.conf lines allowing clients in, after the
+// lines the operator needs for itself
+type PgAuthSpec struct {
+	PasswordEncryption string      `json:"passwordencryption"`
+	HbaRules           []PgHbaRule `json:"hbarules"`
+}
+
+// PgHbaRule is a pg_hba.conf line, Type is local, host, hostssl or
+// hostnossl, Address a CIDR and is left empty for local connections
+type PgHbaRule struct {
+	Type     string `json:"type"`
+	Database string `json:"database"`
+	User     string `json:"user"`
+	Address  string `json:"address"`
+	Method   string `json:"method"`
+}
+
+// PgAuthStatus records the authentication settings last applied to
+// the pods of a cluster, Hash identifies the settings applied
+type PgAuthStatus struct {
+	PasswordEncryption string `json:"passwordencryption"`
+	Hash               string `json:"hash"`
+	State              string `json:"state"`
+	Message            string `json:"message"`
+	UpdateDate         string `json:"updatedate"`
+}
+
+// PgBindingStatus records whether the binding secrets of a cluster
+// are written, Message says why they are not
+type PgBindingStatus struct {
+	State      string `json:"state"`
+	Message    string `json:"message"`
+	UpdateDate string `json:"updatedate"`
+}
+
+// PgArchiveSpec configures continuous WAL archiving for a cluster,
+// Target is BACKUP_TARGET_PVC or BACKUP_TARGET_S3
+type PgArchiveSpec struct {
+	Enabled       bool                `json:"enabled"`
+	Target        string              `json:"target"`
+	Timeout       string              `json:"timeout"`
+	Storage       PgStorageSpec       `json:"storage"`
+	ObjectStorage PgObjectStorageSpec `json:"objectstorage"`
+}
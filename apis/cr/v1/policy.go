@@ -21,11 +21,81 @@ import (
 
 const PgpolicyResourcePlural = "pgpolicies"
 
+// PgpolicySpec is the current version of a policy, the SQL of each
+// version moves a cluster on from the version before it and DownSql
+// moves it back, the versions it replaced are kept in PreviousVersions
+// oldest first so clusters on an older version can be upgraded,
+// VerifySql is a query returning true while the policy is in effect
+// and DependsOn names the policies that must be applied before it,
+// Parameters are the variables the SQL of every version may use,
+// RemoveSql takes the policy off a cluster it was applied to, Scope
+// names the database pods of a cluster the policy runs on,
+// the SQL of a policy defined by a Url is fetched once when the
+// version is created, using the optional UrlSecret, and stored in Sql
 type PgpolicySpec struct {
-	Name   string `json:"name"`
-	Url    string `json:"url"`
-	Sql    string `json:"sql"`
-	Status string `json:"status"`
+	Name             string              `json:"name"`
+	Url              string              `json:"url"`
+	UrlSecret        string              `json:"urlsecret"`
+	FetchDate        string              `json:"fetchdate"`
+	Sql              string              `json:"sql"`
+	Status           string              `json:"status"`
+	Version          int                 `json:"version"`
+	Checksum         string              `json:"checksum"`
+	DownSql          string              `json:"downsql"`
+	RemoveSql        string              `json:"removesql"`
+	VerifySql        string              `json:"verifysql"`
+	PreviousVersions []PgpolicyVersion   `json:"previousversions"`
+	DependsOn        []string            `json:"dependson"`
+	Parameters       []PgpolicyParameter `json:"parameters"`
+	Scope            string              `json:"scope"`
+}
+
+// scopes of a policy, master runs it on the master through the cluster
+// service, replicas on each running replica pod and all on the master
+// and each replica, the SQL of a policy run on replicas must only hold
+// statements a hot standby allows
+const POLICY_SCOPE_MASTER = "master"
+const POLICY_SCOPE_REPLICAS = "replicas"
+const POLICY_SCOPE_ALL = "all"
+
+// keys of the secret named by a policy UrlSecret, the authorization
+// key is sent as the Authorization header, username and password as
+// basic auth, every key starting with header. as the header it names
+// and ca.crt is the CA bundle the server certificate is checked with
+const POLICY_URL_AUTHORIZATION = "authorization"
+const POLICY_URL_USERNAME = "username"
+const POLICY_URL_PASSWORD = "password"
+const POLICY_URL_HEADER_PREFIX = "header."
+const POLICY_URL_CA = "ca.crt"
+
+// kinds of policy parameter, the kind decides how a value is quoted
+// when the policy SQL is rendered
+const POLICY_PARAM_IDENTIFIER = "identifier"
+const POLICY_PARAM_LITERAL = "literal"
+const POLICY_PARAM_NUMBER = "number"
+
+// PgpolicyParameter is a variable the policy SQL uses as {{.owner}}
+// for a parameter named owner, its value is the one given when the
+// policy is applied,
+// else the one taken From a cluster label (label:key) or pgcluster
+// field (cluster:PG_DATABASE), else the Default
+type PgpolicyParameter struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	From    string `json:"from"`
+	Default string `json:"default"`
+}
+
+// PgpolicyVersion is a version of a policy that has been replaced
+type PgpolicyVersion struct {
+	Version   int    `json:"version"`
+	Url       string `json:"url"`
+	FetchDate string `json:"fetchdate"`
+	Sql       string `json:"sql"`
+	Checksum  string `json:"checksum"`
+	DownSql   string `json:"downsql"`
+	RemoveSql string `json:"removesql"`
+	VerifySql string `json:"verifysql"`
 }
 
 type Pgpolicy struct {
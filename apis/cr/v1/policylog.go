@@ -21,12 +21,119 @@ import (
 
 const PgpolicylogResourcePlural = "pgpolicylogs"
 
+// status of a pgpolicylog whose policy SQL returned an error
+const POLICY_FAILED_STATUS = "failed"
+
+// suffix of the pgpolicylogs created for a dry run
+const POLICYLOG_DRYRUN_SUFFIX = "-dryrun"
+
+// what a pgpolicylog asks of the operator, apply runs every version
+// of a policy, upgrade runs the versions after FromVersion, revert
+// runs the down SQL of FromVersion and remove runs the removal SQL of
+// FromVersion and takes the policy label off the cluster
+const POLICY_ACTION_APPLY = "apply"
+const POLICY_ACTION_UPGRADE = "upgrade"
+const POLICY_ACTION_REVERT = "revert"
+const POLICY_ACTION_REMOVE = "remove"
+
+// compliance of a cluster with an applied policy, unknown when the
+// verification query could not be run
+const POLICY_COMPLIANT = "compliant"
+const POLICY_DRIFTED = "drifted"
+const POLICY_COMPLIANCE_UNKNOWN = "unknown"
+
+// where the request to run a policy came from
+const POLICY_REQUEST_PGO = "pgo"
+const POLICY_REQUEST_APISERVER = "apiserver"
+const POLICY_REQUEST_OPERATOR = "operator"
+
+// PgpolicylogSpec requests that a policy is applied to a cluster,
+// DryRun runs the policy in a transaction that is rolled back and
+// Atomic runs it in a single transaction, Version and Checksum record
+// the policy version the cluster is at once the policy has run,
+// Parameters are the values given for the policy parameters and
+// RenderedParameters the quoted values the SQL was rendered with,
+// Username asked for the run from RequestedFrom at RequestDate and
+// it ran on TargetPod at ApplyDate for Duration, Instances holds the
+// outcome on each pod when the policy scope includes replicas, the
+// earlier runs of the policy on the cluster are kept in History oldest
+// first, DryRunBatch names the policies dry run before this one in the
+// same request, which count as applied, or removed, when its
+// dependencies are checked
 type PgpolicylogSpec struct {
-	PolicyName  string `json:"policyname"`
-	Status      string `json:"status"`
-	ApplyDate   string `json:"applydate"`
-	ClusterName string `json:"clustername"`
-	Username    string `json:"username"`
+	PolicyName         string             `json:"policyname"`
+	Status             string             `json:"status"`
+	ApplyDate          string             `json:"applydate"`
+	ClusterName        string             `json:"clustername"`
+	Username           string             `json:"username"`
+	DryRun             bool               `json:"dryrun"`
+	Atomic             bool               `json:"atomic"`
+	Action             string             `json:"action"`
+	FromVersion        int                `json:"fromversion"`
+	Version            int                `json:"version"`
+	Checksum           string             `json:"checksum"`
+	Result             PgpolicyResult     `json:"result"`
+	Compliance         PgpolicyCompliance `json:"compliance"`
+	Parameters         map[string]string  `json:"parameters"`
+	RenderedParameters map[string]string  `json:"renderedparameters"`
+	RequestedFrom      string             `json:"requestedfrom"`
+	RequestDate        string             `json:"requestdate"`
+	Duration           string             `json:"duration"`
+	TargetPod          string             `json:"targetpod"`
+	Instances          []PgpolicyInstance `json:"instances"`
+	History            []PgpolicylogEntry `json:"history"`
+	DryRunBatch        []string           `json:"dryrunbatch"`
+}
+
+// roles of the database pod a policy ran on
+const POLICY_ROLE_MASTER = "master"
+const POLICY_ROLE_REPLICA = "replica"
+
+// PgpolicyInstance is the outcome of a policy on one database pod
+type PgpolicyInstance struct {
+	Pod       string         `json:"pod"`
+	Role      string         `json:"role"`
+	Status    string         `json:"status"`
+	ApplyDate string         `json:"applydate"`
+	Result    PgpolicyResult `json:"result"`
+}
+
+// PgpolicylogEntry is an earlier run of a policy on a cluster
+type PgpolicylogEntry struct {
+	Action             string             `json:"action"`
+	Status             string             `json:"status"`
+	Username           string             `json:"username"`
+	RequestedFrom      string             `json:"requestedfrom"`
+	RequestDate        string             `json:"requestdate"`
+	ApplyDate          string             `json:"applydate"`
+	Duration           string             `json:"duration"`
+	TargetPod          string             `json:"targetpod"`
+	Instances          []PgpolicyInstance `json:"instances"`
+	Atomic             bool               `json:"atomic"`
+	FromVersion        int                `json:"fromversion"`
+	Version            int                `json:"version"`
+	Checksum           string             `json:"checksum"`
+	RenderedParameters map[string]string  `json:"renderedparameters"`
+	Result             PgpolicyResult     `json:"result"`
+}
+
+// PgpolicyCompliance is the outcome of the last run of the policy
+// verification query on the cluster
+type PgpolicyCompliance struct {
+	State     string `json:"state"`
+	CheckTime string `json:"checktime"`
+	Message   string `json:"message"`
+}
+
+// PgpolicyResult is the outcome of running the policy SQL
+type PgpolicyResult struct {
+	Statements   int      `json:"statements"`
+	RowsAffected int64    `json:"rowsaffected"`
+	Notices      []string `json:"notices"`
+	RolledBack   bool     `json:"rolledback"`
+	Error        string   `json:"error"`
+	SqlState     string   `json:"sqlstate"`
+	Position     string   `json:"position"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -53,6 +53,12 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&PgpolicyList{},
 		&Pgpolicylog{},
 		&PgpolicylogList{},
+		&Pgrestore{},
+		&PgrestoreList{},
+		&Pguser{},
+		&PguserList{},
+		&Pgsecretlog{},
+		&PgsecretlogList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
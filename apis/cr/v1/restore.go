@@ -0,0 +1,94 @@
+/*
+Copyright 2017 Crunchy Data Solutions, Inc.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const PgrestoreResourcePlural = "pgrestores"
+
+// the phases a restore moves through, recorded in the pgrestore status
+const RESTORE_PHASE_PENDING = "pending"
+const RESTORE_PHASE_VALIDATING = "validating"
+const RESTORE_PHASE_STOPPING = "stopping"
+const RESTORE_PHASE_PROVISIONING = "provisioning"
+const RESTORE_PHASE_RESTORING = "restoring"
+const RESTORE_PHASE_COMPLETED = "completed"
+const RESTORE_PHASE_FAILED = "failed"
+
+// PgrestoreSpec names the backup to restore, either a pgbackup record
+// or a backup path on a PVC or in object storage, optionally recovered
+// to a point in time, and the cluster it is restored into
+type PgrestoreSpec struct {
+	Name              string              `json:"name"`
+	BackupName        string              `json:"backupname"`
+	BACKUP_TARGET     string              `json:"backuptarget"`
+	BACKUP_PVC_NAME   string              `json:"backuppvcname"`
+	BACKUP_PATH       string              `json:"backuppath"`
+	ObjectStorage     PgObjectStorageSpec `json:"objectstorage"`
+	COMPRESSION       string              `json:"compression"`
+	ENCRYPTION_SECRET string              `json:"encryptionsecret"`
+	RESTORE_FROM      string              `json:"restorefrom"`
+	PITR_TARGET       string              `json:"pitrtarget"`
+	SECRET_FROM       string              `json:"secretfrom"`
+	TargetCluster     string              `json:"targetcluster"`
+	InPlace           bool                `json:"inplace"`
+	Confirm           string              `json:"confirm"`
+	ClusterSpec       PgclusterSpec       `json:"clusterspec"`
+	Username          string              `json:"username"`
+	RequestDate       string              `json:"requestdate"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Pgrestore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   PgrestoreSpec   `json:"spec"`
+	Status PgrestoreStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type PgrestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Pgrestore `json:"items"`
+}
+
+type PgrestoreStatus struct {
+	State          PgrestoreState   `json:"state,omitempty"`
+	Message        string           `json:"message,omitempty"`
+	Phase          string           `json:"phase,omitempty"`
+	StartTime      string           `json:"starttime,omitempty"`
+	CompletionTime string           `json:"completiontime,omitempty"`
+	History        []PgrestorePhase `json:"history,omitempty"`
+}
+
+// PgrestorePhase records when a restore entered a phase
+type PgrestorePhase struct {
+	Phase   string `json:"phase"`
+	Time    string `json:"time"`
+	Message string `json:"message"`
+}
+
+type PgrestoreState string
+
+const (
+	PgrestoreStateCreated   PgrestoreState = "Created"
+	PgrestoreStateProcessed PgrestoreState = "Processed"
+)
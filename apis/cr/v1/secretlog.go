@@ -0,0 +1,78 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const PgsecretlogResourcePlural = "pgsecretlogs"
+
+// where the request to reveal database passwords came from
+const SECRET_REQUEST_PGO = "pgo"
+const SECRET_REQUEST_APISERVER = "apiserver"
+
+// where revealed passwords were written, the terminal of the user or
+// a file given with --secrets-file
+const SECRET_OUTPUT_TERMINAL = "terminal"
+const SECRET_OUTPUT_FILE = "file"
+
+// how revealed credentials were written, a username and password or a
+// postgresql:// connection string
+const SECRET_FORMAT_PLAIN = "plain"
+const SECRET_FORMAT_URI = "uri"
+
+// PgsecretlogSpec is the audit record of database passwords being
+// revealed, Username asked for the passwords of the Secrets of
+// ClusterName from RequestedFrom at RequestDate and had them written
+// to Output in Format
+type PgsecretlogSpec struct {
+	ClusterName   string   `json:"clustername"`
+	Secrets       []string `json:"secrets"`
+	Username      string   `json:"username"`
+	RequestedFrom string   `json:"requestedfrom"`
+	Output        string   `json:"output"`
+	Format        string   `json:"format"`
+	RequestDate   string   `json:"requestdate"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Pgsecretlog struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   PgsecretlogSpec   `json:"spec"`
+	Status PgsecretlogStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type PgsecretlogList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Pgsecretlog `json:"items"`
+}
+
+type PgsecretlogStatus struct {
+	State   PgsecretlogState `json:"state,omitempty"`
+	Message string           `json:"message,omitempty"`
+}
+
+type PgsecretlogState string
+
+const (
+	PgsecretlogStateCreated PgsecretlogState = "Created"
+)
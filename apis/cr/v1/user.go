@@ -0,0 +1,180 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const PguserResourcePlural = "pgusers"
+
+// suffix of the secret holding the password of a pguser on a cluster,
+// the secret is named <cluster>-<user>-secret
+const PGUSER_USER_SECRET_SUFFIX = "-secret"
+
+// the privileges granted on the databases of a pguser when none are
+// given, and the database access is granted on when none is given
+const PGUSER_DEFAULT_PRIVILEGE = "ALL"
+const PGUSER_DEFAULT_DATABASE = "userdb"
+
+// role templates granting a pguser read only or read write access to
+// the tables and sequences of the schemas of its databases
+const PGUSER_TEMPLATE_READONLY = "readonly"
+const PGUSER_TEMPLATE_READWRITE = "readwrite"
+
+// the schema a template applies to when a pguser names none
+const PGUSER_DEFAULT_SCHEMA = "public"
+
+// state of a pguser role on one of its clusters
+const PGUSER_STATE_READY = "ready"
+const PGUSER_STATE_FAILED = "failed"
+
+// PguserSpec describes a database role the operator maintains on each
+// of Clusters, the role can log in to Databases with Privileges, its
+// password is generated following the password policy of each cluster,
+// with PasswordLength characters when it is longer than the policy
+// minimum, and is valid for ValidDays from PasswordDate, 0 meaning it does not expire, a
+// new password is generated when PasswordDate changes, Rotation when
+// enabled replaces the password rotation settings of the clusters
+//
+// A Group role can not log in and has no password, other roles share
+// its privileges by naming it in MemberOf. Template grants read only or
+// read write access to Schemas of each of Databases, Grants add
+// privileges on schemas, tables, sequences and functions, the default
+// privileges they give on objects created later are those of objects
+// created by DefaultOwner, the postgres user when it is empty
+//
+// ConnectionLimit is how many connections the role can open at once, 0
+// meaning no limit, Settings are server settings such as
+// statement_timeout, work_mem or search_path the role gets each time
+// it logs in
+type PguserSpec struct {
+	Name            string                 `json:"name"`
+	Clusters        []string               `json:"clusters"`
+	Databases       []string               `json:"databases"`
+	Privileges      []string               `json:"privileges"`
+	ValidDays       int                    `json:"validdays"`
+	PasswordLength  int                    `json:"passwordlength"`
+	PasswordDate    string                 `json:"passworddate"`
+	Rotation        PgPasswordRotationSpec `json:"rotation"`
+	Group           bool                   `json:"group"`
+	MemberOf        []string               `json:"memberof"`
+	Template        string                 `json:"template"`
+	Schemas         []string               `json:"schemas"`
+	Grants          []PgGrantSpec          `json:"grants"`
+	DefaultOwner    string                 `json:"defaultowner"`
+	ConnectionLimit int                    `json:"connectionlimit"`
+	Settings        map[string]string      `json:"settings"`
+}
+
+// PgGrantSpec grants Privileges on Schema of Database, On is the
+// schema itself or its tables, sequences or functions, Objects names
+// them and is empty for every one of them in the schema, Default also
+// grants the privileges on those later created in the schema, an
+// empty Database is each of the databases of the pguser
+type PgGrantSpec struct {
+	Database   string   `json:"database"`
+	Schema     string   `json:"schema"`
+	On         string   `json:"on"`
+	Objects    []string `json:"objects"`
+	Privileges []string `json:"privileges"`
+	Default    bool     `json:"default"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type Pguser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata"`
+
+	Spec   PguserSpec   `json:"spec"`
+	Status PguserStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+type PguserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Pguser `json:"items"`
+}
+
+type PguserStatus struct {
+	State    PguserState           `json:"state,omitempty"`
+	Message  string                `json:"message,omitempty"`
+	Clusters []PguserClusterStatus `json:"clusters,omitempty"`
+}
+
+// PguserClusterStatus records the role on one cluster, the password
+// date it was last set from, when it expires, the databases it was
+// granted access to and the secret holding its password, along with
+// the group roles and grants last applied so those the pguser no
+// longer names are revoked, DefaultOwner is the owner the default
+// privileges of those grants were given for, Settings are the server
+// settings last set on the role so those removed are reset
+type PguserClusterStatus struct {
+	Cluster      string            `json:"cluster"`
+	State        string            `json:"state"`
+	Message      string            `json:"message"`
+	SecretName   string            `json:"secretname"`
+	PasswordDate string            `json:"passworddate"`
+	ValidUntil   string            `json:"validuntil"`
+	Databases    []string          `json:"databases"`
+	MemberOf     []string          `json:"memberof"`
+	Grants       []PgGrantSpec     `json:"grants"`
+	DefaultOwner string            `json:"defaultowner"`
+	Settings     map[string]string `json:"settings"`
+	UpdateDate   string            `json:"updatedate"`
+}
+
+// PgRolesStatus is the last reading of the roles of a cluster from
+// its catalogs, whether or not they are pgusers, Message says why they
+// could not be read
+type PgRolesStatus struct {
+	Roles     []PgRoleGrants `json:"roles"`
+	CheckTime string         `json:"checktime"`
+	Message   string         `json:"message"`
+}
+
+// PgRoleGrants is a role of a cluster as its catalogs describe it, the
+// group roles it is a member of and the privileges granted to it, a
+// connection limit of -1 is no limit
+type PgRoleGrants struct {
+	Name            string               `json:"name"`
+	Login           bool                 `json:"login"`
+	Superuser       bool                 `json:"superuser"`
+	ValidUntil      string               `json:"validuntil"`
+	ConnectionLimit int                  `json:"connectionlimit"`
+	Settings        string               `json:"settings"`
+	MemberOf        []string             `json:"memberof"`
+	Privileges      []PgCatalogPrivilege `json:"privileges"`
+}
+
+// PgCatalogPrivilege is what a role was granted on an object of a
+// database, Kind is database, schema, table, sequence, function or
+// default followed by the kind of object default privileges apply to
+type PgCatalogPrivilege struct {
+	Database   string `json:"database"`
+	Kind       string `json:"kind"`
+	Object     string `json:"object"`
+	Privileges string `json:"privileges"`
+}
+
+type PguserState string
+
+const (
+	PguserStateCreated   PguserState = "Created"
+	PguserStateProcessed PguserState = "Processed"
+)
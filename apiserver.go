@@ -6,6 +6,7 @@ import (
 	"github.com/crunchydata/kraken/apiserver/cloneservice"
 	"github.com/crunchydata/kraken/apiserver/clusterservice"
 	"github.com/crunchydata/kraken/apiserver/policyservice"
+	"github.com/crunchydata/kraken/apiserver/restoreservice"
 	"github.com/crunchydata/kraken/apiserver/upgradeservice"
 	"github.com/gorilla/mux"
 	"net/http"
@@ -27,6 +28,9 @@ func main() {
 	r.HandleFunc("/clusters/{name}", clusterservice.ShowClusterHandler).Methods("GET", "DELETE")
 	r.HandleFunc("/clusters/test/{name}", clusterservice.TestClusterHandler)
 	r.HandleFunc("/clusters/scale/{name}", clusterservice.ScaleClusterHandler)
+	r.HandleFunc("/clusters/secrets/{name}", clusterservice.ShowSecretsHandler).Methods("GET")
 	r.HandleFunc("/backups/{name}", backupservice.ShowBackupHandler).Methods("GET", "DELETE")
+	r.HandleFunc("/restores", restoreservice.CreateRestoreHandler)
+	r.HandleFunc("/restores/{name}", restoreservice.ShowRestoreHandler).Methods("GET", "DELETE")
 	log.Fatal(http.ListenAndServe(":8080", r))
 }
@@ -0,0 +1,116 @@
+package clusterservice
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	msgs "github.com/crunchydata/kraken/apiservermsgs"
+	"github.com/crunchydata/kraken/util"
+	"k8s.io/client-go/kubernetes"
+	authnv1 "k8s.io/client-go/pkg/apis/authentication/v1"
+	"k8s.io/client-go/rest"
+)
+
+// AccessDeniedError is returned when the user of a request may not get
+// the secrets whose passwords it asks for
+type AccessDeniedError struct {
+	Message string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return e.Message
+}
+
+// ShowSecrets returns the credentials of a cluster with the passwords
+// redacted unless reveal is set, user is then the authenticated user of
+// the request, it must be allowed to get the secrets of the namespace
+// and the reveal is recorded in a pgsecretlog before any password is
+// returned
+func ShowSecrets(clientset *kubernetes.Clientset, restclient *rest.RESTClient, user *authnv1.UserInfo, namespace, name string, reveal bool, format, output string) ([]msgs.SecretDetail, error) {
+	if format == "" {
+		format = crv1.SECRET_FORMAT_PLAIN
+	}
+	if format != crv1.SECRET_FORMAT_PLAIN && format != crv1.SECRET_FORMAT_URI {
+		return nil, errors.New("invalid format " + format + ", must be " + crv1.SECRET_FORMAT_PLAIN + " or " + crv1.SECRET_FORMAT_URI)
+	}
+	if output == "" {
+		output = crv1.SECRET_OUTPUT_TERMINAL
+	}
+	if output != crv1.SECRET_OUTPUT_TERMINAL && output != crv1.SECRET_OUTPUT_FILE {
+		return nil, errors.New("invalid output " + output + ", must be " + crv1.SECRET_OUTPUT_TERMINAL + " or " + crv1.SECRET_OUTPUT_FILE)
+	}
+
+	cluster := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	config := util.GetSecretStoreConfig()
+	store, err := util.NewSecretStore(clientset, config, namespace)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := store.List(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if reveal && len(credentials) > 0 {
+		if user == nil || user.Username == "" {
+			return nil, &AccessDeniedError{Message: "revealing passwords requires an authenticated user"}
+		}
+		username := user.Username
+		allowed, reason, err := util.CanRevealSecrets(clientset, username, user.Groups, namespace)
+		if err != nil {
+			return nil, errors.New("could not check access to the secrets " + err.Error())
+		}
+		if !allowed {
+			message := username + " is not allowed to get the secrets of namespace " + namespace
+			if reason != "" {
+				message = message + ", " + reason
+			}
+			return nil, &AccessDeniedError{Message: message}
+		}
+
+		spec := crv1.PgsecretlogSpec{
+			ClusterName:   name,
+			Username:      username,
+			RequestedFrom: crv1.SECRET_REQUEST_APISERVER,
+			Output:        output,
+			Format:        format,
+		}
+		for _, c := range credentials {
+			spec.Secrets = append(spec.Secrets, c.Name)
+		}
+		err = util.RecordSecretReveal(restclient, &spec, namespace)
+		if err != nil {
+			return nil, errors.New("the passwords were not revealed, the audit record could not be written " + err.Error())
+		}
+		log.Infoln("passwords of " + name + " revealed to " + username)
+	}
+
+	items := make([]msgs.SecretDetail, 0, len(credentials))
+	for _, c := range credentials {
+		detail := msgs.SecretDetail{
+			Name:     c.Name,
+			Username: c.Username,
+			Password: util.REDACTED_PASSWORD,
+		}
+		if reveal {
+			detail.Password = c.Password
+		}
+		if format == crv1.SECRET_FORMAT_URI {
+			detail.ConnectionString = util.GetClusterConnectionString(&cluster, namespace, c.Username, detail.Password)
+		}
+		items = append(items, detail)
+	}
+
+	return items, nil
+}
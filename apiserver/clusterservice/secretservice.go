@@ -0,0 +1,62 @@
+package clusterservice
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	apiserver "github.com/crunchydata/kraken/apiserver"
+	msgs "github.com/crunchydata/kraken/apiservermsgs"
+	"github.com/gorilla/mux"
+	authnv1 "k8s.io/client-go/pkg/apis/authentication/v1"
+	"net/http"
+)
+
+// pgo show cluster mycluster --show-secrets
+// pgo show cluster mycluster --reveal --connection-string
+// parameters namespace, reveal, format (plain or uri), output (terminal or file)
+// returns a ShowSecretsResponse, the passwords are redacted unless
+// reveal=true, the bearer token of the request must then authenticate
+// a user that may get the secrets
+func ShowSecretsHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infoln("clusterservice.ShowSecretsHandler called")
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	query := r.URL.Query()
+	namespace := query.Get("namespace")
+	reveal := query.Get("reveal") == "true"
+
+	resp := msgs.ShowSecretsResponse{}
+	w.Header().Set("Content-Type", "application/json")
+
+	var user *authnv1.UserInfo
+	var err error
+	if reveal {
+		user, err = apiserver.Authenticate(r)
+		if err != nil {
+			log.Error(err.Error())
+			w.WriteHeader(http.StatusUnauthorized)
+			resp.Status = "error"
+			resp.Message = err.Error()
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	items, err := ShowSecrets(apiserver.Clientset, apiserver.RestClient, user, namespace, name, reveal, query.Get("format"), query.Get("output"))
+	if err != nil {
+		log.Error(err.Error())
+		if _, ok := err.(*AccessDeniedError); ok {
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		resp.Status = "error"
+		resp.Message = err.Error()
+	} else {
+		w.WriteHeader(http.StatusOK)
+		resp.Items = items
+		resp.Status = "ok"
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -2,8 +2,11 @@ package policyservice
 
 import (
 	"errors"
+	"fmt"
 	log "github.com/Sirupsen/logrus"
 	"k8s.io/client-go/rest"
+	"strings"
+	"time"
 
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	"github.com/crunchydata/kraken/util"
@@ -12,12 +15,23 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
-func CreatePolicy(RestClient *rest.RESTClient, Namespace, policyName, policyURL, policyFile string) error {
+// how long to wait for the operator to dry run a policy on a cluster
+const DRY_RUN_TIMEOUT = 2 * time.Minute
+
+func CreatePolicy(Clientset *kubernetes.Clientset, RestClient *rest.RESTClient, Namespace, policyName, policyURL, policyURLSecret, policyFile, policyDownFile, policyRemoveFile, policyVerifySql string, dependsOn []string, parameters []crv1.PgpolicyParameter, scope string, newVersion, refresh bool) error {
 	var err error
 
 	log.Debug("create policy called for " + policyName)
 	result := crv1.Pgpolicy{}
 
+	// a file url would be read from the apiserver, the caller sends
+	// the SQL of a local file instead
+	if strings.HasPrefix(policyURL, util.POLICY_SOURCE_FILE+"://") {
+		err = errors.New("file urls are not read by the apiserver, send the policy SQL instead")
+		log.Error(err.Error())
+		return err
+	}
+
 	// error if it already exists
 	err = RestClient.Get().
 		Resource(crv1.PgpolicyResourcePlural).
@@ -25,9 +39,16 @@ func CreatePolicy(RestClient *rest.RESTClient, Namespace, policyName, policyURL,
 		Name(policyName).
 		Do().
 		Into(&result)
-	if err == nil {
+	if err == nil && refresh {
+		return refreshPolicy(Clientset, RestClient, Namespace, &result)
+	} else if err == nil && newVersion {
+		return createPolicyVersion(Clientset, RestClient, Namespace, &result, policyURL, policyURLSecret, policyFile, policyDownFile, policyRemoveFile, policyVerifySql, dependsOn, parameters, scope)
+	} else if err == nil {
 		log.Infoln("pgpolicy " + policyName + " was found so we will not create it")
 		return err
+	} else if kerrors.IsNotFound(err) && refresh {
+		log.Error("pgpolicy " + policyName + " not found so there is nothing to refresh")
+		return err
 	} else if kerrors.IsNotFound(err) {
 		log.Debug("pgpolicy " + policyName + " not found so we will create it")
 	} else {
@@ -39,7 +60,30 @@ func CreatePolicy(RestClient *rest.RESTClient, Namespace, policyName, policyURL,
 	spec := crv1.PgpolicySpec{}
 	spec.Name = policyName
 	spec.Url = policyURL
+	spec.UrlSecret = policyURLSecret
 	spec.Sql = policyFile
+	spec.DownSql = policyDownFile
+	spec.RemoveSql = policyRemoveFile
+	spec.VerifySql = policyVerifySql
+	spec.DependsOn = dependsOn
+	err = util.ValidatePolicyDependencies(RestClient, Namespace, policyName, dependsOn)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	spec.Parameters = parameters
+	err = util.ValidatePolicyParameters(parameters)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	spec.Scope = scope
+	err = util.FetchPolicySource(Clientset, Namespace, &spec)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	util.SetPolicyChecksum(&spec)
 
 	newInstance := &crv1.Pgpolicy{
 		ObjectMeta: meta_v1.ObjectMeta{
@@ -48,6 +92,12 @@ func CreatePolicy(RestClient *rest.RESTClient, Namespace, policyName, policyURL,
 		Spec: spec,
 	}
 
+	err = util.CheckPolicyScope(newInstance)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
 	err = RestClient.Post().
 		Resource(crv1.PgpolicyResourcePlural).
 		Namespace(Namespace).
@@ -63,6 +113,92 @@ func CreatePolicy(RestClient *rest.RESTClient, Namespace, policyName, policyURL,
 
 }
 
+// createPolicyVersion replaces the SQL of an existing policy with a
+// new version, clusters stay on their version until upgraded
+func createPolicyVersion(Clientset *kubernetes.Clientset, RestClient *rest.RESTClient, Namespace string, policy *crv1.Pgpolicy, policyURL, policyURLSecret, policyFile, policyDownFile, policyRemoveFile, policyVerifySql string, dependsOn []string, parameters []crv1.PgpolicyParameter, scope string) error {
+	spec := crv1.PgpolicySpec{}
+	spec.Name = policy.Spec.Name
+	spec.Url = policyURL
+	spec.UrlSecret = policyURLSecret
+	spec.Sql = policyFile
+	spec.DownSql = policyDownFile
+	spec.RemoveSql = policyRemoveFile
+	spec.VerifySql = policyVerifySql
+	err := util.FetchPolicySource(Clientset, Namespace, &spec)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	err = util.NewPolicyVersion(policy, &spec)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if len(dependsOn) > 0 {
+		err = util.ValidatePolicyDependencies(RestClient, Namespace, policy.Spec.Name, dependsOn)
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		policy.Spec.DependsOn = dependsOn
+	}
+	if len(parameters) > 0 {
+		err = util.ValidatePolicyParameters(parameters)
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		policy.Spec.Parameters = parameters
+	}
+	if scope != "" {
+		policy.Spec.Scope = scope
+	}
+	err = util.CheckPolicyScope(policy)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	err = RestClient.Put().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(Namespace).
+		Name(policy.Spec.Name).
+		Body(policy).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating Pgpolicy " + policy.Spec.Name + " " + err.Error())
+		return err
+	}
+	log.Infof("created Pgpolicy %s version %d", policy.Spec.Name, policy.Spec.Version)
+	return err
+}
+
+// refreshPolicy fetches the url of an existing policy again, a new
+// version is added only when the SQL it returns has changed
+func refreshPolicy(Clientset *kubernetes.Clientset, RestClient *rest.RESTClient, Namespace string, policy *crv1.Pgpolicy) error {
+	err := util.RefreshPolicy(Clientset, Namespace, policy)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	err = RestClient.Put().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(Namespace).
+		Name(policy.Spec.Name).
+		Body(policy).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating Pgpolicy " + policy.Spec.Name + " " + err.Error())
+		return err
+	}
+	log.Infof("refreshed Pgpolicy %s version %d", policy.Spec.Name, policy.Spec.Version)
+	return err
+}
+
 func ShowPolicy(RestClient *rest.RESTClient, Namespace string, name string) crv1.PgpolicyList {
 	policyList := crv1.PgpolicyList{}
 
@@ -137,17 +273,40 @@ func DeletePolicy(Namespace string, RestClient *rest.RESTClient, args []string)
 }
 
 // pgo apply mypolicy --selector=name=mycluster
-func ApplyPolicy(username string, Selector string, Clientset *kubernetes.Clientset, DryRun bool, RestClient *rest.RESTClient, Namespace string, args []string) error {
+// a dry run returns the outcome of running each policy on each
+// cluster in a transaction that is rolled back, action is one of the
+// POLICY_ACTION values and params the parameter values given
+func ApplyPolicy(username string, Selector string, Clientset *kubernetes.Clientset, DryRun bool, Atomic bool, action string, params map[string]string, RestClient *rest.RESTClient, Namespace string, args []string) ([]string, error) {
 	var err error
+	results := []string{}
 	//validate policies
-	labels := make(map[string]string)
+	catalog := make(map[string]*crv1.Pgpolicy)
 	for _, p := range args {
-		err = util.ValidatePolicy(RestClient, Namespace, p)
+		policy := crv1.Pgpolicy{}
+		err = RestClient.Get().
+			Resource(crv1.PgpolicyResourcePlural).
+			Namespace(Namespace).
+			Name(p).
+			Do().
+			Into(&policy)
 		if err != nil {
-			return errors.New("policy " + p + " is not found, cancelling request")
+			return results, errors.New("policy " + p + " is not found, cancelling request")
 		}
 
-		labels[p] = "pgpolicy"
+		catalog[p] = &policy
+	}
+
+	//apply each policy after the policies it depends on
+	args, err = util.SortPolicies(args, catalog)
+	if err != nil {
+		return results, err
+	}
+	if action == crv1.POLICY_ACTION_REMOVE {
+		args = util.ReversePolicies(args)
+	}
+	err = util.CheckPolicyParams(catalog, params)
+	if err != nil {
+		return results, err
 	}
 	//get filtered list of Deployments
 	sel := Selector + ",!replica"
@@ -156,41 +315,47 @@ func ApplyPolicy(username string, Selector string, Clientset *kubernetes.Clients
 	deployments, err := Clientset.ExtensionsV1beta1().Deployments(Namespace).List(lo)
 	if err != nil {
 		log.Error("error getting list of deployments" + err.Error())
-		return err
+		return results, err
 	}
 
-	if DryRun {
-		log.Infoln("policy would be applied to the following clusters:")
-		for _, d := range deployments.Items {
-			log.Infoln("deployment : " + d.ObjectMeta.Name)
-		}
-		return err
-	}
-	var newInstance *crv1.Pgpolicylog
+	dryRuns := []*crv1.Pgpolicylog{}
 	for _, d := range deployments.Items {
+		batch := []string{}
 		for _, p := range args {
 			log.Debug("apply policy " + p + " on deployment " + d.ObjectMeta.Name + " based on selector " + sel)
 
-			newInstance = getPolicylog(username, p, d.ObjectMeta.Name)
+			newInstance, existing, err := getPolicylog(RestClient, Namespace, username, catalog[p], d.ObjectMeta.Name, DryRun, Atomic, action, params)
+			if err != nil {
+				log.Infoln(err.Error())
+				results = append(results, err.Error())
+				continue
+			}
 
-			result := crv1.Pgpolicylog{}
-			err = RestClient.Get().
-				Resource(crv1.PgpolicylogResourcePlural).
-				Namespace(Namespace).
-				Name(newInstance.ObjectMeta.Name).
-				Do().Into(&result)
-			if err == nil {
-				log.Infoln(p + " already applied to " + d.ObjectMeta.Name)
-				break
-			} else {
-				if kerrors.IsNotFound(err) {
-				} else {
-					log.Error(err)
-					break
+			//the policies dry run before this one on the cluster count
+			//as applied when its dependencies are checked
+			if DryRun {
+				newInstance.Spec.DryRunBatch = append([]string{}, batch...)
+				batch = append(batch, p)
+				dryRuns = append(dryRuns, newInstance)
+				continue
+			}
+
+			//the pgpolicylog of the run before is replaced, the new one
+			//carries its runs in its history
+			if existing != nil {
+				err = RestClient.Delete().
+					Resource(crv1.PgpolicylogResourcePlural).
+					Namespace(Namespace).
+					Name(existing.ObjectMeta.Name).
+					Do().
+					Error()
+				if err != nil {
+					log.Error("error deleting Pgpolicylog " + existing.ObjectMeta.Name + " " + err.Error())
+					return results, err
 				}
 			}
 
-			result = crv1.Pgpolicylog{}
+			result := crv1.Pgpolicylog{}
 			err = RestClient.Post().
 				Resource(crv1.PgpolicylogResourcePlural).
 				Namespace(Namespace).
@@ -198,31 +363,135 @@ func ApplyPolicy(username string, Selector string, Clientset *kubernetes.Clients
 				Do().Into(&result)
 			if err != nil {
 				log.Error("error in creating Pgpolicylog CRD instance", err.Error())
-				return err
+				return results, err
 			} else {
 				log.Infoln("created Pgpolicylog " + result.ObjectMeta.Name)
+				results = append(results, "created Pgpolicylog "+result.ObjectMeta.Name)
 			}
 
 		}
 
 	}
-	return err
+
+	if DryRun {
+		results = append(results, dryRunPolicies(RestClient, dryRuns, Namespace)...)
+	}
+	return results, err
 
 }
 
-func getPolicylog(username, policyname, clustername string) *crv1.Pgpolicylog {
+// dryRunPolicies has the operator run each policy on each cluster in a
+// transaction that is rolled back and describes the outcomes, the dry
+// run pgpolicylogs are all created before any is waited on so they
+// share one timeout, and are removed afterwards
+func dryRunPolicies(RestClient *rest.RESTClient, instances []*crv1.Pgpolicylog, Namespace string) []string {
+	results := make([]string, len(instances))
+	names := []string{}
+	for i, newInstance := range instances {
+		name := newInstance.ObjectMeta.Name
+
+		//remove the outcome of an earlier dry run
+		RestClient.Delete().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(Namespace).
+			Name(name).
+			Do()
+
+		err := RestClient.Post().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(Namespace).
+			Body(newInstance).
+			Do().
+			Error()
+		if err != nil {
+			log.Error("error in creating dry run Pgpolicylog " + err.Error())
+			results[i] = newInstance.Spec.PolicyName + " on " + newInstance.Spec.ClusterName + " could not be dry run " + err.Error()
+			continue
+		}
+		names = append(names, name)
+	}
+
+	pending := "timed out waiting for its pgpolicylog"
+	outcomes, err := util.WaitForPolicylogs(RestClient, names, Namespace, DRY_RUN_TIMEOUT)
+	if err != nil {
+		log.Error("error waiting for dry run Pgpolicylogs " + err.Error())
+		pending = "could not be read " + err.Error()
+	}
+
+	for i, newInstance := range instances {
+		if results[i] != "" {
+			continue
+		}
+		name := newInstance.ObjectMeta.Name
+		policyName := newInstance.Spec.PolicyName
+		clusterName := newInstance.Spec.ClusterName
+
+		result := outcomes[name]
+		if result == nil {
+			results[i] = policyName + " on " + clusterName + " " + pending
+		} else if result.Spec.Status == crv1.POLICY_FAILED_STATUS {
+			results[i] = policyName + " on " + clusterName + " would fail " + result.Spec.Result.Error
+		} else {
+			results[i] = fmt.Sprintf("%s on %s would succeed, %d statements %d rows affected", policyName, clusterName, result.Spec.Result.Statements, result.Spec.Result.RowsAffected)
+		}
+
+		RestClient.Delete().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(Namespace).
+			Name(name).
+			Do()
+	}
+	return results
+}
+
+// getPolicylog returns the pgpolicylog asking the operator to apply,
+// upgrade or revert a policy on a cluster along with the pgpolicylog
+// it replaces, if any, or an error saying why there is nothing to run
+func getPolicylog(RestClient *rest.RESTClient, Namespace, username string, policy *crv1.Pgpolicy, clustername string, dryRun bool, atomic bool, action string, params map[string]string) (*crv1.Pgpolicylog, *crv1.Pgpolicylog, error) {
+	var existing *crv1.Pgpolicylog
+	applied := crv1.Pgpolicylog{}
+	err := RestClient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(Namespace).
+		Name(util.GetPolicylogName(policy.Spec.Name, clustername, false)).
+		Do().Into(&applied)
+	if err == nil {
+		existing = &applied
+	} else if !kerrors.IsNotFound(err) {
+		log.Error(err)
+		return nil, nil, err
+	}
+
+	var appliedSpec *crv1.PgpolicylogSpec
+	if existing != nil {
+		appliedSpec = &existing.Spec
+	}
+	action, fromVersion, err := util.GetPolicyAction(policy, clustername, appliedSpec, action)
+	if err != nil {
+		return nil, existing, err
+	}
 
 	spec := crv1.PgpolicylogSpec{}
-	spec.PolicyName = policyname
+	spec.PolicyName = policy.Spec.Name
 	spec.Username = username
+	spec.RequestedFrom = crv1.POLICY_REQUEST_APISERVER
+	spec.RequestDate = time.Now().Format(time.RFC3339)
 	spec.ClusterName = clustername
+	spec.DryRun = dryRun
+	spec.Atomic = atomic
+	spec.Action = action
+	spec.FromVersion = fromVersion
+	spec.Parameters = util.GetPolicylogParams(policy, appliedSpec, params)
+	if !dryRun {
+		spec.History = util.GetPolicylogHistory(appliedSpec)
+	}
 
 	newInstance := &crv1.Pgpolicylog{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name: policyname + clustername,
+			Name: util.GetPolicylogName(policy.Spec.Name, clustername, dryRun),
 		},
 		Spec: spec,
 	}
-	return newInstance
+	return newInstance, existing, nil
 
 }
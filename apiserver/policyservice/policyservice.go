@@ -6,8 +6,10 @@ import (
 	//crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	apiserver "github.com/crunchydata/kraken/apiserver"
 	msgs "github.com/crunchydata/kraken/apiservermsgs"
+	"github.com/crunchydata/kraken/util"
 	"github.com/gorilla/mux"
 	"net/http"
+	"strings"
 )
 
 // pgo create policy
@@ -19,7 +21,7 @@ func CreatePolicyHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Infoln("policyservice.CreatePolicyHandler got request " + request.Name)
 
-	err := CreatePolicy(apiserver.RestClient, request.Namespace, request.Name, request.URL, request.SQL)
+	err := CreatePolicy(apiserver.Clientset, apiserver.RestClient, request.Namespace, request.Name, request.URL, request.URLSecret, request.SQL, request.DownSQL, request.RemoveSQL, request.VerifySQL, request.DependsOn, request.Parameters, request.Scope, request.NewVersion, request.Refresh)
 	if err != nil {
 		log.Error(err.Error())
 		log.Infoln("error would be reported back to caller!!!!")
@@ -59,14 +61,36 @@ func ShowPolicyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // pgo apply mypolicy --selector=name=mycluster
+// the policylogs created record the user the bearer token of the
+// request authenticates, a request without a valid token is refused
 func ApplyPolicyHandler(w http.ResponseWriter, r *http.Request) {
 	log.Infoln("policyservice.ApplyPolicyHandler called")
-	//log.Infoln("showsecrets=" + showsecrets)
 	vars := mux.Vars(r)
 	log.Infof(" vars are %v\n", vars)
-	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "application/json")
+	var request msgs.ApplyPolicyRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
 	c := new(msgs.ApplyResults)
-	c.Results = []string{"one", "two"}
+	w.Header().Set("Content-Type", "application/json")
+
+	user, err := apiserver.Authenticate(r)
+	if err != nil {
+		log.Error(err.Error())
+		c.Results = append(c.Results, err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+
+	params, err := util.ParsePolicyParams(request.Params)
+	if err == nil {
+		c.Results, err = ApplyPolicy(user.Username, request.Selector, apiserver.Clientset, request.DryRun, request.Atomic, request.Action, params, apiserver.RestClient, request.Namespace, strings.Split(vars["name"], ","))
+	}
+	if err != nil {
+		log.Error(err.Error())
+		c.Results = append(c.Results, err.Error())
+	}
+
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(c)
 }
@@ -0,0 +1,108 @@
+package restoreservice
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+)
+
+// CreateRestore creates a pgrestore, the operator resolves and
+// validates the backup it names when it processes it
+func CreateRestore(RestClient *rest.RESTClient, Namespace string, spec *crv1.PgrestoreSpec) (string, error) {
+	if spec.TargetCluster == "" {
+		return "", errors.New("a target cluster is required")
+	}
+	if spec.BackupName == "" && spec.BACKUP_PATH == "" {
+		return "", errors.New("a pgbackup or a backup path is required")
+	}
+	if spec.InPlace && spec.Confirm != spec.TargetCluster {
+		return "", errors.New("an in-place restore of " + spec.TargetCluster + " must be confirmed with the cluster name")
+	}
+	if spec.PITR_TARGET != "" {
+		_, err := util.ParseRecoveryTarget(spec.PITR_TARGET)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	t := time.Now()
+	spec.Name = spec.TargetCluster + "-" + t.Format("20060102150405")
+	spec.RequestDate = t.Format(time.RFC3339)
+
+	restoreLabels := make(map[string]string)
+	restoreLabels["pg-cluster"] = spec.TargetCluster
+
+	newInstance := &crv1.Pgrestore{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   spec.Name,
+			Labels: restoreLabels,
+		},
+		Spec: *spec,
+		Status: crv1.PgrestoreStatus{
+			State:   crv1.PgrestoreStateCreated,
+			Message: "Created, not processed yet",
+		},
+	}
+
+	err := RestClient.Post().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(Namespace).
+		Body(newInstance).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error in creating Pgrestore instance " + err.Error())
+		return "", err
+	}
+	log.Infoln("created Pgrestore " + spec.Name)
+	return spec.Name, nil
+}
+
+// ShowRestore returns the restores of a cluster, or every restore
+// when the name is all
+func ShowRestore(RestClient *rest.RESTClient, Namespace string, name string) crv1.PgrestoreList {
+	restoreList := crv1.PgrestoreList{}
+
+	myselector := labels.Everything()
+	if name != "all" {
+		myselector = labels.SelectorFromSet(labels.Set{"pg-cluster": name})
+	}
+
+	err := RestClient.Get().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(Namespace).
+		LabelsSelectorParam(myselector).
+		Do().
+		Into(&restoreList)
+	if err != nil {
+		log.Error("error getting list of restores " + err.Error())
+	}
+
+	return restoreList
+}
+
+// DeleteRestore removes the record of each restore, the restored
+// clusters are left as they are
+func DeleteRestore(RestClient *rest.RESTClient, Namespace string, restoreList crv1.PgrestoreList) error {
+	var err error
+	for _, r := range restoreList.Items {
+		err = RestClient.Delete().
+			Resource(crv1.PgrestoreResourcePlural).
+			Namespace(Namespace).
+			Name(r.Spec.Name).
+			Do().
+			Error()
+		if err != nil {
+			log.Error("error deleting pgrestore " + r.Spec.Name + " " + err.Error())
+			return err
+		}
+		log.Infoln("deleted pgrestore " + r.Spec.Name)
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package restoreservice
+
+import (
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	apiserver "github.com/crunchydata/kraken/apiserver"
+	msgs "github.com/crunchydata/kraken/apiservermsgs"
+	"github.com/gorilla/mux"
+	"net/http"
+)
+
+// pgo restore newcluster --backup=mycluster
+// pgo restore mycluster --backup=mycluster --in-place --confirm=mycluster
+// returns a CreateRestoreResponse
+func CreateRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infoln("restoreservice.CreateRestoreHandler called")
+	var request msgs.CreateRestoreRequest
+	_ = json.NewDecoder(r.Body).Decode(&request)
+
+	log.Infoln("restoreservice.CreateRestoreHandler got request for " + request.Spec.TargetCluster)
+
+	resp := msgs.CreateRestoreResponse{}
+	name, err := CreateRestore(apiserver.RestClient, request.Namespace, &request.Spec)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		log.Error(err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		resp.Status = "error"
+		resp.Message = err.Error()
+	} else {
+		w.WriteHeader(http.StatusOK)
+		resp.Name = name
+		resp.Status = "ok"
+		resp.Message = "created pgrestore " + name
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// pgo show restore mycluster
+// pgo delete restore mycluster
+// parameters namespace
+// returns a ShowRestoreResponse
+func ShowRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	log.Infoln("restoreservice.ShowRestoreHandler called")
+	vars := mux.Vars(r)
+	log.Infof(" vars are %v\n", vars)
+
+	name := vars["name"]
+	namespace := r.URL.Query().Get("namespace")
+
+	resp := msgs.ShowRestoreResponse{}
+	resp.RestoreList = ShowRestore(apiserver.RestClient, namespace, name)
+
+	switch r.Method {
+	case "GET":
+		log.Infoln("restoreservice.ShowRestoreHandler GET called")
+	case "DELETE":
+		log.Infoln("restoreservice.ShowRestoreHandler DELETE called")
+		err := DeleteRestore(apiserver.RestClient, namespace, resp.RestoreList)
+		if err != nil {
+			log.Error(err.Error())
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,12 +1,16 @@
 package apiserver
 
 import (
+	"errors"
 	"flag"
 	log "github.com/Sirupsen/logrus"
 	crdclient "github.com/crunchydata/kraken/client"
 	"k8s.io/client-go/kubernetes"
+	authnv1 "k8s.io/client-go/pkg/apis/authentication/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"net/http"
+	"strings"
 )
 
 var RestClient *rest.RESTClient
@@ -49,3 +53,36 @@ func buildConfig(kubeconfig string) (*rest.Config, error) {
 	}
 	return rest.InClusterConfig()
 }
+
+// Authenticate returns the Kubernetes user the bearer token of a
+// request belongs to, the token is checked with a TokenReview so a
+// request can not name a user it holds no credentials of, the user is
+// recorded in the audit trail of what the request does
+func Authenticate(r *http.Request) (*authnv1.UserInfo, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, errors.New("the request has no bearer token")
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if token == "" {
+		return nil, errors.New("the request has no bearer token")
+	}
+
+	review := &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+	result, err := Clientset.AuthenticationV1().TokenReviews().Create(review)
+	if err != nil {
+		return nil, errors.New("could not review the bearer token " + err.Error())
+	}
+	if !result.Status.Authenticated {
+		message := "the bearer token of the request is not valid"
+		if result.Status.Error != "" {
+			message = message + ", " + result.Status.Error
+		}
+		return nil, errors.New(message)
+	}
+	return &result.Status.User, nil
+}
@@ -5,9 +5,27 @@ import (
 )
 
 type CreatePolicyRequest struct {
+	Name       string
+	URL        string
+	URLSecret  string
+	SQL        string
+	DownSQL    string
+	RemoveSQL  string
+	VerifySQL  string
+	DependsOn  []string
+	Parameters []crv1.PgpolicyParameter
+	Scope      string
+	NewVersion bool
+	Refresh    bool
+	Namespace  string
+}
+type ApplyPolicyRequest struct {
 	Name      string
-	URL       string
-	SQL       string
+	Selector  string
+	DryRun    bool
+	Atomic    bool
+	Action    string
+	Params    []string
 	Namespace string
 }
 type ApplyResults struct {
@@ -0,0 +1,22 @@
+package apiservermsgs
+
+import (
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+)
+
+// CreateRestoreRequest is a pgrestore to create, an in-place restore
+// must carry the target cluster name in Spec.Confirm
+type CreateRestoreRequest struct {
+	Namespace string
+	Spec      crv1.PgrestoreSpec
+}
+
+type CreateRestoreResponse struct {
+	Name    string
+	Status  string
+	Message string
+}
+
+type ShowRestoreResponse struct {
+	RestoreList crv1.PgrestoreList
+}
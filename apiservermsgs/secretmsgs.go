@@ -0,0 +1,17 @@
+package apiservermsgs
+
+// SecretDetail is a credential of a cluster, Password is redacted
+// unless the passwords were asked to be revealed, ConnectionString is
+// only set when the uri format was asked for
+type SecretDetail struct {
+	Name             string
+	Username         string
+	Password         string
+	ConnectionString string
+}
+
+type ShowSecretsResponse struct {
+	Items   []SecretDetail
+	Status  string
+	Message string
+}
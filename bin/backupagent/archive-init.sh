@@ -0,0 +1,36 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+#
+# install the archive_command of a cluster archiving WAL to object
+# storage, runs as an init container of the master database pod
+#
+# /archive-tools is the volume the database container runs
+# archive-wal.sh from, mc and common.sh are copied next to it
+#
+
+source /opt/cpm/bin/common.sh
+
+s3_init
+
+cp $(which mc) /opt/cpm/bin/common.sh /opt/cpm/bin/archive-wal.sh /archive-tools/
+if [ $? -ne 0 ]; then
+	echo "could not install the archive tools"
+	exit 1
+fi
+chmod +x /archive-tools/mc /archive-tools/archive-wal.sh
+
+echo "WAL is archived to $(s3_url ${ARCHIVE_CLUSTER}-wal)"
@@ -0,0 +1,39 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+#
+# archive_command of a cluster archiving WAL to object storage, runs
+# in the database container from the /archive-tools volume and only
+# succeeds once the segment is stored in the bucket, so PostgreSQL
+# keeps the segment until then and retries a failed upload
+#
+# $1 path of the WAL segment (%p)
+# $2 file name of the WAL segment (%f)
+# $ARCHIVE_CLUSTER cluster whose WAL is archived
+#
+
+TOOLS=$(dirname $0)
+export PATH=$TOOLS:$PATH
+
+source $TOOLS/common.sh
+
+s3_alias
+
+$MC cp --quiet "$1" $(s3_url ${ARCHIVE_CLUSTER}-wal)/$2 > /dev/null
+if [ $? -ne 0 ]; then
+	echo "upload of $2 to $S3_ENDPOINT failed"
+	exit 1
+fi
@@ -0,0 +1,92 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+#
+# finalize a backup taken by the crunchy-backup init container
+#
+# /pgdata is the volume the backup is stored on
+# /scratch is the volume a packed backup was taken into, it is memory
+# backed for an encrypted backup and emptied on every exit so the
+# unpacked backup never reaches /pgdata
+# $BACKUP_HOST cluster that was backed up
+# $BACKUP_TARGET pvc or s3
+# $RETENTION_DAYS optional, backups older than this are pruned
+# $COMPRESSION optional, none, gzip or zstd
+# $COMPRESSION_LEVEL optional compression level
+# $ENCRYPTED true to encrypt with the key mounted on /backup-key
+#
+
+source /opt/cpm/bin/common.sh
+
+SOURCE=/pgdata
+if backup_is_packed; then
+	SOURCE=/scratch
+	trap 'rm -rf /scratch/*' EXIT
+fi
+
+BACKUP_ROOT=${BACKUP_HOST}-backups
+LATEST=$(ls -1 $SOURCE/$BACKUP_ROOT | sort | tail -1)
+
+if [ "$LATEST" = "" ]; then
+	echo "no backup found in $SOURCE/$BACKUP_ROOT"
+	exit 1
+fi
+
+BACKUP_PATH=$BACKUP_ROOT/$LATEST
+echo "backup path is $BACKUP_PATH"
+
+ORIGINAL_SIZE=$(du -sb $SOURCE/$BACKUP_PATH | cut -f1)
+STORED_SIZE=$ORIGINAL_SIZE
+
+if backup_is_packed; then
+	ARCHIVE=$(archive_name)
+	echo "packing backup into $ARCHIVE"
+	mkdir -p /pgdata/$BACKUP_PATH
+	set -o pipefail
+	tar -C $SOURCE/$BACKUP_PATH -cf - . | compress_stream | encrypt_stream > /pgdata/$BACKUP_PATH/$ARCHIVE
+	if [ $? -ne 0 ]; then
+		echo "packing of $BACKUP_PATH failed"
+		rm -rf /pgdata/$BACKUP_PATH
+		exit 1
+	fi
+	set +o pipefail
+	rm -rf $SOURCE/$BACKUP_PATH
+	STORED_SIZE=$(stat -c %s /pgdata/$BACKUP_PATH/$ARCHIVE)
+fi
+echo "backup size $ORIGINAL_SIZE bytes, stored size $STORED_SIZE bytes"
+
+case "$BACKUP_TARGET" in
+s3)
+	s3_init
+	$MC cp --recursive /pgdata/$BACKUP_PATH/ $(s3_url $BACKUP_PATH)/
+	if [ $? -ne 0 ]; then
+		echo "upload of $BACKUP_PATH to $S3_ENDPOINT failed"
+		exit 1
+	fi
+	if [ "$RETENTION_DAYS" != "" ]; then
+		echo "pruning object storage backups older than $RETENTION_DAYS days"
+		$MC rm --recursive --force --older-than ${RETENTION_DAYS}d $(s3_url $BACKUP_ROOT)/
+	fi
+	;;
+*)
+	if [ "$RETENTION_DAYS" != "" ]; then
+		echo "pruning pvc backups older than $RETENTION_DAYS days"
+		find /pgdata/$BACKUP_ROOT -mindepth 1 -maxdepth 1 -type d -mtime +$RETENTION_DAYS -exec rm -rf {} \;
+	fi
+	;;
+esac
+
+write_result $BACKUP_PATH $ORIGINAL_SIZE $STORED_SIZE
+echo "backupagent has ended!"
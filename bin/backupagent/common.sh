@@ -0,0 +1,146 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+#
+# functions shared by the backupagent scripts
+#
+# $S3_ENDPOINT url of the S3-compatible endpoint (e.g. https://minio:9000)
+# $S3_BUCKET bucket holding the backups
+# $S3_PREFIX optional key prefix within the bucket
+# $S3_REGION optional bucket region
+# $S3_INSECURE true to skip TLS verification (self-signed MinIO)
+# $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY credentials from the Secret
+# $COMPRESSION none, gzip or zstd
+# $COMPRESSION_LEVEL optional compression level
+# $ENCRYPTED true when the backup is encrypted with /backup-key
+#
+
+MC="mc --config-dir /tmp/.mc"
+
+if [ "$S3_INSECURE" = "true" ]; then
+	MC="$MC --insecure"
+fi
+
+# s3_alias points the s3target alias of mc at the endpoint
+function s3_alias() {
+	$MC alias set s3target "$S3_ENDPOINT" "$AWS_ACCESS_KEY_ID" "$AWS_SECRET_ACCESS_KEY" --api S3v4 > /dev/null
+	if [ $? -ne 0 ]; then
+		echo "could not configure object storage endpoint $S3_ENDPOINT"
+		exit 1
+	fi
+}
+
+function s3_init() {
+	s3_alias
+	if [ "$S3_REGION" != "" ]; then
+		$MC mb --ignore-existing --region "$S3_REGION" s3target/$S3_BUCKET
+	else
+		$MC mb --ignore-existing s3target/$S3_BUCKET
+	fi
+}
+
+# s3_url returns the object storage location of a path relative to
+# the bucket prefix
+function s3_url() {
+	if [ "$S3_PREFIX" != "" ]; then
+		echo "s3target/$S3_BUCKET/$S3_PREFIX/$1"
+	else
+		echo "s3target/$S3_BUCKET/$1"
+	fi
+}
+
+KEY_FILE=/backup-key/encryption-key
+
+# backup_is_packed is true when the backup is written as a single
+# compressed or encrypted archive instead of a data directory
+function backup_is_packed() {
+	[ "$ENCRYPTED" = "true" ] || ( [ "$COMPRESSION" != "" ] && [ "$COMPRESSION" != "none" ] )
+}
+
+# archive_name returns the file name of a packed backup
+function archive_name() {
+	NAME=base.tar
+	case "$COMPRESSION" in
+	gzip)
+		NAME=$NAME.gz
+		;;
+	zstd)
+		NAME=$NAME.zst
+		;;
+	esac
+	if [ "$ENCRYPTED" = "true" ]; then
+		NAME=$NAME.gpg
+	fi
+	echo $NAME
+}
+
+function compress_stream() {
+	case "$COMPRESSION" in
+	gzip)
+		gzip -c -${COMPRESSION_LEVEL:-6}
+		;;
+	zstd)
+		zstd -q -c -${COMPRESSION_LEVEL:-3}
+		;;
+	*)
+		cat
+		;;
+	esac
+}
+
+function encrypt_stream() {
+	if [ "$ENCRYPTED" = "true" ]; then
+		gpg --batch --quiet --symmetric --cipher-algo AES256 --passphrase-file $KEY_FILE -o -
+	else
+		cat
+	fi
+}
+
+# unpack_archive extracts a packed backup into a directory, the
+# compression and encryption are taken from the archive name
+function unpack_archive() {
+	ARCHIVE=$1
+	DEST=$2
+
+	DECRYPT="cat"
+	case "$ARCHIVE" in
+	*.gpg)
+		DECRYPT="gpg --batch --quiet --decrypt --passphrase-file $KEY_FILE"
+		;;
+	esac
+
+	DECOMPRESS="cat"
+	case "${ARCHIVE%.gpg}" in
+	*.gz)
+		DECOMPRESS="gzip -d -c"
+		;;
+	*.zst)
+		DECOMPRESS="zstd -q -d -c"
+		;;
+	esac
+
+	mkdir -p $DEST
+	set -o pipefail
+	$DECRYPT < $ARCHIVE | $DECOMPRESS | tar -C $DEST -xf -
+	RC=$?
+	set +o pipefail
+	return $RC
+}
+
+# write_result reports the job result to the operator through the
+# container termination log
+function write_result() {
+	echo "{\"backuppath\": \"$1\", \"originalsize\": ${2:-0}, \"storedsize\": ${3:-0}}" > /dev/termination-log
+}
@@ -0,0 +1,51 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+
+#
+# stage the archived WAL of a cluster into /recover so the database
+# container can replay it for a point-in-time recovery
+#
+# /recover is the volume the database container replays WAL from
+# /pgwal-source is the archive PVC of the source cluster (pvc target)
+# $RESTORE_FROM cluster whose archived WAL is replayed
+# $WAL_TARGET pvc or s3
+#
+
+source /opt/cpm/bin/common.sh
+
+STAGING=/recover/.staging
+
+case "$WAL_TARGET" in
+s3)
+	s3_init
+	$MC cp --recursive $(s3_url ${RESTORE_FROM}-wal)/ $STAGING/
+	if [ $? -ne 0 ]; then
+		echo "download of ${RESTORE_FROM}-wal from $S3_ENDPOINT failed"
+		exit 1
+	fi
+	;;
+*)
+	mkdir -p $STAGING
+	cp -r /pgwal-source/. $STAGING/
+	;;
+esac
+
+# the archive holds a directory per database pod, WAL segment names
+# are unique across them so they are flattened for restore_command
+find $STAGING -type f -exec mv {} /recover/ \;
+rm -rf $STAGING
+
+echo "staged $(ls -1 /recover | wc -l) WAL files for recovery"
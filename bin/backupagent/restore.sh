@@ -0,0 +1,58 @@
+#!/bin/bash
+
+# Copyright 2017 Crunchy Data Solutions, Inc.
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+# http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+#
+# stage a backup into /backup so the database container can restore it
+#
+# /backup is the volume the database container restores from
+# $BACKUP_PATH backup to restore (e.g. mycluster-backups/2017-03-27-13-56-49)
+# $BACKUP_TARGET pvc or s3
+# $ENCRYPTED true when the backup is encrypted with /backup-key
+# /backup-source the backup PVC when a packed pvc backup is unpacked
+#
+
+source /opt/cpm/bin/common.sh
+
+case "$BACKUP_TARGET" in
+s3)
+	s3_init
+	mkdir -p /backup/$BACKUP_PATH
+	$MC cp --recursive $(s3_url $BACKUP_PATH)/ /backup/$BACKUP_PATH/
+	if [ $? -ne 0 ]; then
+		echo "download of $BACKUP_PATH from $S3_ENDPOINT failed"
+		exit 1
+	fi
+	;;
+*)
+	if [ -d /backup-source/$BACKUP_PATH ]; then
+		mkdir -p /backup/$BACKUP_PATH
+		cp -a /backup-source/$BACKUP_PATH/. /backup/$BACKUP_PATH/
+	fi
+	;;
+esac
+
+ARCHIVE=$(ls -1 /backup/$BACKUP_PATH/base.tar* 2> /dev/null | head -1)
+if [ "$ARCHIVE" != "" ]; then
+	echo "unpacking $ARCHIVE"
+	unpack_archive $ARCHIVE /backup/$BACKUP_PATH.unpack
+	if [ $? -ne 0 ]; then
+		echo "unpacking of $BACKUP_PATH failed, check the encryption key"
+		exit 1
+	fi
+	rm -rf /backup/$BACKUP_PATH
+	mv /backup/$BACKUP_PATH.unpack /backup/$BACKUP_PATH
+fi
+
+echo "restore staging has ended!"
@@ -114,6 +114,9 @@ func (c *PgclusterController) onUpdate(oldObj, newObj interface{}) {
 
 	//look for scale commands
 	clusteroperator.ScaleCluster(c.PgclusterClientset, c.PgclusterClient, newExample, oldExample, oldExample.ObjectMeta.Namespace)
+
+	//apply changed authentication settings
+	clusteroperator.UpdateAuth(c.PgclusterClientset, c.PgclusterClient, newExample, oldExample, oldExample.ObjectMeta.Namespace)
 }
 
 func (c *PgclusterController) onDelete(obj interface{}) {
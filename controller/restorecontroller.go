@@ -0,0 +1,129 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	restoreoperator "github.com/crunchydata/kraken/operator/restore"
+)
+
+// Watcher is an example of watching on resource create/update/delete events
+type PgrestoreController struct {
+	PgrestoreClient    *rest.RESTClient
+	PgrestoreClientset *kubernetes.Clientset
+	PgrestoreScheme    *runtime.Scheme
+}
+
+// Run starts an Example resource controller
+func (c *PgrestoreController) Run(ctx context.Context) error {
+	fmt.Print("Watch Pgrestore objects\n")
+
+	// Watch Example objects
+	_, err := c.watchPgrestores(ctx)
+	if err != nil {
+		fmt.Printf("Failed to register watch for Pgrestore resource: %v\n", err)
+		return err
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *PgrestoreController) watchPgrestores(ctx context.Context) (cache.Controller, error) {
+	source := cache.NewListWatchFromClient(
+		c.PgrestoreClient,
+		crv1.PgrestoreResourcePlural,
+		apiv1.NamespaceAll,
+		fields.Everything())
+
+	_, controller := cache.NewInformer(
+		source,
+
+		// The object type.
+		&crv1.Pgrestore{},
+
+		// resyncPeriod
+		// Every resyncPeriod, all resources in the cache will retrigger events.
+		// Set to 0 to disable the resync.
+		0,
+
+		// Your custom resource event handlers.
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.onAdd,
+			UpdateFunc: c.onUpdate,
+			DeleteFunc: c.onDelete,
+		})
+
+	go controller.Run(ctx.Done())
+	return controller, nil
+}
+
+func (c *PgrestoreController) onAdd(obj interface{}) {
+	restore := obj.(*crv1.Pgrestore)
+	fmt.Printf("[PgrestoreCONTROLLER] OnAdd %s\n", restore.ObjectMeta.SelfLink)
+
+	//a restore that was running when the operator stopped is resumed
+	//from the phase it had reached
+	if restore.Status.State == crv1.PgrestoreStateProcessed {
+		switch restore.Status.Phase {
+		case crv1.RESTORE_PHASE_COMPLETED, crv1.RESTORE_PHASE_FAILED:
+			log.Info("pgrestore " + restore.ObjectMeta.Name + " already processed")
+		default:
+			go restoreoperator.AddRestore(c.PgrestoreClientset, c.PgrestoreClient, restore, restore.ObjectMeta.Namespace)
+		}
+		return
+	}
+
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	// You can use restoreScheme.Copy() to make a deep copy of original object and modify this copy
+	// Or create a copy manually for better performance
+	copyObj, err := c.PgrestoreScheme.Copy(restore)
+	if err != nil {
+		fmt.Printf("ERROR creating a deep copy of restore object: %v\n", err)
+		return
+	}
+
+	restoreCopy := copyObj.(*crv1.Pgrestore)
+	restoreCopy.Status = crv1.PgrestoreStatus{
+		State:   crv1.PgrestoreStateProcessed,
+		Message: "Successfully processed Pgrestore by controller",
+		Phase:   crv1.RESTORE_PHASE_PENDING,
+	}
+
+	err = c.PgrestoreClient.Put().
+		Name(restore.ObjectMeta.Name).
+		Namespace(restore.ObjectMeta.Namespace).
+		Resource(crv1.PgrestoreResourcePlural).
+		Body(restoreCopy).
+		Do().
+		Error()
+
+	if err != nil {
+		fmt.Printf("ERROR updating status: %v\n", err)
+	} else {
+		fmt.Printf("UPDATED status: %#v\n", restoreCopy)
+	}
+
+	//a restore waits on the restored cluster so it runs on its own
+	go restoreoperator.AddRestore(c.PgrestoreClientset, c.PgrestoreClient, restoreCopy, restore.ObjectMeta.Namespace)
+}
+
+func (c *PgrestoreController) onUpdate(oldObj, newObj interface{}) {
+	//oldExample := oldObj.(*crv1.Pgrestore)
+	//newExample := newObj.(*crv1.Pgrestore)
+	//fmt.Printf("[PgrestoreCONTROLLER] OnUpdate oldObj: %s\n", oldExample.ObjectMeta.SelfLink)
+	//fmt.Printf("[PgrestoreCONTROLLER] OnUpdate newObj: %s\n", newExample.ObjectMeta.SelfLink)
+}
+
+func (c *PgrestoreController) onDelete(obj interface{}) {
+	restore := obj.(*crv1.Pgrestore)
+	fmt.Printf("[PgrestoreCONTROLLER] OnDelete %s\n", restore.ObjectMeta.SelfLink)
+}
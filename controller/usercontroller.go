@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"reflect"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	useroperator "github.com/crunchydata/kraken/operator/user"
+)
+
+// PguserController reconciles the roles of pgusers on their clusters
+type PguserController struct {
+	PguserClient    *rest.RESTClient
+	PguserClientset *kubernetes.Clientset
+	PguserScheme    *runtime.Scheme
+}
+
+// Run starts a Pguser resource controller
+func (c *PguserController) Run(ctx context.Context) error {
+	fmt.Print("Watch Pguser objects\n")
+
+	_, err := c.watchPgusers(ctx)
+	if err != nil {
+		fmt.Printf("Failed to register watch for Pguser resource: %v\n", err)
+		return err
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (c *PguserController) watchPgusers(ctx context.Context) (cache.Controller, error) {
+	source := cache.NewListWatchFromClient(
+		c.PguserClient,
+		crv1.PguserResourcePlural,
+		apiv1.NamespaceAll,
+		fields.Everything())
+
+	_, controller := cache.NewInformer(
+		source,
+
+		// The object type.
+		&crv1.Pguser{},
+
+		// resyncPeriod
+		// Every resyncPeriod, all resources in the cache will retrigger events.
+		// Set to 0 to disable the resync.
+		0,
+
+		// Your custom resource event handlers.
+		cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.onAdd,
+			UpdateFunc: c.onUpdate,
+			DeleteFunc: c.onDelete,
+		})
+
+	go controller.Run(ctx.Done())
+	return controller, nil
+}
+
+// onAdd reconciles every pguser including those already processed,
+// reconciling a role that is up to date changes nothing
+func (c *PguserController) onAdd(obj interface{}) {
+	user := obj.(*crv1.Pguser)
+	fmt.Printf("[PguserCONTROLLER] OnAdd %s\n", user.ObjectMeta.SelfLink)
+
+	//the clusters of a user may have to be waited on so it runs on its own
+	go useroperator.ReconcileUser(c.PguserClientset, c.PguserClient, user.ObjectMeta.Name, user.ObjectMeta.Namespace)
+}
+
+// onUpdate reconciles a pguser whose spec changed, updates made by the
+// operator to the status are ignored
+func (c *PguserController) onUpdate(oldObj, newObj interface{}) {
+	oldUser := oldObj.(*crv1.Pguser)
+	newUser := newObj.(*crv1.Pguser)
+	if reflect.DeepEqual(oldUser.Spec, newUser.Spec) {
+		return
+	}
+	log.Info("pguser " + newUser.ObjectMeta.Name + " changed")
+
+	go useroperator.ReconcileUser(c.PguserClientset, c.PguserClient, newUser.ObjectMeta.Name, newUser.ObjectMeta.Namespace)
+}
+
+func (c *PguserController) onDelete(obj interface{}) {
+	user := obj.(*crv1.Pguser)
+	fmt.Printf("[PguserCONTROLLER] OnDelete %s\n", user.ObjectMeta.SelfLink)
+
+	go useroperator.DeleteUser(c.PguserClientset, c.PguserClient, user, user.ObjectMeta.Namespace)
+}
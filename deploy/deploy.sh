@@ -21,8 +21,10 @@ $DIR/cleanup.sh
 
 $CO_CMD --namespace=$CO_NAMESPACE create configmap operator-conf \
 	--from-file=$COROOT/conf/postgres-operator/backup-job.json \
+	--from-file=$COROOT/conf/postgres-operator/backup-vault-init-container.json \
 	--from-file=$COROOT/conf/postgres-operator/pvc.json \
 	--from-file=$COROOT/conf/postgres-operator/pvc-storageclass.json \
+	--from-file=$COROOT/conf/postgres-operator/restore-clear-job.json \
 	--from-file=$COROOT/conf/postgres-operator/cluster/1
 
 envsubst < $DIR/deployment.json | $CO_CMD --namespace=$CO_NAMESPACE create -f -
@@ -21,3 +21,7 @@ if [ -z "$CO_CMD" ]; then
 fi
 
 
+if [ -z "$SECRET_STORE" ]; then
+	echo "SECRET_STORE not set, using kubernetes"
+	export SECRET_STORE=kubernetes
+fi
@@ -21,7 +21,10 @@ $CO_CMD delete pgclones --all
 $CO_CMD delete pgclusters --all
 $CO_CMD delete pgpolicies --all
 $CO_CMD delete pgpolicylogs --all
+$CO_CMD delete pgrestores --all
+$CO_CMD delete pgsecretlogs --all
 $CO_CMD delete pgupgrades --all
+$CO_CMD delete pgusers --all
 
 $CO_CMD delete crd \
 	examples.cr.client-go.k8s.io \
@@ -29,5 +32,8 @@ $CO_CMD delete crd \
 	pgclusters.cr.client-go.k8s.io \
 	pgpolicies.cr.client-go.k8s.io \
 	pgpolicylogs.cr.client-go.k8s.io \
-	pgupgrades.cr.client-go.k8s.io
+	pgrestores.cr.client-go.k8s.io \
+	pgsecretlogs.cr.client-go.k8s.io \
+	pgupgrades.cr.client-go.k8s.io \
+	pgusers.cr.client-go.k8s.io
 
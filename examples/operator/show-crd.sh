@@ -21,5 +21,8 @@ $CO_CMD get pgclones
 $CO_CMD get pgclusters
 $CO_CMD get pgpolicies 
 $CO_CMD get pgpolicylogs
+$CO_CMD get pgrestores
+$CO_CMD get pgsecretlogs
 $CO_CMD get pgupgrades
+$CO_CMD get pgusers
 
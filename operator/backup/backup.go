@@ -20,6 +20,8 @@ import (
 	"encoding/json"
 	log "github.com/Sirupsen/logrus"
 	"io/ioutil"
+	"os"
+	"strconv"
 	"text/template"
 	//"time"
 
@@ -39,20 +41,44 @@ import (
 )
 
 type JobTemplateFields struct {
-	Name             string
-	PVC_NAME         string
-	CCP_IMAGE_TAG    string
-	SECURITY_CONTEXT string
-	BACKUP_HOST      string
-	BACKUP_USER      string
-	BACKUP_PASS      string
-	BACKUP_PORT      string
+	Name              string
+	PVC_NAME          string
+	CCP_IMAGE_TAG     string
+	SECURITY_CONTEXT  string
+	BACKUP_HOST       string
+	BACKUP_USER       string
+	BACKUP_PORT       string
+	BACKUP_TARGET     string
+	RETENTION_DAYS    string
+	CO_IMAGE_TAG      string
+	COMPRESSION       string
+	COMPRESSION_LEVEL string
+	ENCRYPTED         string
+	ENCRYPTION_VOLUME string
+	ENCRYPTION_MOUNT  string
+	//a packed backup is taken into a scratch volume and packed onto
+	//the backup volume by the backupagent
+	BACKUP_VOLUME  string
+	SCRATCH_VOLUME string
+	SCRATCH_MOUNT  string
+	//env entries for the backupagent when the target is object storage
+	OBJECT_STORAGE_ENV string
+	//snippets that hand the master password to the backup container
+	BACKUP_PASS_ENV       string
+	BACKUP_COMMAND        string
+	SECRET_INIT_CONTAINER string
+	SECRET_VOLUME         string
+	SECRET_MOUNT          string
 }
 
 const JOB_PATH = "/operator-conf/backup-job.json"
 
 var JobTemplate *template.Template
 
+// COImageTag is the tag of the operator helper images (backupagent),
+// it is set from the operator's CO_IMAGE_TAG environment variable
+var COImageTag string
+
 func init() {
 	var err error
 	var buf []byte
@@ -64,6 +90,11 @@ func init() {
 	}
 	JobTemplate = template.Must(template.New("backup job template").Parse(string(buf)))
 
+	COImageTag = os.Getenv("CO_IMAGE_TAG")
+	if COImageTag == "" {
+		log.Error("CO_IMAGE_TAG env var is not set, backup jobs will not find the backupagent image")
+	}
+
 }
 
 func AddBackupBase(clientset *kubernetes.Clientset, client *rest.RESTClient, job *crv1.Pgbackup, namespace string) {
@@ -77,6 +108,27 @@ func AddBackupBase(clientset *kubernetes.Clientset, client *rest.RESTClient, job
 	log.Info("creating Pgbackup object" + " in namespace " + namespace)
 	log.Info("created with Name=" + job.Spec.Name + " in namespace " + namespace)
 
+	if job.Spec.BACKUP_TARGET == "" {
+		job.Spec.BACKUP_TARGET = crv1.BACKUP_TARGET_PVC
+	}
+	err = util.ValidateBackupTarget(job.Spec.BACKUP_TARGET, &job.Spec.ObjectStorage)
+	if err != nil {
+		log.Error("pgbackup " + job.Spec.Name + " " + err.Error())
+		return
+	}
+
+	err = util.ValidateBackupOptions(job.Spec.COMPRESSION, job.Spec.COMPRESSION_LEVEL)
+	if err != nil {
+		log.Error("pgbackup " + job.Spec.Name + " " + err.Error())
+		return
+	}
+
+	//an object storage backup is only staged on the pod before
+	//it is uploaded, so it does not need a PVC
+	if job.Spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+		job.Spec.StorageSpec.StorageType = crv1.STORAGE_EMPTYDIR
+	}
+
 	//create the PVC if necessary
 	var pvcName string
 	pvcName, err = pvc.CreatePVC(clientset, job.Spec.Name+"-backup", &job.Spec.StorageSpec, namespace)
@@ -87,18 +139,35 @@ func AddBackupBase(clientset *kubernetes.Clientset, client *rest.RESTClient, job
 	}
 
 	//update the pvc name in the TPR
-	err = util.Patch(client, "/spec/pvcname", pvcName, "pgbackups", job.Spec.Name, namespace)
+	err = util.Patch(client, "/spec/storagespec/pvcname", pvcName, "pgbackups", job.Spec.Name, namespace)
 
 	//create the job -
 	jobFields := JobTemplateFields{
-		Name:             job.Spec.Name,
-		PVC_NAME:         util.CreatePVCSnippet(job.Spec.StorageSpec.StorageType, pvcName),
-		CCP_IMAGE_TAG:    job.Spec.CCP_IMAGE_TAG,
-		SECURITY_CONTEXT: util.CreateSecContext(job.Spec.StorageSpec.FSGROUP, job.Spec.StorageSpec.SUPPLEMENTAL_GROUPS),
-		BACKUP_HOST:      job.Spec.BACKUP_HOST,
-		BACKUP_USER:      job.Spec.BACKUP_USER,
-		BACKUP_PASS:      job.Spec.BACKUP_PASS,
-		BACKUP_PORT:      job.Spec.BACKUP_PORT,
+		Name:               job.Spec.Name,
+		PVC_NAME:           util.CreatePVCSnippet(job.Spec.StorageSpec.StorageType, pvcName),
+		CCP_IMAGE_TAG:      job.Spec.CCP_IMAGE_TAG,
+		SECURITY_CONTEXT:   util.CreateSecContext(job.Spec.StorageSpec.FSGROUP, job.Spec.StorageSpec.SUPPLEMENTAL_GROUPS),
+		BACKUP_HOST:        job.Spec.BACKUP_HOST,
+		BACKUP_USER:        job.Spec.BACKUP_USER,
+		BACKUP_PORT:        job.Spec.BACKUP_PORT,
+		BACKUP_TARGET:      job.Spec.BACKUP_TARGET,
+		RETENTION_DAYS:     job.Spec.RETENTION_DAYS,
+		CO_IMAGE_TAG:       COImageTag,
+		COMPRESSION:        job.Spec.COMPRESSION,
+		COMPRESSION_LEVEL:  job.Spec.COMPRESSION_LEVEL,
+		ENCRYPTED:          strconv.FormatBool(job.Spec.ENCRYPTION_SECRET != ""),
+		ENCRYPTION_VOLUME:  util.CreateEncryptionVolume(job.Spec.ENCRYPTION_SECRET),
+		ENCRYPTION_MOUNT:   util.CreateEncryptionMount(job.Spec.ENCRYPTION_SECRET),
+		BACKUP_VOLUME:      util.GetBackupVolume(job.Spec.COMPRESSION, job.Spec.ENCRYPTION_SECRET),
+		SCRATCH_VOLUME:     util.CreateScratchVolume(job.Spec.COMPRESSION, job.Spec.ENCRYPTION_SECRET),
+		SCRATCH_MOUNT:      util.CreateScratchMount(job.Spec.COMPRESSION, job.Spec.ENCRYPTION_SECRET),
+		OBJECT_STORAGE_ENV: util.CreateObjectStorageEnv(job.Spec.BACKUP_TARGET, &job.Spec.ObjectStorage),
+	}
+
+	err = setSecretFields(&jobFields, getBackupSecret(job), namespace)
+	if err != nil {
+		log.Error("pgbackup " + job.Spec.Name + " " + err.Error())
+		return
 	}
 
 	var doc2 bytes.Buffer
@@ -16,8 +16,11 @@
 package backup
 
 import (
+	"encoding/json"
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	"os"
+	"strconv"
 
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	"github.com/crunchydata/kraken/util"
@@ -63,6 +66,19 @@ func ProcessJobs(clientset *kubernetes.Clientset, restclient *rest.RESTClient, n
 					log.Error("error in backup ProcessJobs " + err.Error())
 				}
 
+				result, err := GetAgentResult(clientset, gotjob.Name, namespace)
+				if err != nil {
+					log.Error("error getting backupagent result for " + gotjob.Name + " " + err.Error())
+				} else {
+					patchBackupSizes(restclient, &result, dbname, namespace)
+					err = util.Patch(restclient, "/spec/backuppath", result.BackupPath, "pgbackups", dbname, namespace)
+					if err != nil {
+						log.Error("error in backup ProcessJobs " + err.Error())
+					} else {
+						StartVerify(clientset, restclient, dbname, namespace)
+					}
+				}
+
 			}
 		default:
 			log.Infoln("backup job unknown watch event %v\n", event.Type)
@@ -76,3 +92,59 @@ func ProcessJobs(clientset *kubernetes.Clientset, restclient *rest.RESTClient, n
 	}
 
 }
+
+// AgentResult is what the backupagent container writes to its
+// termination log when it finishes
+type AgentResult struct {
+	BackupPath   string `json:"backuppath"`
+	OriginalSize int64  `json:"originalsize"`
+	StoredSize   int64  `json:"storedsize"`
+}
+
+// GetAgentResult reads the termination message of the backupagent
+// container of a finished backup job
+func GetAgentResult(clientset *kubernetes.Clientset, jobName, namespace string) (AgentResult, error) {
+	result := AgentResult{}
+
+	lo := meta_v1.ListOptions{LabelSelector: "job-name=" + jobName}
+	pods, err := clientset.CoreV1().Pods(namespace).List(lo)
+	if err != nil {
+		return result, err
+	}
+
+	for _, pod := range pods.Items {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.Name != "backupagent" || cs.State.Terminated == nil {
+				continue
+			}
+			if cs.State.Terminated.ExitCode != 0 {
+				continue
+			}
+			err = json.Unmarshal([]byte(cs.State.Terminated.Message), &result)
+			return result, err
+		}
+	}
+
+	return result, errors.New("no completed backupagent container found for job " + jobName)
+}
+
+// patchBackupSizes records the size of a backup before and after
+// compression and encryption, and the resulting compression ratio
+func patchBackupSizes(restclient *rest.RESTClient, result *AgentResult, name, namespace string) {
+	ratio := "1.00"
+	if result.StoredSize > 0 {
+		ratio = strconv.FormatFloat(float64(result.OriginalSize)/float64(result.StoredSize), 'f', 2, 64)
+	}
+
+	patches := map[string]string{
+		"/spec/backupsize":       strconv.FormatInt(result.OriginalSize, 10),
+		"/spec/storedsize":       strconv.FormatInt(result.StoredSize, 10),
+		"/spec/compressionratio": ratio,
+	}
+	for path, value := range patches {
+		err := util.Patch(restclient, path, value, "pgbackups", name, namespace)
+		if err != nil {
+			log.Error("error in backup ProcessJobs " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"bytes"
+	"text/template"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+)
+
+type SecretInitTemplateFields struct {
+	VAULT_IMAGE        string
+	VAULT_ADDR         string
+	VAULT_AUTH_PATH    string
+	VAULT_ROLE         string
+	VAULT_PATH         string
+	BACKUP_SECRET_NAME string
+}
+
+var SecretInitTemplate *template.Template
+
+// the crunchy-backup entrypoint, run once BACKUP_PASS is read from the
+// volume the Vault init container writes
+const backupEntrypoint = "/opt/cpm/bin/start-backupjob.sh"
+
+func init() {
+	SecretInitTemplate = util.LoadTemplate("/operator-conf/backup-vault-init-container.json")
+}
+
+// getBackupSecret returns the secret holding the master password of
+// the cluster a pgbackup is taken from, pgbackups created before the
+// secret was recorded default to the cluster master secret
+func getBackupSecret(job *crv1.Pgbackup) string {
+	if job.Spec.BACKUP_SECRET != "" {
+		return job.Spec.BACKUP_SECRET
+	}
+	return job.Spec.BACKUP_HOST + crv1.PGMASTER_SECRET_SUFFIX
+}
+
+// setSecretFields fills the job template fields that hand the master
+// password to the backup container, an env entry referencing the
+// Kubernetes secret or, when the credentials are in Vault, an init
+// container that writes the password to a memory backed volume the
+// backup container reads it from
+func setSecretFields(fields *JobTemplateFields, secretName, namespace string) error {
+	config := util.GetSecretStoreConfig()
+	if config.Backend != util.SECRET_STORE_VAULT {
+		var env bytes.Buffer
+		env.WriteString(", {\"name\": \"BACKUP_PASS\", \"valueFrom\": {\"secretKeyRef\": {")
+		env.WriteString("\"name\": " + util.QuoteJSON(secretName) + ", \"key\": \"password\"}}}")
+		fields.BACKUP_PASS_ENV = env.String()
+		return nil
+	}
+
+	initFields := SecretInitTemplateFields{}
+	initFields.VAULT_IMAGE = config.VaultImage
+	initFields.VAULT_ADDR = config.VaultAddr
+	initFields.VAULT_AUTH_PATH = config.VaultAuthPath
+	initFields.VAULT_ROLE = config.VaultDatabaseRole
+	initFields.VAULT_PATH = util.GetVaultPath(config, namespace)
+	initFields.BACKUP_SECRET_NAME = secretName
+
+	var doc bytes.Buffer
+	err := SecretInitTemplate.Execute(&doc, initFields)
+	if err != nil {
+		return err
+	}
+	fields.SECRET_INIT_CONTAINER = doc.String() + ", "
+	fields.SECRET_VOLUME = ", {\"name\": \"pgmaster-volume\", \"emptyDir\": {\"medium\": \"Memory\"}}"
+	fields.SECRET_MOUNT = ", {\"mountPath\": \"/pgmaster\", \"name\": \"pgmaster-volume\", \"readOnly\": true}"
+	fields.BACKUP_COMMAND = "\"command\": [\"/bin/sh\", \"-c\", " +
+		util.QuoteJSON("export BACKUP_PASS=$(cat /pgmaster/password) && exec "+backupEntrypoint) + "],"
+	return nil
+}
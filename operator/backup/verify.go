@@ -0,0 +1,326 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package backup
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strconv"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	"github.com/crunchydata/kraken/util"
+	_ "github.com/lib/pq"
+
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+const VERIFY_SUFFIX = "-verify"
+
+// default number of seconds to wait for the restored cluster
+const VERIFY_TIMEOUT_DEFAULT = 600
+
+// StartVerify starts the verification of a completed backup when the
+// pgbackup asks for it and it has not been verified yet
+func StartVerify(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) {
+	backup := crv1.Pgbackup{}
+	err := restclient.Get().
+		Resource(crv1.PgbackupResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&backup)
+	if err != nil {
+		log.Error("error in backup StartVerify " + err.Error())
+		return
+	}
+
+	if !backup.Spec.VERIFY || backup.Spec.VERIFY_STATUS != "" {
+		return
+	}
+
+	err = util.Patch(restclient, "/spec/verifystatus", crv1.BACKUP_VERIFY_RUNNING, crv1.PgbackupResourcePlural, name, namespace)
+	if err != nil {
+		log.Error("error in backup StartVerify " + err.Error())
+		return
+	}
+
+	go VerifyBackup(clientset, restclient, name, namespace)
+}
+
+// VerifyBackup restores a completed backup into a throwaway cluster
+// using emptydir storage, checks that every cluster user can connect,
+// runs the backup's SQL assertions and records the outcome on the
+// pgbackup before removing the throwaway cluster
+func VerifyBackup(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) {
+	start := time.Now()
+
+	status := crv1.BACKUP_VERIFY_PASSED
+	message := "restore, connectivity and assertions passed"
+
+	err := verify(clientset, restclient, name, namespace)
+	if err != nil {
+		status = crv1.BACKUP_VERIFY_FAILED
+		message = err.Error()
+	}
+	log.Info("verification of backup " + name + " " + status + " " + message)
+
+	patches := map[string]string{
+		"/spec/verifystatus":  status,
+		"/spec/verifymessage": message,
+		"/spec/verifydate":    start.Format(time.RFC3339),
+		"/spec/verifytime":    time.Since(start).String(),
+	}
+	for path, value := range patches {
+		err = util.Patch(restclient, path, value, crv1.PgbackupResourcePlural, name, namespace)
+		if err != nil {
+			log.Error("error in backup VerifyBackup " + err.Error())
+		}
+	}
+}
+
+func verify(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) error {
+	backup := crv1.Pgbackup{}
+	err := restclient.Get().
+		Resource(crv1.PgbackupResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&backup)
+	if err != nil {
+		return err
+	}
+
+	source := crv1.Pgcluster{}
+	err = restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(backup.Spec.BACKUP_HOST).
+		Do().
+		Into(&source)
+	if err != nil {
+		return errors.New("could not find backed up cluster " + backup.Spec.BACKUP_HOST + " " + err.Error())
+	}
+
+	if backup.Spec.BACKUP_PATH == "" {
+		return errors.New("the backup has no recorded backup path")
+	}
+
+	timeout := VERIFY_TIMEOUT_DEFAULT
+	if backup.Spec.VERIFY_TIMEOUT != "" {
+		timeout, err = strconv.Atoi(backup.Spec.VERIFY_TIMEOUT)
+		if err != nil {
+			return errors.New("invalid verify timeout " + backup.Spec.VERIFY_TIMEOUT)
+		}
+	}
+
+	cl := getVerifyCluster(&backup, &source)
+	err = restclient.Post().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Body(cl).
+		Do().
+		Error()
+	if err != nil {
+		return errors.New("could not create the restore cluster " + err.Error())
+	}
+	defer deleteVerifyCluster(restclient, cl.Spec.Name, namespace)
+
+	err = WaitForMaster(clientset, cl.Spec.Name, namespace, time.Duration(timeout)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	err = checkConnections(clientset, cl, namespace)
+	if err != nil {
+		return err
+	}
+
+	return checkAssertions(clientset, cl, backup.Spec.VerifyAsserts, namespace)
+}
+
+// getVerifyCluster describes the throwaway cluster a backup is
+// restored into, it reuses the secrets of the backed up cluster
+func getVerifyCluster(backup *crv1.Pgbackup, source *crv1.Pgcluster) *crv1.Pgcluster {
+	name := source.Spec.Name + VERIFY_SUFFIX
+
+	spec := crv1.PgclusterSpec{}
+	spec.Name = name
+	spec.ClusterName = name
+	spec.CCP_IMAGE_TAG = source.Spec.CCP_IMAGE_TAG
+	spec.Port = source.Spec.Port
+	spec.MasterStorage.StorageType = crv1.STORAGE_EMPTYDIR
+	spec.ReplicaStorage.StorageType = crv1.STORAGE_EMPTYDIR
+	spec.PG_MASTER_HOST = name
+	spec.PG_MASTER_USER = source.Spec.PG_MASTER_USER
+	spec.PG_USER = source.Spec.PG_USER
+	spec.PG_DATABASE = source.Spec.PG_DATABASE
+	spec.REPLICAS = "0"
+	spec.STRATEGY = source.Spec.STRATEGY
+	spec.SECRET_FROM = source.Spec.Name
+	spec.BACKUP_PATH = backup.Spec.BACKUP_PATH
+	spec.BACKUP_TARGET = backup.Spec.BACKUP_TARGET
+	if backup.Spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+		spec.BackupObjectStorage = backup.Spec.ObjectStorage
+	} else {
+		spec.BACKUP_PVC_NAME = backup.Spec.StorageSpec.PvcName
+	}
+	spec.BACKUP_COMPRESSION = backup.Spec.COMPRESSION
+	spec.BACKUP_ENCRYPTION_SECRET = backup.Spec.ENCRYPTION_SECRET
+	spec.PasswordPolicy = source.Spec.PasswordPolicy
+	spec.Auth = source.Spec.Auth
+	spec.PSW_LAST_UPDATE = time.Now().Format(time.RFC3339)
+
+	labels := make(map[string]string)
+	labels["name"] = name
+	labels["pgbackup-verify"] = backup.Spec.Name
+
+	return &crv1.Pgcluster{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   name,
+			Labels: labels,
+		},
+		Spec: spec,
+		Status: crv1.PgclusterStatus{
+			State:   crv1.PgclusterStateCreated,
+			Message: "Created, not processed yet",
+		},
+	}
+}
+
+// WaitForMaster waits until every container of a pod of the named
+// cluster master is ready
+func WaitForMaster(clientset *kubernetes.Clientset, name string, namespace string, timeout time.Duration) error {
+	lo := meta_v1.ListOptions{LabelSelector: "name=" + name}
+	deadline := time.Now().Add(timeout)
+
+	for time.Now().Before(deadline) {
+		pods, err := clientset.CoreV1().Pods(namespace).List(lo)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods.Items {
+			if podReady(&pod) {
+				return nil
+			}
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return errors.New("restored cluster " + name + " was not ready after " + timeout.String())
+}
+
+func podReady(pod *v1.Pod) bool {
+	if pod.Status.Phase != v1.PodRunning || len(pod.Status.ContainerStatuses) == 0 {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if !cs.Ready {
+			return false
+		}
+	}
+	return true
+}
+
+// checkConnections connects as each user holding a cluster secret,
+// the same checks pgo test performs
+func checkConnections(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, namespace string) error {
+	store, err := util.GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+	credentials, err := store.List(cl.Spec.Name)
+	if err != nil {
+		return err
+	}
+	if len(credentials) == 0 {
+		return errors.New("no secrets found for restored cluster " + cl.Spec.Name)
+	}
+
+	for _, s := range credentials {
+		username := s.Username
+		password := s.Password
+		database := "postgres"
+		if username == cl.Spec.PG_USER {
+			database = cl.Spec.PG_DATABASE
+		}
+
+		conn, err := sql.Open("postgres", sqlutil.ConnString(cl.Spec.Name, cl.Spec.Port, username, password, database))
+		if err != nil {
+			return errors.New("connection as " + username + " failed " + err.Error())
+		}
+		var ts string
+		err = conn.QueryRow("select now()::text").Scan(&ts)
+		conn.Close()
+		if err != nil {
+			return errors.New("connection as " + username + " failed " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// checkAssertions runs each assertion as the postgres user against
+// the cluster database, an assertion passes when it returns true
+func checkAssertions(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, asserts []string, namespace string) error {
+	if len(asserts) == 0 {
+		return nil
+	}
+
+	password, err := util.GetPasswordFromSecret(clientset, namespace, cl.Spec.Name+crv1.PGROOT_SECRET_SUFFIX)
+	if err != nil {
+		return err
+	}
+
+	conn, err := sql.Open("postgres", sqlutil.ConnString(cl.Spec.Name, cl.Spec.Port, "postgres", password, cl.Spec.PG_DATABASE))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for i, assert := range asserts {
+		var result bool
+		err = conn.QueryRow(assert).Scan(&result)
+		if err != nil {
+			return fmt.Errorf("assertion %d (%s) failed: %s", i+1, assert, err.Error())
+		}
+		if !result {
+			return fmt.Errorf("assertion %d (%s) returned false", i+1, assert)
+		}
+	}
+
+	return nil
+}
+
+func deleteVerifyCluster(restclient *rest.RESTClient, name string, namespace string) {
+	err := restclient.Delete().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error deleting restore verification cluster " + name + " " + err.Error())
+		return
+	}
+	log.Info("deleted restore verification cluster " + name)
+}
@@ -0,0 +1,315 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	"database/sql"
+	log "github.com/Sirupsen/logrus"
+	"os"
+	"text/template"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/operator/pvc"
+	"github.com/crunchydata/kraken/sqlutil"
+	"github.com/crunchydata/kraken/util"
+	_ "github.com/lib/pq"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const ARCHIVE_TIMEOUT_DEFAULT = "60"
+
+// how often the archive status of each cluster is read
+const ARCHIVE_STATUS_INTERVAL = 60 * time.Second
+
+// where the archive-init container installs the tools archiving WAL to
+// object storage in the database container, and the archive_command
+// that runs them
+const ARCHIVE_TOOLS_PATH = "/opt/archive-tools"
+const ARCHIVE_S3_COMMAND = ARCHIVE_TOOLS_PATH + "/archive-wal.sh %p %f"
+
+type ArchiveTemplateFields struct {
+	Name               string
+	CO_IMAGE_TAG       string
+	OBJECT_STORAGE_ENV string
+}
+
+type WalRestoreTemplateFields struct {
+	CO_IMAGE_TAG       string
+	RESTORE_FROM       string
+	WAL_TARGET         string
+	WAL_SOURCE_MOUNT   string
+	OBJECT_STORAGE_ENV string
+}
+
+var ArchiveInitTemplate1 *template.Template
+var WalRestoreInitTemplate1 *template.Template
+
+func init() {
+	ArchiveInitTemplate1 = util.LoadTemplate("/operator-conf/archive-init-container.json")
+	WalRestoreInitTemplate1 = util.LoadTemplate("/operator-conf/wal-restore-init-container.json")
+}
+
+// CreateArchivePVC creates the PVC WAL is archived to when a cluster
+// archives to a PVC, the PVC name is recorded on the pgcluster so a
+// later point-in-time recovery can find the archive
+func CreateArchivePVC(clientset *kubernetes.Clientset, client *rest.RESTClient, cl *crv1.Pgcluster, namespace string) error {
+	if !cl.Spec.Archive.Enabled || cl.Spec.Archive.Target != crv1.BACKUP_TARGET_PVC {
+		return nil
+	}
+
+	pvcName, err := pvc.CreatePVC(clientset, cl.Spec.Name+"-wal", &cl.Spec.Archive.Storage, namespace)
+	if err != nil {
+		return err
+	}
+	log.Debug("created archive pvc [" + pvcName + "]")
+	cl.Spec.Archive.Storage.PvcName = pvcName
+
+	return util.Patch(client, "/spec/archive/storage/pvcname", pvcName, crv1.PgclusterResourcePlural, cl.Spec.Name, namespace)
+}
+
+func GetArchiveMode(cl *crv1.Pgcluster) string {
+	if cl.Spec.Archive.Enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func GetArchiveTimeout(cl *crv1.Pgcluster) string {
+	if cl.Spec.Archive.Timeout == "" {
+		return ARCHIVE_TIMEOUT_DEFAULT
+	}
+	return cl.Spec.Archive.Timeout
+}
+
+// archiveStorageType is the storage type of the /pgwal volume, WAL
+// archived to object storage does not use it
+func archiveStorageType(cl *crv1.Pgcluster) string {
+	if cl.Spec.Archive.Enabled && cl.Spec.Archive.Target == crv1.BACKUP_TARGET_PVC && cl.Spec.Archive.Storage.PvcName != "" {
+		return cl.Spec.Archive.Storage.StorageType
+	}
+	return crv1.STORAGE_EMPTYDIR
+}
+
+// archivesToS3 is true when a cluster archives WAL to object storage
+func archivesToS3(cl *crv1.Pgcluster) bool {
+	return cl.Spec.Archive.Enabled && cl.Spec.Archive.Target == crv1.BACKUP_TARGET_S3
+}
+
+// GetArchiveInitContainer returns the init container that installs the
+// tools archive_command runs to upload WAL to object storage, or an
+// empty string if the cluster does not archive to s3
+func GetArchiveInitContainer(cl *crv1.Pgcluster) (string, error) {
+	if !archivesToS3(cl) {
+		return "", nil
+	}
+
+	fields := ArchiveTemplateFields{}
+	fields.Name = cl.Spec.Name
+	fields.CO_IMAGE_TAG = os.Getenv("CO_IMAGE_TAG")
+	fields.OBJECT_STORAGE_ENV = util.CreateObjectStorageEnv(cl.Spec.Archive.Target, &cl.Spec.Archive.ObjectStorage)
+
+	var doc bytes.Buffer
+	err := ArchiveInitTemplate1.Execute(&doc, fields)
+	if err != nil {
+		return "", err
+	}
+
+	return doc.String(), nil
+}
+
+// GetArchiveEnv returns the env entries archive_command needs in the
+// database container to reach the bucket WAL is archived to
+func GetArchiveEnv(cl *crv1.Pgcluster) string {
+	if !archivesToS3(cl) {
+		return ""
+	}
+	return util.CreateObjectStorageEnv(cl.Spec.Archive.Target, &cl.Spec.Archive.ObjectStorage)
+}
+
+// GetArchiveMount returns the mount of the archive tools in the
+// database container
+func GetArchiveMount(cl *crv1.Pgcluster) string {
+	if !archivesToS3(cl) {
+		return ""
+	}
+	return ", {\"mountPath\": \"" + ARCHIVE_TOOLS_PATH + "\", \"name\": \"archive-tools\", \"readOnly\": true}"
+}
+
+// GetArchiveVolume returns the volume the archive-init container
+// installs the archive tools in
+func GetArchiveVolume(cl *crv1.Pgcluster) string {
+	if !archivesToS3(cl) {
+		return ""
+	}
+	return ", {\"name\": \"archive-tools\", \"emptyDir\": {}}"
+}
+
+// ApplyArchiveCommand points the archive_command of the master of a
+// cluster archiving to object storage at the archive tools, so a WAL
+// segment is only taken as archived once it is stored in the bucket,
+// the setting is written with ALTER SYSTEM and reloaded
+func ApplyArchiveCommand(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusterName, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(clusterName).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return err
+	}
+	if !archivesToS3(&cl) {
+		return nil
+	}
+
+	target, err := util.GetPolicyTarget(clientset, namespace, clusterName)
+	if err != nil {
+		return err
+	}
+	changed, err := util.SetArchiveCommand(target, ARCHIVE_S3_COMMAND)
+	if err != nil {
+		return err
+	}
+	if changed {
+		log.Info("archive_command of " + clusterName + " set to " + ARCHIVE_S3_COMMAND)
+	}
+	return nil
+}
+
+// GetWalRestoreContainer returns the init container that copies the
+// archived WAL of the RESTORE_FROM cluster into the /recover volume
+func GetWalRestoreContainer(cl *crv1.Pgcluster) (string, error) {
+	fields := WalRestoreTemplateFields{}
+	fields.CO_IMAGE_TAG = os.Getenv("CO_IMAGE_TAG")
+	fields.RESTORE_FROM = cl.Spec.RESTORE_FROM
+	fields.WAL_TARGET = cl.Spec.RecoveryArchive.Target
+	if cl.Spec.RecoveryArchive.Target == crv1.BACKUP_TARGET_PVC {
+		fields.WAL_SOURCE_MOUNT = ", {\"mountPath\": \"/pgwal-source\", \"name\": \"pgwal-source\", \"readOnly\": true}"
+	}
+	fields.OBJECT_STORAGE_ENV = util.CreateObjectStorageEnv(cl.Spec.RecoveryArchive.Target, &cl.Spec.RecoveryArchive.ObjectStorage)
+
+	var doc bytes.Buffer
+	err := WalRestoreInitTemplate1.Execute(&doc, fields)
+	if err != nil {
+		return "", err
+	}
+
+	return doc.String(), nil
+}
+
+// GetRecoveryVolumes returns the volume holding the archive of the
+// RESTORE_FROM cluster when that archive is on a PVC
+func GetRecoveryVolumes(cl *crv1.Pgcluster) string {
+	if cl.Spec.PITR_TARGET == "" || cl.Spec.RecoveryArchive.Target != crv1.BACKUP_TARGET_PVC {
+		return ""
+	}
+
+	return ", {\"name\": \"pgwal-source\", \"persistentVolumeClaim\": {\"claimName\": " +
+		util.QuoteJSON(cl.Spec.RecoveryArchive.Storage.PvcName) + ", \"readOnly\": true}}"
+}
+
+// ProcessArchiveStatus periodically reads pg_stat_archiver on the
+// master of each cluster that archives WAL and records it in the
+// pgcluster status
+func ProcessArchiveStatus(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	ticker := time.NewTicker(ARCHIVE_STATUS_INTERVAL)
+	for range ticker.C {
+		clusterList := crv1.PgclusterList{}
+		err := restclient.Get().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(namespace).
+			Do().
+			Into(&clusterList)
+		if err != nil {
+			log.Error("error getting cluster list in ProcessArchiveStatus " + err.Error())
+			continue
+		}
+
+		for _, cl := range clusterList.Items {
+			if !cl.Spec.Archive.Enabled || cl.Spec.STATUS != crv1.UPGRADE_COMPLETED_STATUS {
+				continue
+			}
+			status := getArchiveStatus(clientset, &cl, namespace)
+			err = updateArchiveStatus(restclient, cl.Spec.Name, status, namespace)
+			if err != nil {
+				log.Error("error updating archive status of " + cl.Spec.Name + " " + err.Error())
+			}
+		}
+	}
+}
+
+func getArchiveStatus(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, namespace string) *crv1.PgArchiveStatus {
+	status := &crv1.PgArchiveStatus{}
+	status.CheckTime = time.Now().Format(time.RFC3339)
+
+	password, err := util.GetPasswordFromSecret(clientset, namespace, cl.Spec.Name+crv1.PGROOT_SECRET_SUFFIX)
+	if err != nil {
+		status.Message = "could not read postgres password " + err.Error()
+		return status
+	}
+
+	conn, err := sql.Open("postgres", sqlutil.ConnString(cl.Spec.Name, cl.Spec.Port, "postgres", password, "postgres"))
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+	defer conn.Close()
+
+	err = conn.QueryRow("select archived_count, coalesce(last_archived_wal, ''), coalesce(last_archived_time::text, ''), "+
+		"failed_count, coalesce(last_failed_wal, ''), coalesce(last_failed_time::text, '') from pg_stat_archiver").
+		Scan(&status.ArchivedCount, &status.LastArchivedWal, &status.LastArchivedTime,
+			&status.FailedCount, &status.LastFailedWal, &status.LastFailedTime)
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+
+	if status.FailedCount > 0 && status.LastFailedTime > status.LastArchivedTime {
+		status.Message = "archiving is failing, last failed wal " + status.LastFailedWal
+	} else {
+		status.Message = "archiving"
+	}
+
+	return status
+}
+
+func updateArchiveStatus(restclient *rest.RESTClient, name string, status *crv1.PgArchiveStatus, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return err
+	}
+
+	cl.Status.Archive = status
+
+	return restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&cl).
+		Do().
+		Error()
+}
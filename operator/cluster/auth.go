@@ -0,0 +1,237 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cluster
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	"github.com/crunchydata/kraken/util"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// the authentication settings of clusters are applied one at a time
+var authLock sync.Mutex
+
+// UpdateAuth applies the authentication settings of a cluster again
+// when they change
+func UpdateAuth(clientset *kubernetes.Clientset, client *rest.RESTClient, cl *crv1.Pgcluster, oldcluster *crv1.Pgcluster, namespace string) {
+	if reflect.DeepEqual(oldcluster.Spec.Auth, cl.Spec.Auth) {
+		return
+	}
+	log.Debug("detected change to the auth settings of " + cl.Spec.Name)
+	go ApplyAuth(clientset, client, cl.Spec.Name, namespace)
+}
+
+// authChanged is true when the auth settings of a cluster have not
+// been applied as they are now, or applying them failed
+func authChanged(restclient *rest.RESTClient, name string, namespace string) bool {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		log.Error("error getting pgcluster " + name + " in authChanged " + err.Error())
+		return false
+	}
+	status := cl.Status.Auth
+	return status == nil || status.State != crv1.AUTH_APPLIED || status.Hash != util.GetAuthHash(&cl.Spec)
+}
+
+// ApplyAuth brings the pg_hba.conf and password encryption of the
+// running pods of a cluster in line with its auth settings and records
+// the outcome in the pgcluster status, the passwords the operator holds
+// for the cluster are encrypted again when it switches to SCRAM
+func ApplyAuth(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) {
+	authLock.Lock()
+	defer authLock.Unlock()
+
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		log.Error("error getting pgcluster " + name + " in ApplyAuth " + err.Error())
+		return
+	}
+
+	status := crv1.PgAuthStatus{
+		PasswordEncryption: util.GetPasswordEncryption(&cl.Spec.Auth),
+		Hash:               util.GetAuthHash(&cl.Spec),
+		State:              crv1.AUTH_APPLIED,
+		Message:            "pg_hba.conf and password encryption applied",
+		UpdateDate:         time.Now().Format(time.RFC3339),
+	}
+	restart, err := applyAuth(clientset, &cl, namespace)
+	if err != nil {
+		log.Error("error applying the auth settings of " + name + " " + err.Error())
+		status.State = crv1.AUTH_FAILED
+		status.Message = err.Error()
+		if cl.Status.Auth != nil {
+			status.PasswordEncryption = cl.Status.Auth.PasswordEncryption
+			status.Hash = cl.Status.Auth.Hash
+		} else {
+			status.PasswordEncryption = ""
+			status.Hash = ""
+		}
+	} else {
+		log.Info("applied the auth settings of " + name)
+		if len(restart) > 0 {
+			status.Message = status.Message + ", " + strings.Join(restart, ", ") + " read pg_hba.conf from " + util.HBA_CONF_PATH + " once restarted"
+		}
+	}
+
+	cl.Status.Auth = &status
+	err = restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&cl).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating the auth status of " + name + " " + err.Error())
+	}
+}
+
+// applyAuth returns the pods that only read the pg_hba.conf of the
+// pgconf ConfigMap once they are restarted
+func applyAuth(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, namespace string) ([]string, error) {
+	restart := []string{}
+	err := util.ValidateAuthSpec(&cl.Spec.Auth)
+	if err != nil {
+		return restart, err
+	}
+
+	targets, err := util.GetPolicyTargets(clientset, namespace, cl.Spec.Name, crv1.POLICY_SCOPE_ALL)
+	if err != nil {
+		return restart, err
+	}
+	master := targets[0].SQL
+
+	encryption := util.GetPasswordEncryption(&cl.Spec.Auth)
+	version, err := util.GetServerVersion(master)
+	if err != nil {
+		return restart, err
+	}
+	if version >= util.SCRAM_SERVER_VERSION {
+		value, err := sqlutil.QuoteLiteral(encryption)
+		if err != nil {
+			return restart, err
+		}
+		_, err = util.ExecSQL(master, "ALTER SYSTEM SET password_encryption = "+value+";\nSELECT pg_reload_conf()", util.SQL_MODE_STATEMENTS)
+		if err != nil {
+			return restart, err
+		}
+	} else if encryption == sqlutil.PASSWORD_ENCRYPTION_SCRAM {
+		return restart, errors.New(sqlutil.PASSWORD_ENCRYPTION_SCRAM + " needs PostgreSQL 10 or later")
+	}
+
+	if encryption == sqlutil.PASSWORD_ENCRYPTION_SCRAM && (cl.Status.Auth == nil || cl.Status.Auth.PasswordEncryption != encryption) {
+		err = encryptPasswords(clientset, master, cl.Spec.Name, encryption, namespace)
+		if err != nil {
+			return restart, err
+		}
+	}
+
+	//the pods read pg_hba.conf from the ConfigMap, the previous file is
+	//put back when a pod refuses the new one
+	conf := util.GetHbaConf(&cl.Spec)
+	previous, err := util.SetAuthConfigMap(clientset, cl.Spec.Name, conf, namespace)
+	if err != nil {
+		return restart, err
+	}
+	deadline := time.Now().Add(util.HBA_SYNC_TIMEOUT)
+	for _, t := range targets {
+		pending, err := util.PointHbaFile(t.SQL)
+		if err == nil && !pending {
+			err = util.ReloadHbaConf(t.SQL, conf, deadline)
+		}
+		if err != nil {
+			if previous != "" {
+				_, restoreErr := util.SetAuthConfigMap(clientset, cl.Spec.Name, previous, namespace)
+				if restoreErr != nil {
+					log.Error("error putting back the pg_hba.conf of " + cl.Spec.Name + " " + restoreErr.Error())
+				}
+			}
+			return restart, errors.New("pg_hba.conf of " + t.Pod + " not updated " + err.Error())
+		}
+		if pending {
+			restart = append(restart, t.Pod)
+		}
+	}
+	return restart, nil
+}
+
+// encryptPasswords sets the password of each role whose password is
+// held in a secret of a cluster again, encrypted with encryption,
+// passwords stored as md5 can only be changed to SCRAM this way
+func encryptPasswords(clientset *kubernetes.Clientset, master util.SQLTarget, clusterName, encryption, namespace string) error {
+	store, err := util.GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+	credentials, err := store.List(clusterName)
+	if err != nil {
+		return err
+	}
+
+	statements := []string{}
+	for _, s := range credentials {
+		username := s.Username
+		password := s.Password
+		if username == "" || password == "" {
+			continue
+		}
+		exists, err := util.QueryBool(master, sqlutil.RoleExistsQuery, username)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			log.Debug("no role " + username + " for secret " + s.Name + ", its password is not encrypted again")
+			continue
+		}
+		stmt, err := sqlutil.SetRolePassword(username, password, encryption)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, stmt)
+	}
+	if len(statements) == 0 {
+		return nil
+	}
+
+	_, err = util.ExecSQL(master, sqlutil.Script(statements), util.SQL_MODE_ATOMIC)
+	if err != nil {
+		return errors.New("passwords not encrypted with " + encryption + " " + err.Error())
+	}
+	log.Info("encrypted " + strconv.Itoa(len(statements)) + " passwords of " + clusterName + " with " + encryption)
+	return nil
+}
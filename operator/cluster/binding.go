@@ -0,0 +1,60 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cluster
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// how often the binding secrets of each cluster are refreshed
+const BINDING_INTERVAL = 60 * time.Second
+
+// ProcessBindings periodically refreshes the binding secrets of each
+// cluster so they follow the passwords changed outside the operator,
+// such as with pgo user --change-password, and the replica service
+// coming and going
+func ProcessBindings(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	ticker := time.NewTicker(BINDING_INTERVAL)
+	for range ticker.C {
+		clusterList := crv1.PgclusterList{}
+		err := restclient.Get().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(namespace).
+			Do().
+			Into(&clusterList)
+		if err != nil {
+			log.Error("error getting cluster list in ProcessBindings " + err.Error())
+			continue
+		}
+
+		for _, cl := range clusterList.Items {
+			if cl.Spec.STATUS != crv1.UPGRADE_COMPLETED_STATUS {
+				continue
+			}
+			err = util.ApplyClusterBindings(clientset, restclient, cl.Spec.Name, namespace)
+			if err != nil {
+				log.Error("error refreshing the binding secrets of " + cl.Spec.Name + " " + err.Error())
+			}
+		}
+	}
+}
@@ -44,7 +44,7 @@ type ClusterStrategy interface {
 	MajorUpgrade(*kubernetes.Clientset, *rest.RESTClient, *crv1.Pgcluster, *crv1.Pgupgrade, string) error
 	MajorUpgradeFinalize(*kubernetes.Clientset, *rest.RESTClient, *crv1.Pgcluster, *crv1.Pgupgrade, string) error
 	PrepareClone(*kubernetes.Clientset, *rest.RESTClient, string, *crv1.Pgcluster, string) error
-	UpdatePolicyLabels(*kubernetes.Clientset, string, string, map[string]string) error
+	UpdatePolicyLabels(*kubernetes.Clientset, string, string, map[string]string, bool) error
 }
 
 type ServiceTemplateFields struct {
@@ -64,11 +64,22 @@ type DeploymentTemplateFields struct {
 	PVC_NAME             string
 	BACKUP_PVC_NAME      string
 	BACKUP_PATH          string
-	PGROOT_SECRET_NAME   string
-	PGUSER_SECRET_NAME   string
-	PGMASTER_SECRET_NAME string
+	SECRET_VOLUMES       string
+	PGCONF_CONFIGMAP     string
 	SECURITY_CONTEXT     string
 	NODE_SELECTOR        string
+	//set when restoring from a backup that must be staged first
+	INIT_CONTAINERS string
+	//continuous WAL archiving and point-in-time recovery
+	ARCHIVE_MODE     string
+	ARCHIVE_TIMEOUT  string
+	ARCHIVE_PVC_NAME string
+	ARCHIVE_ENV      string
+	ARCHIVE_MOUNT    string
+	ARCHIVE_VOLUME   string
+	RECOVERY_ENV     string
+	RECOVERY_VOLUMES string
+	RESTORE_VOLUMES  string
 	//next 2 are for the replica deployment only
 	REPLICAS       string
 	PG_MASTER_HOST string
@@ -97,22 +108,53 @@ func AddClusterBase(clientset *kubernetes.Clientset, client *rest.RESTClient, cl
 	pvcName, err := pvc.CreatePVC(clientset, cl.Spec.Name, &cl.Spec.MasterStorage, namespace)
 	log.Debug("created master pvc [" + pvcName + "]")
 
+	if cl.Spec.Archive.Enabled {
+		err = util.ValidateBackupTarget(cl.Spec.Archive.Target, &cl.Spec.Archive.ObjectStorage)
+		if err != nil {
+			log.Error("invalid archive settings for " + cl.Spec.Name + " " + err.Error())
+			return
+		}
+		err = CreateArchivePVC(clientset, client, cl, namespace)
+		if err != nil {
+			log.Error("error creating archive pvc " + err.Error())
+			return
+		}
+	}
+
 	log.Debug("creating Pgcluster object strategy is [" + cl.Spec.STRATEGY + "]")
 
-	var err1, err2, err3 error
-	if cl.Spec.SECRET_FROM != "" {
-		cl.Spec.PG_ROOT_PASSWORD, err1 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGROOT_SECRET_SUFFIX)
-		cl.Spec.PG_PASSWORD, err2 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGUSER_SECRET_SUFFIX)
-		cl.Spec.PG_MASTER_PASSWORD, err3 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGMASTER_SECRET_SUFFIX)
-		if err1 != nil || err2 != nil || err3 != nil {
-			log.Error("error getting secrets using SECRET_FROM " + cl.Spec.SECRET_FROM)
+	if cl.Spec.SECRET_FROM == cl.Spec.Name {
+		//restored in place, the secrets of the replaced cluster were kept
+		log.Info("using the existing secrets of " + cl.Spec.Name)
+		util.StripClusterPasswords(client, cl, namespace)
+	} else {
+		var err1, err2, err3 error
+		if cl.Spec.SECRET_FROM != "" {
+			cl.Spec.PG_ROOT_PASSWORD, err1 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGROOT_SECRET_SUFFIX)
+			cl.Spec.PG_PASSWORD, err2 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGUSER_SECRET_SUFFIX)
+			cl.Spec.PG_MASTER_PASSWORD, err3 = util.GetPasswordFromSecret(clientset, namespace, cl.Spec.SECRET_FROM+crv1.PGMASTER_SECRET_SUFFIX)
+			if err1 != nil || err2 != nil || err3 != nil {
+				log.Error("error getting secrets using SECRET_FROM " + cl.Spec.SECRET_FROM)
+				return
+			}
+		}
+
+		err = util.CreateDatabaseSecrets(clientset, client, cl, namespace)
+		util.StripClusterPasswords(client, cl, namespace)
+		if err != nil {
+			log.Error("error in create secrets " + err.Error())
 			return
 		}
 	}
 
-	err = util.CreateDatabaseSecrets(clientset, client, cl, namespace)
+	err = util.ValidateAuthSpec(&cl.Spec.Auth)
+	if err != nil {
+		log.Error("invalid auth settings for " + cl.Spec.Name + " " + err.Error())
+		return
+	}
+	err = util.ApplyAuthConfigMap(clientset, cl, namespace)
 	if err != nil {
-		log.Error("error in create secrets " + err.Error())
+		log.Error("error creating the pgconf configmap " + err.Error())
 		return
 	}
 
@@ -134,6 +176,11 @@ func AddClusterBase(clientset *kubernetes.Clientset, client *rest.RESTClient, cl
 
 	strategy.AddCluster(clientset, client, cl, namespace, pvcName)
 
+	err = util.ApplyClusterBindings(clientset, client, cl.Spec.Name, namespace)
+	if err != nil {
+		log.Error("error creating the binding secrets of " + cl.Spec.Name + " " + err.Error())
+	}
+
 	err = util.Patch(client, "/spec/status", crv1.UPGRADE_COMPLETED_STATUS, crv1.PgclusterResourcePlural, cl.Spec.Name, namespace)
 	if err != nil {
 		log.Error("error in status patch " + err.Error())
@@ -175,11 +222,20 @@ func DeleteClusterBase(clientset *kubernetes.Clientset, client *rest.RESTClient,
 		return
 	}
 
-	util.DeleteDatabaseSecrets(clientset, cl.Spec.Name, namespace)
+	if cl.ObjectMeta.Labels[crv1.KEEP_SECRETS_LABEL] == "true" {
+		log.Info("keeping the secrets of " + cl.Spec.Name)
+	} else {
+		util.DeleteDatabaseSecrets(clientset, cl.Spec.Name, namespace)
+	}
+	util.DeleteAuthConfigMap(clientset, cl.Spec.Name, namespace)
+	err := util.DeleteBindings(clientset, cl.Spec.Name, namespace)
+	if err != nil {
+		log.Error("error deleting the binding secrets of " + cl.Spec.Name + " " + err.Error())
+	}
 
 	strategy.DeleteCluster(clientset, client, cl, namespace)
 
-	err := client.Delete().
+	err = client.Delete().
 		Resource(crv1.PgupgradeResourcePlural).
 		Namespace(namespace).
 		Name(cl.Spec.Name).
@@ -261,6 +317,10 @@ func ScaleCluster(clientset *kubernetes.Clientset, client *rest.RESTClient, cl *
 		if newReps > 0 {
 			serviceName := cl.Spec.Name + "-replica"
 			ScaleReplicasBase(serviceName, clientset, cl, newReps, namespace)
+			err = util.ApplyClusterBindings(clientset, client, cl.Spec.Name, namespace)
+			if err != nil {
+				log.Error("error refreshing the binding secrets of " + cl.Spec.Name + " " + err.Error())
+			}
 		} else {
 			log.Error("scale to the same number does nothing")
 		}
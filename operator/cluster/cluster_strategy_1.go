@@ -33,7 +33,9 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 
+	"os"
 	"strconv"
+	"strings"
 
 	//"k8s.io/client-go/pkg/api"
 	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
@@ -51,9 +53,20 @@ type AffinityTemplateFields struct {
 	OPERATOR string
 }
 
+type RestoreInitTemplateFields struct {
+	CO_IMAGE_TAG       string
+	BACKUP_PATH        string
+	BACKUP_TARGET      string
+	COMPRESSION        string
+	ENCRYPTED          string
+	RESTORE_MOUNTS     string
+	OBJECT_STORAGE_ENV string
+}
+
 type ClusterStrategy1 struct{}
 
 var AffinityTemplate1 *template.Template
+var RestoreInitTemplate1 *template.Template
 var DeploymentTemplate1 *template.Template
 var ReplicaDeploymentTemplate1 *template.Template
 var ReplicaDeploymentTemplate1Shared *template.Template
@@ -67,6 +80,7 @@ func init() {
 	ReplicaDeploymentTemplate1Shared = util.LoadTemplate("/operator-conf/cluster-replica-deployment-1-shared.json")
 	DeploymentTemplate1 = util.LoadTemplate("/operator-conf/cluster-deployment-1.json")
 	AffinityTemplate1 = util.LoadTemplate("/operator-conf/affinity.json")
+	RestoreInitTemplate1 = util.LoadTemplate("/operator-conf/restore-init-container.json")
 }
 
 func (r ClusterStrategy1) AddCluster(clientset *kubernetes.Clientset, client *rest.RESTClient, cl *crv1.Pgcluster, namespace string, masterPvcName string) error {
@@ -92,6 +106,12 @@ func (r ClusterStrategy1) AddCluster(clientset *kubernetes.Clientset, client *re
 
 	masterLabels := getMasterLabels(cl.Spec.Name, cl.Spec.ClusterName, false, false, cl.Spec.UserLabels)
 
+	recoveryEnv, err := util.CreateRecoveryEnv(cl.Spec.PITR_TARGET)
+	if err != nil {
+		log.Error("invalid point-in-time recovery target " + err.Error())
+		return err
+	}
+
 	//create the master deployment
 	deploymentFields := DeploymentTemplateFields{
 		Name:                 cl.Spec.Name,
@@ -100,15 +120,24 @@ func (r ClusterStrategy1) AddCluster(clientset *kubernetes.Clientset, client *re
 		CCP_IMAGE_TAG:        cl.Spec.CCP_IMAGE_TAG,
 		PVC_NAME:             util.CreatePVCSnippet(cl.Spec.MasterStorage.StorageType, masterPvcName),
 		OPERATOR_LABELS:      util.GetLabelsFromMap(masterLabels),
-		BACKUP_PVC_NAME:      util.CreateBackupPVCSnippet(cl.Spec.BACKUP_PVC_NAME),
+		BACKUP_PVC_NAME:      util.CreateBackupPVCSnippet(getBackupPVCName(cl)),
 		BACKUP_PATH:          cl.Spec.BACKUP_PATH,
 		PGDATA_PATH_OVERRIDE: cl.Spec.Name,
 		PG_DATABASE:          cl.Spec.PG_DATABASE,
 		SECURITY_CONTEXT:     util.CreateSecContext(cl.Spec.MasterStorage.FSGROUP, cl.Spec.MasterStorage.SUPPLEMENTAL_GROUPS),
-		PGROOT_SECRET_NAME:   cl.Spec.PGROOT_SECRET_NAME,
-		PGMASTER_SECRET_NAME: cl.Spec.PGMASTER_SECRET_NAME,
-		PGUSER_SECRET_NAME:   cl.Spec.PGUSER_SECRET_NAME,
+		SECRET_VOLUMES:       GetSecretVolumes(cl),
+		PGCONF_CONFIGMAP:     util.GetAuthConfigMapName(cl.Spec.Name),
 		NODE_SELECTOR:        GetAffinity(cl.Spec.NodeName, "In"),
+		INIT_CONTAINERS:      GetInitContainers(cl, namespace),
+		ARCHIVE_MODE:         GetArchiveMode(cl),
+		ARCHIVE_TIMEOUT:      GetArchiveTimeout(cl),
+		ARCHIVE_PVC_NAME:     util.CreatePVCSnippet(archiveStorageType(cl), cl.Spec.Archive.Storage.PvcName),
+		ARCHIVE_ENV:          GetArchiveEnv(cl),
+		ARCHIVE_MOUNT:        GetArchiveMount(cl),
+		ARCHIVE_VOLUME:       GetArchiveVolume(cl),
+		RECOVERY_ENV:         recoveryEnv,
+		RECOVERY_VOLUMES:     GetRecoveryVolumes(cl),
+		RESTORE_VOLUMES:      GetRestoreVolumes(cl),
 	}
 
 	err = DeploymentTemplate1.Execute(&masterDoc, deploymentFields)
@@ -351,7 +380,7 @@ func (r ClusterStrategy1) PrepareClone(clientset *kubernetes.Clientset, restclie
 	}
 
 	//apply policy labels to new clone deployment
-	err = r.UpdatePolicyLabels(clientset, cloneName, namespace, polyLabels)
+	err = r.UpdatePolicyLabels(clientset, cloneName, namespace, polyLabels, false)
 	if err != nil {
 		log.Error("getPolicyLabels error updating poly labels")
 	}
@@ -372,7 +401,9 @@ func deploymentExists(clientset *kubernetes.Clientset, namespace, clusterName st
 	return true
 }
 
-func (r ClusterStrategy1) UpdatePolicyLabels(clientset *kubernetes.Clientset, clusterName string, namespace string, newLabels map[string]string) error {
+// UpdatePolicyLabels adds the policy labels to the deployment of a
+// cluster, or takes them off it when remove is true
+func (r ClusterStrategy1) UpdatePolicyLabels(clientset *kubernetes.Clientset, clusterName string, namespace string, newLabels map[string]string, remove bool) error {
 
 	var err error
 	var deployment *v1beta1.Deployment
@@ -402,7 +433,11 @@ func (r ClusterStrategy1) UpdatePolicyLabels(clientset *kubernetes.Clientset, cl
 
 	//update the deployment labels
 	for key, value := range newLabels {
-		objLabels[key] = value
+		if remove {
+			delete(objLabels, key)
+		} else {
+			objLabels[key] = value
+		}
 	}
 	log.Debugf("updated labels are %v\n", objLabels)
 
@@ -444,20 +479,20 @@ func (r ClusterStrategy1) CreateReplica(serviceName string, clientset *kubernete
 	replicaLabels := getMasterLabels(serviceName, clusterName, cloneFlag, true, cl.Spec.UserLabels)
 	//create the replica deployment
 	replicaDeploymentFields := DeploymentTemplateFields{
-		Name:                 depName,
-		ClusterName:          clusterName,
-		Port:                 cl.Spec.Port,
-		CCP_IMAGE_TAG:        cl.Spec.CCP_IMAGE_TAG,
-		PVC_NAME:             pvcName,
-		PG_MASTER_HOST:       cl.Spec.PG_MASTER_HOST,
-		PG_DATABASE:          cl.Spec.PG_DATABASE,
-		REPLICAS:             "1",
-		OPERATOR_LABELS:      util.GetLabelsFromMap(replicaLabels),
-		SECURITY_CONTEXT:     util.CreateSecContext(cl.Spec.ReplicaStorage.FSGROUP, cl.Spec.ReplicaStorage.SUPPLEMENTAL_GROUPS),
-		PGROOT_SECRET_NAME:   cl.Spec.PGROOT_SECRET_NAME,
-		PGMASTER_SECRET_NAME: cl.Spec.PGMASTER_SECRET_NAME,
-		PGUSER_SECRET_NAME:   cl.Spec.PGUSER_SECRET_NAME,
-		NODE_SELECTOR:        GetAffinity(cl.Spec.NodeName, "NotIn"),
+		Name:             depName,
+		ClusterName:      clusterName,
+		Port:             cl.Spec.Port,
+		CCP_IMAGE_TAG:    cl.Spec.CCP_IMAGE_TAG,
+		PVC_NAME:         pvcName,
+		PG_MASTER_HOST:   cl.Spec.PG_MASTER_HOST,
+		PG_DATABASE:      cl.Spec.PG_DATABASE,
+		REPLICAS:         "1",
+		OPERATOR_LABELS:  util.GetLabelsFromMap(replicaLabels),
+		SECURITY_CONTEXT: util.CreateSecContext(cl.Spec.ReplicaStorage.FSGROUP, cl.Spec.ReplicaStorage.SUPPLEMENTAL_GROUPS),
+		SECRET_VOLUMES:   GetSecretVolumes(cl),
+		PGCONF_CONFIGMAP: util.GetAuthConfigMapName(cl.Spec.Name),
+		NODE_SELECTOR:    GetAffinity(cl.Spec.NodeName, "NotIn"),
+		INIT_CONTAINERS:  GetSecretInitContainers(cl, namespace),
 	}
 
 	switch cl.Spec.ReplicaStorage.StorageType {
@@ -533,3 +568,107 @@ func GetAffinity(nodeName string, operator string) string {
 
 	return affinityDocString
 }
+
+// restoreNeedsStaging is true when the backup a cluster restores from
+// has to be downloaded or unpacked before the database container can
+// use it
+func restoreNeedsStaging(cl *crv1.Pgcluster) bool {
+	if cl.Spec.BACKUP_PATH == "" {
+		return false
+	}
+	return cl.Spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 ||
+		util.BackupIsPacked(cl.Spec.BACKUP_COMPRESSION, cl.Spec.BACKUP_ENCRYPTION_SECRET)
+}
+
+// getBackupPVCName is the PVC mounted on /backup, a staged restore
+// unpacks into an emptydir instead of the backup PVC
+func getBackupPVCName(cl *crv1.Pgcluster) string {
+	if restoreNeedsStaging(cl) {
+		return ""
+	}
+	return cl.Spec.BACKUP_PVC_NAME
+}
+
+// GetRestoreVolumes returns the volumes only the restore init container
+// uses, the backup PVC it unpacks from and the backup encryption key
+func GetRestoreVolumes(cl *crv1.Pgcluster) string {
+	if !restoreNeedsStaging(cl) {
+		return ""
+	}
+
+	output := ""
+	if cl.Spec.BACKUP_TARGET != crv1.BACKUP_TARGET_S3 && cl.Spec.BACKUP_PVC_NAME != "" {
+		output = ", {\"name\": \"backup-source\", \"persistentVolumeClaim\": {\"claimName\": " +
+			util.QuoteJSON(cl.Spec.BACKUP_PVC_NAME) + ", \"readOnly\": true}}"
+	}
+
+	return output + util.CreateEncryptionVolume(cl.Spec.BACKUP_ENCRYPTION_SECRET)
+}
+
+// GetInitContainers returns the initContainers of the master
+// deployment, these write the credentials held in Vault, stage a
+// backup held in object storage or packed by compression or
+// encryption, install the archive_command of a cluster archiving to
+// object storage and stage the WAL needed for a point-in-time
+// recovery, it returns an empty string when none is needed
+func GetInitContainers(cl *crv1.Pgcluster, namespace string) string {
+	containers := make([]string, 0)
+
+	secretContainer, err := GetSecretInitContainer(cl, namespace)
+	if err != nil {
+		log.Error(err.Error())
+		return ""
+	}
+	if secretContainer != "" {
+		containers = append(containers, secretContainer)
+	}
+
+	if restoreNeedsStaging(cl) {
+		fields := RestoreInitTemplateFields{}
+		fields.CO_IMAGE_TAG = os.Getenv("CO_IMAGE_TAG")
+		fields.BACKUP_PATH = cl.Spec.BACKUP_PATH
+		fields.BACKUP_TARGET = cl.Spec.BACKUP_TARGET
+		fields.COMPRESSION = cl.Spec.BACKUP_COMPRESSION
+		fields.ENCRYPTED = strconv.FormatBool(cl.Spec.BACKUP_ENCRYPTION_SECRET != "")
+		if cl.Spec.BACKUP_TARGET != crv1.BACKUP_TARGET_S3 && cl.Spec.BACKUP_PVC_NAME != "" {
+			fields.RESTORE_MOUNTS = ", {\"mountPath\": \"/backup-source\", \"name\": \"backup-source\", \"readOnly\": true}"
+		}
+		fields.RESTORE_MOUNTS += util.CreateEncryptionMount(cl.Spec.BACKUP_ENCRYPTION_SECRET)
+		fields.OBJECT_STORAGE_ENV = util.CreateObjectStorageEnv(cl.Spec.BACKUP_TARGET, &cl.Spec.BackupObjectStorage)
+
+		var doc bytes.Buffer
+		err := RestoreInitTemplate1.Execute(&doc, fields)
+		if err != nil {
+			log.Error(err.Error())
+			return ""
+		}
+		containers = append(containers, doc.String())
+	}
+
+	archiveContainer, err := GetArchiveInitContainer(cl)
+	if err != nil {
+		log.Error(err.Error())
+		return ""
+	}
+	if archiveContainer != "" {
+		containers = append(containers, archiveContainer)
+	}
+
+	if cl.Spec.PITR_TARGET != "" {
+		walContainer, err := GetWalRestoreContainer(cl)
+		if err != nil {
+			log.Error(err.Error())
+			return ""
+		}
+		containers = append(containers, walContainer)
+	}
+
+	if len(containers) == 0 {
+		return ""
+	}
+
+	output := "\"initContainers\": [" + strings.Join(containers, ", ") + "],"
+	log.Info(output)
+
+	return output
+}
@@ -0,0 +1,177 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cluster
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// how often applied policies are verified
+const POLICY_COMPLIANCE_INTERVAL = 5 * time.Minute
+
+// ProcessPolicyCompliance periodically runs the verification query of
+// each policy on every cluster labelled with the policy and records
+// on the pgpolicylog whether the cluster is still compliant
+func ProcessPolicyCompliance(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	ticker := time.NewTicker(POLICY_COMPLIANCE_INTERVAL)
+	for range ticker.C {
+		policyList := crv1.PgpolicyList{}
+		err := restclient.Get().
+			Resource(crv1.PgpolicyResourcePlural).
+			Namespace(namespace).
+			Do().
+			Into(&policyList)
+		if err != nil {
+			log.Error("error getting policy list in ProcessPolicyCompliance " + err.Error())
+			continue
+		}
+
+		for i := range policyList.Items {
+			checkPolicyCompliance(clientset, restclient, &policyList.Items[i], namespace)
+		}
+	}
+}
+
+func checkPolicyCompliance(clientset *kubernetes.Clientset, restclient *rest.RESTClient, policy *crv1.Pgpolicy, namespace string) {
+	verified := false
+	for _, v := range util.GetPolicyVersions(policy) {
+		verified = verified || v.VerifySql != ""
+	}
+	if !verified {
+		return
+	}
+
+	lo := meta_v1.ListOptions{LabelSelector: policy.Spec.Name + "=pgpolicy,!replica"}
+	deployments, err := clientset.ExtensionsV1beta1().Deployments(namespace).List(lo)
+	if err != nil {
+		log.Error("error getting deployments in checkPolicyCompliance " + err.Error())
+		return
+	}
+
+	for _, d := range deployments.Items {
+		clusterName := d.ObjectMeta.Name
+		spec, err := getAppliedPolicylog(restclient, policy.Spec.Name, clusterName, namespace)
+		if err != nil {
+			log.Error("error getting policylog of " + policy.Spec.Name + " on " + clusterName + " " + err.Error())
+			continue
+		}
+
+		version, ok := util.GetPolicyVersion(policy, util.AppliedPolicyVersion(spec))
+		if !ok || version.VerifySql == "" {
+			continue
+		}
+
+		query := util.RenderPolicySQL(version.VerifySql, spec.RenderedParameters)
+		spec.Compliance = verifyPolicy(clientset, clusterName, query, namespace)
+		if spec.Compliance.State == crv1.POLICY_DRIFTED {
+			log.Info("policy " + policy.Spec.Name + " has drifted on " + clusterName)
+		}
+
+		err = updatePolicyCompliance(restclient, namespace, spec)
+		if err != nil {
+			log.Error("error recording compliance of " + policy.Spec.Name + " on " + clusterName + " " + err.Error())
+		}
+	}
+}
+
+// getAppliedPolicylog returns the pgpolicylog of a policy applied to
+// a cluster, a cluster labelled before policy applications were
+// recorded is given the record of a completed apply
+func getAppliedPolicylog(restclient *rest.RESTClient, policyName, clusterName, namespace string) (*crv1.PgpolicylogSpec, error) {
+	policylog := crv1.Pgpolicylog{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(util.GetPolicylogName(policyName, clusterName, false)).
+		Do().
+		Into(&policylog)
+	if err == nil {
+		return &policylog.Spec, nil
+	} else if !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	spec := &crv1.PgpolicylogSpec{}
+	spec.PolicyName = policyName
+	spec.ClusterName = clusterName
+	spec.Username = "postgres-operator"
+	spec.Status = crv1.UPGRADE_COMPLETED_STATUS
+	return spec, nil
+}
+
+func verifyPolicy(clientset *kubernetes.Clientset, clusterName, query, namespace string) crv1.PgpolicyCompliance {
+	compliance := crv1.PgpolicyCompliance{}
+	compliance.CheckTime = time.Now().Format(time.RFC3339)
+	compliance.State = crv1.POLICY_COMPLIANCE_UNKNOWN
+
+	target, err := util.GetPolicyTarget(clientset, namespace, clusterName)
+	if err != nil {
+		compliance.Message = err.Error()
+		return compliance
+	}
+
+	ok, err := util.QueryBool(target, query)
+	if err != nil {
+		compliance.Message = "verification query failed " + err.Error()
+		return compliance
+	}
+
+	if ok {
+		compliance.State = crv1.POLICY_COMPLIANT
+		compliance.Message = "verification query returned true"
+	} else {
+		compliance.State = crv1.POLICY_DRIFTED
+		compliance.Message = "verification query returned false"
+	}
+	return compliance
+}
+
+// updatePolicyCompliance records the compliance of a cluster without
+// touching the rest of a pgpolicylog that may be updated meanwhile
+func updatePolicyCompliance(restclient *rest.RESTClient, namespace string, spec *crv1.PgpolicylogSpec) error {
+	name := util.GetPolicylogName(spec.PolicyName, spec.ClusterName, false)
+	policylog := crv1.Pgpolicylog{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&policylog)
+	if kerrors.IsNotFound(err) {
+		return recordPolicylog(restclient, namespace, spec)
+	} else if err != nil {
+		return err
+	}
+
+	policylog.Spec.Compliance = spec.Compliance
+
+	return restclient.Put().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&policylog).
+		Do().
+		Error()
+}
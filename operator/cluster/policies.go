@@ -18,15 +18,16 @@ package cluster
 import (
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/operator/user"
 	"github.com/crunchydata/kraken/util"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	//"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
-	//"k8s.io/client-go/pkg/api/v1"
-	"k8s.io/api/core/v1"
+	"k8s.io/client-go/pkg/api/v1"
 
 	"k8s.io/client-go/rest"
 	//"k8s.io/client-go/tools/cache"
@@ -35,37 +36,65 @@ import (
 	"time"
 )
 
+// ProcessPolicies watches the master and the replica pods of the
+// clusters, the policies of a cluster are applied to a pod the first
+// time it is seen becoming ready, a master also gets its
+// archive_command, auth settings and users then
 func ProcessPolicies(clientset *kubernetes.Clientset, restclient *rest.RESTClient, stopchan chan struct{}, namespace string) {
+	go watchPolicyPods(clientset, "pg-cluster,replica", namespace, func(pod *v1.Pod) {
+		applyReplicaPolicies(namespace, clientset, restclient, pod)
+	})
+
+	watchPolicyPods(clientset, "pg-cluster,master", namespace, func(pod *v1.Pod) {
+		clusterName := getClusterName(pod)
+		err := ApplyArchiveCommand(clientset, restclient, clusterName, namespace)
+		if err != nil {
+			log.Error("error setting the archive_command of " + clusterName + " " + err.Error())
+		}
+		applyPolicies(namespace, clientset, restclient, clusterName)
+		if authChanged(restclient, clusterName, namespace) {
+			ApplyAuth(clientset, restclient, clusterName, namespace)
+		}
+		user.ReconcileClusterUsers(clientset, restclient, clusterName, namespace)
+	})
+}
 
-	lo := meta_v1.ListOptions{LabelSelector: "pg-cluster,master"}
+// watchPolicyPods calls ready the first time a pod matching selector
+// is seen becoming ready, pods already ready when the watch starts are
+// taken as handled
+func watchPolicyPods(clientset *kubernetes.Clientset, selector string, namespace string, ready func(pod *v1.Pod)) {
+	lo := meta_v1.ListOptions{LabelSelector: selector}
 	fw, err := clientset.Core().Pods(namespace).Watch(lo)
 	if err != nil {
 		log.Error("fatal error in ProcessPolicies " + err.Error())
 		os.Exit(2)
 	}
 
+	//the pods seen ready, by uid so a recreated pod is new
+	readyPods := make(map[types.UID]bool)
+
 	_, err4 := watch.Until(0, fw, func(event watch.Event) (bool, error) {
 		log.Infof("got a processpolicies watch event %v\n", event.Type)
 
 		switch event.Type {
 		case watch.Added:
-			//deployment := event.Object.(*v1beta1.Deployment)
-			//log.Infof("deployment processpolicy added=%s\n", dep.Name)
+			pod := event.Object.(*v1.Pod)
+			if podIsReady, _ := podReady(pod); podIsReady {
+				readyPods[pod.UID] = true
+			}
 		case watch.Deleted:
-			//deployment := event.Object.(*v1beta1.Deployment)
-			//log.Infof("deployment processpolicy deleted=%s\n", deployment.Name)
+			pod := event.Object.(*v1.Pod)
+			delete(readyPods, pod.UID)
 		case watch.Error:
 			log.Infof("deployment processpolicy error event")
 		case watch.Modified:
 			pod := event.Object.(*v1.Pod)
-			//log.Infof("deployment processpolicy modified=%s\n", deployment.Name)
-			//log.Infof("status available replicas=%d\n", deployment.Status.AvailableReplicas)
-			ready, restarts := podReady(pod)
+			podIsReady, restarts := podReady(pod)
 			if restarts > 0 {
 				log.Info("restarts > 0, will not apply policies again to " + pod.Name)
-			} else if ready {
-				clusterName := getClusterName(pod)
-				applyPolicies(namespace, clientset, restclient, clusterName)
+			} else if podIsReady && !readyPods[pod.UID] {
+				readyPods[pod.UID] = true
+				ready(pod)
 			}
 
 		default:
@@ -107,17 +136,51 @@ func applyPolicies(namespace string, clientset *kubernetes.Clientset, restclient
 	log.Debug("policies to apply to " + clusterName + " are " + cl.Spec.Policies)
 	policies := strings.Split(cl.Spec.Policies, ",")
 
+	//apply each policy after the policies it depends on
+	catalog := make(map[string]*crv1.Pgpolicy)
+	for _, v := range policies {
+		policy := crv1.Pgpolicy{}
+		err = restclient.Get().
+			Resource(crv1.PgpolicyResourcePlural).
+			Namespace(namespace).
+			Name(v).
+			Do().
+			Into(&policy)
+		if err != nil {
+			log.Error("error getting policy " + v + " in policy processing " + err.Error())
+			continue
+		}
+		catalog[v] = &policy
+	}
+	policies, err = util.SortPolicies(policies, catalog)
+	if err != nil {
+		log.Error("error in policy processing " + err.Error())
+		return
+	}
+
 	//apply the policies
 	labels := make(map[string]string)
 
 	for _, v := range policies {
-		err = util.ExecPolicy(clientset, restclient, namespace, v, cl.Spec.Name)
+		spec := crv1.PgpolicylogSpec{}
+		spec.PolicyName = v
+		spec.ClusterName = cl.Spec.Name
+		spec.Username = "postgres-operator"
+		spec.RequestedFrom = crv1.POLICY_REQUEST_OPERATOR
+		spec.RequestDate = time.Now().Format(time.RFC3339)
+		spec.Action = crv1.POLICY_ACTION_APPLY
+		err = util.ExecPolicy(clientset, restclient, namespace, &spec)
 		if err != nil {
 			log.Error(err)
 		} else {
 			labels[v] = "pgpolicy"
 		}
 
+		//record the version applied so the cluster can be upgraded
+		err = recordPolicylog(restclient, namespace, &spec)
+		if err != nil {
+			log.Error("error recording policy " + v + " on " + cl.Spec.Name + " " + err.Error())
+		}
 	}
 
 	strategy, ok := StrategyMap[cl.Spec.STRATEGY]
@@ -128,7 +191,7 @@ func applyPolicies(namespace string, clientset *kubernetes.Clientset, restclient
 		return
 	}
 
-	err = strategy.UpdatePolicyLabels(clientset, clusterName, namespace, labels)
+	err = strategy.UpdatePolicyLabels(clientset, clusterName, namespace, labels, false)
 
 	//err = util.UpdateDeploymentLabels(clientset, clusterName, namespace, labels)
 	if err != nil {
@@ -136,28 +199,104 @@ func applyPolicies(namespace string, clientset *kubernetes.Clientset, restclient
 	}
 }
 
+// applyReplicaPolicies runs the policies applied to a cluster whose
+// scope includes replicas on a replica pod that has become ready and
+// has not run them yet
+func applyReplicaPolicies(namespace string, clientset *kubernetes.Clientset, restclient *rest.RESTClient, pod *v1.Pod) {
+	clusterName := getClusterName(pod)
+
+	policylogList := crv1.PgpolicylogList{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&policylogList)
+	if err != nil {
+		log.Error("error getting policylogs in applyReplicaPolicies " + err.Error())
+		return
+	}
+
+	for _, p := range policylogList.Items {
+		if p.Spec.ClusterName != clusterName || p.Spec.DryRun || util.AppliedPolicyVersion(&p.Spec) == 0 {
+			continue
+		}
+		if replicaPolicyApplied(&p.Spec, pod.Name) {
+			continue
+		}
+		policy := crv1.Pgpolicy{}
+		err = restclient.Get().
+			Resource(crv1.PgpolicyResourcePlural).
+			Namespace(namespace).
+			Name(p.Spec.PolicyName).
+			Do().
+			Into(&policy)
+		if err != nil || !util.PolicyScopeIncludesReplicas(policy.Spec.Scope) {
+			continue
+		}
+
+		spec := p.Spec
+		err = util.ExecPolicyOnReplica(clientset, restclient, namespace, &spec, pod)
+		if err != nil {
+			log.Error("policy " + spec.PolicyName + " failed on replica " + pod.Name + " " + err.Error())
+		}
+		err = updatePolicylog(restclient, p.ObjectMeta.Name, namespace, &spec)
+		if err != nil {
+			log.Error("error recording policy " + spec.PolicyName + " on replica " + pod.Name + " " + err.Error())
+		}
+	}
+}
+
+func replicaPolicyApplied(spec *crv1.PgpolicylogSpec, podName string) bool {
+	for _, instance := range spec.Instances {
+		if instance.Pod == podName && instance.Status == crv1.UPGRADE_COMPLETED_STATUS {
+			return true
+		}
+	}
+	return false
+}
+
 func AddPolicylog(clientset *kubernetes.Clientset, restclient *rest.RESTClient, policylog *crv1.Pgpolicylog, namespace string) {
-	policylogname := policylog.Spec.PolicyName + policylog.Spec.ClusterName
+	policylogname := policylog.ObjectMeta.Name
 	log.Infof("policylog added=%s\n", policylogname)
 
 	labels := make(map[string]string)
 
-	err := util.ExecPolicy(clientset, restclient, namespace, policylog.Spec.PolicyName, policylog.Spec.ClusterName)
+	spec := policylog.Spec
+	err := util.ExecPolicy(clientset, restclient, namespace, &spec)
 	if err != nil {
 		log.Error(err)
 	} else {
 		labels[policylog.Spec.PolicyName] = "pgpolicy"
 	}
 
+	//a dry run changes nothing so the labels are left alone, a
+	//removed policy has its label taken off
+	if !policylog.Spec.DryRun {
+		remove := policylog.Spec.Action == crv1.POLICY_ACTION_REMOVE
+		updatePolicyLabels(clientset, restclient, policylog.Spec.ClusterName, namespace, labels, remove)
+		if remove && err == nil {
+			removeClusterPolicy(restclient, policylog.Spec.ClusterName, policylog.Spec.PolicyName, namespace)
+		}
+	}
+
+	//update the policylog with applydate, status, version and result
+	err = updatePolicylog(restclient, policylogname, namespace, &spec)
+	if err != nil {
+		log.Error("error in policylog result update " + err.Error())
+	}
+
+}
+
+func updatePolicyLabels(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusterName string, namespace string, labels map[string]string, remove bool) {
 	cl := crv1.Pgcluster{}
-	err = restclient.Get().
+	err := restclient.Get().
 		Resource(crv1.PgclusterResourcePlural).
 		Namespace(namespace).
-		Name(policylog.Spec.ClusterName).
+		Name(clusterName).
 		Do().
 		Into(&cl)
 	if err != nil {
-		log.Error("error getting cluster crv1 in addPolicylog " + policylog.Spec.ClusterName)
+		log.Error("error getting cluster crv1 in addPolicylog " + clusterName)
 		return
 
 	}
@@ -169,26 +308,111 @@ func AddPolicylog(clientset *kubernetes.Clientset, restclient *rest.RESTClient,
 		return
 	}
 
-	err = strategy.UpdatePolicyLabels(clientset, policylog.Spec.ClusterName, namespace, labels)
-
 	//update the deployment's labels to show applied policies
-	//err = util.UpdateDeploymentLabels(clientset, policylog.Spec.ClusterName, namespace, labels)
+	err = strategy.UpdatePolicyLabels(clientset, clusterName, namespace, labels, remove)
 	if err != nil {
 		log.Error(err)
 	}
+}
+
+// removeClusterPolicy takes a removed policy out of the policies a
+// cluster was created with so it is not applied to the cluster again
+func removeClusterPolicy(restclient *rest.RESTClient, clusterName, policyName, namespace string) {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(clusterName).
+		Do().
+		Into(&cl)
+	if err != nil {
+		log.Error("error getting cluster " + clusterName + " in removeClusterPolicy " + err.Error())
+		return
+	}
+
+	policies := []string{}
+	for _, p := range strings.Split(cl.Spec.Policies, ",") {
+		if p != "" && p != policyName {
+			policies = append(policies, p)
+		}
+	}
+	if strings.Join(policies, ",") == cl.Spec.Policies {
+		return
+	}
 
-	//update the policylog with applydate and status
-	err = util.Patch(restclient, "/spec/status", crv1.UPGRADE_COMPLETED_STATUS, crv1.PgpolicylogResourcePlural, policylogname, namespace)
+	err = util.Patch(restclient, "/spec/policies", strings.Join(policies, ","), crv1.PgclusterResourcePlural, clusterName, namespace)
 	if err != nil {
-		log.Error("error in policylog status patch " + err.Error())
+		log.Error("error removing policy " + policyName + " from cluster " + clusterName + " " + err.Error())
 	}
+}
 
-	t := time.Now()
-	err = util.Patch(restclient, "/spec/applydate", t.Format("2006-01-02-15:04:05"), crv1.PgpolicylogResourcePlural, policylogname, namespace)
+func updatePolicylog(restclient *rest.RESTClient, name string, namespace string, spec *crv1.PgpolicylogSpec) error {
+	policylog := crv1.Pgpolicylog{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&policylog)
 	if err != nil {
-		log.Error("error in policylog applydate patch " + err.Error())
+		return err
 	}
 
+	policylog.Spec = *spec
+
+	return restclient.Put().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&policylog).
+		Do().
+		Error()
+}
+
+// recordPolicylog records a policy the operator applied itself, the
+// pgpolicylog is created as processed so it is not applied again and
+// an existing pgpolicylog keeps its earlier runs in its history
+func recordPolicylog(restclient *rest.RESTClient, namespace string, spec *crv1.PgpolicylogSpec) error {
+	name := util.GetPolicylogName(spec.PolicyName, spec.ClusterName, false)
+
+	existing := crv1.Pgpolicylog{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&existing)
+	if err == nil {
+		spec.History = util.GetPolicylogHistory(&existing.Spec)
+		existing.Spec = *spec
+		return restclient.Put().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(namespace).
+			Name(name).
+			Body(&existing).
+			Do().
+			Error()
+	} else if !kerrors.IsNotFound(err) {
+		return err
+	}
+
+	policylog := &crv1.Pgpolicylog{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name: name,
+		},
+		Spec: *spec,
+		Status: crv1.PgpolicylogStatus{
+			State:   crv1.PgpolicylogStateProcessed,
+			Message: "Applied by the operator",
+		},
+	}
+
+	return restclient.Post().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(namespace).
+		Body(policylog).
+		Do().
+		Error()
 }
 
 func podReady(pod *v1.Pod) (bool, int32) {
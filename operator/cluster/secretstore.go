@@ -0,0 +1,108 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package cluster
+
+import (
+	"bytes"
+	log "github.com/Sirupsen/logrus"
+	"text/template"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+)
+
+type SecretInitTemplateFields struct {
+	VAULT_IMAGE          string
+	VAULT_ADDR           string
+	VAULT_AUTH_PATH      string
+	VAULT_ROLE           string
+	VAULT_PATH           string
+	PGROOT_SECRET_NAME   string
+	PGMASTER_SECRET_NAME string
+	PGUSER_SECRET_NAME   string
+}
+
+var SecretInitTemplate1 *template.Template
+
+func init() {
+	SecretInitTemplate1 = util.LoadTemplate("/operator-conf/vault-secrets-init-container.json")
+}
+
+// GetSecretVolumes returns the pguser, pgmaster and pgroot volumes of
+// a database pod, each prefixed with a comma, the Kubernetes secrets
+// themselves or, when the credentials are in Vault, memory backed
+// volumes the init container of GetSecretInitContainer fills
+func GetSecretVolumes(cl *crv1.Pgcluster) string {
+	config := util.GetSecretStoreConfig()
+	volumes := []struct{ name, secretName string }{
+		{"pguser-volume", cl.Spec.PGUSER_SECRET_NAME},
+		{"pgmaster-volume", cl.Spec.PGMASTER_SECRET_NAME},
+		{"pgroot-volume", cl.Spec.PGROOT_SECRET_NAME},
+	}
+
+	var output bytes.Buffer
+	for _, v := range volumes {
+		output.WriteString(", {\"name\": " + util.QuoteJSON(v.name) + ", ")
+		if config.Backend == util.SECRET_STORE_VAULT {
+			output.WriteString("\"emptyDir\": {\"medium\": \"Memory\"}}")
+		} else {
+			output.WriteString("\"secret\": {\"secretName\": " + util.QuoteJSON(v.secretName) + "}}")
+		}
+	}
+	return output.String()
+}
+
+// GetSecretInitContainer returns the init container that logs in to
+// Vault with the pod service account and writes the cluster
+// credentials to the secret volumes, or an empty string when the
+// credentials are Kubernetes secrets
+func GetSecretInitContainer(cl *crv1.Pgcluster, namespace string) (string, error) {
+	config := util.GetSecretStoreConfig()
+	if config.Backend != util.SECRET_STORE_VAULT {
+		return "", nil
+	}
+
+	fields := SecretInitTemplateFields{}
+	fields.VAULT_IMAGE = config.VaultImage
+	fields.VAULT_ADDR = config.VaultAddr
+	fields.VAULT_AUTH_PATH = config.VaultAuthPath
+	fields.VAULT_ROLE = config.VaultDatabaseRole
+	fields.VAULT_PATH = util.GetVaultPath(config, namespace)
+	fields.PGROOT_SECRET_NAME = cl.Spec.PGROOT_SECRET_NAME
+	fields.PGMASTER_SECRET_NAME = cl.Spec.PGMASTER_SECRET_NAME
+	fields.PGUSER_SECRET_NAME = cl.Spec.PGUSER_SECRET_NAME
+
+	var doc bytes.Buffer
+	err := SecretInitTemplate1.Execute(&doc, fields)
+	if err != nil {
+		return "", err
+	}
+	return doc.String(), nil
+}
+
+// GetSecretInitContainers returns the initContainers of a deployment
+// that only needs the credentials written by GetSecretInitContainer
+func GetSecretInitContainers(cl *crv1.Pgcluster, namespace string) string {
+	container, err := GetSecretInitContainer(cl, namespace)
+	if err != nil {
+		log.Error(err.Error())
+		return ""
+	}
+	if container == "" {
+		return ""
+	}
+	return "\"initContainers\": [" + container + "],"
+}
@@ -82,9 +82,9 @@ func (r ClusterStrategy1) MinorUpgrade(clientset *kubernetes.Clientset, restclie
 		BACKUP_PVC_NAME:      util.CreateBackupPVCSnippet(cl.Spec.BACKUP_PVC_NAME),
 		BACKUP_PATH:          cl.Spec.BACKUP_PATH,
 		PGDATA_PATH_OVERRIDE: cl.Spec.Name,
-		PGROOT_SECRET_NAME:   cl.Spec.PGROOT_SECRET_NAME,
-		PGUSER_SECRET_NAME:   cl.Spec.PGUSER_SECRET_NAME,
-		PGMASTER_SECRET_NAME: cl.Spec.PGMASTER_SECRET_NAME,
+		SECRET_VOLUMES:       GetSecretVolumes(cl),
+		INIT_CONTAINERS:      GetSecretInitContainers(cl, namespace),
+		PGCONF_CONFIGMAP:     util.GetAuthConfigMapName(cl.Spec.Name),
 		PG_DATABASE:          cl.Spec.PG_DATABASE,
 		NODE_SELECTOR:        cl.Spec.NodeName,
 		SECURITY_CONTEXT:     util.CreateSecContext(cl.Spec.MasterStorage.FSGROUP, cl.Spec.MasterStorage.SUPPLEMENTAL_GROUPS),
@@ -205,9 +205,9 @@ func (r ClusterStrategy1) MajorUpgradeFinalize(clientset *kubernetes.Clientset,
 		PGDATA_PATH_OVERRIDE: upgrade.Spec.NEW_DATABASE_NAME,
 		PG_DATABASE:          cl.Spec.PG_DATABASE,
 		NODE_SELECTOR:        cl.Spec.NodeName,
-		PGROOT_SECRET_NAME:   cl.Spec.PGROOT_SECRET_NAME,
-		PGUSER_SECRET_NAME:   cl.Spec.PGUSER_SECRET_NAME,
-		PGMASTER_SECRET_NAME: cl.Spec.PGMASTER_SECRET_NAME,
+		SECRET_VOLUMES:       GetSecretVolumes(cl),
+		INIT_CONTAINERS:      GetSecretInitContainers(cl, namespace),
+		PGCONF_CONFIGMAP:     util.GetAuthConfigMapName(cl.Spec.Name),
 		SECURITY_CONTEXT:     util.CreateSecContext(cl.Spec.MasterStorage.FSGROUP, cl.Spec.MasterStorage.SUPPLEMENTAL_GROUPS),
 	}
 
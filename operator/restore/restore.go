@@ -0,0 +1,586 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package restore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"text/template"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/operator/backup"
+	"github.com/crunchydata/kraken/operator/pvc"
+	"github.com/crunchydata/kraken/util"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	v1batch "k8s.io/client-go/pkg/apis/batch/v1"
+	"k8s.io/client-go/rest"
+)
+
+// how long a restore waits for the restored cluster to be ready
+const RESTORE_TIMEOUT = 30 * time.Minute
+
+// how long an in-place restore waits for the old cluster to be removed
+const STOP_TIMEOUT = 5 * time.Minute
+
+// suffix of the job that clears the data directory of a cluster on
+// existing storage that is restored in place
+const CLEAR_JOB_SUFFIX = "-restore-clear"
+
+const CLEAR_JOB_PATH = "/operator-conf/restore-clear-job.json"
+
+var ClearJobTemplate *template.Template
+
+type ClearJobTemplateFields struct {
+	Name             string
+	RESTORE_NAME     string
+	PVC_NAME         string
+	DATA_PATH        string
+	CCP_IMAGE_TAG    string
+	SECURITY_CONTEXT string
+}
+
+func init() {
+	ClearJobTemplate = util.LoadTemplate(CLEAR_JOB_PATH)
+}
+
+// AddRestore validates a pgrestore, stops the target cluster of an
+// in-place restore, then creates the restored cluster and waits for
+// it to be ready, each step is recorded as a phase of the pgrestore
+// and a restore interrupted by an operator restart resumes from the
+// phase it had reached
+func AddRestore(clientset *kubernetes.Clientset, restclient *rest.RESTClient, restore *crv1.Pgrestore, namespace string) {
+	var spec crv1.PgclusterSpec
+	name := restore.Spec.Name
+	phase := restore.Status.Phase
+
+	switch phase {
+	case "", crv1.RESTORE_PHASE_PENDING, crv1.RESTORE_PHASE_VALIDATING:
+		setPhase(restclient, name, crv1.RESTORE_PHASE_VALIDATING, "validating restore of "+restore.Spec.TargetCluster, namespace)
+
+		cl, err := getRestoreCluster(clientset, restclient, restore, namespace)
+		if err != nil {
+			setPhase(restclient, name, crv1.RESTORE_PHASE_FAILED, err.Error(), namespace)
+			return
+		}
+		spec = cl.Spec
+
+		//the resolved spec is what a resumed restore recreates the
+		//cluster from once an in-place target has been removed
+		err = recordSource(restclient, name, &spec, namespace)
+		if err != nil {
+			setPhase(restclient, name, crv1.RESTORE_PHASE_FAILED, "could not record the source of the restore "+err.Error(), namespace)
+			return
+		}
+		phase = crv1.RESTORE_PHASE_STOPPING
+	case crv1.RESTORE_PHASE_STOPPING, crv1.RESTORE_PHASE_PROVISIONING, crv1.RESTORE_PHASE_RESTORING:
+		log.Info("resuming pgrestore " + name + " in phase " + phase)
+		spec = restore.Spec.ClusterSpec
+	default:
+		log.Info("pgrestore " + name + " already " + phase)
+		return
+	}
+
+	if phase == crv1.RESTORE_PHASE_STOPPING {
+		if restore.Spec.InPlace {
+			setPhase(restclient, name, crv1.RESTORE_PHASE_STOPPING, "stopping cluster "+spec.Name, namespace)
+			err := stopCluster(clientset, restclient, name, &spec, namespace)
+			if err != nil {
+				setPhase(restclient, name, crv1.RESTORE_PHASE_FAILED, err.Error(), namespace)
+				return
+			}
+		}
+		phase = crv1.RESTORE_PHASE_PROVISIONING
+	}
+
+	if phase == crv1.RESTORE_PHASE_PROVISIONING {
+		setPhase(restclient, name, crv1.RESTORE_PHASE_PROVISIONING, "creating cluster "+spec.Name, namespace)
+		err := restclient.Post().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(namespace).
+			Body(getCluster(name, &spec)).
+			Do().
+			Error()
+		if err != nil && !kerrors.IsAlreadyExists(err) {
+			setPhase(restclient, name, crv1.RESTORE_PHASE_FAILED, "could not create cluster "+spec.Name+" "+err.Error(), namespace)
+			return
+		}
+	}
+
+	setPhase(restclient, name, crv1.RESTORE_PHASE_RESTORING, "restoring "+spec.BACKUP_PATH+" into "+spec.Name, namespace)
+	err := backup.WaitForMaster(clientset, spec.Name, namespace, RESTORE_TIMEOUT)
+	if err != nil {
+		setPhase(restclient, name, crv1.RESTORE_PHASE_FAILED, err.Error(), namespace)
+		return
+	}
+
+	setPhase(restclient, name, crv1.RESTORE_PHASE_COMPLETED, "cluster "+spec.Name+" restored from "+spec.BACKUP_PATH, namespace)
+}
+
+// getRestoreCluster describes the cluster a backup is restored into,
+// for an in-place restore it is the spec of the cluster being replaced
+func getRestoreCluster(clientset *kubernetes.Clientset, restclient *rest.RESTClient, restore *crv1.Pgrestore, namespace string) (*crv1.Pgcluster, error) {
+	var spec crv1.PgclusterSpec
+	targetName := restore.Spec.TargetCluster
+
+	if targetName == "" {
+		return nil, errors.New("a target cluster is required")
+	}
+
+	target := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(targetName).
+		Do().
+		Into(&target)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return nil, err
+	}
+	found := err == nil
+
+	if restore.Spec.InPlace {
+		if !found {
+			return nil, errors.New("cluster " + targetName + " does not exist so it can not be restored in place")
+		}
+		if restore.Spec.Confirm != targetName {
+			return nil, errors.New("an in-place restore replaces the data of " + targetName + " and must be confirmed with the cluster name")
+		}
+		spec, err = getInPlaceSpec(clientset, &target, namespace)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if found {
+			return nil, errors.New("cluster " + targetName + " already exists, restore into a new cluster or restore in place")
+		}
+		spec = restore.Spec.ClusterSpec
+		spec.Name = targetName
+		spec.ClusterName = targetName
+		spec.PG_MASTER_HOST = targetName
+		spec.SECRET_FROM = restore.Spec.SECRET_FROM
+		if spec.SECRET_FROM == "" {
+			return nil, errors.New("the cluster to take secrets from is required when restoring into a new cluster")
+		}
+		_, err = util.GetPasswordFromSecret(clientset, namespace, spec.SECRET_FROM+crv1.PGROOT_SECRET_SUFFIX)
+		if err != nil {
+			return nil, errors.New("secrets of " + spec.SECRET_FROM + " not found " + err.Error())
+		}
+	}
+
+	err = setRestoreSource(restclient, restore, &spec, namespace)
+	if err != nil {
+		return nil, err
+	}
+	err = validateSource(clientset, restore.Spec.InPlace, &spec, namespace)
+	if err != nil {
+		return nil, err
+	}
+	spec.STATUS = ""
+	spec.PSW_LAST_UPDATE = time.Now().Format(time.RFC3339)
+
+	return getCluster(restore.Spec.Name, &spec), nil
+}
+
+// getCluster is the pgcluster a restore creates from the resolved spec
+func getCluster(restoreName string, spec *crv1.PgclusterSpec) *crv1.Pgcluster {
+	labels := make(map[string]string)
+	labels["name"] = spec.Name
+	labels["pgrestore"] = restoreName
+
+	return &crv1.Pgcluster{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   spec.Name,
+			Labels: labels,
+		},
+		Spec: *spec,
+		Status: crv1.PgclusterStatus{
+			State:   crv1.PgclusterStateCreated,
+			Message: "Created, not processed yet",
+		},
+	}
+}
+
+// getInPlaceSpec copies the spec of a cluster being restored in place,
+// the secrets of the cluster are kept when it is stopped so the
+// restored cluster takes its passwords from them
+func getInPlaceSpec(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, namespace string) (crv1.PgclusterSpec, error) {
+	spec := cl.Spec
+
+	_, err := util.GetPasswordFromSecret(clientset, namespace, cl.Spec.Name+crv1.PGROOT_SECRET_SUFFIX)
+	if err != nil {
+		return spec, errors.New("secrets of " + cl.Spec.Name + " not found " + err.Error())
+	}
+	spec.PG_ROOT_PASSWORD = ""
+	spec.PG_MASTER_PASSWORD = ""
+	spec.PG_PASSWORD = ""
+	spec.SECRET_FROM = cl.Spec.Name
+
+	//the archive PVC is kept and reused by the restored cluster
+	if spec.Archive.Enabled && spec.Archive.Storage.PvcName != "" {
+		spec.Archive.Storage.StorageType = crv1.STORAGE_EXISTING
+	}
+
+	return spec, nil
+}
+
+// setRestoreSource fills in the backup a cluster is restored from,
+// either the pgbackup named by the restore or a backup path, and the
+// archive of the RESTORE_FROM cluster for a point-in-time recovery
+func setRestoreSource(restclient *rest.RESTClient, restore *crv1.Pgrestore, spec *crv1.PgclusterSpec, namespace string) error {
+	spec.BACKUP_TARGET = ""
+	spec.BACKUP_PVC_NAME = ""
+	spec.BACKUP_PATH = ""
+	spec.BackupObjectStorage = crv1.PgObjectStorageSpec{}
+	spec.BACKUP_COMPRESSION = ""
+	spec.BACKUP_ENCRYPTION_SECRET = ""
+	spec.RESTORE_FROM = ""
+	spec.PITR_TARGET = ""
+	spec.RecoveryArchive = crv1.PgArchiveSpec{}
+
+	if restore.Spec.BackupName != "" {
+		b := crv1.Pgbackup{}
+		err := restclient.Get().
+			Resource(crv1.PgbackupResourcePlural).
+			Namespace(namespace).
+			Name(restore.Spec.BackupName).
+			Do().
+			Into(&b)
+		if err != nil {
+			return errors.New("could not find pgbackup " + restore.Spec.BackupName + " " + err.Error())
+		}
+		if b.Spec.BACKUP_STATUS != crv1.UPGRADE_COMPLETED_STATUS {
+			return errors.New("pgbackup " + restore.Spec.BackupName + " has not completed")
+		}
+		spec.BACKUP_TARGET = b.Spec.BACKUP_TARGET
+		spec.BACKUP_PATH = b.Spec.BACKUP_PATH
+		if b.Spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+			spec.BackupObjectStorage = b.Spec.ObjectStorage
+		} else {
+			spec.BACKUP_PVC_NAME = b.Spec.StorageSpec.PvcName
+		}
+		spec.BACKUP_COMPRESSION = b.Spec.COMPRESSION
+		spec.BACKUP_ENCRYPTION_SECRET = b.Spec.ENCRYPTION_SECRET
+	} else {
+		spec.BACKUP_TARGET = restore.Spec.BACKUP_TARGET
+		if spec.BACKUP_TARGET == "" {
+			spec.BACKUP_TARGET = crv1.BACKUP_TARGET_PVC
+		}
+		spec.BACKUP_PATH = restore.Spec.BACKUP_PATH
+		spec.BACKUP_PVC_NAME = restore.Spec.BACKUP_PVC_NAME
+		spec.BackupObjectStorage = restore.Spec.ObjectStorage
+		spec.BACKUP_COMPRESSION = restore.Spec.COMPRESSION
+		spec.BACKUP_ENCRYPTION_SECRET = restore.Spec.ENCRYPTION_SECRET
+		if spec.BACKUP_TARGET == crv1.BACKUP_TARGET_PVC && spec.BACKUP_PVC_NAME == "" {
+			return errors.New("a backup pvc is required to restore a backup path from the pvc backup target")
+		}
+	}
+
+	if spec.BACKUP_PATH == "" {
+		return errors.New("a backup path is required")
+	}
+	err := util.ValidateBackupTarget(spec.BACKUP_TARGET, &spec.BackupObjectStorage)
+	if err != nil {
+		return err
+	}
+
+	if restore.Spec.PITR_TARGET == "" {
+		return nil
+	}
+
+	_, err = util.ParseRecoveryTarget(restore.Spec.PITR_TARGET)
+	if err != nil {
+		return err
+	}
+	if restore.Spec.RESTORE_FROM == "" {
+		return errors.New("the cluster whose archived WAL is replayed is required for a point-in-time recovery")
+	}
+	source := crv1.Pgcluster{}
+	err = restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(restore.Spec.RESTORE_FROM).
+		Do().
+		Into(&source)
+	if err != nil {
+		return errors.New("could not find cluster " + restore.Spec.RESTORE_FROM + " " + err.Error())
+	}
+	if !source.Spec.Archive.Enabled {
+		return errors.New(restore.Spec.RESTORE_FROM + " does not archive WAL, it can only be restored to the time of its backup")
+	}
+	spec.RESTORE_FROM = restore.Spec.RESTORE_FROM
+	spec.PITR_TARGET = restore.Spec.PITR_TARGET
+	spec.RecoveryArchive = source.Spec.Archive
+
+	return nil
+}
+
+// validateSource checks that the backup a cluster is restored from
+// can be read before an in-place restore removes anything, the backup
+// PVC must exist and not be the master PVC that is removed, and the
+// secrets it is read with must exist
+func validateSource(clientset *kubernetes.Clientset, inPlace bool, spec *crv1.PgclusterSpec, namespace string) error {
+	if spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+		_, err := clientset.CoreV1().Secrets(namespace).Get(spec.BackupObjectStorage.SecretName, meta_v1.GetOptions{})
+		if err != nil {
+			return errors.New("object storage secret " + spec.BackupObjectStorage.SecretName + " not found " + err.Error())
+		}
+	} else {
+		if !pvc.Exists(clientset, spec.BACKUP_PVC_NAME, namespace) {
+			return errors.New("backup pvc " + spec.BACKUP_PVC_NAME + " not found")
+		}
+		if inPlace && removesMasterPVC(spec) && spec.BACKUP_PVC_NAME == masterPVCName(spec) {
+			return errors.New("backup pvc " + spec.BACKUP_PVC_NAME + " is the master pvc of " + spec.Name + " which an in-place restore removes")
+		}
+	}
+
+	if spec.BACKUP_ENCRYPTION_SECRET != "" {
+		_, err := clientset.CoreV1().Secrets(namespace).Get(spec.BACKUP_ENCRYPTION_SECRET, meta_v1.GetOptions{})
+		if err != nil {
+			return errors.New("backup encryption secret " + spec.BACKUP_ENCRYPTION_SECRET + " not found " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// stopCluster removes a cluster being restored in place, keeping its
+// secrets, and waits until it is gone so the restored cluster can take
+// its name, the master PVC is removed when the operator created it and
+// otherwise the data directory on the existing PVC is cleared, each
+// step can be repeated when a restore is resumed
+func stopCluster(clientset *kubernetes.Clientset, restclient *rest.RESTClient, restoreName string, spec *crv1.PgclusterSpec, namespace string) error {
+	err := keepSecrets(restclient, spec.Name, namespace)
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.New("could not keep the secrets of cluster " + spec.Name + " " + err.Error())
+	}
+
+	//the secrets were kept before the cluster was deleted, so a
+	//cluster that is already gone is being resumed
+	if err == nil {
+		err = restclient.Delete().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(namespace).
+			Name(spec.Name).
+			Do().
+			Error()
+		if err != nil && !kerrors.IsNotFound(err) {
+			return errors.New("could not delete cluster " + spec.Name + " " + err.Error())
+		}
+	}
+
+	deadline := time.Now().Add(STOP_TIMEOUT)
+	for !clusterRemoved(clientset, spec.Name, namespace) {
+		if time.Now().After(deadline) {
+			return errors.New("cluster " + spec.Name + " was not removed after " + STOP_TIMEOUT.String())
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	pvcName := masterPVCName(spec)
+	if !removesMasterPVC(spec) {
+		return clearData(clientset, restoreName, pvcName, spec, namespace)
+	}
+
+	log.Info("deleting master pvc " + pvcName + " of in-place restore of " + spec.Name)
+	err = clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(pvcName, &meta_v1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return errors.New("could not delete pvc " + pvcName + " " + err.Error())
+	}
+	for pvc.Exists(clientset, pvcName, namespace) {
+		if time.Now().After(deadline) {
+			return errors.New("pvc " + pvcName + " was not removed after " + STOP_TIMEOUT.String())
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return nil
+}
+
+// removesMasterPVC is true when an in-place restore deletes the master
+// PVC, which it only does for a PVC the operator created
+func removesMasterPVC(spec *crv1.PgclusterSpec) bool {
+	return spec.MasterStorage.StorageType == crv1.STORAGE_CREATE || spec.MasterStorage.StorageType == crv1.STORAGE_DYNAMIC
+}
+
+func masterPVCName(spec *crv1.PgclusterSpec) string {
+	if spec.MasterStorage.PvcName != "" {
+		return spec.MasterStorage.PvcName
+	}
+	return spec.Name + "-pvc"
+}
+
+// clearData runs a job that removes the data directory of a cluster
+// from an existing PVC, which is kept, and waits for it to finish
+func clearData(clientset *kubernetes.Clientset, restoreName string, pvcName string, spec *crv1.PgclusterSpec, namespace string) error {
+	jobName := spec.Name + CLEAR_JOB_SUFFIX
+
+	fields := ClearJobTemplateFields{
+		Name:             jobName,
+		RESTORE_NAME:     restoreName,
+		PVC_NAME:         pvcName,
+		DATA_PATH:        spec.Name,
+		CCP_IMAGE_TAG:    spec.CCP_IMAGE_TAG,
+		SECURITY_CONTEXT: util.CreateSecContext(spec.MasterStorage.FSGROUP, spec.MasterStorage.SUPPLEMENTAL_GROUPS),
+	}
+
+	var doc bytes.Buffer
+	err := ClearJobTemplate.Execute(&doc, fields)
+	if err != nil {
+		log.Error("error in clear job template execute " + err.Error())
+		return err
+	}
+	log.Debug(doc.String())
+
+	newjob := v1batch.Job{}
+	err = json.Unmarshal(doc.Bytes(), &newjob)
+	if err != nil {
+		log.Error("error unmarshalling json into Job " + err.Error())
+		return err
+	}
+
+	log.Info("clearing data of " + spec.Name + " on pvc " + pvcName)
+	_, err = clientset.Batch().Jobs(namespace).Create(&newjob)
+	if err != nil && !kerrors.IsAlreadyExists(err) {
+		return errors.New("could not create job " + jobName + " " + err.Error())
+	}
+
+	deadline := time.Now().Add(STOP_TIMEOUT)
+	for {
+		job, err := clientset.Batch().Jobs(namespace).Get(jobName, meta_v1.GetOptions{})
+		if err != nil {
+			return errors.New("could not get job " + jobName + " " + err.Error())
+		}
+		if job.Status.Failed > 0 {
+			return errors.New("job " + jobName + " could not clear the data of " + spec.Name)
+		}
+		if job.Status.Succeeded > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("job " + jobName + " did not finish after " + STOP_TIMEOUT.String())
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	err = clientset.Batch().Jobs(namespace).Delete(jobName, &meta_v1.DeleteOptions{})
+	if err != nil {
+		log.Error("error deleting Job " + jobName + " " + err.Error())
+	}
+	return nil
+}
+
+// keepSecrets labels a pgcluster so its secrets are not removed along
+// with it
+func keepSecrets(restclient *rest.RESTClient, name string, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return err
+	}
+	if cl.ObjectMeta.Labels == nil {
+		cl.ObjectMeta.Labels = make(map[string]string)
+	}
+	cl.ObjectMeta.Labels[crv1.KEEP_SECRETS_LABEL] = "true"
+
+	return restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&cl).
+		Do().
+		Error()
+}
+
+// clusterRemoved is true once the master deployment of a deleted
+// cluster is gone, it is removed after the other objects of the cluster
+func clusterRemoved(clientset *kubernetes.Clientset, name string, namespace string) bool {
+	_, err := clientset.ExtensionsV1beta1().Deployments(namespace).Get(name, meta_v1.GetOptions{})
+	return kerrors.IsNotFound(err)
+}
+
+// recordSource records on the pgrestore the backup that was resolved
+// for it so the restore can be audited later, along with the spec of
+// the cluster it creates
+func recordSource(restclient *rest.RESTClient, name string, spec *crv1.PgclusterSpec, namespace string) error {
+	return updateRestore(restclient, name, namespace, func(restore *crv1.Pgrestore) {
+		restore.Spec.ClusterSpec = *spec
+		restore.Spec.BACKUP_TARGET = spec.BACKUP_TARGET
+		restore.Spec.BACKUP_PATH = spec.BACKUP_PATH
+		restore.Spec.BACKUP_PVC_NAME = spec.BACKUP_PVC_NAME
+		restore.Spec.ObjectStorage = spec.BackupObjectStorage
+		restore.Spec.COMPRESSION = spec.BACKUP_COMPRESSION
+		restore.Spec.ENCRYPTION_SECRET = spec.BACKUP_ENCRYPTION_SECRET
+	})
+}
+
+// setPhase moves a pgrestore to a phase and adds it to the history
+func setPhase(restclient *rest.RESTClient, name string, phase string, message string, namespace string) {
+	log.Info("pgrestore " + name + " " + phase + " " + message)
+
+	now := time.Now().Format(time.RFC3339)
+	err := updateRestore(restclient, name, namespace, func(restore *crv1.Pgrestore) {
+		restore.Status.Phase = phase
+		restore.Status.Message = message
+		restore.Status.History = append(restore.Status.History, crv1.PgrestorePhase{
+			Phase:   phase,
+			Time:    now,
+			Message: message,
+		})
+		switch phase {
+		case crv1.RESTORE_PHASE_VALIDATING:
+			restore.Status.StartTime = now
+		case crv1.RESTORE_PHASE_COMPLETED, crv1.RESTORE_PHASE_FAILED:
+			restore.Status.CompletionTime = now
+		}
+	})
+	if err != nil {
+		log.Error("error updating phase of pgrestore " + name + " " + err.Error())
+	}
+}
+
+func updateRestore(restclient *rest.RESTClient, name string, namespace string, update func(*crv1.Pgrestore)) error {
+	restore := crv1.Pgrestore{}
+	err := restclient.Get().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&restore)
+	if err != nil {
+		return err
+	}
+
+	update(&restore)
+
+	return restclient.Put().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&restore).
+		Do().
+		Error()
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package user
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// how often the roles of each cluster are read from its catalogs
+const ROLES_STATUS_INTERVAL = 5 * time.Minute
+
+// ProcessRolesStatus periodically reads the roles of each cluster, with
+// their group roles and privileges, and records them in the pgcluster
+// status so they can be shown without connecting to the cluster
+func ProcessRolesStatus(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	ticker := time.NewTicker(ROLES_STATUS_INTERVAL)
+	for range ticker.C {
+		clusterList := crv1.PgclusterList{}
+		err := restclient.Get().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(namespace).
+			Do().
+			Into(&clusterList)
+		if err != nil {
+			log.Error("error getting cluster list in ProcessRolesStatus " + err.Error())
+			continue
+		}
+
+		clusters := []string{}
+		for _, cl := range clusterList.Items {
+			if cl.Spec.STATUS == crv1.UPGRADE_COMPLETED_STATUS {
+				clusters = append(clusters, cl.Spec.Name)
+			}
+		}
+		refreshRoles(clientset, restclient, clusters, namespace)
+	}
+}
+
+// refreshRoles reads the roles of clusters whose roles may have
+// changed and records them in their status
+func refreshRoles(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusters []string, namespace string) {
+	for _, clusterName := range clusters {
+		status := getRolesStatus(clientset, clusterName, namespace)
+		err := updateRolesStatus(restclient, clusterName, status, namespace)
+		if err != nil {
+			log.Error("error updating the roles status of " + clusterName + " " + err.Error())
+		}
+	}
+}
+
+func getRolesStatus(clientset *kubernetes.Clientset, clusterName, namespace string) *crv1.PgRolesStatus {
+	status := &crv1.PgRolesStatus{}
+	status.CheckTime = time.Now().Format(time.RFC3339)
+
+	target, err := util.GetPolicyTarget(clientset, namespace, clusterName)
+	if err != nil {
+		status.Message = "could not connect to the cluster " + err.Error()
+		return status
+	}
+	roles, err := util.GetRoleGrants(target)
+	if err != nil {
+		status.Message = "could not read the roles " + err.Error()
+		return status
+	}
+	status.Roles = roles
+	return status
+}
+
+func updateRolesStatus(restclient *rest.RESTClient, name string, status *crv1.PgRolesStatus, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return err
+	}
+
+	cl.Status.Roles = status
+
+	return restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&cl).
+		Do().
+		Error()
+}
@@ -0,0 +1,335 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package user
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// how often the operator looks for passwords to rotate
+const ROTATION_INTERVAL = time.Hour
+
+// how many rotations the status of a cluster keeps
+const ROTATION_HISTORY_MAX = 50
+
+// ProcessPasswordRotation periodically rotates the passwords that are
+// about to expire on the clusters, or of the pgusers, that ask for it
+func ProcessPasswordRotation(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	ticker := time.NewTicker(ROTATION_INTERVAL)
+	for range ticker.C {
+		RotatePasswords(clientset, restclient, namespace)
+	}
+}
+
+// RotatePasswords rotates the expiring passwords of each cluster once,
+// the rotations are recorded in the cluster status
+func RotatePasswords(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string) {
+	clusterList := crv1.PgclusterList{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&clusterList)
+	if err != nil {
+		log.Error("error getting cluster list in RotatePasswords " + err.Error())
+		return
+	}
+
+	userList := crv1.PguserList{}
+	err = restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&userList)
+	if err != nil {
+		log.Error("error getting pgusers in RotatePasswords " + err.Error())
+		return
+	}
+
+	for _, cl := range clusterList.Items {
+		users := make(map[string]*crv1.Pguser)
+		enabled := cl.Spec.PasswordRotation.Enabled
+		for i := range userList.Items {
+			u := &userList.Items[i]
+			if containsString(u.Spec.Clusters, cl.Spec.Name) {
+				users[u.Spec.Name] = u
+				enabled = enabled || u.Spec.Rotation.Enabled
+			}
+		}
+		if !enabled {
+			continue
+		}
+
+		rotations := rotateCluster(clientset, restclient, &cl, users, namespace)
+		if len(rotations) == 0 {
+			continue
+		}
+		err = recordRotations(restclient, cl.Spec.Name, rotations, namespace)
+		if err != nil {
+			log.Error("error recording password rotations of " + cl.Spec.Name + " " + err.Error())
+		}
+	}
+}
+
+// rotateCluster rotates the passwords of the roles of a cluster that
+// expire within the rotation window, a pguser is rotated by changing
+// its password date, any other role only when the operator holds its
+// password in a secret of the cluster, the replication user is never
+// rotated as the replicas stream with it, the role the operator
+// connects as is rotated last and the connection is rebuilt from its
+// secret once its password changed
+func rotateCluster(clientset *kubernetes.Clientset, restclient *rest.RESTClient, cl *crv1.Pgcluster, users map[string]*crv1.Pguser, namespace string) []crv1.PgPasswordRotation {
+	rotations := []crv1.PgPasswordRotation{}
+
+	target, err := util.GetPolicyTarget(clientset, namespace, cl.Spec.Name)
+	if err != nil {
+		log.Error("password rotation can not reach cluster " + cl.Spec.Name + " " + err.Error())
+		return rotations
+	}
+	roles, err := util.GetExpiringRoles(target)
+	if err != nil {
+		log.Error("error reading the expiring roles of " + cl.Spec.Name + " " + err.Error())
+		return rotations
+	}
+	roles = connectingRoleLast(roles, target.User)
+
+	now := time.Now()
+	for _, role := range roles {
+		if role.Name == cl.Spec.PG_MASTER_USER {
+			log.Debug("the replication user " + role.Name + " of " + cl.Spec.Name + " is not rotated")
+			continue
+		}
+
+		var userSpec *crv1.PguserSpec
+		if u, ok := users[role.Name]; ok {
+			userSpec = &u.Spec
+		}
+		settings := util.GetRotationSettings(&cl.Spec, userSpec)
+		if !settings.Enabled || role.ValidUntil.After(now.AddDate(0, 0, settings.WindowDays)) {
+			continue
+		}
+
+		var rotation crv1.PgPasswordRotation
+		if userSpec != nil {
+			rotation = rotatePguser(clientset, restclient, users[role.Name].ObjectMeta.Name, cl.Spec.Name, settings, namespace)
+		} else {
+			secret, err := getRoleSecret(clientset, cl.Spec.Name, role.Name, namespace)
+			if err != nil {
+				log.Error("password of " + role.Name + " on " + cl.Spec.Name + " not rotated " + err.Error())
+				continue
+			}
+			rotation = rotateRole(clientset, target, secret, role, settings, &cl.Spec, namespace)
+		}
+
+		if rotation.Status == crv1.ROTATION_SUCCEEDED && role.Name == target.User {
+			target, err = util.GetPolicyTarget(clientset, namespace, cl.Spec.Name)
+			if err != nil {
+				log.Error("password rotation can not reconnect to cluster " + cl.Spec.Name + " " + err.Error())
+			}
+		}
+
+		if rotation.Status == crv1.ROTATION_SUCCEEDED && userSpec == nil {
+			err = util.ApplyClusterBindings(clientset, restclient, cl.Spec.Name, namespace)
+			if err != nil {
+				rotation.Message = rotation.Message + ", refreshing the binding secrets failed " + err.Error()
+			}
+		}
+		if rotation.Status == crv1.ROTATION_SUCCEEDED && settings.RestartConsumers {
+			for _, secretName := range []string{rotation.SecretName, util.GetBindingSecretName(cl.Spec.Name, rotation.Role)} {
+				restarted, err := util.RestartSecretConsumers(clientset, secretName, namespace)
+				rotation.Restarted = append(rotation.Restarted, restarted...)
+				if err != nil {
+					rotation.Message = rotation.Message + ", restarting the deployments reading " + secretName + " failed " + err.Error()
+				}
+			}
+		}
+		log.Info("password rotation of " + role.Name + " on " + cl.Spec.Name + " " + rotation.Status + " " + rotation.Message)
+		rotations = append(rotations, rotation)
+	}
+
+	return rotations
+}
+
+// connectingRoleLast moves the role the operator connects as to the
+// end of the roles, rotating it changes the password every later
+// statement has to connect with
+func connectingRoleLast(roles []util.ExpiringRole, user string) []util.ExpiringRole {
+	ordered := []util.ExpiringRole{}
+	last := []util.ExpiringRole{}
+	for _, role := range roles {
+		if role.Name == user {
+			last = append(last, role)
+		} else {
+			ordered = append(ordered, role)
+		}
+	}
+	return append(ordered, last...)
+}
+
+// rotatePguser gives a pguser a new password date, which generates a
+// new password on each of its clusters, and reconciles it
+func rotatePguser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name, clusterName string, settings crv1.PgPasswordRotationSpec, namespace string) crv1.PgPasswordRotation {
+	now := time.Now()
+	rotation := crv1.PgPasswordRotation{
+		Date:   now.Format(time.RFC3339),
+		Status: crv1.ROTATION_FAILED,
+	}
+
+	user := crv1.Pguser{}
+	err := restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&user)
+	if err != nil {
+		rotation.Role = name
+		rotation.Message = err.Error()
+		return rotation
+	}
+	rotation.Role = user.Spec.Name
+	rotation.SecretName = util.GetUserSecretName(clusterName, user.Spec.Name)
+
+	user.Spec.PasswordDate = rotation.Date
+	if settings.PeriodDays > 0 {
+		user.Spec.ValidDays = settings.PeriodDays
+	}
+	err = restclient.Put().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&user).
+		Do().
+		Error()
+	if err != nil {
+		rotation.Message = err.Error()
+		return rotation
+	}
+
+	ReconcileUser(clientset, restclient, name, namespace)
+
+	err = restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&user)
+	if err != nil {
+		rotation.Message = err.Error()
+		return rotation
+	}
+	for _, cs := range user.Status.Clusters {
+		if cs.Cluster != clusterName {
+			continue
+		}
+		rotation.ValidUntil = cs.ValidUntil
+		rotation.Message = cs.Message
+		if cs.State == crv1.PGUSER_STATE_READY && cs.PasswordDate == rotation.Date {
+			rotation.Status = crv1.ROTATION_SUCCEEDED
+			rotation.Message = "rotated on each cluster of pguser " + name
+		}
+	}
+	return rotation
+}
+
+// rotateRole sets a new password following the cluster password policy
+// on a role whose password is held in a secret of the cluster
+func rotateRole(clientset *kubernetes.Clientset, target util.SQLTarget, secret *util.Credential, role util.ExpiringRole, settings crv1.PgPasswordRotationSpec, cluster *crv1.PgclusterSpec, namespace string) crv1.PgPasswordRotation {
+	now := time.Now()
+	period := settings.PeriodDays
+	if period <= 0 {
+		period = crv1.ROTATION_DEFAULT_PERIOD_DAYS
+	}
+
+	rotation := crv1.PgPasswordRotation{
+		Role:       role.Name,
+		SecretName: secret.Name,
+		Date:       now.Format(time.RFC3339),
+		ValidUntil: util.GetPasswordValidUntil(now, period),
+	}
+
+	password, err := util.GeneratePassword(&cluster.PasswordPolicy, 0)
+	if err == nil {
+		err = util.RotateRolePassword(clientset, target, secret, role.Name, password, rotation.ValidUntil, role.ValidUntil.Format(time.RFC3339), util.GetPasswordEncryption(&cluster.Auth), namespace)
+	}
+	if err != nil {
+		rotation.Status = crv1.ROTATION_FAILED
+		rotation.Message = err.Error()
+		return rotation
+	}
+	rotation.Status = crv1.ROTATION_SUCCEEDED
+	rotation.Message = "rotated"
+	return rotation
+}
+
+// getRoleSecret returns the secret of a cluster holding the password
+// of a role
+func getRoleSecret(clientset *kubernetes.Clientset, clusterName, role, namespace string) (*util.Credential, error) {
+	store, err := util.GetSecretStore(clientset, namespace)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := store.List(clusterName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range credentials {
+		if credentials[i].Username == role {
+			return &credentials[i], nil
+		}
+	}
+	return nil, errors.New("no secret of " + clusterName + " holds the password of " + role)
+}
+
+// recordRotations adds rotations to the history kept in the cluster
+// status and records when its passwords were last updated
+func recordRotations(restclient *rest.RESTClient, name string, rotations []crv1.PgPasswordRotation, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return err
+	}
+
+	cl.Status.Rotations = append(cl.Status.Rotations, rotations...)
+	if len(cl.Status.Rotations) > ROTATION_HISTORY_MAX {
+		cl.Status.Rotations = cl.Status.Rotations[len(cl.Status.Rotations)-ROTATION_HISTORY_MAX:]
+	}
+	for _, r := range rotations {
+		if r.Status == crv1.ROTATION_SUCCEEDED {
+			cl.Spec.PSW_LAST_UPDATE = r.Date
+		}
+	}
+
+	return restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&cl).
+		Do().
+		Error()
+}
@@ -0,0 +1,573 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package user
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	"github.com/crunchydata/kraken/util"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// reconciles of pgusers run one at a time so a role is never altered
+// by two of them at once
+var reconcileLock sync.Mutex
+
+// ReconcileUser brings the role of a pguser on each of its clusters in
+// line with its spec and drops it from the clusters it no longer
+// names, the outcome on each cluster is recorded in the pguser status
+func ReconcileUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) {
+	reconcileLock.Lock()
+	group := reconcileUser(clientset, restclient, name, namespace)
+	reconcileLock.Unlock()
+
+	if group != nil {
+		reconcileMembers(clientset, restclient, group, namespace)
+	}
+}
+
+// reconcileUser reconciles a pguser, when it is a group role its spec
+// is returned so the members that could not join it can be retried
+func reconcileUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, name string, namespace string) *crv1.PguserSpec {
+	user := crv1.Pguser{}
+	err := restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&user)
+	if err != nil {
+		log.Error("error getting pguser " + name + " " + err.Error())
+		return nil
+	}
+
+	err = util.ValidatePguserSpec(&user.Spec)
+	if err != nil {
+		log.Error(err.Error())
+		err = updateStatus(restclient, name, err.Error(), user.Status.Clusters, namespace)
+		if err != nil {
+			log.Error("error updating pguser " + name + " " + err.Error())
+		}
+		return nil
+	}
+
+	previous := make(map[string]*crv1.PguserClusterStatus)
+	for i := range user.Status.Clusters {
+		previous[user.Status.Clusters[i].Cluster] = &user.Status.Clusters[i]
+	}
+
+	statuses := []crv1.PguserClusterStatus{}
+	failed := 0
+	for _, clusterName := range user.Spec.Clusters {
+		cs := reconcileCluster(clientset, restclient, &user.Spec, clusterName, previous[clusterName], namespace)
+		if cs.State != crv1.PGUSER_STATE_READY {
+			failed++
+		}
+		statuses = append(statuses, cs)
+		delete(previous, clusterName)
+	}
+
+	dropped := []string{}
+	for clusterName, cs := range previous {
+		dropped = append(dropped, clusterName)
+		err = dropClusterUser(clientset, restclient, user.Spec.Name, cs, namespace)
+		if err != nil {
+			log.Error("error dropping user " + user.Spec.Name + " from " + clusterName + " " + err.Error())
+			failedDrop := *cs
+			failedDrop.State = crv1.PGUSER_STATE_FAILED
+			failedDrop.Message = "could not drop the role " + err.Error()
+			failedDrop.UpdateDate = time.Now().Format(time.RFC3339)
+			statuses = append(statuses, failedDrop)
+			failed++
+			continue
+		}
+		log.Info("dropped user " + user.Spec.Name + " from " + clusterName)
+	}
+
+	message := "user is up to date on every cluster"
+	if failed > 0 {
+		message = "user could not be updated on every cluster"
+	}
+	err = updateStatus(restclient, name, message, statuses, namespace)
+	if err != nil {
+		log.Error("error updating pguser " + name + " " + err.Error())
+	}
+
+	if !user.Spec.Group {
+		refreshBindings(clientset, restclient, user.Spec.Clusters, namespace)
+		refreshBindings(clientset, restclient, dropped, namespace)
+	}
+	refreshRoles(clientset, restclient, user.Spec.Clusters, namespace)
+	refreshRoles(clientset, restclient, dropped, namespace)
+
+	if user.Spec.Group {
+		return &user.Spec
+	}
+	return nil
+}
+
+// reconcileMembers reconciles the pgusers naming a group role that
+// are not ready on one of its clusters, a member reconciled before
+// its group could not be granted it
+func reconcileMembers(clientset *kubernetes.Clientset, restclient *rest.RESTClient, group *crv1.PguserSpec, namespace string) {
+	userList := crv1.PguserList{}
+	err := restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&userList)
+	if err != nil {
+		log.Error("error getting pgusers in reconcileMembers " + err.Error())
+		return
+	}
+
+	for _, user := range userList.Items {
+		if !containsString(user.Spec.MemberOf, group.Name) {
+			continue
+		}
+		for _, c := range group.Clusters {
+			if containsString(user.Spec.Clusters, c) && !clusterReady(&user.Status, c) {
+				reconcileLock.Lock()
+				reconcileUser(clientset, restclient, user.ObjectMeta.Name, namespace)
+				reconcileLock.Unlock()
+				break
+			}
+		}
+	}
+}
+
+// ReconcileClusterUsers reconciles the pgusers naming a cluster that
+// are not yet ready on it, it is called when a master pod of the
+// cluster first becomes ready so users declared along with a cluster
+// are created once the cluster can be reached
+func ReconcileClusterUsers(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusterName string, namespace string) {
+	userList := crv1.PguserList{}
+	err := restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&userList)
+	if err != nil {
+		log.Error("error getting pgusers in ReconcileClusterUsers " + err.Error())
+		return
+	}
+
+	for _, user := range userList.Items {
+		if !containsString(user.Spec.Clusters, clusterName) || clusterReady(&user.Status, clusterName) {
+			continue
+		}
+		ReconcileUser(clientset, restclient, user.ObjectMeta.Name, namespace)
+	}
+}
+
+// DeleteUser drops the role of a deleted pguser from each cluster it
+// was created on and removes the secrets holding its password and its
+// binding secrets
+func DeleteUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, user *crv1.Pguser, namespace string) {
+	reconcileLock.Lock()
+	defer reconcileLock.Unlock()
+
+	clusters := []string{}
+	for i := range user.Status.Clusters {
+		cs := &user.Status.Clusters[i]
+		clusters = append(clusters, cs.Cluster)
+		err := dropClusterUser(clientset, restclient, user.Spec.Name, cs, namespace)
+		if err != nil {
+			log.Error("error dropping user " + user.Spec.Name + " from " + cs.Cluster + " " + err.Error())
+			continue
+		}
+		log.Info("dropped user " + user.Spec.Name + " from " + cs.Cluster)
+	}
+	refreshBindings(clientset, restclient, clusters, namespace)
+	refreshRoles(clientset, restclient, clusters, namespace)
+}
+
+// reconcileCluster creates or alters the role of a pguser on a
+// cluster, a new password is generated when the secret holding it
+// does not exist or the pguser password date has changed
+func reconcileCluster(clientset *kubernetes.Clientset, restclient *rest.RESTClient, spec *crv1.PguserSpec, clusterName string, previous *crv1.PguserClusterStatus, namespace string) crv1.PguserClusterStatus {
+	now := time.Now()
+	cs := crv1.PguserClusterStatus{
+		Cluster:    clusterName,
+		UpdateDate: now.Format(time.RFC3339),
+	}
+	if !spec.Group {
+		cs.SecretName = util.GetUserSecretName(clusterName, spec.Name)
+	}
+	if previous != nil {
+		cs.PasswordDate = previous.PasswordDate
+		cs.ValidUntil = previous.ValidUntil
+		cs.Databases = previous.Databases
+		cs.MemberOf = previous.MemberOf
+		cs.Grants = previous.Grants
+		cs.DefaultOwner = previous.DefaultOwner
+		cs.Settings = previous.Settings
+	}
+
+	err := applyClusterUser(clientset, restclient, spec, &cs, previous, now, namespace)
+	if err != nil {
+		log.Error("error updating user " + spec.Name + " on " + clusterName + " " + err.Error())
+		cs.State = crv1.PGUSER_STATE_FAILED
+		cs.Message = err.Error()
+		return cs
+	}
+
+	cs.State = crv1.PGUSER_STATE_READY
+	cs.Message = "role is up to date"
+	return cs
+}
+
+// applyClusterUser creates or alters the role of a pguser on a
+// cluster, sets its connection limit and settings once the server has
+// accepted them, grants it its databases and group roles, then applies
+// its grants in each of its databases
+func applyClusterUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, spec *crv1.PguserSpec, cs *crv1.PguserClusterStatus, previous *crv1.PguserClusterStatus, now time.Time, namespace string) error {
+	target, err := util.GetPolicyTarget(clientset, namespace, cs.Cluster)
+	if err != nil {
+		return errors.New("cluster " + cs.Cluster + " can not be reached " + err.Error())
+	}
+
+	exists, err := util.QueryBool(target, sqlutil.RoleExistsQuery, spec.Name)
+	if err != nil {
+		return err
+	}
+
+	err = util.ValidateRoleSettings(target, spec)
+	if err != nil {
+		return err
+	}
+
+	revoke := []string{}
+	revokeGroups := []string{}
+	reset := []string{}
+	if previous != nil {
+		for _, d := range previous.Databases {
+			if !containsString(spec.Databases, d) {
+				revoke = append(revoke, d)
+			}
+		}
+		for _, g := range previous.MemberOf {
+			if containsString(spec.MemberOf, g) {
+				continue
+			}
+			// a group role that was dropped has no members left
+			found, err := util.QueryBool(target, sqlutil.RoleExistsQuery, g)
+			if err != nil {
+				return err
+			}
+			if found {
+				revokeGroups = append(revokeGroups, g)
+			}
+		}
+		for _, name := range util.GetSettingNames(previous.Settings) {
+			if _, ok := spec.Settings[name]; !ok {
+				reset = append(reset, name)
+			}
+		}
+	}
+
+	if spec.Group {
+		script, err := util.GetUserSQL(spec, exists, "", "", "", revoke, revokeGroups, reset)
+		if err != nil {
+			return err
+		}
+		_, err = util.ExecSQL(target, script, util.SQL_MODE_ATOMIC)
+		if err != nil {
+			return err
+		}
+		cs.PasswordDate = ""
+		cs.ValidUntil = ""
+	} else {
+		err = applyLoginUser(clientset, restclient, target, spec, cs, previous, exists, revoke, revokeGroups, reset, now, namespace)
+		if err != nil {
+			return err
+		}
+	}
+	cs.Databases = spec.Databases
+	cs.MemberOf = spec.MemberOf
+	cs.Settings = spec.Settings
+
+	return applyGrants(target, spec, cs)
+}
+
+// applyLoginUser creates or alters a role that logs in, a new password
+// is generated when the secret holding it does not exist or the pguser
+// password date has changed, the secret is written once the role has
+// its new password
+func applyLoginUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, target util.SQLTarget, spec *crv1.PguserSpec, cs *crv1.PguserClusterStatus, previous *crv1.PguserClusterStatus, exists bool, revoke, revokeGroups, reset []string, now time.Time, namespace string) error {
+	password, err := util.GetUserSecretPassword(clientset, cs.Cluster, spec.Name, namespace)
+	if err != nil {
+		return err
+	}
+
+	passwordDate := spec.PasswordDate
+	rotate := password == ""
+	if passwordDate == "" {
+		if previous != nil && previous.PasswordDate != "" && !rotate {
+			passwordDate = previous.PasswordDate
+		} else {
+			passwordDate = now.Format(time.RFC3339)
+		}
+	} else if previous != nil && previous.PasswordDate != passwordDate {
+		rotate = true
+	}
+	date, err := time.Parse(time.RFC3339, passwordDate)
+	if err != nil {
+		return err
+	}
+	validUntil := util.GetPasswordValidUntil(date, spec.ValidDays)
+
+	cl := crv1.Pgcluster{}
+	err = restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(cs.Cluster).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return errors.New("could not read the password settings of " + cs.Cluster + " " + err.Error())
+	}
+	encryption := util.GetPasswordEncryption(&cl.Spec.Auth)
+
+	oldPassword := password
+	if rotate {
+		password, err = util.GeneratePassword(&cl.Spec.PasswordPolicy, spec.PasswordLength)
+		if err != nil {
+			return err
+		}
+	}
+
+	script, err := util.GetUserSQL(spec, exists, password, validUntil, encryption, revoke, revokeGroups, reset)
+	if err != nil {
+		return err
+	}
+	_, err = util.ExecSQL(target, script, util.SQL_MODE_ATOMIC)
+	if err != nil {
+		return err
+	}
+
+	err = util.ApplyUserSecret(clientset, cs.Cluster, spec.Name, password, namespace)
+	if err != nil && oldPassword != "" && oldPassword != password {
+		return restorePassword(target, spec.Name, oldPassword, cs.ValidUntil, encryption, err)
+	} else if err != nil {
+		return errors.New("the role was updated but its secret could not be written " + err.Error())
+	}
+
+	cs.PasswordDate = passwordDate
+	cs.ValidUntil = validUntil
+	return nil
+}
+
+// applyGrants revokes the grants a role no longer has and gives it its
+// grants, each database in a transaction of its own, the grants are
+// recorded as applied once every database is done, a database that
+// was dropped has no grants left to revoke
+func applyGrants(target util.SQLTarget, spec *crv1.PguserSpec, cs *crv1.PguserClusterStatus) error {
+	grants := util.GetPguserGrants(spec)
+	revoke := util.GetRevokedGrants(cs.Grants, grants, cs.DefaultOwner, spec.DefaultOwner)
+	scripts, err := util.GetGrantSQL(spec.Name, grants, revoke, spec.DefaultOwner, cs.DefaultOwner)
+	if err != nil {
+		return err
+	}
+
+	databases := []string{}
+	for d := range scripts {
+		databases = append(databases, d)
+	}
+	sort.Strings(databases)
+
+	for _, d := range databases {
+		found, err := util.QueryBool(target, util.DatabaseExistsQuery, d)
+		if err != nil {
+			return err
+		}
+		if !found {
+			log.Debug("database " + d + " of " + cs.Cluster + " no longer exists, its grants are not revoked")
+			continue
+		}
+		t := target
+		t.Database = d
+		_, err = util.ExecSQL(t, scripts[d], util.SQL_MODE_ATOMIC)
+		if err != nil {
+			return errors.New("could not apply the grants in " + d + " " + err.Error())
+		}
+	}
+
+	cs.Grants = grants
+	cs.DefaultOwner = spec.DefaultOwner
+	return nil
+}
+
+// restorePassword puts back the password a role had when its secret
+// could not be updated with a new one
+func restorePassword(target util.SQLTarget, role, password, validUntil, encryption string, secretErr error) error {
+	if validUntil == "" {
+		validUntil = "infinity"
+	}
+	stmt, err := sqlutil.AlterRolePassword(role, password, validUntil, encryption)
+	if err == nil {
+		_, err = util.ExecSQL(target, sqlutil.Script([]string{stmt}), util.SQL_MODE_ATOMIC)
+	}
+	if err != nil {
+		return errors.New("the secret could not be written and the old password could not be restored " + err.Error())
+	}
+	return errors.New("the secret could not be written, the old password was restored " + secretErr.Error())
+}
+
+// dropClusterUser hands what a role owns in each database it was
+// granted to the cluster owner, revokes its privileges there and drops
+// the role itself, then removes the secret holding its password, a
+// cluster that no longer exists has nothing to drop
+func dropClusterUser(clientset *kubernetes.Clientset, restclient *rest.RESTClient, username string, cs *crv1.PguserClusterStatus, namespace string) error {
+	target, err := util.GetPolicyTarget(clientset, namespace, cs.Cluster)
+	if kerrors.IsNotFound(err) {
+		return deleteUserSecret(clientset, cs.Cluster, username, namespace)
+	} else if err != nil {
+		return err
+	}
+
+	exists, err := util.QueryBool(target, sqlutil.RoleExistsQuery, username)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		owner, err := getClusterOwner(restclient, cs.Cluster, username, target.User, namespace)
+		if err != nil {
+			return err
+		}
+		reassign, err := sqlutil.ReassignOwned(username, owner)
+		if err != nil {
+			return err
+		}
+		dropOwned, err := sqlutil.DropOwned(username)
+		if err != nil {
+			return err
+		}
+		dropRole, err := sqlutil.DropRole(username)
+		if err != nil {
+			return err
+		}
+		for _, d := range cs.Databases {
+			t := target
+			t.Database = d
+			_, err = util.ExecSQL(t, sqlutil.Script([]string{reassign, dropOwned}), util.SQL_MODE_ATOMIC)
+			if err != nil {
+				return errors.New("could not reassign what the role owns in " + d + " to " + owner + " " + err.Error())
+			}
+		}
+		_, err = util.ExecSQL(target, sqlutil.Script([]string{reassign, dropOwned, dropRole}), util.SQL_MODE_ATOMIC)
+		if err != nil {
+			return err
+		}
+	}
+
+	return deleteUserSecret(clientset, cs.Cluster, username, namespace)
+}
+
+// getClusterOwner returns the role that takes over the objects of a
+// dropped role, the user of the cluster or, when that is the role
+// being dropped, the superuser the operator connects as
+func getClusterOwner(restclient *rest.RESTClient, clusterName, username, superuser, namespace string) (string, error) {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(clusterName).
+		Do().
+		Into(&cl)
+	if err != nil {
+		return "", errors.New("could not read the owner of " + clusterName + " " + err.Error())
+	}
+	if cl.Spec.PG_USER == "" || cl.Spec.PG_USER == username {
+		return superuser, nil
+	}
+	return cl.Spec.PG_USER, nil
+}
+
+func deleteUserSecret(clientset *kubernetes.Clientset, clusterName, username, namespace string) error {
+	store, err := util.GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+	err = store.Delete(util.GetUserSecretName(clusterName, username))
+	if err != nil && err != util.ErrSecretNotFound {
+		return err
+	}
+	return nil
+}
+
+func updateStatus(restclient *rest.RESTClient, name, message string, clusters []crv1.PguserClusterStatus, namespace string) error {
+	user := crv1.Pguser{}
+	err := restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Do().
+		Into(&user)
+	if err != nil {
+		return err
+	}
+
+	user.Status.State = crv1.PguserStateProcessed
+	user.Status.Message = message
+	user.Status.Clusters = clusters
+
+	return restclient.Put().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Name(name).
+		Body(&user).
+		Do().
+		Error()
+}
+
+// refreshBindings refreshes the binding secrets of the clusters a
+// pguser was reconciled on or dropped from
+func refreshBindings(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusters []string, namespace string) {
+	for _, clusterName := range clusters {
+		err := util.ApplyClusterBindings(clientset, restclient, clusterName, namespace)
+		if err != nil {
+			log.Error("error refreshing the binding secrets of " + clusterName + " " + err.Error())
+		}
+	}
+}
+
+func clusterReady(status *crv1.PguserStatus, clusterName string) bool {
+	for _, cs := range status.Clusters {
+		if cs.Cluster == clusterName {
+			return cs.State == crv1.PGUSER_STATE_READY
+		}
+	}
+	return false
+}
+
+func containsString(list []string, name string) bool {
+	for _, s := range list {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
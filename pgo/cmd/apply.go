@@ -17,9 +17,18 @@ package cmd
 
 import (
 	log "github.com/Sirupsen/logrus"
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	"github.com/spf13/cobra"
+	"time"
 )
 
+// how long to wait for the operator to dry run a policy on a cluster
+const DRY_RUN_TIMEOUT = 2 * time.Minute
+
+var Atomic bool
+var PolicyUpgrade, PolicyRevert, PolicyRemove bool
+var PolicyParams []string
+
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "apply a Policy",
@@ -29,6 +38,11 @@ For example:
 pgo apply mypolicy1 --selector=name=mycluster
 pgo apply mypolicy1 --selector=someotherpolicy
 pgo apply mypolicy1 --selector=someotherpolicy --dry-run
+pgo apply mypolicy1 --selector=name=mycluster --atomic
+pgo apply mypolicy1 --selector=name=mycluster --upgrade
+pgo apply mypolicy1 --selector=name=mycluster --revert
+pgo apply mypolicy1 --selector=name=mycluster --remove
+pgo apply mypolicy1 --selector=name=mycluster --param owner=app_owner
 .`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Debug("apply called")
@@ -36,6 +50,10 @@ pgo apply mypolicy1 --selector=someotherpolicy --dry-run
 			log.Error("selector is required to apply a policy")
 			return
 		}
+		if (PolicyUpgrade && PolicyRevert) || (PolicyRemove && (PolicyUpgrade || PolicyRevert)) {
+			log.Error("only one of --upgrade, --revert and --remove can be used")
+			return
+		}
 		if len(args) == 0 {
 			log.Error(`You must specify the name of a policy to apply.`)
 		} else {
@@ -48,6 +66,25 @@ func init() {
 	RootCmd.AddCommand(applyCmd)
 
 	applyCmd.Flags().StringVarP(&Selector, "selector", "s", "", "The selector to use for cluster filtering ")
-	applyCmd.Flags().BoolVarP(&DryRun, "dry-run", "d", false, "--dry-run shows clusters that policy would be applied to but does not actually apply them")
+	applyCmd.Flags().BoolVarP(&DryRun, "dry-run", "d", false, "--dry-run runs the policy on each matching cluster in a transaction that is rolled back and shows whether it would succeed")
+	applyCmd.Flags().BoolVarP(&Atomic, "atomic", "a", false, "--atomic applies the policy in a single transaction so a failing statement leaves no changes")
+	applyCmd.Flags().BoolVarP(&PolicyUpgrade, "upgrade", "", false, "--upgrade applies the versions of the policy newer than the version applied to each cluster")
+	applyCmd.Flags().StringArrayVarP(&PolicyParams, "param", "", []string{}, "A policy parameter value written as key=value, may be repeated")
+	applyCmd.Flags().BoolVarP(&PolicyRevert, "revert", "", false, "--revert runs the down SQL of the policy version applied to each cluster, moving it back one version")
+	applyCmd.Flags().BoolVarP(&PolicyRemove, "remove", "", false, "--remove runs the removal SQL of the policy version applied to each cluster and takes the policy off it")
+
+}
 
+// getApplyAction returns the policy action the apply flags ask for
+func getApplyAction() string {
+	if PolicyUpgrade {
+		return crv1.POLICY_ACTION_UPGRADE
+	}
+	if PolicyRevert {
+		return crv1.POLICY_ACTION_REVERT
+	}
+	if PolicyRemove {
+		return crv1.POLICY_ACTION_REMOVE
+	}
+	return crv1.POLICY_ACTION_APPLY
 }
@@ -17,9 +17,11 @@
 package cmd
 
 import (
+	goerrors "errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
 	"github.com/spf13/cobra"
 	"k8s.io/apimachinery/pkg/labels"
 
@@ -45,10 +47,20 @@ var backupCmd = &cobra.Command{
 	},
 }
 
+var VerifyFlag bool
+var VerifyAsserts []string
+var Compression, CompressionLevel, EncryptionSecret string
+
 func init() {
 	RootCmd.AddCommand(backupCmd)
 
 	backupCmd.Flags().StringVarP(&Selector, "selector", "s", "", "The selector to use for cluster filtering ")
+	backupCmd.Flags().BoolVarP(&VerifyFlag, "verify", "v", false, "Verify the backup by restoring it into a temporary cluster once it completes")
+	backupCmd.Flags().StringArrayVarP(&VerifyAsserts, "assert", "a", []string{}, "A SQL query returning true that must pass on the restored backup, may be repeated, implies --verify")
+	backupCmd.Flags().StringVarP(&BackupTarget, "backup-target", "t", "", "The backup target (pvc or s3), if specified overrides the .pgo.yaml setting")
+	backupCmd.Flags().StringVarP(&Compression, "compression", "", "", "The backup compression (none, gzip or zstd), if specified overrides the .pgo.yaml setting")
+	backupCmd.Flags().StringVarP(&CompressionLevel, "compression-level", "", "", "The compression level, if specified overrides the .pgo.yaml setting")
+	backupCmd.Flags().StringVarP(&EncryptionSecret, "encryption-secret", "", "", "The Secret holding the backup encryption key, if specified overrides the .pgo.yaml setting")
 }
 
 func showBackup(args []string) {
@@ -114,7 +126,9 @@ func showBackupInfo(name string) {
 
 		//get the pgdata volume info
 		for _, v := range p.Spec.Volumes {
-			if v.Name == "pgdata" {
+			if v.Name == "pgdata" && v.VolumeSource.PersistentVolumeClaim == nil {
+				fmt.Printf("%s%s (emptydir)\n\n", TREE_TRUNK, p.Name)
+			} else if v.Name == "pgdata" {
 				fmt.Printf("%s%s (pvc %s)\n\n", TREE_TRUNK, p.Name, v.VolumeSource.PersistentVolumeClaim.ClaimName)
 				pvcMap[v.VolumeSource.PersistentVolumeClaim.ClaimName] = v.VolumeSource.PersistentVolumeClaim.ClaimName
 			}
@@ -137,6 +151,28 @@ func printBackupCRD(result *crv1.Pgbackup) {
 	fmt.Printf("%s%s\n", "", "")
 	fmt.Printf("%s%s\n", "", "pgbackup : "+result.Spec.Name)
 
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Target:\t"+result.Spec.BACKUP_TARGET)
+	if result.Spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+		fmt.Printf("%s%s\n", TREE_BRANCH, "S3 Endpoint:\t"+result.Spec.ObjectStorage.Endpoint)
+		fmt.Printf("%s%s\n", TREE_BRANCH, "S3 Bucket:\t"+result.Spec.ObjectStorage.Bucket)
+		fmt.Printf("%s%s\n", TREE_BRANCH, "S3 Prefix:\t"+result.Spec.ObjectStorage.Prefix)
+	}
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Path:\t"+result.Spec.BACKUP_PATH)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Retention Days:\t"+result.Spec.RETENTION_DAYS)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Compression:\t"+result.Spec.COMPRESSION+" "+result.Spec.COMPRESSION_LEVEL)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Encryption Secret:\t"+result.Spec.ENCRYPTION_SECRET)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Size:\t"+result.Spec.BACKUP_SIZE)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Stored Size:\t"+result.Spec.STORED_SIZE)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Compression Ratio:\t"+result.Spec.COMPRESSION_RATIO)
+	if result.Spec.VERIFY {
+		fmt.Printf("%s%s\n", TREE_BRANCH, "Verify Status:\t"+result.Spec.VERIFY_STATUS)
+		fmt.Printf("%s%s\n", TREE_BRANCH, "Verify Message:\t"+result.Spec.VERIFY_MESSAGE)
+		fmt.Printf("%s%s\n", TREE_BRANCH, "Verify Date:\t"+result.Spec.VERIFY_DATE)
+		fmt.Printf("%s%s\n", TREE_BRANCH, "Verify Time:\t"+result.Spec.VERIFY_TIME)
+		for _, assert := range result.Spec.VerifyAsserts {
+			fmt.Printf("%s%s\n", TREE_BRANCH, "Verify Assert:\t"+assert)
+		}
+	}
 	fmt.Printf("%s%s\n", TREE_BRANCH, "PVC Name:\t"+result.Spec.StorageSpec.PvcName)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "PVC Access Mode:\t"+result.Spec.StorageSpec.PvcAccessMode)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "PVC Size:\t\t"+result.Spec.StorageSpec.PvcSize)
@@ -144,7 +180,7 @@ func printBackupCRD(result *crv1.Pgbackup) {
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Status:\t"+result.Spec.BACKUP_STATUS)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Host:\t"+result.Spec.BACKUP_HOST)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup User:\t"+result.Spec.BACKUP_USER)
-	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Pass:\t"+result.Spec.BACKUP_PASS)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Secret:\t"+result.Spec.BACKUP_SECRET)
 	fmt.Printf("%s%s\n", TREE_TRUNK, "Backup Port:\t"+result.Spec.BACKUP_PORT)
 
 }
@@ -288,15 +324,37 @@ func getBackupParams(name string) (*crv1.Pgbackup, error) {
 	spec.StorageSpec.StorageType = viper.GetString("BACKUP_STORAGE.STORAGE_TYPE")
 	spec.StorageSpec.SUPPLEMENTAL_GROUPS = viper.GetString("BACKUP_STORAGE.SUPPLEMENTAL_GROUPS")
 	spec.StorageSpec.FSGROUP = viper.GetString("BACKUP_STORAGE.FSGROUP")
+	spec.BACKUP_TARGET = getBackupTarget()
+	if spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+		spec.ObjectStorage = getObjectStorageSpec()
+	}
+	spec.RETENTION_DAYS = viper.GetString("BACKUP_RETENTION_DAYS")
+	spec.COMPRESSION = getBackupOption(Compression, "BACKUP_OPTIONS.COMPRESSION")
+	spec.COMPRESSION_LEVEL = getBackupOption(CompressionLevel, "BACKUP_OPTIONS.COMPRESSION_LEVEL")
+	spec.ENCRYPTION_SECRET = getBackupOption(EncryptionSecret, "BACKUP_OPTIONS.ENCRYPTION_SECRET")
+	err := util.ValidateBackupOptions(spec.COMPRESSION, spec.COMPRESSION_LEVEL)
+	if err != nil {
+		fmt.Println(err.Error())
+		return newInstance, err
+	}
+	if spec.ENCRYPTION_SECRET != "" {
+		err = validateEncryptionSecret(spec.ENCRYPTION_SECRET)
+		if err != nil {
+			fmt.Println(err.Error())
+			return newInstance, err
+		}
+	}
+	spec.VERIFY = VerifyFlag || len(VerifyAsserts) > 0 || viper.GetBool("BACKUP_VERIFY.ENABLED")
+	spec.VerifyAsserts = VerifyAsserts
+	spec.VERIFY_TIMEOUT = viper.GetString("BACKUP_VERIFY.TIMEOUT")
 	spec.CCP_IMAGE_TAG = viper.GetString("CLUSTER.CCP_IMAGE_TAG")
 	spec.BACKUP_STATUS = "initial"
 	spec.BACKUP_HOST = "basic"
 	spec.BACKUP_USER = "master"
-	spec.BACKUP_PASS = "password"
 	spec.BACKUP_PORT = "5432"
 
 	cluster := crv1.Pgcluster{}
-	err := RestClient.Get().
+	err = RestClient.Get().
 		Resource(crv1.PgclusterResourcePlural).
 		Namespace(Namespace).
 		Name(name).
@@ -304,9 +362,8 @@ func getBackupParams(name string) (*crv1.Pgbackup, error) {
 		Into(&cluster)
 	if err == nil {
 		spec.BACKUP_HOST = cluster.Spec.Name
-		//spec.BACKUP_USER = cluster.Spec.PG_MASTER_USER
-		//spec.BACKUP_PASS = cluster.Spec.PG_MASTER_PASSWORD
-		spec.BACKUP_PASS = GetSecretPassword(cluster.Spec.Name, crv1.PGMASTER_SECRET_SUFFIX)
+		//the job reads the master password from this secret
+		spec.BACKUP_SECRET = cluster.Spec.PGMASTER_SECRET_NAME
 		spec.BACKUP_PORT = cluster.Spec.Port
 	} else if errors.IsNotFound(err) {
 		log.Debug(name + " is not a cluster")
@@ -326,6 +383,52 @@ func getBackupParams(name string) (*crv1.Pgbackup, error) {
 	return newInstance, nil
 }
 
+// getBackupTarget returns the --backup-target flag value, or the
+// .pgo.yaml BACKUP_TARGET setting, defaulting to pvc
+func getBackupTarget() string {
+	if BackupTarget != "" {
+		return BackupTarget
+	}
+	target := viper.GetString("BACKUP_TARGET")
+	if target == "" {
+		return crv1.BACKUP_TARGET_PVC
+	}
+	return target
+}
+
+func getObjectStorageSpec() crv1.PgObjectStorageSpec {
+	spec := crv1.PgObjectStorageSpec{}
+	spec.Endpoint = viper.GetString("BACKUP_OBJECT_STORAGE.ENDPOINT")
+	spec.Bucket = viper.GetString("BACKUP_OBJECT_STORAGE.BUCKET")
+	spec.Prefix = viper.GetString("BACKUP_OBJECT_STORAGE.PREFIX")
+	spec.Region = viper.GetString("BACKUP_OBJECT_STORAGE.REGION")
+	spec.SecretName = viper.GetString("BACKUP_OBJECT_STORAGE.SECRET_NAME")
+	spec.Insecure = viper.GetBool("BACKUP_OBJECT_STORAGE.INSECURE")
+	return spec
+}
+
+// getBackupOption returns a backup option flag value, or the
+// .pgo.yaml setting when the flag was not given
+func getBackupOption(flag string, key string) string {
+	if flag != "" {
+		return flag
+	}
+	return viper.GetString(key)
+}
+
+// validateEncryptionSecret checks the encryption Secret exists and
+// holds the encryption key
+func validateEncryptionSecret(name string) error {
+	secret, err := Clientset.Core().Secrets(Namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return goerrors.New("encryption secret " + name + " not found " + err.Error())
+	}
+	if len(secret.Data[crv1.BACKUP_ENCRYPTION_KEY]) == 0 {
+		return goerrors.New("encryption secret " + name + " has no " + crv1.BACKUP_ENCRYPTION_KEY + " key")
+	}
+	return nil
+}
+
 type PodTemplateFields struct {
 	Name         string
 	CO_IMAGE_TAG string
@@ -335,8 +438,7 @@ type PodTemplateFields struct {
 
 func GetSecretPassword(db, suffix string) string {
 
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + db}
-	secrets, err := Clientset.Core().Secrets(Namespace).List(lo)
+	secrets, err := GetSecretStore().List(db)
 	if err != nil {
 		log.Error("error getting list of secrets" + err.Error())
 		return "error"
@@ -344,11 +446,11 @@ func GetSecretPassword(db, suffix string) string {
 
 	log.Debug("secrets for " + db)
 	secretName := db + suffix
-	for _, s := range secrets.Items {
-		log.Debug("secret : " + s.ObjectMeta.Name)
-		if s.ObjectMeta.Name == secretName {
+	for _, s := range secrets {
+		log.Debug("secret : " + s.Name)
+		if s.Name == secretName {
 			log.Debug("pgmaster password found")
-			return string(s.Data["password"][:])
+			return s.Password
 		}
 	}
 
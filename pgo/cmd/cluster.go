@@ -19,6 +19,8 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	"io"
 
 	"github.com/spf13/viper"
 	//"k8s.io/api/core/v1"
@@ -68,6 +70,17 @@ func showCluster(args []string) {
 		return
 	}
 
+	var secretsOut io.Writer
+	if SecretsFile != "" {
+		f, err := openSecretsFile(SecretsFile)
+		if err != nil {
+			log.Error("could not create --secrets-file " + err.Error())
+			return
+		}
+		defer f.Close()
+		secretsOut = f
+	}
+
 	itemFound := false
 
 	//each arg represents a cluster name or the special 'all' value
@@ -86,10 +99,19 @@ func showCluster(args []string) {
 					listReplicaSets(cluster.Spec.Name)
 					//list the pods
 					listPods(cluster.Spec.Name)
+					//list the binding secrets
+					listBindings(&cluster)
 					//list the services
 					listServices(cluster.Spec.Name)
-					if ShowSecrets {
-						PrintSecrets(cluster.Spec.Name)
+					if cluster.Spec.Archive.Enabled {
+						printArchiveStatus(&cluster)
+					}
+					if len(cluster.Status.Rotations) > 0 {
+						printRotations(&cluster)
+					}
+					printAuth(&cluster)
+					if ShowSecrets || RevealSecrets || ConnectionString || SecretsFile != "" {
+						PrintSecrets(&cluster, secretsOut)
 					}
 					fmt.Println("")
 				}
@@ -170,6 +192,165 @@ func listServices(name string) {
 	}
 }
 
+// listBindings lists the binding secrets applications mount to connect
+// to a cluster, with the role each is for, or why there are none
+func listBindings(cluster *crv1.Pgcluster) {
+	status := cluster.Status.Bindings
+	if status != nil && status.State == crv1.BINDING_SKIPPED {
+		fmt.Println(TREE_BRANCH + "binding : " + status.Message)
+		return
+	}
+	lo := meta_v1.ListOptions{LabelSelector: crv1.BINDING_LABEL + "=" + cluster.Spec.Name}
+	secrets, err := Clientset.CoreV1().Secrets(Namespace).List(lo)
+	if err != nil {
+		log.Error("error getting list of binding secrets" + err.Error())
+		return
+	}
+	for _, s := range secrets.Items {
+		fmt.Println(TREE_BRANCH + "binding : " + s.ObjectMeta.Name + " (" + string(s.Data["username"]) + ")")
+	}
+}
+
+func printArchiveStatus(cluster *crv1.Pgcluster) {
+	fmt.Println(TREE_BRANCH + "archive : " + cluster.Spec.Archive.Target)
+	status := cluster.Status.Archive
+	if status == nil {
+		fmt.Println(TREE_TRUNK + "archive status : not checked yet")
+		return
+	}
+	fmt.Printf("%sarchived : %d (last %s at %s)\n", TREE_BRANCH, status.ArchivedCount, status.LastArchivedWal, status.LastArchivedTime)
+	fmt.Printf("%sfailed : %d (last %s at %s)\n", TREE_BRANCH, status.FailedCount, status.LastFailedWal, status.LastFailedTime)
+	fmt.Println(TREE_TRUNK + "archive status : " + status.Message + " (checked " + status.CheckTime + ")")
+}
+
+// printAuth prints the password encryption and the state of the last
+// pg_hba.conf change of a cluster
+func printAuth(cluster *crv1.Pgcluster) {
+	status := cluster.Status.Auth
+	if status == nil {
+		fmt.Println(TREE_TRUNK + "auth : " + util.GetPasswordEncryption(&cluster.Spec.Auth) + " (not applied yet)")
+		return
+	}
+	line := TREE_TRUNK + "auth : " + status.PasswordEncryption + " " + status.State + " at " + status.UpdateDate
+	if status.State == crv1.AUTH_FAILED {
+		line = line + " (" + status.Message + ")"
+	}
+	fmt.Println(line)
+}
+
+// printRotations prints the last rotation of each role of a cluster,
+// the full history is kept in the pgcluster status
+func printRotations(cluster *crv1.Pgcluster) {
+	last := make(map[string]crv1.PgPasswordRotation)
+	roles := []string{}
+	for _, r := range cluster.Status.Rotations {
+		if _, ok := last[r.Role]; !ok {
+			roles = append(roles, r.Role)
+		}
+		last[r.Role] = r
+	}
+
+	for i, role := range roles {
+		r := last[role]
+		prefix := TREE_BRANCH
+		if i == len(roles)-1 {
+			prefix = TREE_TRUNK
+		}
+		line := prefix + "password rotation : " + role + " " + r.Status + " at " + r.Date
+		if r.Status == crv1.ROTATION_SUCCEEDED {
+			line = line + " (valid until " + r.ValidUntil + ")"
+		} else {
+			line = line + " (" + r.Message + ")"
+		}
+		if len(r.Restarted) > 0 {
+			line = line + " restarted " + strings.Join(r.Restarted, ",")
+		}
+		fmt.Println(line)
+	}
+}
+
+// setRestoreOptions sets the compression and encryption of a backup
+// restored by path, these are read from the pgbackup of the SECRET_FROM
+// cluster when it recorded that path, otherwise the .pgo.yaml backup
+// options are assumed
+func setRestoreOptions(spec *crv1.PgclusterSpec) {
+	spec.BACKUP_COMPRESSION = viper.GetString("BACKUP_OPTIONS.COMPRESSION")
+	spec.BACKUP_ENCRYPTION_SECRET = viper.GetString("BACKUP_OPTIONS.ENCRYPTION_SECRET")
+
+	if spec.SECRET_FROM == "" {
+		return
+	}
+	backup := crv1.Pgbackup{}
+	err := RestClient.Get().
+		Resource(crv1.PgbackupResourcePlural).
+		Namespace(Namespace).
+		Name(spec.SECRET_FROM).
+		Do().
+		Into(&backup)
+	if err == nil && backup.Spec.BACKUP_PATH == spec.BACKUP_PATH {
+		spec.BACKUP_COMPRESSION = backup.Spec.COMPRESSION
+		spec.BACKUP_ENCRYPTION_SECRET = backup.Spec.ENCRYPTION_SECRET
+	}
+}
+
+// setRestoreFrom fills in a point-in-time recovery of the RestoreFrom
+// cluster, the base backup is the last completed pgbackup of that
+// cluster unless --backup-path names another one
+func setRestoreFrom(spec *crv1.PgclusterSpec) error {
+	source := crv1.Pgcluster{}
+	err := RestClient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(Namespace).
+		Name(RestoreFrom).
+		Do().
+		Into(&source)
+	if err != nil {
+		return errors.New("could not find restore-from cluster " + RestoreFrom + " " + err.Error())
+	}
+
+	backup := crv1.Pgbackup{}
+	err = RestClient.Get().
+		Resource(crv1.PgbackupResourcePlural).
+		Namespace(Namespace).
+		Name(RestoreFrom).
+		Do().
+		Into(&backup)
+	if err != nil {
+		return errors.New("could not find a backup of " + RestoreFrom + " " + err.Error())
+	}
+	if backup.Spec.BACKUP_STATUS != crv1.UPGRADE_COMPLETED_STATUS {
+		return errors.New("the last backup of " + RestoreFrom + " has not completed")
+	}
+
+	spec.RESTORE_FROM = RestoreFrom
+	if spec.SECRET_FROM == "" {
+		spec.SECRET_FROM = RestoreFrom
+	}
+	spec.BACKUP_TARGET = backup.Spec.BACKUP_TARGET
+	spec.BackupObjectStorage = backup.Spec.ObjectStorage
+	if spec.BACKUP_PATH == "" {
+		spec.BACKUP_PATH = backup.Spec.BACKUP_PATH
+	}
+	if spec.BACKUP_PATH == "" {
+		return errors.New("the backup of " + RestoreFrom + " has no recorded backup path, use --backup-path")
+	}
+	if spec.BACKUP_TARGET != crv1.BACKUP_TARGET_S3 {
+		spec.BACKUP_PVC_NAME = backup.Spec.StorageSpec.PvcName
+	}
+	spec.BACKUP_COMPRESSION = backup.Spec.COMPRESSION
+	spec.BACKUP_ENCRYPTION_SECRET = backup.Spec.ENCRYPTION_SECRET
+
+	if PitrTarget != "" {
+		if !source.Spec.Archive.Enabled {
+			return errors.New(RestoreFrom + " does not archive WAL, it can only be restored to the time of its backup")
+		}
+		spec.PITR_TARGET = PitrTarget
+		spec.RecoveryArchive = source.Spec.Archive
+	}
+
+	return nil
+}
+
 func createCluster(args []string) {
 	var err error
 
@@ -219,6 +400,26 @@ func createCluster(args []string) {
 			newInstance := getClusterParams(clusterName)
 			validateConfigPolicies()
 
+			err = validatePasswords(&newInstance.Spec)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			err = setAuth(&newInstance.Spec)
+			if err != nil {
+				log.Error(err.Error())
+				return
+			}
+
+			if RestoreFrom != "" {
+				err = setRestoreFrom(&newInstance.Spec)
+				if err != nil {
+					log.Error(err.Error())
+					return
+				}
+			}
+
 			t := time.Now()
 			newInstance.Spec.PSW_LAST_UPDATE = t.Format(time.RFC3339)
 
@@ -236,6 +437,55 @@ func createCluster(args []string) {
 	}
 }
 
+// validatePasswords checks the password policy of a new cluster and
+// that the passwords given in the .pgo.yaml follow it, the passwords
+// that are not given are generated by the operator
+func validatePasswords(spec *crv1.PgclusterSpec) error {
+	err := util.ValidatePasswordPolicy(&spec.PasswordPolicy)
+	if err != nil {
+		return err
+	}
+
+	passwords := map[string]string{
+		"CLUSTER.PG_ROOT_PASSWORD":   spec.PG_ROOT_PASSWORD,
+		"CLUSTER.PG_MASTER_PASSWORD": spec.PG_MASTER_PASSWORD,
+		"CLUSTER.PG_PASSWORD":        spec.PG_PASSWORD,
+	}
+	for setting, password := range passwords {
+		if password == "" {
+			continue
+		}
+		err = util.CheckPassword(&spec.PasswordPolicy, password)
+		if err != nil {
+			return errors.New(setting + " does not follow the password policy, " + err.Error())
+		}
+	}
+	return nil
+}
+
+// setAuth sets the password encryption and the pg_hba.conf rules of a
+// new cluster from the .pgo.yaml or the command line and checks them
+func setAuth(spec *crv1.PgclusterSpec) error {
+	spec.Auth.PasswordEncryption = viper.GetString("AUTH.PASSWORD_ENCRYPTION")
+	if PasswordEncryption != "" {
+		spec.Auth.PasswordEncryption = PasswordEncryption
+	}
+
+	rules := viper.GetStringSlice("AUTH.HBA_RULES")
+	if len(HbaRules) > 0 {
+		rules = HbaRules
+	}
+	for _, line := range rules {
+		rule, err := util.ParseHbaRule(line)
+		if err != nil {
+			return errors.New("invalid hba rule " + line + ", " + err.Error())
+		}
+		spec.Auth.HbaRules = append(spec.Auth.HbaRules, rule)
+	}
+
+	return util.ValidateAuthSpec(&spec.Auth)
+}
+
 func getClusterParams(name string) *crv1.Pgcluster {
 
 	spec := crv1.PgclusterSpec{}
@@ -265,6 +515,44 @@ func getClusterParams(name string) *crv1.Pgcluster {
 	spec.ReplicaStorage.FSGROUP = viper.GetString("REPLICA_STORAGE.FSGROUP")
 	spec.ReplicaStorage.SUPPLEMENTAL_GROUPS = viper.GetString("REPLICA_STORAGE.SUPPLEMENTAL_GROUPS")
 
+	spec.Archive.Enabled = viper.GetBool("ARCHIVE.ENABLED") || ArchiveFlag
+	if spec.Archive.Enabled {
+		spec.Archive.Target = viper.GetString("ARCHIVE.TARGET")
+		if spec.Archive.Target == "" {
+			spec.Archive.Target = crv1.BACKUP_TARGET_PVC
+		}
+		spec.Archive.Timeout = viper.GetString("ARCHIVE.TIMEOUT")
+		spec.Archive.Storage.PvcName = viper.GetString("ARCHIVE_STORAGE.PVC_NAME")
+		spec.Archive.Storage.StorageClass = viper.GetString("ARCHIVE_STORAGE.STORAGE_CLASS")
+		spec.Archive.Storage.PvcAccessMode = viper.GetString("ARCHIVE_STORAGE.PVC_ACCESS_MODE")
+		spec.Archive.Storage.PvcSize = viper.GetString("ARCHIVE_STORAGE.PVC_SIZE")
+		spec.Archive.Storage.StorageType = viper.GetString("ARCHIVE_STORAGE.STORAGE_TYPE")
+		spec.Archive.Storage.FSGROUP = viper.GetString("ARCHIVE_STORAGE.FSGROUP")
+		spec.Archive.Storage.SUPPLEMENTAL_GROUPS = viper.GetString("ARCHIVE_STORAGE.SUPPLEMENTAL_GROUPS")
+		if spec.Archive.Target == crv1.BACKUP_TARGET_S3 {
+			spec.Archive.ObjectStorage = getObjectStorageSpec()
+		}
+	}
+
+	spec.PasswordRotation.Enabled = viper.GetBool("PASSWORD_ROTATION.ENABLED") || RotatePasswordsFlag
+	spec.PasswordRotation.PeriodDays = viper.GetInt("PASSWORD_ROTATION.PERIOD_DAYS")
+	spec.PasswordRotation.WindowDays = viper.GetInt("PASSWORD_ROTATION.WINDOW_DAYS")
+	spec.PasswordRotation.RestartConsumers = viper.GetBool("PASSWORD_ROTATION.RESTART_CONSUMERS")
+
+	spec.PasswordPolicy.Length = viper.GetInt("PASSWORD_POLICY.LENGTH")
+	if PasswordPolicyLength > 0 {
+		spec.PasswordPolicy.Length = PasswordPolicyLength
+	}
+	spec.PasswordPolicy.MinLength = viper.GetInt("PASSWORD_POLICY.MIN_LENGTH")
+	classes := viper.GetString("PASSWORD_POLICY.CLASSES")
+	if PasswordPolicyClasses != "" {
+		classes = PasswordPolicyClasses
+	}
+	if classes != "" {
+		spec.PasswordPolicy.Classes = strings.Split(classes, ",")
+	}
+	spec.PasswordPolicy.AllowAmbiguous = viper.GetBool("PASSWORD_POLICY.ALLOW_AMBIGUOUS")
+
 	spec.Name = name
 	spec.ClusterName = name
 	spec.Port = "5432"
@@ -323,6 +611,13 @@ func getClusterParams(name string) *crv1.Pgcluster {
 	if BackupPVC != "" {
 		spec.BACKUP_PVC_NAME = BackupPVC
 	}
+	if BackupPath != "" {
+		spec.BACKUP_TARGET = getBackupTarget()
+		if spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+			spec.BackupObjectStorage = getObjectStorageSpec()
+		}
+		setRestoreOptions(&spec)
+	}
 
 	labels := make(map[string]string)
 	labels["name"] = name
@@ -489,8 +784,7 @@ func validateNodeName(nodeName string) error {
 
 func validateSecretFrom(secretname string) error {
 	var err error
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + secretname}
-	secrets, err := Clientset.Core().Secrets(v1.NamespaceDefault).List(lo)
+	secrets, err := GetSecretStore().List(secretname)
 	if err != nil {
 		log.Error("error getting list of secrets" + err.Error())
 		return err
@@ -501,14 +795,14 @@ func validateSecretFrom(secretname string) error {
 	pgrootFound := false
 	pguserFound := false
 
-	for _, s := range secrets.Items {
+	for _, s := range secrets {
 		//fmt.Println("")
-		//fmt.Println("secret : " + s.ObjectMeta.Name)
-		if s.ObjectMeta.Name == secretname+crv1.PGMASTER_SECRET_SUFFIX {
+		//fmt.Println("secret : " + s.Name)
+		if s.Name == secretname+crv1.PGMASTER_SECRET_SUFFIX {
 			pgmasterFound = true
-		} else if s.ObjectMeta.Name == secretname+crv1.PGROOT_SECRET_SUFFIX {
+		} else if s.Name == secretname+crv1.PGROOT_SECRET_SUFFIX {
 			pgrootFound = true
-		} else if s.ObjectMeta.Name == secretname+crv1.PGUSER_SECRET_SUFFIX {
+		} else if s.Name == secretname+crv1.PGUSER_SECRET_SUFFIX {
 			pguserFound = true
 		}
 	}
@@ -524,22 +818,3 @@ func validateSecretFrom(secretname string) error {
 
 	return err
 }
-
-func PrintSecrets(db string) {
-
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + db}
-	secrets, err := Clientset.Core().Secrets(Namespace).List(lo)
-	if err != nil {
-		log.Error("error getting list of secrets" + err.Error())
-		return
-	}
-
-	log.Debug("secrets for " + db)
-	for _, s := range secrets.Items {
-		fmt.Println("")
-		fmt.Println("secret : " + s.ObjectMeta.Name)
-		fmt.Println(TREE_BRANCH + "username: " + string(s.Data["username"][:]))
-		fmt.Println(TREE_TRUNK + "password: " + string(s.Data["password"][:]))
-	}
-
-}
@@ -18,13 +18,28 @@ package cmd
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
 	"github.com/spf13/cobra"
 )
 
 var CCP_IMAGE_TAG string
 var Password string
 var SecretFrom, BackupPath, BackupPVC string
-var PoliciesFlag, PolicyFile, PolicyURL string
+var BackupTarget string
+var RestoreFrom, PitrTarget string
+var ArchiveFlag bool
+var RotatePasswordsFlag bool
+var PasswordPolicyLength int
+var PasswordPolicyClasses string
+var PasswordEncryption string
+var HbaRules []string
+var PoliciesFlag, PolicyFile, PolicyURL, PolicyURLSecret string
+var PolicyDownFile, PolicyRemoveFile, PolicyVerifySql string
+var PolicyDependsOn string
+var PolicyParameters []string
+var PolicyNewVersion, PolicyRefresh bool
+var PolicyScope string
 var NodeName string
 var UserLabels string
 var UserLabelsMap map[string]string
@@ -63,13 +78,40 @@ pgo create cluster mycluster`,
 		if err != nil {
 			return
 		}
-		if SecretFrom != "" || BackupPath != "" || BackupPVC != "" {
+		if getBackupTarget() == crv1.BACKUP_TARGET_S3 {
+			if BackupPVC != "" {
+				log.Error("backup-pvc is not used when restoring from the s3 backup target")
+				return
+			}
+			if SecretFrom != "" || BackupPath != "" {
+				if SecretFrom == "" || BackupPath == "" {
+					log.Error("secret-from and backup-path are both required to perform a restore from object storage")
+					return
+				}
+			}
+		} else if SecretFrom != "" || BackupPath != "" || BackupPVC != "" {
 			if SecretFrom == "" || BackupPath == "" || BackupPVC == "" {
 				log.Error("secret-from, backup-path, backup-pvc are all required to perform a restore")
 				return
 			}
 		}
 
+		if PitrTarget != "" {
+			if RestoreFrom == "" {
+				log.Error("--restore-from is required with --pitr-target")
+				return
+			}
+			_, err = util.ParseRecoveryTarget(PitrTarget)
+			if err != nil {
+				log.Error("invalid --pitr-target " + err.Error())
+				return
+			}
+		}
+		if RestoreFrom != "" && BackupPVC != "" {
+			log.Error("--backup-pvc is taken from the backup of the --restore-from cluster")
+			return
+		}
+
 		//always have a valid NodeName
 		if NodeName == "" {
 			//NodeName = getValidNodeName()
@@ -102,13 +144,25 @@ var createPolicyCmd = &cobra.Command{
 	Use:   "policy",
 	Short: "Create a policy",
 	Long: `Create a policy. For example:
-pgo create policy mypolicy --in-file=/tmp/mypolicy.sql`,
+pgo create policy mypolicy --in-file=/tmp/mypolicy.sql
+pgo create policy gitpolicy --url=https://git.example.com/policies/gitpolicy.sql --url-secret=gitpolicy-auth
+pgo create policy cmpolicy --url=configmap://policies/cmpolicy.sql
+pgo create policy gitpolicy --refresh
+pgo create policy settings --in-file=/tmp/settings.sql --scope=all
+pgo create policy mytables --in-file=/tmp/mytables.sql --depends-on=myextension
+pgo create policy myschema --in-file=/tmp/myschema.sql --parameter=name=owner,from=cluster:PG_USER
+pgo create policy mypolicy --in-file=/tmp/mypolicy-v2.sql --down-file=/tmp/mypolicy-v2-down.sql --new-version
+pgo create policy monitoring --in-file=/tmp/monitoring.sql --remove-file=/tmp/monitoring-remove.sql`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Debug("create policy called")
 		if PolicyFile != "" && PolicyURL != "" {
 			log.Error("--in-file or --url is required to create a policy")
 			return
 		}
+		if PolicyURLSecret != "" && PolicyURL == "" {
+			log.Error("--url-secret can only be used with --url")
+			return
+		}
 
 		if len(args) == 0 {
 			log.Error("a policy name is required for this command")
@@ -129,11 +183,29 @@ func init() {
 	createClusterCmd.Flags().StringVarP(&BackupPVC, "backup-pvc", "p", "", "The backup archive PVC to restore from")
 	createClusterCmd.Flags().StringVarP(&UserLabels, "labels", "l", "", "The labels to apply to this cluster")
 	createClusterCmd.Flags().StringVarP(&BackupPath, "backup-path", "x", "", "The backup archive path to restore from")
+	createClusterCmd.Flags().StringVarP(&RestoreFrom, "restore-from", "", "", "The cluster to restore from, using its last backup and archived WAL")
+	createClusterCmd.Flags().StringVarP(&PitrTarget, "pitr-target", "", "", "The point-in-time recovery target, a timestamp, LSN or restore point name, requires --restore-from")
+	createClusterCmd.Flags().BoolVarP(&ArchiveFlag, "archive", "", false, "Enables continuous WAL archiving, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().BoolVarP(&RotatePasswordsFlag, "rotate-passwords", "", false, "Enables automatic rotation of expiring passwords, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().IntVarP(&PasswordPolicyLength, "password-length", "", 0, "The length of generated passwords, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().StringVarP(&PasswordPolicyClasses, "password-classes", "", "", "The character classes generated passwords hold (lower, upper, digit, symbol), comma separated, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().StringVarP(&PasswordEncryption, "password-encryption", "", "", "The password encryption (md5 or scram-sha-256) of the cluster, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().StringArrayVarP(&HbaRules, "hba-rule", "", []string{}, "A pg_hba.conf rule written as type database user address method, may be repeated, if specified overrides the .pgo.yaml setting")
+	createClusterCmd.Flags().StringVarP(&BackupTarget, "backup-target", "", "", "The backup target (pvc or s3) holding the backup archive, if specified overrides the .pgo.yaml setting")
 	createClusterCmd.Flags().StringVarP(&PoliciesFlag, "policies", "z", "", "The policies to apply when creating a cluster, comma separated")
 	createClusterCmd.Flags().StringVarP(&CCP_IMAGE_TAG, "ccp-image-tag", "c", "", "The CCP_IMAGE_TAG to use for cluster creation, if specified overrides the .pgo.yaml setting")
 	createClusterCmd.Flags().IntVarP(&Series, "series", "e", 1, "The number of clusters to create in a series, defaults to 1")
 	createPolicyCmd.Flags().StringVarP(&PolicyURL, "url", "u", "", "The url to use for adding a policy")
 	createPolicyCmd.Flags().StringVarP(&PolicyFile, "in-file", "i", "", "The policy file path to use for adding a policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyDownFile, "down-file", "", "", "The policy file path holding the SQL that reverts this version of the policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyRemoveFile, "remove-file", "", "", "The policy file path holding the SQL that removes this version of the policy from a cluster")
+	createPolicyCmd.Flags().StringVarP(&PolicyVerifySql, "verify-sql", "", "", "A SQL query returning true while the policy is in effect, used to detect drift")
+	createPolicyCmd.Flags().StringVarP(&PolicyDependsOn, "depends-on", "", "", "The policies that must be applied before this policy, comma separated")
+	createPolicyCmd.Flags().StringArrayVarP(&PolicyParameters, "parameter", "", []string{}, "A policy parameter written as name=owner,kind=identifier,from=cluster:PG_USER,default=app, may be repeated")
+	createPolicyCmd.Flags().BoolVarP(&PolicyNewVersion, "new-version", "", false, "Adds a new version of an existing policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyScope, "scope", "", "", "The pods of a cluster the policy runs on, master (the default), replicas or all")
+	createPolicyCmd.Flags().StringVarP(&PolicyURLSecret, "url-secret", "", "", "The secret holding the authorization headers and CA bundle used to fetch --url")
+	createPolicyCmd.Flags().BoolVarP(&PolicyRefresh, "refresh", "", false, "Fetches the url of an existing policy again, adding a new version if its SQL changed")
 	UserLabelsMap = make(map[string]string)
 
 }
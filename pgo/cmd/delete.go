@@ -23,14 +23,15 @@ import (
 // deleteCmd represents the delete command
 var deleteCmd = &cobra.Command{
 	Use:   "delete",
-	Short: "Delete a policy, database, cluster, backup, or upgrade",
-	Long: `delete allows you to delete a policy, database, cluster, backup, or upgrade
+	Short: "Delete a policy, database, cluster, backup, restore, or upgrade",
+	Long: `delete allows you to delete a policy, database, cluster, backup, restore, or upgrade
 For example:
 
 pgo delete policy mypolicy
 pgo delete database mydatabase
 pgo delete cluster mycluster
 pgo delete backup mycluster
+pgo delete restore mycluster
 pgo delete upgrade mycluster`,
 	Run: func(cmd *cobra.Command, args []string) {
 
@@ -40,6 +41,7 @@ pgo delete upgrade mycluster`,
 	* database
 	* cluster
 	* backup
+	* restore
 	* upgrade`)
 		} else {
 			switch args[0] {
@@ -47,6 +49,7 @@ pgo delete upgrade mycluster`,
 			case "database":
 			case "cluster":
 			case "backup":
+			case "restore":
 			case "upgrade":
 				break
 			default:
@@ -55,6 +58,7 @@ pgo delete upgrade mycluster`,
 	* database
 	* cluster
 	* backup
+	* restore
 	* upgrade`)
 			}
 		}
@@ -70,6 +74,7 @@ func init() {
 
 	deleteCmd.AddCommand(deleteBackupCmd)
 	deleteCmd.AddCommand(deleteUpgradeCmd)
+	deleteCmd.AddCommand(deleteRestoreCmd)
 
 }
 
@@ -87,6 +92,21 @@ var deleteUpgradeCmd = &cobra.Command{
 	},
 }
 
+var deleteRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "delete the record of a restore",
+	Long: `delete the record of a restore, the restored cluster is kept. For example:
+	pgo delete restore mycluster-20171001120000
+	pgo delete restore mycluster`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Error("a restore or cluster name is required for this command")
+		} else {
+			deleteRestore(args)
+		}
+	},
+}
+
 var deleteBackupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "delete a backup",
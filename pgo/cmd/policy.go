@@ -25,8 +25,10 @@ import (
 	"io/ioutil"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/pkg/apis/extensions/v1beta1"
 	"os/user"
 	"strings"
+	"time"
 )
 
 func showPolicy(args []string) {
@@ -57,7 +59,33 @@ func showPolicy(args []string) {
 				log.Debug("listing policy " + arg)
 				fmt.Println("policy : " + policy.Spec.Name)
 				fmt.Println(TREE_BRANCH + "url : " + policy.Spec.Url)
+				if policy.Spec.Url != "" {
+					fmt.Println(TREE_BRANCH + "fetched : " + policy.Spec.FetchDate)
+				}
 				fmt.Println(TREE_BRANCH + "status : " + policy.Spec.Status)
+				fmt.Printf("%sversion : %d\n", TREE_BRANCH, policy.Spec.Version)
+				fmt.Println(TREE_BRANCH + "checksum : " + policy.Spec.Checksum)
+				for _, v := range policy.Spec.PreviousVersions {
+					fmt.Printf("%sprevious version : %d checksum : %s\n", TREE_BRANCH, v.Version, v.Checksum)
+				}
+				if policy.Spec.DownSql != "" {
+					fmt.Println(TREE_BRANCH + "downsql : " + policy.Spec.DownSql)
+				}
+				if policy.Spec.RemoveSql != "" {
+					fmt.Println(TREE_BRANCH + "removesql : " + policy.Spec.RemoveSql)
+				}
+				if policy.Spec.VerifySql != "" {
+					fmt.Println(TREE_BRANCH + "verifysql : " + policy.Spec.VerifySql)
+				}
+				if policy.Spec.Scope != "" {
+					fmt.Println(TREE_BRANCH + "scope : " + policy.Spec.Scope)
+				}
+				if len(policy.Spec.DependsOn) > 0 {
+					fmt.Println(TREE_BRANCH + "dependson : " + strings.Join(policy.Spec.DependsOn, ","))
+				}
+				for _, p := range policy.Spec.Parameters {
+					fmt.Println(TREE_BRANCH + "parameter : " + p.Name + " kind : " + p.Kind + " from : " + p.From + " default : " + p.Default)
+				}
 				fmt.Println(TREE_TRUNK + "sql : " + policy.Spec.Sql)
 			}
 		}
@@ -68,6 +96,131 @@ func showPolicy(args []string) {
 	}
 }
 
+// showPolicyCompliance summarizes, for each policy, the compliance
+// the operator last recorded for each cluster it is applied to
+func showPolicyCompliance(args []string) {
+	policylogList := crv1.PgpolicylogList{}
+	err := RestClient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(Namespace).
+		Do().Into(&policylogList)
+	if err != nil {
+		log.Error("error getting list of policylogs" + err.Error())
+		return
+	}
+
+	for _, arg := range args {
+		counts := make(map[string]int)
+		fmt.Println("")
+		fmt.Println("policy : " + arg)
+		for _, p := range policylogList.Items {
+			if (arg != "all" && p.Spec.PolicyName != arg) || p.Spec.DryRun {
+				continue
+			}
+			state := p.Spec.Compliance.State
+			if state == "" {
+				state = "not checked"
+			}
+			counts[state]++
+			fmt.Printf("%scluster : %s policy : %s version : %d %s", TREE_BRANCH, p.Spec.ClusterName, p.Spec.PolicyName, util.AppliedPolicyVersion(&p.Spec), state)
+			if p.Spec.Compliance.CheckTime != "" {
+				fmt.Printf(" at %s (%s)", p.Spec.Compliance.CheckTime, p.Spec.Compliance.Message)
+			}
+			fmt.Println("")
+		}
+		fmt.Printf("%s%s : %d %s : %d %s : %d not checked : %d\n", TREE_TRUNK,
+			crv1.POLICY_COMPLIANT, counts[crv1.POLICY_COMPLIANT],
+			crv1.POLICY_DRIFTED, counts[crv1.POLICY_DRIFTED],
+			crv1.POLICY_COMPLIANCE_UNKNOWN, counts[crv1.POLICY_COMPLIANCE_UNKNOWN],
+			counts["not checked"])
+	}
+}
+
+// showPolicylog prints the audit trail of the named pgpolicylogs,
+// every run of a policy on a cluster that matches the filter flags
+func showPolicylog(args []string) {
+	filter, err := getPolicylogFilter()
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	policylogList := crv1.PgpolicylogList{}
+	err = RestClient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(Namespace).
+		Do().Into(&policylogList)
+	if err != nil {
+		log.Error("error getting list of policylogs" + err.Error())
+		return
+	}
+
+	itemFound := false
+	for _, arg := range args {
+		for _, p := range policylogList.Items {
+			if (arg != "all" && p.ObjectMeta.Name != arg) || p.Spec.DryRun {
+				continue
+			}
+			entries := []crv1.PgpolicylogEntry{}
+			for _, e := range util.GetPolicylogEntries(&p.Spec) {
+				if filter.Match(&p.Spec, &e) {
+					entries = append(entries, e)
+				}
+			}
+			if len(entries) == 0 {
+				continue
+			}
+			itemFound = true
+			fmt.Println("")
+			fmt.Println("policylog : " + p.ObjectMeta.Name + " policy : " + p.Spec.PolicyName + " cluster : " + p.Spec.ClusterName)
+			for i, e := range entries {
+				prefix := TREE_BRANCH
+				if i == len(entries)-1 {
+					prefix = TREE_TRUNK
+				}
+				printPolicylogEntry(prefix, &e)
+			}
+		}
+	}
+	if !itemFound {
+		fmt.Println("no policy runs found")
+	}
+}
+
+func printPolicylogEntry(prefix string, e *crv1.PgpolicylogEntry) {
+	status := e.Status
+	if status == "" {
+		status = "pending"
+	}
+	fmt.Printf("%s%s %s %s version %d to %d requested by %s from %s at %s\n", prefix, e.ApplyDate, e.Action, status,
+		e.FromVersion, e.Version, e.Username, e.RequestedFrom, e.RequestDate)
+	fmt.Printf("%s    pod : %s duration : %s checksum : %s statements : %d rows affected : %d\n", prefix, e.TargetPod, e.Duration,
+		e.Checksum, e.Result.Statements, e.Result.RowsAffected)
+	if e.Result.Error != "" {
+		fmt.Printf("%s    error : %s sqlstate : %s\n", prefix, e.Result.Error, e.Result.SqlState)
+	}
+	if len(e.Instances) > 1 || (len(e.Instances) == 1 && e.Instances[0].Role != crv1.POLICY_ROLE_MASTER) {
+		for _, i := range e.Instances {
+			fmt.Printf("%s    %s %s : %s at %s %s\n", prefix, i.Role, i.Pod, i.Status, i.ApplyDate, i.Result.Error)
+		}
+	}
+}
+
+func getPolicylogFilter() (util.PolicylogFilter, error) {
+	var err error
+	filter := util.PolicylogFilter{Cluster: PolicylogCluster, Policy: PolicylogPolicy, User: PolicylogUser}
+	if PolicylogSince != "" {
+		filter.Since, err = util.ParsePolicylogDate(PolicylogSince)
+		if err != nil {
+			return filter, err
+		}
+	}
+	if PolicylogUntil != "" {
+		filter.Until, err = util.ParsePolicylogDate(PolicylogUntil)
+	}
+	return filter, err
+}
+
 func createPolicy(args []string) {
 
 	var err error
@@ -83,9 +236,18 @@ func createPolicy(args []string) {
 			Name(arg).
 			Do().
 			Into(&result)
-		if err == nil {
+		if err == nil && PolicyRefresh {
+			refreshPolicy(&result)
+			break
+		} else if err == nil && PolicyNewVersion {
+			createPolicyVersion(&result)
+			break
+		} else if err == nil {
 			log.Debug("pgpolicy " + arg + " was found so we will not create it")
 			break
+		} else if kerrors.IsNotFound(err) && PolicyRefresh {
+			log.Error("pgpolicy " + arg + " not found so there is nothing to refresh")
+			break
 		} else if kerrors.IsNotFound(err) {
 			log.Debug("pgpolicy " + arg + " not found so we will create it")
 		} else {
@@ -115,6 +277,73 @@ func createPolicy(args []string) {
 	}
 }
 
+// createPolicyVersion replaces the SQL of an existing policy with a
+// new version, clusters stay on their version until upgraded
+func createPolicyVersion(policy *crv1.Pgpolicy) {
+	newInstance, err := getPolicyParams(policy.Spec.Name)
+	if err != nil {
+		log.Error(" error in policy parameters ")
+		log.Error(err.Error())
+		return
+	}
+
+	err = util.NewPolicyVersion(policy, &newInstance.Spec)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	if PolicyDependsOn != "" {
+		policy.Spec.DependsOn = newInstance.Spec.DependsOn
+	}
+	if len(PolicyParameters) > 0 {
+		policy.Spec.Parameters = newInstance.Spec.Parameters
+	}
+	if PolicyScope != "" {
+		policy.Spec.Scope = PolicyScope
+	}
+	err = util.CheckPolicyScope(policy)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	err = RestClient.Put().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(Namespace).
+		Name(policy.Spec.Name).
+		Body(policy).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating Pgpolicy " + policy.Spec.Name + " " + err.Error())
+		return
+	}
+	fmt.Printf("created Pgpolicy %s version %d\n", policy.Spec.Name, policy.Spec.Version)
+}
+
+// refreshPolicy fetches the url of an existing policy again, a new
+// version is added only when the SQL it returns has changed
+func refreshPolicy(policy *crv1.Pgpolicy) {
+	err := util.RefreshPolicy(Clientset, Namespace, policy)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	err = RestClient.Put().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(Namespace).
+		Name(policy.Spec.Name).
+		Body(policy).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating Pgpolicy " + policy.Spec.Name + " " + err.Error())
+		return
+	}
+	fmt.Printf("refreshed Pgpolicy %s version %d fetched %s\n", policy.Spec.Name, policy.Spec.Version, policy.Spec.FetchDate)
+}
+
 func getPolicyParams(name string) (*crv1.Pgpolicy, error) {
 
 	var err error
@@ -124,6 +353,7 @@ func getPolicyParams(name string) (*crv1.Pgpolicy, error) {
 
 	if PolicyURL != "" {
 		spec.Url = PolicyURL
+		spec.UrlSecret = PolicyURLSecret
 	}
 	if PolicyFile != "" {
 		spec.Sql, err = getPolicyString(PolicyFile)
@@ -132,6 +362,48 @@ func getPolicyParams(name string) (*crv1.Pgpolicy, error) {
 			return &crv1.Pgpolicy{}, err
 		}
 	}
+	spec.VerifySql = PolicyVerifySql
+	for _, p := range PolicyParameters {
+		var param crv1.PgpolicyParameter
+		param, err = util.ParsePolicyParameter(p)
+		if err != nil {
+			return &crv1.Pgpolicy{}, err
+		}
+		spec.Parameters = append(spec.Parameters, param)
+	}
+	err = util.ValidatePolicyParameters(spec.Parameters)
+	if err != nil {
+		return &crv1.Pgpolicy{}, err
+	}
+	if PolicyDependsOn != "" {
+		spec.DependsOn = strings.Split(PolicyDependsOn, ",")
+	}
+	err = util.ValidatePolicyDependencies(RestClient, Namespace, name, spec.DependsOn)
+	if err != nil {
+		return &crv1.Pgpolicy{}, err
+	}
+	if PolicyDownFile != "" {
+		spec.DownSql, err = getPolicyString(PolicyDownFile)
+
+		if err != nil {
+			return &crv1.Pgpolicy{}, err
+		}
+	}
+	if PolicyRemoveFile != "" {
+		spec.RemoveSql, err = getPolicyString(PolicyRemoveFile)
+
+		if err != nil {
+			return &crv1.Pgpolicy{}, err
+		}
+	}
+
+	err = util.FetchPolicySource(Clientset, Namespace, &spec)
+	if err != nil {
+		return &crv1.Pgpolicy{}, err
+	}
+	util.SetPolicyChecksum(&spec)
+
+	spec.Scope = PolicyScope
 
 	newInstance := &crv1.Pgpolicy{
 		ObjectMeta: meta_v1.ObjectMeta{
@@ -140,6 +412,7 @@ func getPolicyParams(name string) (*crv1.Pgpolicy, error) {
 		Spec: spec,
 	}
 
+	err = util.CheckPolicyScope(newInstance)
 	return newInstance, err
 }
 
@@ -234,15 +507,40 @@ func validateConfigPolicies() error {
 func applyPolicy(policies []string) {
 	var err error
 	//validate policies
-	labels := make(map[string]string)
+	catalog := make(map[string]*crv1.Pgpolicy)
 	for _, p := range policies {
-		err = util.ValidatePolicy(RestClient, Namespace, p)
+		policy := crv1.Pgpolicy{}
+		err = RestClient.Get().
+			Resource(crv1.PgpolicyResourcePlural).
+			Namespace(Namespace).
+			Name(p).
+			Do().
+			Into(&policy)
 		if err != nil {
 			log.Error("policy " + p + " is not found, cancelling request")
 			return
 		}
 
-		labels[p] = "pgpolicy"
+		catalog[p] = &policy
+	}
+
+	//apply each policy after the policies it depends on
+	policies, err = util.SortPolicies(policies, catalog)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	if PolicyRemove {
+		policies = util.ReversePolicies(policies)
+	}
+
+	params, err := util.ParsePolicyParams(PolicyParams)
+	if err == nil {
+		err = util.CheckPolicyParams(catalog, params)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		return
 	}
 
 	//get filtered list of Deployments
@@ -260,35 +558,37 @@ func applyPolicy(policies []string) {
 		for _, d := range deployments.Items {
 			fmt.Println("deployment : " + d.ObjectMeta.Name)
 		}
+		dryRunPolicies(policies, catalog, params, deployments.Items)
 		return
 	}
 
-	var newInstance *crv1.Pgpolicylog
 	for _, d := range deployments.Items {
 		fmt.Println("deployment : " + d.ObjectMeta.Name)
 		for _, p := range policies {
 			log.Debug("apply policy " + p + " on deployment " + d.ObjectMeta.Name + " based on selector " + sel)
 
-			newInstance, err = getPolicylog(p, d.ObjectMeta.Name)
+			newInstance, existing, err := getPolicylog(catalog[p], d.ObjectMeta.Name, params, false)
+			if err != nil {
+				fmt.Println(err.Error())
+				continue
+			}
 
-			result := crv1.Pgpolicylog{}
-			err = RestClient.Get().
-				Resource(crv1.PgpolicylogResourcePlural).
-				Namespace(Namespace).
-				Name(newInstance.ObjectMeta.Name).
-				Do().Into(&result)
-			if err == nil {
-				fmt.Println(p + " already applied to " + d.ObjectMeta.Name)
-				break
-			} else {
-				if kerrors.IsNotFound(err) {
-				} else {
-					log.Error(err)
-					break
+			//the pgpolicylog of the run before is replaced, the new one
+			//carries its runs in its history
+			if existing != nil {
+				err = RestClient.Delete().
+					Resource(crv1.PgpolicylogResourcePlural).
+					Namespace(Namespace).
+					Name(existing.ObjectMeta.Name).
+					Do().
+					Error()
+				if err != nil {
+					log.Error("error deleting Pgpolicylog " + existing.ObjectMeta.Name + " " + err.Error())
+					continue
 				}
 			}
 
-			result = crv1.Pgpolicylog{}
+			result := crv1.Pgpolicylog{}
 			err = RestClient.Post().
 				Resource(crv1.PgpolicylogResourcePlural).
 				Namespace(Namespace).
@@ -306,23 +606,170 @@ func applyPolicy(policies []string) {
 
 }
 
-func getPolicylog(policyname, clustername string) (*crv1.Pgpolicylog, error) {
-	u, err := user.Current()
+// dryRunPolicies has the operator run each policy on each cluster in
+// a transaction that is rolled back and prints the outcome, the dry
+// run pgpolicylogs are all created before any is waited on so they
+// share one timeout, and are removed afterwards, the policies dry run
+// before one on a cluster count as applied for its dependencies
+func dryRunPolicies(policies []string, catalog map[string]*crv1.Pgpolicy, params map[string]string, deployments []v1beta1.Deployment) {
+	failed := make(map[string]string)
+	instances := make(map[string]*crv1.Pgpolicylog)
+	names := []string{}
+	for _, d := range deployments {
+		batch := []string{}
+		for _, p := range policies {
+			newInstance, _, err := getPolicylog(catalog[p], d.ObjectMeta.Name, params, true)
+			if err != nil {
+				failed[d.ObjectMeta.Name+"/"+p] = err.Error()
+				continue
+			}
+			newInstance.Spec.DryRunBatch = append([]string{}, batch...)
+			batch = append(batch, p)
+			name := newInstance.ObjectMeta.Name
+
+			//remove the outcome of an earlier dry run
+			RestClient.Delete().
+				Resource(crv1.PgpolicylogResourcePlural).
+				Namespace(Namespace).
+				Name(name).
+				Do()
+
+			err = RestClient.Post().
+				Resource(crv1.PgpolicylogResourcePlural).
+				Namespace(Namespace).
+				Body(newInstance).
+				Do().
+				Error()
+			if err != nil {
+				log.Error("error in creating dry run Pgpolicylog " + err.Error())
+				failed[d.ObjectMeta.Name+"/"+p] = "could not be dry run " + err.Error()
+				continue
+			}
+			instances[d.ObjectMeta.Name+"/"+p] = newInstance
+			names = append(names, name)
+		}
+	}
+
+	pending := "timed out waiting for its pgpolicylog"
+	outcomes, err := util.WaitForPolicylogs(RestClient, names, Namespace, DRY_RUN_TIMEOUT)
 	if err != nil {
-		log.Error(err.Error())
+		pending = "could not be read " + err.Error()
+	}
+
+	for _, d := range deployments {
+		fmt.Println("")
+		fmt.Println("deployment : " + d.ObjectMeta.Name)
+		for _, p := range policies {
+			if msg, ok := failed[d.ObjectMeta.Name+"/"+p]; ok {
+				fmt.Println(TREE_TRUNK + p + " : " + msg)
+				continue
+			}
+			newInstance, ok := instances[d.ObjectMeta.Name+"/"+p]
+			if !ok {
+				continue
+			}
+			name := newInstance.ObjectMeta.Name
+			if result := outcomes[name]; result != nil {
+				printPolicyResult(p, &result.Spec)
+			} else {
+				fmt.Println(TREE_TRUNK + p + " : " + pending)
+			}
+
+			err = RestClient.Delete().
+				Resource(crv1.PgpolicylogResourcePlural).
+				Namespace(Namespace).
+				Name(name).
+				Do().
+				Error()
+			if err != nil {
+				log.Error("error deleting dry run Pgpolicylog " + name + " " + err.Error())
+			}
+		}
+	}
+}
+
+func printPolicyResult(policyName string, spec *crv1.PgpolicylogSpec) {
+	r := spec.Result
+	if spec.Status == crv1.POLICY_FAILED_STATUS {
+		fmt.Println(TREE_BRANCH + policyName + " : would fail")
+		fmt.Println(TREE_BRANCH + "error : " + r.Error)
+		if r.SqlState != "" {
+			fmt.Println(TREE_BRANCH + "sqlstate : " + r.SqlState)
+		}
+		if r.Position != "" {
+			fmt.Println(TREE_BRANCH + "position : " + r.Position)
+		}
+	} else {
+		fmt.Println(TREE_BRANCH + policyName + " : would succeed")
+	}
+	if len(spec.RenderedParameters) > 0 {
+		fmt.Println(TREE_BRANCH + "parameters : " + util.FormatPolicyParams(spec.RenderedParameters))
+	}
+	for _, n := range r.Notices {
+		fmt.Println(TREE_BRANCH + "notice : " + n)
+	}
+	fmt.Printf("%sstatements : %d rows affected : %d\n", TREE_TRUNK, r.Statements, r.RowsAffected)
+}
+
+// getPolicylog returns the pgpolicylog asking the operator to apply,
+// upgrade or revert a policy on a cluster along with the pgpolicylog
+// it replaces, if any, or an error saying why there is nothing to run
+func getPolicylog(policy *crv1.Pgpolicy, clustername string, params map[string]string, dryRun bool) (*crv1.Pgpolicylog, *crv1.Pgpolicylog, error) {
+	var existing *crv1.Pgpolicylog
+	applied := crv1.Pgpolicylog{}
+	err := RestClient.Get().
+		Resource(crv1.PgpolicylogResourcePlural).
+		Namespace(Namespace).
+		Name(util.GetPolicylogName(policy.Spec.Name, clustername, false)).
+		Do().Into(&applied)
+	if err == nil {
+		existing = &applied
+	} else if !kerrors.IsNotFound(err) {
+		log.Error(err)
+		return nil, nil, err
+	}
+
+	var appliedSpec *crv1.PgpolicylogSpec
+	if existing != nil {
+		appliedSpec = &existing.Spec
+	}
+	action, fromVersion, err := util.GetPolicyAction(policy, clustername, appliedSpec, getApplyAction())
+	if err != nil {
+		return nil, existing, err
 	}
 
 	spec := crv1.PgpolicylogSpec{}
-	spec.PolicyName = policyname
-	spec.Username = u.Name
+	spec.PolicyName = policy.Spec.Name
+	spec.Username = getUsername()
+	spec.RequestedFrom = crv1.POLICY_REQUEST_PGO
+	spec.RequestDate = time.Now().Format(time.RFC3339)
 	spec.ClusterName = clustername
+	spec.DryRun = dryRun
+	spec.Atomic = Atomic
+	spec.Action = action
+	spec.FromVersion = fromVersion
+	spec.Parameters = util.GetPolicylogParams(policy, appliedSpec, params)
+	if !dryRun {
+		spec.History = util.GetPolicylogHistory(appliedSpec)
+	}
 
 	newInstance := &crv1.Pgpolicylog{
 		ObjectMeta: meta_v1.ObjectMeta{
-			Name: policyname + clustername,
+			Name: util.GetPolicylogName(policy.Spec.Name, clustername, dryRun),
 		},
 		Spec: spec,
 	}
-	return newInstance, err
+	return newInstance, existing, nil
 
 }
+
+// getUsername returns the login name of the user running pgo, it is
+// recorded on the pgpolicylogs pgo creates
+func getUsername() string {
+	u, err := user.Current()
+	if err != nil {
+		log.Error(err.Error())
+		return "unknown"
+	}
+	return u.Username
+}
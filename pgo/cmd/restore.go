@@ -0,0 +1,322 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+
+// Package cmd provides the command line functions of the crunchy CLI
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	"github.com/spf13/cobra"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+var BackupName string
+var InPlace bool
+var Confirm string
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "perform a Restore",
+	Long: `RESTORE restores a backup into a new cluster or in place over an
+existing cluster, for example:
+
+pgo restore newcluster --backup=mycluster
+pgo restore newcluster --backup-pvc=mypvc --backup-path=mycluster-backups/2017-10-01-12-00-00 --secret-from=mycluster
+pgo restore newcluster --restore-from=mycluster --pitr-target="2017-10-01 12:30:00"
+pgo restore mycluster --backup=mycluster --in-place`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log.Debug("restore called")
+		if len(args) != 1 {
+			fmt.Println(`You must specify the cluster to restore into.`)
+			return
+		}
+		err := validateRestore(args[0])
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		createRestore(args[0])
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(restoreCmd)
+
+	restoreCmd.Flags().StringVarP(&BackupName, "backup", "b", "", "The pgbackup to restore, named after the cluster that was backed up")
+	restoreCmd.Flags().StringVarP(&BackupPVC, "backup-pvc", "p", "", "The backup archive PVC to restore from")
+	restoreCmd.Flags().StringVarP(&BackupPath, "backup-path", "x", "", "The backup archive path to restore from")
+	restoreCmd.Flags().StringVarP(&BackupTarget, "backup-target", "", "", "The backup target (pvc or s3) holding the backup archive, if specified overrides the .pgo.yaml setting")
+	restoreCmd.Flags().StringVarP(&SecretFrom, "secret-from", "s", "", "The cluster name to use when restoring secrets, defaults to the backed up cluster")
+	restoreCmd.Flags().StringVarP(&RestoreFrom, "restore-from", "", "", "The cluster whose last backup and archived WAL are restored")
+	restoreCmd.Flags().StringVarP(&PitrTarget, "pitr-target", "", "", "The point-in-time recovery target, a timestamp, LSN or restore point name")
+	restoreCmd.Flags().BoolVarP(&InPlace, "in-place", "", false, "Replace the data of the existing cluster with the backup")
+	restoreCmd.Flags().StringVarP(&Confirm, "confirm", "", "", "The name of the cluster restored in place, skips the confirmation prompt")
+}
+
+func validateRestore(target string) error {
+	if BackupName != "" && BackupPath != "" {
+		return errors.New("--backup and --backup-path can not both be specified")
+	}
+	if BackupName == "" && BackupPath == "" && RestoreFrom == "" {
+		return errors.New("--backup, --backup-path or --restore-from is required")
+	}
+	if BackupPath != "" && getBackupTarget() == crv1.BACKUP_TARGET_PVC && BackupPVC == "" {
+		return errors.New("--backup-pvc is required to restore a --backup-path from the pvc backup target")
+	}
+	if PitrTarget != "" {
+		_, err := util.ParseRecoveryTarget(PitrTarget)
+		if err != nil {
+			return errors.New("invalid --pitr-target " + err.Error())
+		}
+	}
+	if !InPlace && Confirm != "" {
+		return errors.New("--confirm is only used with --in-place")
+	}
+	return nil
+}
+
+// confirmInPlace asks for the name of a cluster being restored in
+// place unless --confirm already gave it
+func confirmInPlace(target string) bool {
+	if Confirm != "" {
+		return Confirm == target
+	}
+
+	fmt.Println("WARNING: restoring in place deletes the data and storage of cluster " + target + ".")
+	fmt.Print("Type the cluster name to continue: ")
+	reader := bufio.NewReader(os.Stdin)
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	Confirm = strings.TrimSpace(answer)
+	return Confirm == target
+}
+
+func createRestore(target string) {
+	if InPlace && !confirmInPlace(target) {
+		fmt.Println("the in-place restore of " + target + " was not confirmed")
+		return
+	}
+
+	newInstance, err := getRestoreParams(target)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	result := crv1.Pgrestore{}
+	err = RestClient.Post().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(Namespace).
+		Body(newInstance).
+		Do().Into(&result)
+	if err != nil {
+		log.Error("error in creating Pgrestore CRD instance " + err.Error())
+		return
+	}
+	fmt.Println("created Pgrestore " + newInstance.Spec.Name)
+	fmt.Println("follow its progress with pgo show restore " + target)
+}
+
+func getRestoreParams(target string) (*crv1.Pgrestore, error) {
+	t := time.Now()
+
+	spec := crv1.PgrestoreSpec{}
+	spec.Name = target + "-" + t.Format("20060102150405")
+	spec.TargetCluster = target
+	spec.InPlace = InPlace
+	spec.Confirm = Confirm
+	spec.RESTORE_FROM = RestoreFrom
+	spec.PITR_TARGET = PitrTarget
+	spec.SECRET_FROM = SecretFrom
+	spec.RequestDate = t.Format(time.RFC3339)
+	if u, err := user.Current(); err == nil {
+		spec.Username = u.Username
+	}
+
+	spec.BackupName = BackupName
+	if BackupName == "" && BackupPath == "" {
+		//the last backup of the cluster being recovered
+		spec.BackupName = RestoreFrom
+	}
+
+	if spec.BackupName != "" {
+		backup := crv1.Pgbackup{}
+		err := RestClient.Get().
+			Resource(crv1.PgbackupResourcePlural).
+			Namespace(Namespace).
+			Name(spec.BackupName).
+			Do().
+			Into(&backup)
+		if kerrors.IsNotFound(err) {
+			return nil, errors.New("pgbackup " + spec.BackupName + " not found")
+		} else if err != nil {
+			return nil, err
+		}
+		if spec.SECRET_FROM == "" {
+			spec.SECRET_FROM = backup.Spec.BACKUP_HOST
+		}
+		if spec.RESTORE_FROM == "" && PitrTarget != "" {
+			spec.RESTORE_FROM = backup.Spec.BACKUP_HOST
+		}
+	} else {
+		if spec.SECRET_FROM == "" && !InPlace {
+			return nil, errors.New("--secret-from is required to restore a --backup-path into a new cluster")
+		}
+		spec.BACKUP_TARGET = getBackupTarget()
+		spec.BACKUP_PATH = BackupPath
+		spec.BACKUP_PVC_NAME = BackupPVC
+		if spec.BACKUP_TARGET == crv1.BACKUP_TARGET_S3 {
+			spec.ObjectStorage = getObjectStorageSpec()
+		}
+		options := crv1.PgclusterSpec{SECRET_FROM: spec.SECRET_FROM, BACKUP_PATH: BackupPath}
+		setRestoreOptions(&options)
+		spec.COMPRESSION = options.BACKUP_COMPRESSION
+		spec.ENCRYPTION_SECRET = options.BACKUP_ENCRYPTION_SECRET
+	}
+
+	if !InPlace {
+		//a new cluster is created with the pgo.yaml cluster settings
+		spec.ClusterSpec = getClusterParams(target).Spec
+	}
+
+	restoreLabels := make(map[string]string)
+	restoreLabels["pg-cluster"] = target
+
+	newInstance := &crv1.Pgrestore{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name:   spec.Name,
+			Labels: restoreLabels,
+		},
+		Spec: spec,
+		Status: crv1.PgrestoreStatus{
+			State:   crv1.PgrestoreStateCreated,
+			Message: "Created, not processed yet",
+		},
+	}
+	return newInstance, nil
+}
+
+func showRestore(args []string) {
+	log.Debugf("showRestore called %v\n", args)
+
+	for _, arg := range args {
+		myselector := labels.Everything()
+		if arg != "all" {
+			myselector = labels.SelectorFromSet(labels.Set{"pg-cluster": arg})
+		}
+
+		restoreList := crv1.PgrestoreList{}
+		err := RestClient.Get().
+			Resource(crv1.PgrestoreResourcePlural).
+			Namespace(Namespace).
+			LabelsSelectorParam(myselector).
+			Do().
+			Into(&restoreList)
+		if err != nil {
+			log.Error("error getting list of pgrestores " + err.Error())
+			return
+		}
+		if len(restoreList.Items) == 0 {
+			fmt.Println("no pgrestores found for " + arg)
+		}
+		for _, r := range restoreList.Items {
+			printRestoreCRD(&r)
+		}
+	}
+}
+
+func printRestoreCRD(result *crv1.Pgrestore) {
+	target := result.Spec.TargetCluster
+	if result.Spec.InPlace {
+		target = target + " (in place)"
+	}
+	source := result.Spec.BACKUP_PATH
+	if result.Spec.BackupName != "" {
+		source = "pgbackup " + result.Spec.BackupName + " " + source
+	}
+
+	fmt.Printf("%s%s\n", "", "")
+	fmt.Printf("%s%s\n", "", "pgrestore : "+result.Spec.Name)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Target Cluster:\t"+target)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Source:\t\t"+source)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Target:\t"+result.Spec.BACKUP_TARGET)
+	if result.Spec.BACKUP_PVC_NAME != "" {
+		fmt.Printf("%s%s\n", TREE_BRANCH, "Backup PVC:\t"+result.Spec.BACKUP_PVC_NAME)
+	}
+	if result.Spec.PITR_TARGET != "" {
+		fmt.Printf("%s%s\n", TREE_BRANCH, "PITR Target:\t"+result.Spec.PITR_TARGET+" (WAL of "+result.Spec.RESTORE_FROM+")")
+	}
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Secret From:\t"+result.Spec.SECRET_FROM)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Requested By:\t"+result.Spec.Username+" at "+result.Spec.RequestDate)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Start Time:\t"+result.Status.StartTime)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Completion Time:\t"+result.Status.CompletionTime)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Phase:\t\t"+result.Status.Phase)
+	fmt.Printf("%s%s\n", TREE_TRUNK, "Message:\t"+result.Status.Message)
+	for _, h := range result.Status.History {
+		fmt.Printf("\t%s %s %s\n", h.Time, h.Phase, h.Message)
+	}
+}
+
+func deleteRestore(args []string) {
+	log.Debugf("deleteRestore called %v\n", args)
+	restoreList := crv1.PgrestoreList{}
+	err := RestClient.Get().
+		Resource(crv1.PgrestoreResourcePlural).
+		Namespace(Namespace).
+		Do().Into(&restoreList)
+	if err != nil {
+		log.Error("error getting restore list " + err.Error())
+		return
+	}
+
+	// removing a pgrestore only removes the record of the restore,
+	// the restored cluster is left as it is
+	for _, arg := range args {
+		restoreFound := false
+		for _, r := range restoreList.Items {
+			if arg == "all" || r.Spec.Name == arg || r.Spec.TargetCluster == arg {
+				restoreFound = true
+				err = RestClient.Delete().
+					Resource(crv1.PgrestoreResourcePlural).
+					Namespace(Namespace).
+					Name(r.Spec.Name).
+					Do().
+					Error()
+				if err != nil {
+					log.Error("error deleting pgrestore " + r.Spec.Name + " " + err.Error())
+				} else {
+					fmt.Println("deleted pgrestore " + r.Spec.Name)
+				}
+			}
+		}
+		if !restoreFound {
+			fmt.Println("restore " + arg + " not found")
+		}
+	}
+}
@@ -21,6 +21,7 @@ import (
 
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	"github.com/crunchydata/kraken/client"
+	"github.com/crunchydata/kraken/util"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -237,6 +238,33 @@ func validateConfig() {
 			os.Exit(2)
 		}
 	}
+	objectStorage := getObjectStorageSpec()
+	err := util.ValidateBackupTarget(getBackupTarget(), &objectStorage)
+	if err != nil {
+		log.Error("invalid BACKUP_TARGET or BACKUP_OBJECT_STORAGE settings, " + err.Error())
+		os.Exit(2)
+	}
+	retention := viper.GetString("BACKUP_RETENTION_DAYS")
+	if retention != "" {
+		_, err = strconv.Atoi(retention)
+		if err != nil {
+			log.Error("BACKUP_RETENTION_DAYS not a valid integer")
+			os.Exit(2)
+		}
+	}
+	verifyTimeout := viper.GetString("BACKUP_VERIFY.TIMEOUT")
+	if verifyTimeout != "" {
+		_, err = strconv.Atoi(verifyTimeout)
+		if err != nil {
+			log.Error("BACKUP_VERIFY.TIMEOUT not a valid integer")
+			os.Exit(2)
+		}
+	}
+	err = util.ValidateBackupOptions(viper.GetString("BACKUP_OPTIONS.COMPRESSION"), viper.GetString("BACKUP_OPTIONS.COMPRESSION_LEVEL"))
+	if err != nil {
+		log.Error("BACKUP_OPTIONS " + err.Error())
+		os.Exit(2)
+	}
 	passwordLen := viper.GetString("CLUSTER.PASSWORD_LENGTH")
 	if passwordLen != "" {
 		_, err := resource.ParseQuantity(passwordLen)
@@ -245,7 +273,38 @@ func validateConfig() {
 			os.Exit(2)
 		}
 	}
+	err = getSecretStoreConfig().Validate()
+	if err != nil {
+		log.Error("SECRET_STORE " + err.Error())
+		os.Exit(2)
+	}
+
+}
+
+// getSecretStoreConfig returns the secret store holding the database
+// credentials, the Vault token is read from the VAULT_TOKEN environment
+// variable so it is never kept in the .pgo.yaml
+func getSecretStoreConfig() *util.SecretStoreConfig {
+	config := &util.SecretStoreConfig{
+		Backend:       viper.GetString("SECRET_STORE.BACKEND"),
+		VaultAddr:     viper.GetString("SECRET_STORE.VAULT_ADDR"),
+		VaultToken:    os.Getenv("VAULT_TOKEN"),
+		VaultKVMount:  viper.GetString("SECRET_STORE.VAULT_KV_MOUNT"),
+		VaultKVPrefix: viper.GetString("SECRET_STORE.VAULT_KV_PREFIX"),
+	}
+	config.SetDefaults()
+	return config
+}
 
+// GetSecretStore returns the secret store holding the database
+// credentials of the namespace
+func GetSecretStore() util.SecretStore {
+	store, err := util.NewSecretStore(Clientset, getSecretStoreConfig(), Namespace)
+	if err != nil {
+		log.Error("SECRET_STORE " + err.Error())
+		os.Exit(2)
+	}
+	return store
 }
 
 func ConnectToKube() {
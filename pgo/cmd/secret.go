@@ -0,0 +1,183 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/util"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// PrintSecrets prints the credentials of a cluster with the passwords
+// redacted unless --reveal or --secrets-file ask for them, the user
+// must then be allowed to get the secrets and the reveal is recorded
+// in a pgsecretlog before any password is shown, out is the
+// --secrets-file the credentials are written to instead of the terminal
+func PrintSecrets(cluster *crv1.Pgcluster, out io.Writer) {
+
+	credentials, err := GetSecretStore().List(cluster.Spec.Name)
+	if err != nil {
+		log.Error("error getting list of secrets" + err.Error())
+		return
+	}
+
+	log.Debug("secrets for " + cluster.Spec.Name)
+	reveal := RevealSecrets || out != nil
+	if reveal && len(credentials) > 0 {
+		err = revealSecrets(cluster.Spec.Name, credentials, out != nil)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+	}
+
+	for _, s := range credentials {
+		password := util.REDACTED_PASSWORD
+		if reveal {
+			password = s.Password
+		}
+		if out != nil {
+			writeSecret(out, cluster, &s, password)
+			continue
+		}
+		fmt.Println("")
+		fmt.Println("secret : " + s.Name)
+		if ConnectionString {
+			fmt.Println(TREE_TRUNK + "uri: " + util.GetClusterConnectionString(cluster, Namespace, s.Username, password))
+		} else {
+			fmt.Println(TREE_BRANCH + "username: " + s.Username)
+			fmt.Println(TREE_TRUNK + "password: " + password)
+		}
+	}
+	if out != nil && len(credentials) > 0 {
+		fmt.Println(TREE_TRUNK + "secrets written to " + SecretsFile)
+	}
+
+}
+
+// revealSecrets checks the user of the kubeconfig may get the secrets
+// of the namespace and records the reveal of the passwords
+func revealSecrets(clusterName string, credentials []util.Credential, toFile bool) error {
+	allowed, reason, err := util.CanRevealSecrets(Clientset, "", nil, Namespace)
+	if err != nil {
+		return errors.New("could not check access to the secrets " + err.Error())
+	}
+	if !allowed {
+		message := "not allowed to get the secrets of namespace " + Namespace
+		if reason != "" {
+			message = message + ", " + reason
+		}
+		return errors.New(message)
+	}
+
+	spec := crv1.PgsecretlogSpec{
+		ClusterName:   clusterName,
+		Username:      getUsername(),
+		RequestedFrom: crv1.SECRET_REQUEST_PGO,
+		Output:        crv1.SECRET_OUTPUT_TERMINAL,
+		Format:        crv1.SECRET_FORMAT_PLAIN,
+	}
+	if toFile {
+		spec.Output = crv1.SECRET_OUTPUT_FILE
+	}
+	if ConnectionString {
+		spec.Format = crv1.SECRET_FORMAT_URI
+	}
+	for _, s := range credentials {
+		spec.Secrets = append(spec.Secrets, s.Name)
+	}
+
+	err = util.RecordSecretReveal(RestClient, &spec, Namespace)
+	if err != nil {
+		return errors.New("the passwords were not revealed, the audit record could not be written " + err.Error())
+	}
+	return nil
+}
+
+// openSecretsFile creates the --secrets-file, readable by its owner
+// only as it holds passwords
+func openSecretsFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Chmod(0600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeSecret writes a credential to the --secrets-file, a connection
+// string or the cluster, secret, username and password separated by
+// tabs
+func writeSecret(out io.Writer, cluster *crv1.Pgcluster, s *util.Credential, password string) {
+	var err error
+	if ConnectionString {
+		_, err = fmt.Fprintln(out, util.GetClusterConnectionString(cluster, Namespace, s.Username, password))
+	} else {
+		_, err = fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", cluster.Spec.Name, s.Name, s.Username, password)
+	}
+	if err != nil {
+		log.Error("error writing " + SecretsFile + " " + err.Error())
+	}
+}
+
+// secretlogByDate orders pgsecretlogs oldest first
+type secretlogByDate []crv1.Pgsecretlog
+
+func (s secretlogByDate) Len() int           { return len(s) }
+func (s secretlogByDate) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s secretlogByDate) Less(i, j int) bool { return s[i].Spec.RequestDate < s[j].Spec.RequestDate }
+
+func showSecretlog(args []string) {
+	secretlogList := crv1.PgsecretlogList{}
+	err := RestClient.Get().
+		Resource(crv1.PgsecretlogResourcePlural).
+		Namespace(Namespace).
+		Do().Into(&secretlogList)
+	if err != nil {
+		log.Error("error getting list of secretlogs" + err.Error())
+		return
+	}
+
+	items := secretlogList.Items
+	sort.Sort(secretlogByDate(items))
+
+	itemFound := false
+	for _, arg := range args {
+		for _, l := range items {
+			if arg != "all" && l.Spec.ClusterName != arg {
+				continue
+			}
+			itemFound = true
+			fmt.Println("")
+			fmt.Println("secretlog : " + l.ObjectMeta.Name + " cluster : " + l.Spec.ClusterName)
+			fmt.Printf("%s%s revealed by %s from %s to %s as %s\n", TREE_BRANCH, l.Spec.RequestDate,
+				l.Spec.Username, l.Spec.RequestedFrom, l.Spec.Output, l.Spec.Format)
+			fmt.Println(TREE_TRUNK + "secrets : " + strings.Join(l.Spec.Secrets, ", "))
+		}
+	}
+	if !itemFound {
+		fmt.Println("no secret reveals found")
+	}
+}
@@ -26,17 +26,26 @@ const TREE_TRUNK = "└── "
 var PostgresVersion string
 var ShowPVC bool
 var ShowSecrets bool
+var RevealSecrets, ConnectionString bool
+var SecretsFile string
 var PVCRoot string
+var ShowCompliance bool
+var PolicylogCluster, PolicylogPolicy, PolicylogUser string
+var PolicylogSince, PolicylogUntil string
 
 var ShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "show a description of a cluster",
-	Long: `show allows you to show the details of a policy, backup, pvc, or cluster.
+	Long: `show allows you to show the details of a policy, backup, restore, pvc, or cluster.
 For example:
 
 	pgo show policy policy1
+	pgo show policylog all --cluster=mycluster
 	pgo show pvc mypvc
 	pgo show backup mycluster
+	pgo show restore mycluster
+	pgo show user mycluster
+	pgo show secretlog mycluster
 	pgo show cluster mycluster`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
@@ -45,15 +54,23 @@ Valid resource types include:
 	* cluster
 	* pvc
 	* policy
+	* policylog
 	* upgrade
-	* backup`)
+	* backup
+	* restore
+	* secretlog
+	* user`)
 		} else {
 			switch args[0] {
 			case "cluster":
 			case "pvc":
 			case "policy":
+			case "policylog":
 			case "upgrade":
 			case "backup":
+			case "restore":
+			case "secretlog":
+			case "user":
 				break
 			default:
 				fmt.Println(`You must specify the type of resource to show.  
@@ -61,8 +78,12 @@ Valid resource types include:
 	* cluster
 	* pvc
 	* policy
+	* policylog
 	* upgrade
-	* backup`)
+	* backup
+	* restore
+	* secretlog
+	* user`)
 			}
 		}
 
@@ -74,8 +95,12 @@ func init() {
 	ShowCmd.AddCommand(ShowClusterCmd)
 	ShowCmd.AddCommand(ShowBackupCmd)
 	ShowCmd.AddCommand(ShowPolicyCmd)
+	ShowCmd.AddCommand(ShowPolicylogCmd)
 	ShowCmd.AddCommand(ShowPVCCmd)
 	ShowCmd.AddCommand(ShowUpgradeCmd)
+	ShowCmd.AddCommand(ShowRestoreCmd)
+	ShowCmd.AddCommand(ShowSecretlogCmd)
+	ShowCmd.AddCommand(ShowUserCmd)
 
 	// Here you will define your flags and configuration settings.
 
@@ -87,11 +112,20 @@ func init() {
 	// is called directly, e.g.:
 	// ShowCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
-	ShowClusterCmd.Flags().BoolVarP(&ShowSecrets, "show-secrets", "s", false, "Show secrets ")
+	ShowClusterCmd.Flags().BoolVarP(&ShowSecrets, "show-secrets", "s", false, "Show secrets, the passwords are redacted ")
+	ShowClusterCmd.Flags().BoolVarP(&RevealSecrets, "reveal", "", false, "Show the passwords of the secrets, requires access to the secrets and is audited")
+	ShowClusterCmd.Flags().StringVarP(&SecretsFile, "secrets-file", "", "", "Write the credentials, passwords included, to this file instead, requires access to the secrets and is audited")
+	ShowClusterCmd.Flags().BoolVarP(&ConnectionString, "connection-string", "", false, "Show the secrets as postgresql:// connection strings")
 	ShowClusterCmd.Flags().StringVarP(&PostgresVersion, "version", "v", "", "The postgres version to filter on")
 	ShowPVCCmd.Flags().StringVarP(&PVCRoot, "pvc-root", "r", "", "The PVC directory to list")
 
 	ShowBackupCmd.Flags().BoolVarP(&ShowPVC, "show-pvc", "p", false, "Show backup archive PVC listing ")
+	ShowPolicyCmd.Flags().BoolVarP(&ShowCompliance, "compliance", "", false, "Show the compliance of each cluster the policy is applied to")
+	ShowPolicylogCmd.Flags().StringVarP(&PolicylogCluster, "cluster", "", "", "Only show the policy runs on this cluster")
+	ShowPolicylogCmd.Flags().StringVarP(&PolicylogPolicy, "policy", "", "", "Only show the runs of this policy")
+	ShowPolicylogCmd.Flags().StringVarP(&PolicylogUser, "user", "", "", "Only show the policy runs requested by this user")
+	ShowPolicylogCmd.Flags().StringVarP(&PolicylogSince, "since", "", "", "Only show the policy runs on or after this date, 2006-01-02 or RFC3339")
+	ShowPolicylogCmd.Flags().StringVarP(&PolicylogUntil, "until", "", "", "Only show the policy runs before this date, 2006-01-02 or RFC3339")
 
 }
 
@@ -100,16 +134,36 @@ var ShowPolicyCmd = &cobra.Command{
 	Short: "Show policy information",
 	Long: `Show policy information. For example:
 
-				pgo show policy policy1`,
+				pgo show policy policy1
+				pgo show policy all --compliance`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if len(args) == 0 {
 			log.Error("policy name(s) required for this command")
+		} else if ShowCompliance {
+			showPolicyCompliance(args)
 		} else {
 			showPolicy(args)
 		}
 	},
 }
 
+var ShowPolicylogCmd = &cobra.Command{
+	Use:   "policylog",
+	Short: "Show the policy audit trail",
+	Long: `Show every run of policies on clusters, who asked for it and its outcome. For example:
+
+				pgo show policylog all
+				pgo show policylog all --cluster=mycluster --since=2017-11-01
+				pgo show policylog all --policy=policy1 --user=bob`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Error("policylog name(s) or all required for this command")
+		} else {
+			showPolicylog(args)
+		}
+	},
+}
+
 var ShowPVCCmd = &cobra.Command{
 	Use:   "pvc",
 	Short: "Show pvc information",
@@ -156,12 +210,64 @@ var ShowBackupCmd = &cobra.Command{
 	},
 }
 
+var ShowRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Show restore information",
+	Long: `Show the restores of a cluster. For example:
+
+				pgo show restore mycluster
+				pgo show restore all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Error("cluster name(s) required for this command")
+		} else {
+			showRestore(args)
+		}
+	},
+}
+
+var ShowUserCmd = &cobra.Command{
+	Use:   "user",
+	Short: "Show the roles and grants of a cluster",
+	Long: `Show the roles of a cluster, the group roles they are members of and the
+privileges granted to them, as read from the catalogs of the cluster. For example:
+
+				pgo show user mycluster`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Error("cluster name(s) required for this command")
+		} else {
+			showUserGrants(args)
+		}
+	},
+}
+
+var ShowSecretlogCmd = &cobra.Command{
+	Use:   "secretlog",
+	Short: "Show the secret audit trail",
+	Long: `Show every time the passwords of a cluster were revealed, who asked for them
+and where they were written. For example:
+
+				pgo show secretlog mycluster
+				pgo show secretlog all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			log.Error("cluster name(s) required for this command")
+		} else {
+			showSecretlog(args)
+		}
+	},
+}
+
 var ShowClusterCmd = &cobra.Command{
 	Use:   "cluster",
 	Short: "Show cluster information",
 	Long: `Show a crunchy cluster. For example:
 
-				pgo show cluster mycluster`,
+				pgo show cluster mycluster
+				pgo show cluster mycluster --show-secrets
+				pgo show cluster mycluster --reveal --connection-string
+				pgo show cluster mycluster --secrets-file=/tmp/mycluster.secrets`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if Labelselector == "" && len(args) == 0 {
 			log.Error("cluster name(s) required for this command")
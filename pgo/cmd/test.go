@@ -19,6 +19,7 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
 	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -93,17 +94,16 @@ func testServices(cluster *crv1.Pgcluster) {
 		return
 	}
 
-	lo = meta_v1.ListOptions{LabelSelector: "pg-database=" + cluster.Spec.Name}
-	secrets, err := Clientset.Core().Secrets(Namespace).List(lo)
+	secrets, err := GetSecretStore().List(cluster.Spec.Name)
 	if err != nil {
 		log.Error("error getting list of secrets" + err.Error())
 		return
 	}
 
 	for _, service := range services.Items {
-		for _, s := range secrets.Items {
-			username := string(s.Data["username"][:])
-			password := string(s.Data["password"][:])
+		for _, s := range secrets {
+			username := s.Username
+			password := s.Password
 			database := "postgres"
 			if username == cluster.Spec.PG_USER {
 				database = cluster.Spec.PG_DATABASE
@@ -124,7 +124,7 @@ func query(dbUser, dbHost, dbPort, database, dbPassword string) bool {
 	var conn *sql.DB
 	var err error
 
-	conn, err = sql.Open("postgres", "sslmode=disable user="+dbUser+" host="+dbHost+" port="+dbPort+" dbname="+database+" password="+dbPassword)
+	conn, err = sql.Open("postgres", sqlutil.ConnString(dbHost, dbPort, dbUser, dbPassword, database))
 	if err != nil {
 		log.Debug(err.Error())
 		return false
@@ -16,40 +16,22 @@
 package cmd
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	"github.com/crunchydata/kraken/util"
-	_ "github.com/lib/pq"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"os"
 	"strconv"
+	"strings"
 	"time"
-	//"io/ioutil"
-	//kerrors "k8s.io/apimachinery/pkg/api/errors"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	//"os/user"
-	//"strings"
 )
 
-type ConnInfo struct {
-	Username string
-	Hostip   string
-	Port     string
-	Database string
-	Password string
-}
-type PswResult struct {
-	Rolname       string
-	Rolvaliduntil string
-	ConnDetails   ConnInfo
-}
-
 const DEFAULT_AGE_DAYS = 365
-const DEFAULT_PSW_LEN = 8
 
 var PasswordAgeDays, PasswordLength int
 
@@ -57,25 +39,42 @@ var ChangePasswordForUser string
 var DeleteUser string
 var ValidDays string
 var UserDBAccess string
+var UserPrivileges string
 var AddUser string
 var Expired string
 var UpdatePasswords bool
-var ManagedUser bool
+var RotateBefore int
+var UserGroup bool
+var UserMemberOf string
+var UserTemplate string
+var UserSchemas string
+var UserGrants []string
+var UserDefaultOwner string
+var UserConnectionLimit int
+var UserSettings []string
 
 var userCmd = &cobra.Command{
 	Use:   "user",
 	Short: "manage users",
-	Long: `USER allows you to manage users and passwords across a set of clusters
+	Long: `USER allows you to manage users and passwords across a set of clusters,
+each user is a pguser the operator maintains on the clusters it names
 For example:
 
-pgo user --selector=name=mycluster --update
+pgo user --selector=name=mycluster
 pgo user --expired=7 --selector=name=mycluster
-pgo user --add-user=bob --selector=sname=mycluster
-pgo user --change-password=bob --selector=sname=mycluster
+pgo user --expired=7 --update-passwords --selector=name=mycluster
+pgo user --add-user=bob --selector=name=mycluster
+pgo user --add-user=bob --db=userdb --privileges=CONNECT,TEMPORARY --selector=name=mycluster
+pgo user --add-user=readers --group --db=userdb --template=readonly --schemas=public,sales --selector=name=mycluster
+pgo user --add-user=bob --member-of=readers --selector=name=mycluster
+pgo user --add-user=bob --grant="SELECT,INSERT on tables orders in sales" --grant="USAGE on schema sales" --selector=name=mycluster
+pgo user --add-user=batch --connection-limit=10 --setting=statement_timeout=5min --setting=work_mem=64MB --selector=name=mycluster
+pgo user --change-password=bob --selector=name=mycluster
+pgo user --delete-user=bob --selector=name=mycluster
 .`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Debug("user called")
-		userManager()
+		userManager(cmd)
 	},
 }
 
@@ -87,35 +86,68 @@ func init() {
 	userCmd.Flags().IntVarP(&PasswordAgeDays, "valid-days", "v", 30, "--valid-days=7 sets passwords for new users to 7 days")
 	userCmd.Flags().StringVarP(&AddUser, "add-user", "a", "", "--add-user=bob adds a new user to selective clusters")
 	userCmd.Flags().StringVarP(&ChangePasswordForUser, "change-password", "c", "", "--change-password=bob updates the password for a user on selective clusters")
-	userCmd.Flags().StringVarP(&UserDBAccess, "db", "b", "", "--db=userdb grants the user access to a database")
+	userCmd.Flags().StringVarP(&UserDBAccess, "db", "b", "", "--db=userdb grants the user access to a database, several are separated by commas")
+	userCmd.Flags().StringVarP(&UserPrivileges, "privileges", "", "", "--privileges=CONNECT,TEMPORARY sets the privileges granted on --db, ALL by default")
 	userCmd.Flags().StringVarP(&DeleteUser, "delete-user", "d", "", "--delete-user=bob deletes a user on selective clusters")
 	userCmd.Flags().BoolVarP(&UpdatePasswords, "update-passwords", "u", false, "--update-passwords performs password updating on expired passwords")
-	userCmd.Flags().BoolVarP(&ManagedUser, "managed", "m", false, "--managed creates a user with secrets")
+	userCmd.Flags().IntVarP(&RotateBefore, "rotate-before", "", 0, "--rotate-before=7 has the operator rotate the password of --add-user 7 days before it expires")
+	userCmd.Flags().BoolVarP(&UserGroup, "group", "", false, "--group makes --add-user a group role that can not log in, other users join it with --member-of")
+	userCmd.Flags().StringVarP(&UserMemberOf, "member-of", "", "", "--member-of=readers makes --add-user a member of group roles, several are separated by commas")
+	userCmd.Flags().StringVarP(&UserTemplate, "template", "", "", "--template=readonly grants --add-user read only or read write access to the tables and sequences of --schemas")
+	userCmd.Flags().StringVarP(&UserSchemas, "schemas", "", "", "--schemas=public,sales sets the schemas --template applies to, public by default")
+	userCmd.Flags().StringArrayVarP(&UserGrants, "grant", "", []string{}, "--grant=\"SELECT on tables in sales default\" grants --add-user privileges on a schema or its tables, sequences or functions, may be repeated")
+	userCmd.Flags().IntVarP(&UserConnectionLimit, "connection-limit", "", 0, "--connection-limit=10 limits --add-user to 10 connections at once, 0 means no limit")
+	userCmd.Flags().StringArrayVarP(&UserSettings, "setting", "", []string{}, "--setting=statement_timeout=5min sets a server setting each time --add-user logs in, an empty value removes it, may be repeated")
+	userCmd.Flags().StringVarP(&UserDefaultOwner, "default-owner", "", "", "--default-owner=app has the default privileges of --add-user apply to the objects app creates, postgres by default")
 	getDefaults()
 
 }
 
-func userManager() {
-	//build the selector based on the selector parameter
-
-	//set up the selector
-	var sel string
-	if Selector != "" {
-		sel = Selector + ",pg-cluster,!replica"
-	} else {
+func userManager(cmd *cobra.Command) {
+	if Selector == "" {
 		log.Error("--selector is required")
 		return
+	}
+
+	clusters, err := getUserClusters(Selector)
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+	if len(clusters) == 0 {
+		fmt.Println("no clusters found")
+		return
+	}
 
+	if AddUser != "" {
+		addUser(AddUser, clusters, cmd.Flags().Changed("connection-limit"))
 	}
+	if ChangePasswordForUser != "" {
+		changePassword(ChangePasswordForUser, clusters, cmd.Flags().Changed("valid-days"))
+	}
+	if DeleteUser != "" {
+		deleteUser(DeleteUser, clusters)
+	}
+	if Expired != "" {
+		showExpired(clusters, Expired)
+	}
+	if AddUser == "" && ChangePasswordForUser == "" && DeleteUser == "" && Expired == "" {
+		showUsers(clusters)
+	}
+}
+
+// getUserClusters returns the names of the clusters matching a selector
+func getUserClusters(selector string) ([]string, error) {
+	names := []string{}
+
+	sel := selector + ",pg-cluster,!replica"
 	log.Debug("selector string=[" + sel + "]")
 
 	myselector, err := labels.Parse(sel)
 	if err != nil {
-		log.Error("could not parse --selector value " + err.Error())
-		return
+		return names, fmt.Errorf("could not parse --selector value %s", err.Error())
 	}
 
-	//get the clusters list
 	clusterList := crv1.PgclusterList{}
 	err = RestClient.Get().
 		Resource(crv1.PgclusterResourcePlural).
@@ -124,334 +156,538 @@ func userManager() {
 		Do().
 		Into(&clusterList)
 	if err != nil {
-		log.Error("error getting cluster list" + err.Error())
-		return
+		return names, fmt.Errorf("error getting cluster list %s", err.Error())
 	}
 
-	if len(clusterList.Items) == 0 {
-		fmt.Println("no clusters found")
-		return
+	for _, cluster := range clusterList.Items {
+		names = append(names, cluster.Spec.Name)
 	}
+	return names, nil
+}
 
-	for _, cluster := range clusterList.Items {
+// getPguser returns the pguser of a role, nil if there is none
+func getPguser(name string) (*crv1.Pguser, error) {
+	result := crv1.Pguser{}
+	err := RestClient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Name(name).
+		Do().
+		Into(&result)
+	if kerrors.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
 
-		//log.Infoln("working on cluster " + cluster.Spec.Name)
-		//log.Infof("clusters %v\n", clusterList.Items)
-		sel = "pg-cluster=" + cluster.Spec.Name + ",!replica"
-		lo := meta_v1.ListOptions{LabelSelector: sel}
-		deployments, err := Clientset.ExtensionsV1beta1().Deployments(Namespace).List(lo)
+func updatePguser(pguser *crv1.Pguser) error {
+	return RestClient.Put().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Name(pguser.ObjectMeta.Name).
+		Body(pguser).
+		Do().
+		Error()
+}
+
+// addUser creates the pguser of a new role on the selected clusters,
+// or adds the clusters and databases to the pguser of an existing one
+func addUser(name string, clusters []string, connectionLimitChanged bool) {
+	databases := []string{crv1.PGUSER_DEFAULT_DATABASE}
+	if UserDBAccess != "" {
+		databases = strings.Split(UserDBAccess, ",")
+	}
+	privileges := []string{}
+	if UserPrivileges != "" {
+		privileges = strings.Split(UserPrivileges, ",")
+	}
+	grants := []crv1.PgGrantSpec{}
+	for _, line := range UserGrants {
+		grant, err := util.ParseGrant(line)
 		if err != nil {
-			log.Error("error getting list of deployments" + err.Error())
+			log.Error(err.Error())
 			return
 		}
+		grants = append(grants, grant)
+	}
 
-		for _, d := range deployments.Items {
-			info := getPostgresUserInfo(d.ObjectMeta.Name)
+	pguser, err := getPguser(name)
+	if err != nil {
+		log.Error("error getting pguser " + name + " " + err.Error())
+		return
+	}
 
-			if ChangePasswordForUser != "" {
-				fmt.Println("changing password of user " + ChangePasswordForUser + " on " + d.ObjectMeta.Name)
-				newPassword := util.GeneratePassword(PasswordLength)
-				newExpireDate := GeneratePasswordExpireDate(PasswordAgeDays)
-				err = updatePassword(cluster.Spec.Name, info, ChangePasswordForUser, newPassword, newExpireDate)
-				if err != nil {
-					log.Error(err.Error())
-					os.Exit(2)
-				}
-			}
-			if DeleteUser != "" {
-				fmt.Println("deleting user " + DeleteUser + " from " + d.ObjectMeta.Name)
-				deleteUser(cluster.Spec.Name, info, DeleteUser)
-			}
-			if AddUser != "" {
-				fmt.Println("adding new user " + AddUser + " to " + d.ObjectMeta.Name)
-				addUser(d.ObjectMeta.Name, info, AddUser)
-				newPassword := util.GeneratePassword(PasswordLength)
-				newExpireDate := GeneratePasswordExpireDate(PasswordAgeDays)
-				err = updatePassword(cluster.Spec.Name, info, AddUser, newPassword, newExpireDate)
-				if err != nil {
-					log.Error(err.Error())
-					os.Exit(2)
-				}
+	if pguser != nil {
+		for _, c := range clusters {
+			if !containsName(pguser.Spec.Clusters, c) {
+				pguser.Spec.Clusters = append(pguser.Spec.Clusters, c)
 			}
-
-			if Expired != "" {
-				results := callDB(info, d.ObjectMeta.Name, Expired)
-				if len(results) > 0 {
-					fmt.Println("expired passwords....")
-					for _, v := range results {
-						fmt.Printf("RoleName %s Role Valid Until %s\n", v.Rolname, v.Rolvaliduntil)
-						if UpdatePasswords {
-							newPassword := util.GeneratePassword(PasswordLength)
-							newExpireDate := GeneratePasswordExpireDate(PasswordAgeDays)
-							err = updatePassword(cluster.Spec.Name, v.ConnDetails, v.Rolname, newPassword, newExpireDate)
-							if err != nil {
-								fmt.Println("error in updating password")
-							}
-							fmt.Printf("new password for %s is %s new expiration is %s\n", v.Rolname, newPassword, newExpireDate)
-						}
-					}
+		}
+		if UserDBAccess != "" {
+			for _, d := range databases {
+				if !containsName(pguser.Spec.Databases, d) {
+					pguser.Spec.Databases = append(pguser.Spec.Databases, d)
 				}
 			}
-
 		}
+		if len(privileges) > 0 {
+			pguser.Spec.Privileges = privileges
+		}
+		if RotateBefore > 0 {
+			pguser.Spec.Rotation = getUserRotation()
+		}
+		if UserGroup != pguser.Spec.Group {
+			log.Error("user " + name + " already exists, a role can not be turned into a group role or back")
+			return
+		}
+		setUserGrants(&pguser.Spec, grants)
+		if connectionLimitChanged {
+			pguser.Spec.ConnectionLimit = UserConnectionLimit
+		}
+		err = setUserSettings(&pguser.Spec)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		err = util.ValidatePguserSpec(&pguser.Spec)
+		if err != nil {
+			log.Error(err.Error())
+			return
+		}
+		err = updatePguser(pguser)
+		if err != nil {
+			log.Error("error updating pguser " + name + " " + err.Error())
+			return
+		}
+		fmt.Println("adding user " + name + " to " + strings.Join(clusters, ", "))
+		return
 	}
 
-}
-
-func callDB(info ConnInfo, clusterName, maxdays string) []PswResult {
-	var conn *sql.DB
-	var err error
-
-	results := []PswResult{}
-
-	conn, err = sql.Open("postgres", "sslmode=disable user="+info.Username+" host="+info.Hostip+" port="+info.Port+" dbname="+info.Database+" password="+info.Password)
+	spec := crv1.PguserSpec{}
+	spec.Name = name
+	spec.Clusters = clusters
+	spec.Databases = databases
+	spec.Privileges = privileges
+	spec.ValidDays = PasswordAgeDays
+	spec.PasswordLength = PasswordLength
+	spec.PasswordDate = time.Now().Format(time.RFC3339)
+	if RotateBefore > 0 {
+		spec.Rotation = getUserRotation()
+	}
+	spec.Group = UserGroup
+	setUserGrants(&spec, grants)
+	spec.ConnectionLimit = UserConnectionLimit
+	err = setUserSettings(&spec)
 	if err != nil {
-		log.Debug(err.Error())
-		return results
+		log.Error(err.Error())
+		return
+	}
+	err = util.ValidatePguserSpec(&spec)
+	if err != nil {
+		log.Error(err.Error())
+		return
 	}
 
-	var ts string
-	var rows *sql.Rows
+	newInstance := &crv1.Pguser{
+		ObjectMeta: meta_v1.ObjectMeta{
+			Name: name,
+		},
+		Spec: spec,
+	}
 
-	querystr := "SELECT rolname, rolvaliduntil as expiring_soon FROM pg_authid WHERE rolvaliduntil < now() + '" + maxdays + " days'"
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
+	err = RestClient.Post().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Body(newInstance).
+		Do().
+		Error()
 	if err != nil {
-		log.Debug(err.Error())
-		return results
+		log.Error("error creating pguser " + name + " " + err.Error())
+		return
 	}
+	fmt.Println("adding new user " + name + " to " + strings.Join(clusters, ", "))
+}
 
-	defer func() {
-		if conn != nil {
-			conn.Close()
+// setUserGrants adds the group roles and grants given on the command
+// line to a pguser, and sets its template, schemas and default owner
+// when they are given
+func setUserGrants(spec *crv1.PguserSpec, grants []crv1.PgGrantSpec) {
+	if UserMemberOf != "" {
+		for _, g := range strings.Split(UserMemberOf, ",") {
+			if !containsName(spec.MemberOf, g) {
+				spec.MemberOf = append(spec.MemberOf, g)
+			}
 		}
-		if rows != nil {
-			rows.Close()
+	}
+	if UserTemplate != "" {
+		spec.Template = UserTemplate
+	}
+	if UserSchemas != "" {
+		spec.Schemas = strings.Split(UserSchemas, ",")
+	}
+	if UserDefaultOwner != "" {
+		spec.DefaultOwner = UserDefaultOwner
+	}
+	for i := range grants {
+		line := util.GetGrantLine(&grants[i])
+		found := false
+		for j := range spec.Grants {
+			if util.GetGrantLine(&spec.Grants[j]) == line {
+				found = true
+			}
 		}
-	}()
-
-	for rows.Next() {
-		p := PswResult{}
-		c := ConnInfo{Username: info.Username, Hostip: info.Hostip, Port: info.Port, Database: info.Database, Password: info.Password}
-		p.ConnDetails = c
-
-		if err = rows.Scan(&p.Rolname, &p.Rolvaliduntil); err != nil {
-			log.Debug(err.Error())
-			return results
+		if !found {
+			spec.Grants = append(spec.Grants, grants[i])
 		}
-		results = append(results, p)
-		log.Debug("returned " + ts)
 	}
-
-	return results
-
 }
 
-func updatePassword(clusterName string, p ConnInfo, username, newPassword, passwordExpireDate string) error {
-	var err error
-	var conn *sql.DB
-
-	conn, err = sql.Open("postgres", "sslmode=disable user="+p.Username+" host="+p.Hostip+" port="+p.Port+" dbname="+p.Database+" password="+p.Password)
-	if err != nil {
-		log.Debug(err.Error())
-		return err
+// setUserSettings sets the server settings given on the command line
+// as name=value on a pguser, an empty value removes the setting
+func setUserSettings(spec *crv1.PguserSpec) error {
+	for _, s := range UserSettings {
+		pair := strings.SplitN(s, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return errors.New("invalid setting " + s + ", must be written as name=value")
+		}
+		if spec.Settings == nil {
+			spec.Settings = make(map[string]string)
+		}
+		if pair[1] == "" {
+			delete(spec.Settings, pair[0])
+		} else {
+			spec.Settings[pair[0]] = pair[1]
+		}
 	}
+	return nil
+}
 
-	//var ts string
-	var rows *sql.Rows
-
-	querystr := "ALTER user " + username + " PASSWORD '" + newPassword + "'"
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
+// changePassword asks the operator for a new password for a role, a
+// pguser has one password date so the password changes on each of its
+// clusters
+func changePassword(name string, clusters []string, validDaysChanged bool) {
+	pguser, err := getPguser(name)
 	if err != nil {
-		log.Debug(err.Error())
-		return err
+		log.Error("error getting pguser " + name + " " + err.Error())
+		return
 	}
-	querystr = "ALTER user " + username + " VALID UNTIL '" + passwordExpireDate + "'"
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
-	if err != nil {
-		log.Debug(err.Error())
-		return err
+	if pguser == nil || !containsAny(pguser.Spec.Clusters, clusters) {
+		log.Error("user " + name + " is not managed on the selected clusters, add it with --add-user")
+		return
+	}
+	if pguser.Spec.Group {
+		log.Error("user " + name + " is a group role, it has no password")
+		return
 	}
 
-	defer func() {
-		if conn != nil {
-			conn.Close()
-		}
-		if rows != nil {
-			rows.Close()
-		}
-	}()
-
-	err = util.UpdateUserSecret(Clientset, clusterName, username, newPassword, Namespace)
+	pguser.Spec.PasswordDate = time.Now().Format(time.RFC3339)
+	if validDaysChanged {
+		pguser.Spec.ValidDays = PasswordAgeDays
+	}
+	err = updatePguser(pguser)
 	if err != nil {
-		log.Debug(err.Error())
-		return err
+		log.Error("error updating pguser " + name + " " + err.Error())
+		return
 	}
-	return err
+	fmt.Println("changing password of user " + name + " on " + strings.Join(pguser.Spec.Clusters, ", "))
+	printUserSecrets(pguser)
 }
 
-func GeneratePasswordExpireDate(daysFromNow int) string {
-
-	now := time.Now()
-	totalHours := daysFromNow * 24
-	diffDays, _ := time.ParseDuration(strconv.Itoa(totalHours) + "h")
-	futureTime := now.Add(diffDays)
-	return futureTime.Format("2006-01-02")
-
-}
-
-func getDefaults() {
-	PasswordAgeDays = DEFAULT_AGE_DAYS
-	PasswordLength = DEFAULT_PSW_LEN
-	str := viper.GetString("CLUSTER.PASSWORD_AGE_DAYS")
-	if str != "" {
-		PasswordAgeDays, _ = strconv.Atoi(str)
-		log.Debugf("PasswordAgeDays set to %d\n", PasswordAgeDays)
+// deleteUser takes the selected clusters out of the pguser of a role,
+// the operator drops the role from them, the pguser is deleted when
+// it names no clusters any more
+func deleteUser(name string, clusters []string) {
+	pguser, err := getPguser(name)
+	if err != nil {
+		log.Error("error getting pguser " + name + " " + err.Error())
+		return
+	}
+	if pguser == nil {
+		log.Error("user " + name + " is not managed, there is no pguser " + name)
+		return
+	}
 
+	remaining := []string{}
+	for _, c := range pguser.Spec.Clusters {
+		if containsName(clusters, c) {
+			fmt.Println("deleting user " + name + " from " + c)
+		} else {
+			remaining = append(remaining, c)
+		}
 	}
-	str = viper.GetString("CLUSTER.PASSWORD_LENGTH")
-	if str != "" {
-		PasswordLength, _ = strconv.Atoi(str)
-		log.Debugf("PasswordLength set to %d\n", PasswordLength)
+
+	if len(remaining) > 0 {
+		pguser.Spec.Clusters = remaining
+		err = updatePguser(pguser)
+		if err != nil {
+			log.Error("error updating pguser " + name + " " + err.Error())
+		}
+		return
 	}
 
+	err = RestClient.Delete().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Name(name).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error deleting pguser " + name + " " + err.Error())
+	}
 }
 
-func getPostgresUserInfo(clusterName string) ConnInfo {
-	info := ConnInfo{}
-
-	//get the service for the cluster
-	service, err := Clientset.CoreV1().Services(Namespace).Get(clusterName, meta_v1.GetOptions{})
+// showExpired lists the roles whose passwords expire within maxdays
+// on the selected clusters, when asked their passwords are changed
+func showExpired(clusters []string, maxdays string) {
+	days, err := strconv.Atoi(maxdays)
 	if err != nil {
-		log.Error("error getting list of services" + err.Error())
-		os.Exit(2)
-		return info
+		log.Error("invalid --expired value " + maxdays)
+		return
 	}
+	limit := time.Now().AddDate(0, 0, days)
 
-	//get the secrets for this cluster
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + clusterName}
-	secrets, err := Clientset.Secrets(Namespace).List(lo)
+	userList := crv1.PguserList{}
+	err = RestClient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Do().
+		Into(&userList)
 	if err != nil {
-		log.Error("error getting list of secrets" + err.Error())
-		os.Exit(2)
-		return info
+		log.Error("error getting pgusers " + err.Error())
+		return
 	}
 
-	//get the postgres user secret info
-	var username, password, database, hostip string
-	for _, s := range secrets.Items {
-		username = string(s.Data["username"][:])
-		password = string(s.Data["password"][:])
-		database = "postgres"
-		hostip = service.Spec.ClusterIP
-		if username == "postgres" {
-			log.Debug("got postgres user secrets")
-			break
+	fmt.Println("expired passwords....")
+	for _, pguser := range userList.Items {
+		expired := false
+		for _, cs := range pguser.Status.Clusters {
+			if !containsName(clusters, cs.Cluster) || !passwordExpires(cs.ValidUntil, limit) {
+				continue
+			}
+			fmt.Printf("RoleName %s Cluster %s Role Valid Until %s\n", pguser.Spec.Name, cs.Cluster, cs.ValidUntil)
+			expired = true
+		}
+		if expired && UpdatePasswords {
+			pguser.Spec.PasswordDate = time.Now().Format(time.RFC3339)
+			err = updatePguser(&pguser)
+			if err != nil {
+				log.Error("error updating pguser " + pguser.Spec.Name + " " + err.Error())
+				continue
+			}
+			fmt.Println("changing password of user " + pguser.Spec.Name)
+			printUserSecrets(&pguser)
 		}
 	}
-
-	//query the database for users that have expired
-	strPort := fmt.Sprint(service.Spec.Ports[0].Port)
-	info.Username = username
-	info.Password = password
-	info.Database = database
-	info.Hostip = hostip
-	info.Port = strPort
-
-	return info
 }
 
-func addUser(clusterName string, info ConnInfo, newUser string) {
-	var conn *sql.DB
-	var err error
-
-	conn, err = sql.Open("postgres", "sslmode=disable user="+info.Username+" host="+info.Hostip+" port="+info.Port+" dbname="+info.Database+" password="+info.Password)
+// showUsers prints the pgusers of the selected clusters and the state
+// of each role on them
+func showUsers(clusters []string) {
+	userList := crv1.PguserList{}
+	err := RestClient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(Namespace).
+		Do().
+		Into(&userList)
 	if err != nil {
-		log.Debug(err.Error())
-		os.Exit(2)
+		log.Error("error getting pgusers " + err.Error())
+		return
 	}
 
-	var rows *sql.Rows
-
-	querystr := "create user " + newUser
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
-	if err != nil {
-		log.Debug(err.Error())
-		os.Exit(2)
+	for _, pguser := range userList.Items {
+		if !containsAny(pguser.Spec.Clusters, clusters) {
+			continue
+		}
+		fmt.Println("")
+		if pguser.Spec.Group {
+			fmt.Println("group : " + pguser.Spec.Name)
+		} else {
+			fmt.Println("user : " + pguser.Spec.Name)
+		}
+		fmt.Println(TREE_BRANCH + "databases : " + strings.Join(pguser.Spec.Databases, ", "))
+		fmt.Println(TREE_BRANCH + "privileges : " + strings.Join(util.GetPguserPrivileges(&pguser.Spec), ", "))
+		if len(pguser.Spec.MemberOf) > 0 {
+			fmt.Println(TREE_BRANCH + "member of : " + strings.Join(pguser.Spec.MemberOf, ", "))
+		}
+		if pguser.Spec.Template != "" {
+			schemas := pguser.Spec.Schemas
+			if len(schemas) == 0 {
+				schemas = []string{crv1.PGUSER_DEFAULT_SCHEMA}
+			}
+			fmt.Println(TREE_BRANCH + "template : " + pguser.Spec.Template + " on " + strings.Join(schemas, ", "))
+		}
+		for i := range pguser.Spec.Grants {
+			fmt.Println(TREE_BRANCH + "grant : " + util.GetGrantLine(&pguser.Spec.Grants[i]))
+		}
+		if pguser.Spec.DefaultOwner != "" {
+			fmt.Println(TREE_BRANCH + "default privileges of : " + pguser.Spec.DefaultOwner)
+		}
+		if pguser.Spec.ConnectionLimit > 0 {
+			fmt.Printf("%sconnection limit : %d\n", TREE_BRANCH, pguser.Spec.ConnectionLimit)
+		}
+		for _, name := range util.GetSettingNames(pguser.Spec.Settings) {
+			fmt.Println(TREE_BRANCH + "setting : " + name + " = " + pguser.Spec.Settings[name])
+		}
+		if !pguser.Spec.Group {
+			fmt.Printf("%svalid days : %d\n", TREE_BRANCH, pguser.Spec.ValidDays)
+		}
+		if pguser.Spec.Rotation.Enabled {
+			fmt.Printf("%srotated : %d days before expiry\n", TREE_BRANCH, pguser.Spec.Rotation.WindowDays)
+		}
+		for _, c := range pguser.Spec.Clusters {
+			if !containsName(clusters, c) {
+				continue
+			}
+			state := "pending"
+			for _, cs := range pguser.Status.Clusters {
+				if cs.Cluster == c && pguser.Spec.Group {
+					state = cs.State + " " + cs.Message
+				} else if cs.Cluster == c {
+					state = cs.State + " valid until " + cs.ValidUntil + " secret " + cs.SecretName + " " + cs.Message
+				}
+			}
+			fmt.Println(TREE_TRUNK + c + " : " + state)
+		}
 	}
+}
 
-	if UserDBAccess != "" {
-		querystr = "grant all on database " + UserDBAccess + " to  " + newUser
-	} else {
-		querystr = "grant all on database userdb to  " + newUser
-	}
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
-	if err != nil {
-		log.Debug(err.Error())
-		os.Exit(2)
+// getUserRotation has the operator rotate the password of a pguser
+// RotateBefore days before it expires, it is then valid for another
+// valid days
+func getUserRotation() crv1.PgPasswordRotationSpec {
+	return crv1.PgPasswordRotationSpec{
+		Enabled:    true,
+		WindowDays: RotateBefore,
 	}
+}
 
-	defer func() {
-		if conn != nil {
-			conn.Close()
+// showUserGrants lists the roles of each cluster, the group roles
+// they are members of and the privileges granted to them as the
+// operator last read them from the catalogs of the cluster, whether or
+// not they are pgusers
+func showUserGrants(args []string) {
+	for _, arg := range args {
+		cluster := crv1.Pgcluster{}
+		err := RestClient.Get().
+			Resource(crv1.PgclusterResourcePlural).
+			Namespace(Namespace).
+			Name(arg).
+			Do().
+			Into(&cluster)
+		if kerrors.IsNotFound(err) {
+			fmt.Println(arg + " was not found")
+			continue
+		} else if err != nil {
+			log.Error("error getting cluster " + arg + " " + err.Error())
+			return
+		}
+
+		status := cluster.Status.Roles
+		if status == nil {
+			fmt.Println(arg + " roles have not been read yet")
+			continue
 		}
-		if rows != nil {
-			rows.Close()
+		if status.Message != "" {
+			fmt.Println(arg + " roles could not be read at " + status.CheckTime + " " + status.Message)
+			continue
 		}
-	}()
 
-	//add a secret if managed
-	if ManagedUser {
-		err = util.CreateUserSecret(Clientset, clusterName, newUser, info.Password, Namespace)
-		if err != nil {
+		fmt.Println("")
+		fmt.Println("cluster : " + cluster.Spec.Name + " (read at " + status.CheckTime + ")")
+		for _, r := range status.Roles {
+			attributes := []string{}
+			if r.Superuser {
+				attributes = append(attributes, "superuser")
+			}
+			if r.Login {
+				attributes = append(attributes, "login")
+			} else {
+				attributes = append(attributes, "group")
+			}
+			if r.ValidUntil != "" {
+				attributes = append(attributes, "valid until "+r.ValidUntil)
+			}
+			if r.ConnectionLimit >= 0 {
+				attributes = append(attributes, "connection limit "+strconv.Itoa(r.ConnectionLimit))
+			}
+			fmt.Println("")
+			fmt.Println("role : " + r.Name + " (" + strings.Join(attributes, ", ") + ")")
+
+			lines := []string{}
+			if r.Settings != "" {
+				lines = append(lines, "settings : "+r.Settings)
+			}
+			if len(r.MemberOf) > 0 {
+				lines = append(lines, "member of : "+strings.Join(r.MemberOf, ", "))
+			}
+			for _, p := range r.Privileges {
+				if p.Kind == "database" {
+					lines = append(lines, "database "+p.Object+" : "+p.Privileges)
+				} else {
+					lines = append(lines, p.Kind+" "+p.Object+" in "+p.Database+" : "+p.Privileges)
+				}
+			}
+			for i, line := range lines {
+				if i == len(lines)-1 {
+					fmt.Println(TREE_TRUNK + line)
+				} else {
+					fmt.Println(TREE_BRANCH + line)
+				}
+			}
 		}
 	}
-
 }
-func deleteUser(clusterName string, info ConnInfo, user string) {
-	var conn *sql.DB
-	var err error
 
-	conn, err = sql.Open("postgres", "sslmode=disable user="+info.Username+" host="+info.Hostip+" port="+info.Port+" dbname="+info.Database+" password="+info.Password)
-	if err != nil {
-		log.Debug(err.Error())
-		//os.Exit(2)
+func printUserSecrets(pguser *crv1.Pguser) {
+	for _, c := range pguser.Spec.Clusters {
+		fmt.Println(TREE_BRANCH + "new password is written to secret " + util.GetUserSecretName(c, pguser.Spec.Name))
 	}
+}
 
-	var rows *sql.Rows
-
-	querystr := "drop owned by  " + user + " cascade"
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
-	if err != nil {
-		log.Debug(err.Error())
-		//os.Exit(2)
+func passwordExpires(validUntil string, limit time.Time) bool {
+	if validUntil == "" || validUntil == "infinity" {
+		return false
 	}
-	querystr = "drop user if exists " + user
-	log.Debug(querystr)
-	rows, err = conn.Query(querystr)
+	t, err := time.Parse(time.RFC3339, validUntil)
 	if err != nil {
-		log.Debug(err.Error())
-		//os.Exit(2)
+		return false
 	}
+	return t.Before(limit)
+}
 
-	defer func() {
-		if conn != nil {
-			conn.Close()
+func containsName(list []string, name string) bool {
+	for _, s := range list {
+		if s == name {
+			return true
 		}
-		if rows != nil {
-			rows.Close()
+	}
+	return false
+}
+
+func containsAny(list []string, names []string) bool {
+	for _, n := range names {
+		if containsName(list, n) {
+			return true
 		}
-	}()
+	}
+	return false
+}
 
-	err = util.DeleteUserSecret(Clientset, clusterName, user, Namespace)
-	if err != nil {
-		log.Debug(err.Error())
-		//os.Exit(2)
+func getDefaults() {
+	PasswordAgeDays = DEFAULT_AGE_DAYS
+	PasswordLength = 0
+	str := viper.GetString("CLUSTER.PASSWORD_AGE_DAYS")
+	if str != "" {
+		PasswordAgeDays, _ = strconv.Atoi(str)
+		log.Debugf("PasswordAgeDays set to %d\n", PasswordAgeDays)
+
+	}
+	str = viper.GetString("CLUSTER.PASSWORD_LENGTH")
+	if str != "" {
+		PasswordLength, _ = strconv.Atoi(str)
+		log.Debugf("PasswordLength set to %d\n", PasswordLength)
 	}
 
 }
@@ -38,7 +38,10 @@ import (
 	//crv1 "github.com/crunchydata/kraken/apis/cr/v1"
 	crdclient "github.com/crunchydata/kraken/client"
 	"github.com/crunchydata/kraken/operator/backup"
+	"github.com/crunchydata/kraken/operator/cluster"
 	"github.com/crunchydata/kraken/operator/upgrade"
+	"github.com/crunchydata/kraken/operator/user"
+	"github.com/crunchydata/kraken/util"
 
 	"github.com/crunchydata/kraken/controller"
 	"k8s.io/client-go/kubernetes"
@@ -117,6 +120,34 @@ func main() {
 	if policylogcrd != nil {
 		fmt.Println(policylogcrd.Name + " exists ")
 	}
+	restorecrd, err := crdclient.PgrestoreCreateCustomResourceDefinition(apiextensionsclientset)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		panic(err)
+	}
+	if restorecrd != nil {
+		fmt.Println(restorecrd.Name + " exists ")
+	}
+	usercrd, err := crdclient.PguserCreateCustomResourceDefinition(apiextensionsclientset)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		panic(err)
+	}
+	if usercrd != nil {
+		fmt.Println(usercrd.Name + " exists ")
+	}
+	secretlogcrd, err := crdclient.PgsecretlogCreateCustomResourceDefinition(apiextensionsclientset)
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		panic(err)
+	}
+	if secretlogcrd != nil {
+		fmt.Println(secretlogcrd.Name + " exists ")
+	}
+
+	secretStore := util.GetSecretStoreConfig()
+	err = secretStore.Validate()
+	if err != nil {
+		panic(err)
+	}
+	log.Info("database credentials are held in the " + secretStore.Backend + " secret store")
 
 	// make a new config for our extension's API group, using the first config as a baseline
 	crdClient, crdScheme, err := crdclient.NewClient(config)
@@ -151,6 +182,16 @@ func main() {
 		PgpolicylogClient:    crdClient,
 		PgpolicylogScheme:    crdScheme,
 	}
+	pgRestorecontroller := controller.PgrestoreController{
+		PgrestoreClientset: Clientset,
+		PgrestoreClient:    crdClient,
+		PgrestoreScheme:    crdScheme,
+	}
+	pgUsercontroller := controller.PguserController{
+		PguserClientset: Clientset,
+		PguserClient:    crdClient,
+		PguserScheme:    crdScheme,
+	}
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
@@ -159,10 +200,18 @@ func main() {
 	go pgUpgradecontroller.Run(ctx)
 	go pgPolicycontroller.Run(ctx)
 	go pgPolicylogcontroller.Run(ctx)
+	go pgRestorecontroller.Run(ctx)
+	go pgUsercontroller.Run(ctx)
 
 	Namespace := "default"
 	go backup.ProcessJobs(Clientset, crdClient, Namespace)
 	go upgrade.MajorUpgradeProcess(Clientset, crdClient, Namespace)
+	go cluster.ProcessArchiveStatus(Clientset, crdClient, Namespace)
+	go cluster.ProcessBindings(Clientset, crdClient, Namespace)
+	go user.ProcessPasswordRotation(Clientset, crdClient, Namespace)
+	go user.ProcessRolesStatus(Clientset, crdClient, Namespace)
+	go cluster.ProcessPolicyCompliance(Clientset, crdClient, Namespace)
+	go cluster.ProcessPolicies(Clientset, crdClient, nil, Namespace)
 
 	fmt.Print("at end of setup, beginning wait...")
 
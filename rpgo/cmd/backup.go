@@ -144,7 +144,7 @@ func printBackupTPR(result *crv1.Pgbackup) {
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Status:\t"+result.Spec.BACKUP_STATUS)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Host:\t"+result.Spec.BACKUP_HOST)
 	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup User:\t"+result.Spec.BACKUP_USER)
-	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Pass:\t"+result.Spec.BACKUP_PASS)
+	fmt.Printf("%s%s\n", TREE_BRANCH, "Backup Secret:\t"+result.Spec.BACKUP_SECRET)
 	fmt.Printf("%s%s\n", TREE_TRUNK, "Backup Port:\t"+result.Spec.BACKUP_PORT)
 
 }
@@ -292,7 +292,6 @@ func getBackupParams(name string) (*crv1.Pgbackup, error) {
 	spec.BACKUP_STATUS = "initial"
 	spec.BACKUP_HOST = "basic"
 	spec.BACKUP_USER = "master"
-	spec.BACKUP_PASS = "password"
 	spec.BACKUP_PORT = "5432"
 
 	cluster := crv1.Pgcluster{}
@@ -304,9 +303,8 @@ func getBackupParams(name string) (*crv1.Pgbackup, error) {
 		Into(&cluster)
 	if err == nil {
 		spec.BACKUP_HOST = cluster.Spec.Name
-		//spec.BACKUP_USER = cluster.Spec.PG_MASTER_USER
-		//spec.BACKUP_PASS = cluster.Spec.PG_MASTER_PASSWORD
-		spec.BACKUP_PASS = GetSecretPassword(cluster.Spec.Name, crv1.PGMASTER_SECRET_SUFFIX)
+		//the job reads the master password from this secret
+		spec.BACKUP_SECRET = cluster.Spec.PGMASTER_SECRET_NAME
 		spec.BACKUP_PORT = cluster.Spec.Port
 	} else if errors.IsNotFound(err) {
 		log.Debug(name + " is not a cluster")
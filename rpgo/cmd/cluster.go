@@ -19,6 +19,7 @@ import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"io"
 
 	"github.com/spf13/viper"
 	//"k8s.io/api/core/v1"
@@ -68,6 +69,17 @@ func showCluster(args []string) {
 		return
 	}
 
+	var secretsOut io.Writer
+	if SecretsFile != "" {
+		f, err := openSecretsFile(SecretsFile)
+		if err != nil {
+			log.Error("could not create --secrets-file " + err.Error())
+			return
+		}
+		defer f.Close()
+		secretsOut = f
+	}
+
 	itemFound := false
 
 	//each arg represents a cluster name or the special 'all' value
@@ -88,8 +100,8 @@ func showCluster(args []string) {
 					listPods(cluster.Spec.Name)
 					//list the services
 					listServices(cluster.Spec.Name)
-					if ShowSecrets {
-						PrintSecrets(cluster.Spec.Name)
+					if ShowSecrets || RevealSecrets || ConnectionString || SecretsFile != "" {
+						PrintSecrets(cluster.Spec.Name, secretsOut)
 					}
 					fmt.Println("")
 				}
@@ -24,7 +24,12 @@ import (
 var CCP_IMAGE_TAG string
 var Password string
 var SecretFrom, BackupPath, BackupPVC string
-var PoliciesFlag, PolicyFile, PolicyURL string
+var PoliciesFlag, PolicyFile, PolicyURL, PolicyURLSecret string
+var PolicyDownFile, PolicyRemoveFile, PolicyVerifySql string
+var PolicyDependsOn string
+var PolicyParameters []string
+var PolicyNewVersion, PolicyRefresh bool
+var PolicyScope string
 var NodeName string
 var UserLabels string
 var UserLabelsMap map[string]string
@@ -105,7 +110,7 @@ var createPolicyCmd = &cobra.Command{
 pgo create policy mypolicy --in-file=/tmp/mypolicy.sql`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log.Debug("create policy called ")
-		if PolicyFile == "" && PolicyURL == "" {
+		if PolicyFile == "" && PolicyURL == "" && !PolicyRefresh {
 			log.Error("--in-file or --url is required to create a policy")
 			return
 		}
@@ -134,6 +139,15 @@ func init() {
 	createClusterCmd.Flags().IntVarP(&Series, "series", "e", 1, "The number of clusters to create in a series, defaults to 1")
 	createPolicyCmd.Flags().StringVarP(&PolicyURL, "url", "u", "", "The url to use for adding a policy")
 	createPolicyCmd.Flags().StringVarP(&PolicyFile, "in-file", "i", "", "The policy file path to use for adding a policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyDownFile, "down-file", "", "", "The policy file path holding the SQL that reverts this version of the policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyRemoveFile, "remove-file", "", "", "The policy file path holding the SQL that removes this version of the policy from a cluster")
+	createPolicyCmd.Flags().StringVarP(&PolicyVerifySql, "verify-sql", "", "", "A SQL query returning true while the policy is in effect, used to detect drift")
+	createPolicyCmd.Flags().StringVarP(&PolicyDependsOn, "depends-on", "", "", "The policies that must be applied before this policy, comma separated")
+	createPolicyCmd.Flags().StringArrayVarP(&PolicyParameters, "parameter", "", []string{}, "A policy parameter written as name=owner,kind=identifier,from=cluster:PG_USER,default=app, may be repeated")
+	createPolicyCmd.Flags().BoolVarP(&PolicyNewVersion, "new-version", "", false, "Adds a new version of an existing policy")
+	createPolicyCmd.Flags().StringVarP(&PolicyURLSecret, "url-secret", "", "", "The secret holding the authorization headers and CA bundle used to fetch --url")
+	createPolicyCmd.Flags().BoolVarP(&PolicyRefresh, "refresh", "", false, "Fetches the url of an existing policy again, adding a new version if its SQL changed")
+	createPolicyCmd.Flags().StringVarP(&PolicyScope, "scope", "", "", "The pods of a cluster the policy runs on, master (the default), replicas or all")
 	UserLabelsMap = make(map[string]string)
 
 }
@@ -29,8 +29,6 @@ import (
 	"github.com/spf13/viper"
 	"io/ioutil"
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"os/user"
 	"strings"
 )
 
@@ -124,9 +122,16 @@ func createPolicy(args []string) {
 	r := new(apiservermsgs.CreatePolicyRequest)
 	r.Name = args[0]
 
-	if PolicyURL != "" {
+	// the apiserver does not read files, a file url is sent as SQL
+	if strings.HasPrefix(PolicyURL, util.POLICY_SOURCE_FILE+"://") {
+		PolicyFile = strings.TrimPrefix(PolicyURL, util.POLICY_SOURCE_FILE+"://")
+	} else if PolicyURL != "" {
 		r.URL = PolicyURL
+		r.URLSecret = PolicyURLSecret
 	}
+	r.Refresh = PolicyRefresh
+	r.NewVersion = PolicyNewVersion
+	r.Scope = PolicyScope
 	if PolicyFile != "" {
 		r.SQL, err = getPolicyString(PolicyFile)
 
@@ -135,6 +140,34 @@ func createPolicy(args []string) {
 			return
 		}
 	}
+	if PolicyDownFile != "" {
+		r.DownSQL, err = getPolicyString(PolicyDownFile)
+
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	if PolicyRemoveFile != "" {
+		r.RemoveSQL, err = getPolicyString(PolicyRemoveFile)
+
+		if err != nil {
+			log.Error(err)
+			return
+		}
+	}
+	r.VerifySQL = PolicyVerifySql
+	if PolicyDependsOn != "" {
+		r.DependsOn = strings.Split(PolicyDependsOn, ",")
+	}
+	for _, p := range PolicyParameters {
+		param, err := util.ParsePolicyParameter(p)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		r.Parameters = append(r.Parameters, param)
+	}
 
 	r.Namespace = Namespace
 
@@ -267,98 +300,52 @@ func validateConfigPolicies() error {
 	return err
 }
 
+// applyPolicy asks the apiserver to apply, upgrade, revert or remove
+// the policies on the clusters of the selector, the apiserver orders
+// them by their dependencies and dry runs them together with --dry-run
 func applyPolicy(policies []string) {
-	var err error
-	//validate policies
-	labels := make(map[string]string)
-	for _, p := range policies {
-		err = util.ValidatePolicy(RestClient, Namespace, p)
-		if err != nil {
-			log.Error("policy " + p + " is not found, cancelling request")
-			return
-		}
-
-		labels[p] = "pgpolicy"
-	}
-
-	//get filtered list of Deployments
-	sel := Selector + ",!replica"
-	log.Debug("selector string=[" + sel + "]")
-	lo := meta_v1.ListOptions{LabelSelector: sel}
-	deployments, err := Clientset.ExtensionsV1beta1().Deployments(Namespace).List(lo)
-	if err != nil {
-		log.Error("error getting list of deployments" + err.Error())
-		return
-	}
-
-	if DryRun {
-		fmt.Println("policy would be applied to the following clusters:")
-		for _, d := range deployments.Items {
-			fmt.Println("deployment : " + d.ObjectMeta.Name)
-		}
+	if Namespace == "" {
+		log.Error("Namespace can not be empty")
 		return
 	}
 
-	var newInstance *crv1.Pgpolicylog
-	for _, d := range deployments.Items {
-		fmt.Println("deployment : " + d.ObjectMeta.Name)
-		for _, p := range policies {
-			log.Debug("apply policy " + p + " on deployment " + d.ObjectMeta.Name + " based on selector " + sel)
-
-			newInstance, err = getPolicylog(p, d.ObjectMeta.Name)
-
-			result := crv1.Pgpolicylog{}
-			err = RestClient.Get().
-				Resource(crv1.PgpolicyResourcePlural).
-				Namespace(Namespace).
-				Name(newInstance.ObjectMeta.Name).
-				Do().Into(&result)
-			if err == nil {
-				fmt.Println(p + " already applied to " + d.ObjectMeta.Name)
-				break
-			} else {
-				if kerrors.IsNotFound(err) {
-				} else {
-					log.Error(err)
-					break
-				}
-			}
+	r := new(apiservermsgs.ApplyPolicyRequest)
+	r.Name = strings.Join(policies, ",")
+	r.Selector = Selector
+	r.DryRun = DryRun
+	r.Atomic = Atomic
+	r.Action = getApplyAction()
+	r.Params = PolicyParams
+	r.Namespace = Namespace
 
-			result = crv1.Pgpolicylog{}
-			err = RestClient.Post().
-				Resource(crv1.PgpolicyResourcePlural).
-				Namespace(Namespace).
-				Body(newInstance).
-				Do().Into(&result)
-			if err != nil {
-				log.Error("error in creating Pgpolicylog TPR instance", err.Error())
-			} else {
-				fmt.Println("created Pgpolicylog " + result.ObjectMeta.Name)
-			}
+	jsonValue, _ := json.Marshal(r)
 
-		}
+	url := APISERVER_URL + "/policies/apply/" + r.Name
+	log.Debug("applyPolicy called...[" + url + "]")
 
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	if err != nil {
+		log.Error("NewRequest: " + err.Error())
+		return
 	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthorization(req)
 
-}
-
-func getPolicylog(policyname, clustername string) (*crv1.Pgpolicylog, error) {
-	u, err := user.Current()
+	client := &http.Client{}
+	resp, err := client.Do(req)
 	if err != nil {
-		log.Error(err.Error())
+		log.Error("Do: " + err.Error())
+		return
 	}
+	defer resp.Body.Close()
 
-	spec := crv1.PgpolicylogSpec{}
-	spec.PolicyName = policyname
-	spec.Username = u.Name
-	spec.ClusterName = clustername
-
-	newInstance := &crv1.Pgpolicylog{
-		ObjectMeta: meta_v1.ObjectMeta{
-			Name: policyname + clustername,
-		},
-		Spec: spec,
+	var response apiservermsgs.ApplyResults
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		log.Error(err)
+		return
 	}
-	return newInstance, err
 
+	for _, result := range response.Results {
+		fmt.Println(result)
+	}
 }
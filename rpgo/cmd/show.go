@@ -26,6 +26,8 @@ const TREE_TRUNK = "└── "
 var PostgresVersion string
 var ShowPVC bool
 var ShowSecrets bool
+var RevealSecrets, ConnectionString bool
+var SecretsFile string
 var PVCRoot string
 
 var ShowCmd = &cobra.Command{
@@ -87,7 +89,10 @@ func init() {
 	// is called directly, e.g.:
 	// ShowCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 
-	ShowClusterCmd.Flags().BoolVarP(&ShowSecrets, "show-secrets", "s", false, "Show secrets ")
+	ShowClusterCmd.Flags().BoolVarP(&ShowSecrets, "show-secrets", "s", false, "Show secrets, the passwords are redacted ")
+	ShowClusterCmd.Flags().BoolVarP(&RevealSecrets, "reveal", "", false, "Show the passwords of the secrets, requires access to the secrets and is audited")
+	ShowClusterCmd.Flags().StringVarP(&SecretsFile, "secrets-file", "", "", "Write the credentials, passwords included, to this file instead, requires access to the secrets and is audited")
+	ShowClusterCmd.Flags().BoolVarP(&ConnectionString, "connection-string", "", false, "Show the secrets as postgresql:// connection strings")
 	ShowClusterCmd.Flags().StringVarP(&PostgresVersion, "version", "v", "", "The postgres version to filter on")
 	ShowPVCCmd.Flags().StringVarP(&PVCRoot, "pvc-root", "r", "", "The PVC directory to list")
 
@@ -16,8 +16,17 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/apiservermsgs"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	//"k8s.io/client-go/kubernetes"
@@ -62,23 +71,97 @@ func ConnectToKube() {
 }
 */
 
-func PrintSecrets(db string) {
+// PrintSecrets asks the apiserver for the credentials of a cluster,
+// the passwords are redacted unless --reveal or --secrets-file ask for
+// them, the apiserver then checks the user of the PGO.TOKEN of the
+// request may get the secrets and records the reveal, out is the --secrets-file
+// the credentials are written to instead of the terminal
+func PrintSecrets(db string, out io.Writer) {
+
+	reveal := RevealSecrets || out != nil
+	format := crv1.SECRET_FORMAT_PLAIN
+	if ConnectionString {
+		format = crv1.SECRET_FORMAT_URI
+	}
+	output := crv1.SECRET_OUTPUT_TERMINAL
+	if out != nil {
+		output = crv1.SECRET_OUTPUT_FILE
+	}
 
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + db}
-	secrets, err := Clientset.Core().Secrets(Namespace).List(lo)
+	values := url.Values{}
+	values.Set("namespace", Namespace)
+	values.Set("reveal", strconv.FormatBool(reveal))
+	values.Set("format", format)
+	values.Set("output", output)
+	endpoint := APISERVER_URL + "/clusters/secrets/" + db + "?" + values.Encode()
+	log.Debug("PrintSecrets called...[" + endpoint + "]")
+
+	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
-		log.Error("error getting list of secrets" + err.Error())
+		log.Error("NewRequest: " + err.Error())
+		return
+	}
+	setAuthorization(req)
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Error("Do: " + err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	var response apiservermsgs.ShowSecretsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		log.Error(err)
+		return
+	}
+	if response.Status != "ok" {
+		log.Error(response.Message)
 		return
 	}
 
 	log.Debug("secrets for " + db)
-	for _, s := range secrets.Items {
+	for _, s := range response.Items {
+		if out != nil {
+			if ConnectionString {
+				_, err = fmt.Fprintln(out, s.ConnectionString)
+			} else {
+				_, err = fmt.Fprintf(out, "%s\t%s\t%s\t%s\n", db, s.Name, s.Username, s.Password)
+			}
+			if err != nil {
+				log.Error("error writing " + SecretsFile + " " + err.Error())
+			}
+			continue
+		}
 		fmt.Println("")
-		fmt.Println("secret : " + s.ObjectMeta.Name)
-		fmt.Println(TREE_BRANCH + "username: " + string(s.Data["username"][:]))
-		fmt.Println(TREE_TRUNK + "password: " + string(s.Data["password"][:]))
+		fmt.Println("secret : " + s.Name)
+		if ConnectionString {
+			fmt.Println(TREE_TRUNK + "uri: " + s.ConnectionString)
+		} else {
+			fmt.Println(TREE_BRANCH + "username: " + s.Username)
+			fmt.Println(TREE_TRUNK + "password: " + s.Password)
+		}
 	}
+	if out != nil && len(response.Items) > 0 {
+		fmt.Println(TREE_TRUNK + "secrets written to " + SecretsFile)
+	}
+
+}
 
+// openSecretsFile creates the --secrets-file, readable by its owner
+// only as it holds passwords
+func openSecretsFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Chmod(0600)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
 }
 
 func GetSecretPassword(db, suffix string) string {
@@ -104,3 +187,13 @@ func GetSecretPassword(db, suffix string) string {
 	return "error"
 
 }
+
+// setAuthorization sends the PGO.TOKEN of the configuration as the
+// bearer token of a request, the apiserver reviews it to authenticate
+// the user of the request
+func setAuthorization(req *http.Request) {
+	token := viper.GetString("PGO.TOKEN")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
@@ -0,0 +1,223 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"errors"
+	"strings"
+)
+
+// the kinds of object privileges are granted on by GrantObjects, a
+// schema itself or the tables, sequences or functions in it
+const OBJECT_SCHEMA = "schema"
+const OBJECT_TABLES = "tables"
+const OBJECT_SEQUENCES = "sequences"
+const OBJECT_FUNCTIONS = "functions"
+
+var objectKinds = []string{OBJECT_SCHEMA, OBJECT_TABLES, OBJECT_SEQUENCES, OBJECT_FUNCTIONS}
+
+// the privileges that can be granted on each kind of object
+var objectPrivileges = map[string][]string{
+	OBJECT_SCHEMA:    {"ALL", "USAGE", "CREATE"},
+	OBJECT_TABLES:    {"ALL", "SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+	OBJECT_SEQUENCES: {"ALL", "USAGE", "SELECT", "UPDATE"},
+	OBJECT_FUNCTIONS: {"ALL", "EXECUTE"},
+}
+
+// the keyword naming one object of each kind in a GRANT statement
+var objectKeywords = map[string]string{
+	OBJECT_TABLES:    "TABLE",
+	OBJECT_SEQUENCES: "SEQUENCE",
+	OBJECT_FUNCTIONS: "FUNCTION",
+}
+
+// ValidateObjectPrivileges checks each privilege can be granted on a
+// kind of object
+func ValidateObjectPrivileges(kind string, privileges []string) error {
+	valid, ok := objectPrivileges[kind]
+	if !ok {
+		return errors.New("invalid object kind " + kind + ", must be one of " + strings.Join(objectKinds, ", "))
+	}
+	if len(privileges) == 0 {
+		return errors.New("no privileges are granted on " + kind)
+	}
+	for _, p := range privileges {
+		if !contains(valid, strings.ToUpper(p)) {
+			return errors.New("invalid " + kind + " privilege " + p + ", must be one of " + strings.Join(valid, ", "))
+		}
+	}
+	return nil
+}
+
+// CreateGroupRole returns the statement creating a role that can not
+// log in, other roles are made members of it to share its privileges
+func CreateGroupRole(role string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "CREATE ROLE " + name + " NOLOGIN", nil
+}
+
+// GrantRole returns the statement making a role a member of a group
+// role, the group may be one of the pg_ roles of the server such as
+// pg_monitor
+func GrantRole(group, role string) (string, error) {
+	g, name, err := quoteMembership(group, role)
+	if err != nil {
+		return "", err
+	}
+	return "GRANT " + g + " TO " + name, nil
+}
+
+// RevokeRole returns the statement taking a role out of a group role
+func RevokeRole(group, role string) (string, error) {
+	g, name, err := quoteMembership(group, role)
+	if err != nil {
+		return "", err
+	}
+	return "REVOKE " + g + " FROM " + name, nil
+}
+
+// GrantObjects returns the statement granting privileges to a role on
+// a schema, or on objects of a kind in it, no objects meaning every
+// object of the kind in the schema
+func GrantObjects(role, kind, schema string, objects, privileges []string) (string, error) {
+	on, err := objectsClause(kind, schema, objects, privileges)
+	if err != nil {
+		return "", err
+	}
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "GRANT " + strings.ToUpper(strings.Join(privileges, ", ")) + " ON " + on + " TO " + name, nil
+}
+
+// RevokeObjects returns the statement revoking privileges granted by
+// GrantObjects
+func RevokeObjects(role, kind, schema string, objects, privileges []string) (string, error) {
+	on, err := objectsClause(kind, schema, objects, privileges)
+	if err != nil {
+		return "", err
+	}
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "REVOKE " + strings.ToUpper(strings.Join(privileges, ", ")) + " ON " + on + " FROM " + name, nil
+}
+
+// GrantDefaultPrivileges returns the statement granting privileges to
+// a role on the objects of a kind owner later creates in a schema, an
+// empty owner is the role running the statement
+func GrantDefaultPrivileges(owner, role, kind, schema string, privileges []string) (string, error) {
+	prefix, on, err := defaultPrivilegesClause(owner, kind, schema, privileges)
+	if err != nil {
+		return "", err
+	}
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return prefix + " GRANT " + strings.ToUpper(strings.Join(privileges, ", ")) + " ON " + on + " TO " + name, nil
+}
+
+// RevokeDefaultPrivileges returns the statement revoking privileges
+// granted by GrantDefaultPrivileges
+func RevokeDefaultPrivileges(owner, role, kind, schema string, privileges []string) (string, error) {
+	prefix, on, err := defaultPrivilegesClause(owner, kind, schema, privileges)
+	if err != nil {
+		return "", err
+	}
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return prefix + " REVOKE " + strings.ToUpper(strings.Join(privileges, ", ")) + " ON " + on + " FROM " + name, nil
+}
+
+func objectsClause(kind, schema string, objects, privileges []string) (string, error) {
+	err := ValidateObjectPrivileges(kind, privileges)
+	if err != nil {
+		return "", err
+	}
+	s, err := QuoteIdentifier(schema)
+	if err != nil {
+		return "", err
+	}
+	if kind == OBJECT_SCHEMA {
+		if len(objects) > 0 {
+			return "", errors.New("a grant on schema " + schema + " can not name objects")
+		}
+		return "SCHEMA " + s, nil
+	}
+	if len(objects) == 0 {
+		return "ALL " + strings.ToUpper(kind) + " IN SCHEMA " + s, nil
+	}
+	names := []string{}
+	for _, o := range objects {
+		n, err := QuoteIdentifier(o)
+		if err != nil {
+			return "", err
+		}
+		names = append(names, s+"."+n)
+	}
+	return objectKeywords[kind] + " " + strings.Join(names, ", "), nil
+}
+
+func defaultPrivilegesClause(owner, kind, schema string, privileges []string) (string, string, error) {
+	if kind == OBJECT_SCHEMA {
+		return "", "", errors.New("default privileges can not be granted on schemas")
+	}
+	err := ValidateObjectPrivileges(kind, privileges)
+	if err != nil {
+		return "", "", err
+	}
+	s, err := QuoteIdentifier(schema)
+	if err != nil {
+		return "", "", err
+	}
+	prefix := "ALTER DEFAULT PRIVILEGES"
+	if owner != "" {
+		o, err := QuoteIdentifier(owner)
+		if err != nil {
+			return "", "", err
+		}
+		prefix = prefix + " FOR ROLE " + o
+	}
+	return prefix + " IN SCHEMA " + s, strings.ToUpper(kind), nil
+}
+
+// quoteMembership quotes a group role and its member, the group may
+// be a pg_ role of the server but not a reserved name
+func quoteMembership(group, role string) (string, string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", "", err
+	}
+	if group == role {
+		return "", "", errors.New("role " + role + " can not be a member of itself")
+	}
+	if group == "" || contains(reservedRoleNames, strings.ToLower(group)) {
+		return "", "", errors.New("invalid group role " + group)
+	}
+	g, err := QuoteIdentifier(group)
+	if err != nil {
+		return "", "", err
+	}
+	return g, name, nil
+}
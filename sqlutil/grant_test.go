@@ -0,0 +1,66 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"testing"
+)
+
+func TestGrantObjects(t *testing.T) {
+	tests := []struct {
+		role       string
+		kind       string
+		schema     string
+		objects    []string
+		privileges []string
+		want       string
+		wantErr    bool
+	}{
+		{"app", OBJECT_SCHEMA, "public", nil, []string{"usage"},
+			`GRANT USAGE ON SCHEMA "public" TO "app"`, false},
+		{"app", OBJECT_TABLES, "public", nil, []string{"select", "insert"},
+			`GRANT SELECT, INSERT ON ALL TABLES IN SCHEMA "public" TO "app"`, false},
+		{"app", OBJECT_TABLES, "sales", []string{"orders", `odd"name`}, []string{"SELECT"},
+			`GRANT SELECT ON TABLE "sales"."orders", "sales"."odd""name" TO "app"`, false},
+		{"app", OBJECT_SEQUENCES, `a"b`, []string{"ids"}, []string{"usage"},
+			`GRANT USAGE ON SEQUENCE "a""b"."ids" TO "app"`, false},
+		{"app", OBJECT_FUNCTIONS, "public", nil, []string{"execute"},
+			`GRANT EXECUTE ON ALL FUNCTIONS IN SCHEMA "public" TO "app"`, false},
+		{`x" TO "postgres`, OBJECT_TABLES, "public", nil, []string{"select"},
+			`GRANT SELECT ON ALL TABLES IN SCHEMA "public" TO "x"" TO ""postgres"`, false},
+		{"app", OBJECT_TABLES, "public", []string{"t; DROP TABLE t"}, []string{"select"},
+			`GRANT SELECT ON TABLE "public"."t; DROP TABLE t" TO "app"`, false},
+		{"app", OBJECT_TABLES, "public", nil, []string{"select; DROP TABLE t"}, "", true},
+		{"app", OBJECT_TABLES, "public", nil, []string{"usage"}, "", true},
+		{"app", OBJECT_TABLES, "public", nil, nil, "", true},
+		{"app", OBJECT_SCHEMA, "public", []string{"orders"}, []string{"usage"}, "", true},
+		{"app", "views", "public", nil, []string{"select"}, "", true},
+		{"app", OBJECT_TABLES, "", nil, []string{"select"}, "", true},
+		{"app", OBJECT_TABLES, "public", []string{"a\x00b"}, []string{"select"}, "", true},
+		{"pg_monitor", OBJECT_TABLES, "public", nil, []string{"select"}, "", true},
+		{"public", OBJECT_TABLES, "public", nil, []string{"select"}, "", true},
+	}
+	for _, tt := range tests {
+		got, err := GrantObjects(tt.role, tt.kind, tt.schema, tt.objects, tt.privileges)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("GrantObjects(%q, %q, %q, %q, %q) error = %v, wantErr %v", tt.role, tt.kind, tt.schema, tt.objects, tt.privileges, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("GrantObjects(%q, %q, %q, %q, %q) = %s, want %s", tt.role, tt.kind, tt.schema, tt.objects, tt.privileges, got, tt.want)
+		}
+	}
+}
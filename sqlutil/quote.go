@@ -0,0 +1,73 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+// Package sqlutil builds the SQL and connection strings the operator,
+// pgo, rpgo and the apiserver send to PostgreSQL, every identifier and
+// literal is quoted and role names are validated so a value can never
+// change the meaning of a statement
+package sqlutil
+
+import (
+	"errors"
+	"strings"
+)
+
+// QuoteIdentifier quotes a SQL identifier such as a role or schema
+// name, a double quote within it is doubled
+func QuoteIdentifier(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("an identifier can not be empty")
+	}
+	if strings.ContainsRune(name, 0) {
+		return "", errors.New("an identifier can not contain a NUL character")
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`, nil
+}
+
+// QuoteLiteral quotes a SQL string literal, a quote within it is
+// doubled and a value holding a backslash is written as an escape
+// string so it is read the same whatever standard_conforming_strings
+// is set to
+func QuoteLiteral(value string) (string, error) {
+	if strings.ContainsRune(value, 0) {
+		return "", errors.New("a literal can not contain a NUL character")
+	}
+	quoted := strings.Replace(value, `'`, `''`, -1)
+	if strings.Contains(quoted, `\`) {
+		return `E'` + strings.Replace(quoted, `\`, `\\`, -1) + `'`, nil
+	}
+	return `'` + quoted + `'`, nil
+}
+
+// ConnString builds a lib/pq connection string, each value is quoted
+// so a password holding spaces, quotes or other keywords can not add
+// settings to the connection
+func ConnString(host, port, user, password, database string) string {
+	pairs := []string{
+		"sslmode=disable",
+		"host=" + connValue(host),
+		"port=" + connValue(port),
+		"user=" + connValue(user),
+		"password=" + connValue(password),
+		"dbname=" + connValue(database),
+	}
+	return strings.Join(pairs, " ")
+}
+
+func connValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `'`, `\'`, -1)
+	return "'" + value + "'"
+}
@@ -0,0 +1,120 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"testing"
+)
+
+func TestQuoteIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"app", `"app"`, false},
+		{"App User", `"App User"`, false},
+		{`a"b`, `"a""b"`, false},
+		{`"; DROP ROLE postgres; --`, `"""; DROP ROLE postgres; --"`, false},
+		{`a\b`, `"a\b"`, false},
+		{"a'b", `"a'b"`, false},
+		{"", "", true},
+		{"a\x00b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := QuoteIdentifier(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("QuoteIdentifier(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("QuoteIdentifier(%q) = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"", `''`, false},
+		{"secret", `'secret'`, false},
+		{"it's", `'it''s'`, false},
+		{`'; DROP ROLE postgres; --`, `'''; DROP ROLE postgres; --'`, false},
+		{`a\b`, `E'a\\b'`, false},
+		{`\'`, `E'\\'''`, false},
+		{`a"b`, `'a"b'`, false},
+		{"a\x00b", "", true},
+	}
+	for _, tt := range tests {
+		got, err := QuoteLiteral(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("QuoteLiteral(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("QuoteLiteral(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConnString(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		database string
+		want     string
+	}{
+		{
+			name:     "plain",
+			password: "secret",
+			database: "userdb",
+			want:     `sslmode=disable host='db' port='5432' user='app' password='secret' dbname='userdb'`,
+		},
+		{
+			name:     "spaces and keywords",
+			password: "x sslmode=require host=evil",
+			database: "userdb",
+			want:     `sslmode=disable host='db' port='5432' user='app' password='x sslmode=require host=evil' dbname='userdb'`,
+		},
+		{
+			name:     "quotes",
+			password: `it's`,
+			database: `a'b`,
+			want:     `sslmode=disable host='db' port='5432' user='app' password='it\'s' dbname='a\'b'`,
+		},
+		{
+			name:     "backslashes",
+			password: `a\' dbname=postgres`,
+			database: "userdb",
+			want:     `sslmode=disable host='db' port='5432' user='app' password='a\\\' dbname=postgres' dbname='userdb'`,
+		},
+		{
+			name:     "empty",
+			password: "",
+			database: "",
+			want:     `sslmode=disable host='db' port='5432' user='app' password='' dbname=''`,
+		},
+	}
+	for _, tt := range tests {
+		got := ConnString("db", "5432", "app", tt.password, tt.database)
+		if got != tt.want {
+			t.Errorf("%s: ConnString = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
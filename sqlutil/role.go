@@ -0,0 +1,305 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// the longest identifier PostgreSQL keeps, longer names are truncated
+const MAX_IDENTIFIER_LENGTH = 63
+
+// RoleExistsQuery tells whether the role named by its parameter exists
+const RoleExistsQuery = "select exists (select 1 from pg_roles where rolname = $1)"
+
+// names that can not be given to a role
+var reservedRoleNames = []string{"public", "none", "current_role", "current_user", "session_user"}
+
+// how PostgreSQL stores role passwords, the password_encryption setting
+const PASSWORD_ENCRYPTION_MD5 = "md5"
+const PASSWORD_ENCRYPTION_SCRAM = "scram-sha-256"
+
+// the name of a server setting, a setting of an extension is prefixed
+// with the extension name and a dot
+var settingNameFormat = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// settings whose value is a list, each element is quoted on its own
+var listSettings = []string{"search_path", "temp_tablespaces"}
+
+// the privileges that can be granted on a database
+var databasePrivileges = []string{"ALL", "CREATE", "CONNECT", "TEMPORARY", "TEMP"}
+
+// ValidateRoleName checks a role name can be created as given, it must
+// fit in an identifier, can not be a reserved name and can not start
+// with pg_ which is reserved for the roles of the server
+func ValidateRoleName(name string) error {
+	if name == "" {
+		return errors.New("a role name is required")
+	}
+	if len(name) > MAX_IDENTIFIER_LENGTH {
+		return errors.New("role name " + name + " is longer than 63 bytes")
+	}
+	if strings.ContainsRune(name, 0) {
+		return errors.New("a role name can not contain a NUL character")
+	}
+	if strings.HasPrefix(strings.ToLower(name), "pg_") {
+		return errors.New("role name " + name + " can not start with pg_")
+	}
+	for _, r := range reservedRoleNames {
+		if strings.ToLower(name) == r {
+			return errors.New("role name " + name + " is reserved")
+		}
+	}
+	return nil
+}
+
+// ValidateDatabasePrivileges checks each privilege can be granted on
+// a database
+func ValidateDatabasePrivileges(privileges []string) error {
+	for _, p := range privileges {
+		if !contains(databasePrivileges, strings.ToUpper(p)) {
+			return errors.New("invalid database privilege " + p + ", must be one of " + strings.Join(databasePrivileges, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidatePasswordEncryption checks a password encryption method is
+// known, an empty method is md5
+func ValidatePasswordEncryption(method string) error {
+	switch method {
+	case "", PASSWORD_ENCRYPTION_MD5, PASSWORD_ENCRYPTION_SCRAM:
+		return nil
+	}
+	return errors.New("invalid password encryption " + method + ", must be " + PASSWORD_ENCRYPTION_MD5 + " or " + PASSWORD_ENCRYPTION_SCRAM)
+}
+
+// EncryptPassword returns the md5 or SCRAM-SHA-256 form of a role
+// password that PostgreSQL stores as given, so the cleartext password
+// is never part of a statement that could be logged, an empty method
+// is md5
+func EncryptPassword(method, role, password string) (string, error) {
+	switch method {
+	case "", PASSWORD_ENCRYPTION_MD5:
+		sum := md5.Sum([]byte(password + role))
+		return "md5" + hex.EncodeToString(sum[:]), nil
+	case PASSWORD_ENCRYPTION_SCRAM:
+		return scramVerifier(password)
+	}
+	return "", ValidatePasswordEncryption(method)
+}
+
+// CreateRole returns the statement creating a role that can log in
+func CreateRole(role string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "CREATE ROLE " + name + " LOGIN", nil
+}
+
+// AlterRolePassword returns the statement setting the password of a
+// role, encrypted with method, and when it expires, validUntil is a
+// timestamp or infinity
+func AlterRolePassword(role, password, validUntil, method string) (string, error) {
+	stmt, err := SetRolePassword(role, password, method)
+	if err != nil {
+		return "", err
+	}
+	until, err := QuoteLiteral(validUntil)
+	if err != nil {
+		return "", err
+	}
+	return stmt + " VALID UNTIL " + until, nil
+}
+
+// SetRolePassword returns the statement setting the password of a
+// role, encrypted with method, leaving when it expires as it is
+func SetRolePassword(role, password, method string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := EncryptPassword(method, role, password)
+	if err != nil {
+		return "", err
+	}
+	pw, err := QuoteLiteral(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return "ALTER ROLE " + name + " WITH LOGIN PASSWORD " + pw, nil
+}
+
+// ValidateSettingName checks a server setting name can be written in
+// a statement as given
+func ValidateSettingName(name string) error {
+	if !settingNameFormat.MatchString(name) {
+		return errors.New("invalid setting name " + name + ", must be lower case letters, digits and underscores")
+	}
+	return nil
+}
+
+// AlterRoleConnectionLimit returns the statement limiting how many
+// connections a role can open at once, -1 meaning no limit
+func AlterRoleConnectionLimit(role string, limit int) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	if limit < -1 {
+		return "", errors.New("invalid connection limit " + strconv.Itoa(limit))
+	}
+	return "ALTER ROLE " + name + " CONNECTION LIMIT " + strconv.Itoa(limit), nil
+}
+
+// AlterRoleSet returns the statement setting a server setting each
+// time a role logs in, the elements of a list setting such as
+// search_path are separated by commas
+func AlterRoleSet(role, setting, value string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	err = ValidateSettingName(setting)
+	if err != nil {
+		return "", err
+	}
+
+	values := []string{value}
+	if contains(listSettings, setting) {
+		values = []string{}
+		for _, v := range strings.Split(value, ",") {
+			values = append(values, strings.TrimSpace(v))
+		}
+	}
+	literals := []string{}
+	for _, v := range values {
+		literal, err := QuoteLiteral(v)
+		if err != nil {
+			return "", err
+		}
+		literals = append(literals, literal)
+	}
+	return "ALTER ROLE " + name + " SET " + setting + " = " + strings.Join(literals, ", "), nil
+}
+
+// AlterRoleReset returns the statement removing a setting set by
+// AlterRoleSet
+func AlterRoleReset(role, setting string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	err = ValidateSettingName(setting)
+	if err != nil {
+		return "", err
+	}
+	return "ALTER ROLE " + name + " RESET " + setting, nil
+}
+
+// GrantDatabase returns the statement granting privileges on a
+// database to a role
+func GrantDatabase(role, database string, privileges []string) (string, error) {
+	err := ValidateDatabasePrivileges(privileges)
+	if err != nil {
+		return "", err
+	}
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	db, err := QuoteIdentifier(database)
+	if err != nil {
+		return "", err
+	}
+	return "GRANT " + strings.ToUpper(strings.Join(privileges, ", ")) + " ON DATABASE " + db + " TO " + name, nil
+}
+
+// RevokeDatabase returns the statement revoking every privilege of a
+// role on a database
+func RevokeDatabase(role, database string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	db, err := QuoteIdentifier(database)
+	if err != nil {
+		return "", err
+	}
+	return "REVOKE ALL ON DATABASE " + db + " FROM " + name, nil
+}
+
+// ReassignOwned returns the statement handing what a role owns in the
+// database it is run in, and the databases it owns, to another role
+func ReassignOwned(role, owner string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	newOwner, err := quoteRole(owner)
+	if err != nil {
+		return "", err
+	}
+	return "REASSIGN OWNED BY " + name + " TO " + newOwner, nil
+}
+
+// DropOwned returns the statement revoking the privileges of a role in
+// the database it is run in, it is run after ReassignOwned so no
+// objects are left for it to drop
+func DropOwned(role string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "DROP OWNED BY " + name, nil
+}
+
+// DropRole returns the statement dropping a role if it exists
+func DropRole(role string) (string, error) {
+	name, err := quoteRole(role)
+	if err != nil {
+		return "", err
+	}
+	return "DROP ROLE IF EXISTS " + name, nil
+}
+
+// Script joins statements into a script
+func Script(statements []string) string {
+	return strings.Join(statements, ";\n") + ";\n"
+}
+
+func quoteRole(role string) (string, error) {
+	err := ValidateRoleName(role)
+	if err != nil {
+		return "", err
+	}
+	return QuoteIdentifier(role)
+}
+
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateRoleName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"app", false},
+		{"App User", false},
+		{`a"b`, false},
+		{strings.Repeat("a", MAX_IDENTIFIER_LENGTH), false},
+		{strings.Repeat("a", MAX_IDENTIFIER_LENGTH+1), true},
+		{strings.Repeat("é", 32), true},
+		{"", true},
+		{"a\x00b", true},
+		{"pg_monitor", true},
+		{"PG_Monitor", true},
+		{"pgapp", false},
+		{"public", true},
+		{"PUBLIC", true},
+		{"none", true},
+		{"current_role", true},
+		{"current_user", true},
+		{"session_user", true},
+		{"user", false},
+	}
+	for _, tt := range tests {
+		err := ValidateRoleName(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ValidateRoleName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestAlterRoleSet(t *testing.T) {
+	tests := []struct {
+		role    string
+		setting string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{"app", "work_mem", "64MB", `ALTER ROLE "app" SET work_mem = '64MB'`, false},
+		{"app", "statement_timeout", "0", `ALTER ROLE "app" SET statement_timeout = '0'`, false},
+		{"app", "search_path", "app, public", `ALTER ROLE "app" SET search_path = 'app', 'public'`, false},
+		{"app", "search_path", `"$user",public`, `ALTER ROLE "app" SET search_path = '"$user"', 'public'`, false},
+		{"app", "application_name", "it's", `ALTER ROLE "app" SET application_name = 'it''s'`, false},
+		{"app", "application_name", `a\b`, `ALTER ROLE "app" SET application_name = E'a\\b'`, false},
+		{"app", "application_name", "a, b", `ALTER ROLE "app" SET application_name = 'a, b'`, false},
+		{"app", "pg_stat_statements.track", "all", `ALTER ROLE "app" SET pg_stat_statements.track = 'all'`, false},
+		{`a"b`, "work_mem", "64MB", `ALTER ROLE "a""b" SET work_mem = '64MB'`, false},
+		{"app", "work_mem = '1GB'; --", "64MB", "", true},
+		{"app", "Work_Mem", "64MB", "", true},
+		{"app", "", "64MB", "", true},
+		{"app", "application_name", "a\x00b", "", true},
+		{"pg_monitor", "work_mem", "64MB", "", true},
+		{"public", "work_mem", "64MB", "", true},
+	}
+	for _, tt := range tests {
+		got, err := AlterRoleSet(tt.role, tt.setting, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("AlterRoleSet(%q, %q, %q) error = %v, wantErr %v", tt.role, tt.setting, tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("AlterRoleSet(%q, %q, %q) = %s, want %s", tt.role, tt.setting, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestReassignOwned(t *testing.T) {
+	tests := []struct {
+		role    string
+		owner   string
+		want    string
+		wantErr bool
+	}{
+		{"app", "testuser", `REASSIGN OWNED BY "app" TO "testuser"`, false},
+		{`a"b`, "postgres", `REASSIGN OWNED BY "a""b" TO "postgres"`, false},
+		{"app", "public", "", true},
+		{"pg_monitor", "postgres", "", true},
+		{"app", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ReassignOwned(tt.role, tt.owner)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ReassignOwned(%q, %q) error = %v, wantErr %v", tt.role, tt.owner, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ReassignOwned(%q, %q) = %s, want %s", tt.role, tt.owner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package sqlutil
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+)
+
+// the iteration count and salt length PostgreSQL itself uses when it
+// hashes a password for SCRAM-SHA-256
+const SCRAM_ITERATIONS = 4096
+const SCRAM_SALT_LENGTH = 16
+
+// scramVerifier returns the SCRAM-SHA-256 verifier PostgreSQL stores
+// for a password, using a random salt, the password is used as given
+// which matches what PostgreSQL does for the ASCII passwords the
+// operator generates
+func scramVerifier(password string) (string, error) {
+	salt := make([]byte, SCRAM_SALT_LENGTH)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	return scramVerifierWithSalt(password, salt, SCRAM_ITERATIONS), nil
+}
+
+func scramVerifierWithSalt(password string, salt []byte, iterations int) string {
+	salted := pbkdf2SHA256([]byte(password), salt, iterations)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+
+	return "SCRAM-SHA-256$" + strconv.Itoa(iterations) + ":" + base64.StdEncoding.EncodeToString(salt) +
+		"$" + base64.StdEncoding.EncodeToString(storedKey[:]) + ":" + base64.StdEncoding.EncodeToString(serverKey)
+}
+
+// pbkdf2SHA256 derives a key of one SHA-256 block, all SCRAM needs
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(nil)
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func hmacSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,48 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"github.com/crunchydata/kraken/sqlutil"
+)
+
+// SetArchiveCommand sets the archive_command of a server with ALTER
+// SYSTEM and reloads its configuration, archive_command does not need
+// a restart, it returns false when the server already runs command
+func SetArchiveCommand(target SQLTarget, command string) (bool, error) {
+	var current string
+	err := queryRow(target, "select current_setting('archive_command')", &current)
+	if err != nil {
+		return false, err
+	}
+	if current == command {
+		return false, nil
+	}
+
+	value, err := sqlutil.QuoteLiteral(command)
+	if err != nil {
+		return false, err
+	}
+	_, err = ExecSQL(target, "ALTER SYSTEM SET archive_command = "+value, SQL_MODE_STATEMENTS)
+	if err != nil {
+		return false, err
+	}
+	_, err = ExecSQL(target, "select pg_reload_conf()", SQL_MODE_STATEMENTS)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
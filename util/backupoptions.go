@@ -0,0 +1,116 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"strconv"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+)
+
+// the volume and mount path of the backup encryption key
+const BACKUP_KEY_VOLUME = "backup-key"
+const BACKUP_KEY_PATH = "/backup-key"
+
+// the volume a packed backup is taken into before the backupagent packs
+// it onto the backup volume, and its mount path in the backupagent
+const BACKUP_SCRATCH_VOLUME = "backup-scratch"
+const BACKUP_SCRATCH_PATH = "/scratch"
+
+// ValidateBackupOptions checks a compression algorithm and level
+func ValidateBackupOptions(compression string, level string) error {
+	max := 0
+	switch compression {
+	case "", crv1.COMPRESSION_NONE:
+		if level != "" {
+			return errors.New("a compression level requires a compression algorithm")
+		}
+		return nil
+	case crv1.COMPRESSION_GZIP:
+		max = 9
+	case crv1.COMPRESSION_ZSTD:
+		max = 19
+	default:
+		return errors.New("invalid compression " + compression + ", must be " +
+			crv1.COMPRESSION_NONE + ", " + crv1.COMPRESSION_GZIP + " or " + crv1.COMPRESSION_ZSTD)
+	}
+
+	if level == "" {
+		return nil
+	}
+	l, err := strconv.Atoi(level)
+	if err != nil || l < 1 || l > max {
+		return errors.New("invalid " + compression + " compression level " + level + ", must be 1 to " + strconv.Itoa(max))
+	}
+	return nil
+}
+
+// BackupIsPacked is true when a backup is written as a compressed or
+// encrypted archive rather than a plain data directory
+func BackupIsPacked(compression string, encryptionSecret string) bool {
+	return (compression != "" && compression != crv1.COMPRESSION_NONE) || encryptionSecret != ""
+}
+
+// CreateEncryptionVolume returns the secret volume holding the backup
+// encryption key, prefixed with a comma so it can follow a volume list
+func CreateEncryptionVolume(secretName string) string {
+	if secretName == "" {
+		return ""
+	}
+	return ", {\"name\": \"" + BACKUP_KEY_VOLUME + "\", \"secret\": {\"secretName\": " + QuoteJSON(secretName) + "}}"
+}
+
+// CreateEncryptionMount returns the volumeMount of the backup
+// encryption key, prefixed with a comma
+func CreateEncryptionMount(secretName string) string {
+	if secretName == "" {
+		return ""
+	}
+	return ", {\"mountPath\": \"" + BACKUP_KEY_PATH + "\", \"name\": \"" + BACKUP_KEY_VOLUME + "\", \"readOnly\": true}"
+}
+
+// GetBackupVolume returns the volume the backup container writes the
+// backup to, a packed backup is written to the scratch volume so the
+// unpacked backup never reaches the backup PVC
+func GetBackupVolume(compression, encryptionSecret string) string {
+	if BackupIsPacked(compression, encryptionSecret) {
+		return BACKUP_SCRATCH_VOLUME
+	}
+	return "pgdata"
+}
+
+// CreateScratchVolume returns the scratch volume of a packed backup,
+// prefixed with a comma, it is memory backed for an encrypted backup so
+// the unencrypted backup is not written to any disk
+func CreateScratchVolume(compression, encryptionSecret string) string {
+	if !BackupIsPacked(compression, encryptionSecret) {
+		return ""
+	}
+	if encryptionSecret != "" {
+		return ", {\"name\": \"" + BACKUP_SCRATCH_VOLUME + "\", \"emptyDir\": {\"medium\": \"Memory\"}}"
+	}
+	return ", {\"name\": \"" + BACKUP_SCRATCH_VOLUME + "\", \"emptyDir\": {}}"
+}
+
+// CreateScratchMount returns the volumeMount of the scratch volume of a
+// packed backup in the backupagent, prefixed with a comma
+func CreateScratchMount(compression, encryptionSecret string) string {
+	if !BackupIsPacked(compression, encryptionSecret) {
+		return ""
+	}
+	return ", {\"mountPath\": \"" + BACKUP_SCRATCH_PATH + "\", \"name\": \"" + BACKUP_SCRATCH_VOLUME + "\", \"readOnly\": false}"
+}
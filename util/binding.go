@@ -0,0 +1,294 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"net/url"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+// entries of a binding secret describing the replica service, they
+// are removed when the cluster no longer has one
+var bindingReplicaKeys = []string{"replica-host", "replica-uri", "replica-jdbc-url"}
+
+// GetBindingSecretName returns the name of the binding secret of a
+// role of a cluster
+func GetBindingSecretName(clusterName, username string) string {
+	return clusterName + "-" + username + crv1.BINDING_SECRET_SUFFIX
+}
+
+// GetServiceHost returns the cluster DNS name of a service, the
+// master service of a cluster follows its master so the name stays
+// valid after a failover
+func GetServiceHost(serviceName, namespace string) string {
+	return serviceName + "." + namespace + ".svc"
+}
+
+// GetJdbcURL returns the JDBC URL of a database, it carries no
+// credentials as the binding secret holds them in its username and
+// password entries
+func GetJdbcURL(host, port, database string) string {
+	u := url.URL{
+		Scheme: "postgresql",
+		Host:   host + ":" + port,
+		Path:   "/" + database,
+	}
+	return "jdbc:" + u.String()
+}
+
+// GetBindingData returns the entries of the binding secret of a role
+// following the service binding specification, type, provider, host,
+// port, database, username, password, uri and jdbc-url for the master
+// service and when the cluster has a replica service replica-host,
+// replica-uri and replica-jdbc-url
+func GetBindingData(cl *crv1.Pgcluster, namespace, username, password, database string, replicas bool) map[string]string {
+	host := GetServiceHost(cl.Spec.Name, namespace)
+	port := cl.Spec.Port
+
+	data := make(map[string]string)
+	data["type"] = crv1.BINDING_TYPE
+	data["provider"] = crv1.BINDING_PROVIDER
+	data["host"] = host
+	data["port"] = port
+	data["database"] = database
+	data["username"] = username
+	data["password"] = password
+	data["uri"] = GetConnectionString(host, port, username, password, database)
+	data["jdbc-url"] = GetJdbcURL(host, port, database)
+
+	if replicas {
+		replicaHost := GetServiceHost(cl.Spec.Name+"-replica", namespace)
+		data["replica-host"] = replicaHost
+		data["replica-uri"] = GetConnectionString(replicaHost, port, username, password, database)
+		data["replica-jdbc-url"] = GetJdbcURL(replicaHost, port, database)
+	}
+	return data
+}
+
+// ApplyClusterBindings creates or refreshes the binding secrets of a
+// cluster, one for PG_USER and one for each login pguser holding a
+// secret on the cluster, and removes those of roles that no longer
+// have one, nothing is written with the vault secret store as the
+// binding secrets would copy its passwords into Kubernetes, the skip
+// is recorded in the pgcluster status instead
+func ApplyClusterBindings(clientset *kubernetes.Clientset, restclient *rest.RESTClient, clusterName, namespace string) error {
+	cl := crv1.Pgcluster{}
+	err := restclient.Get().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(clusterName).
+		Do().
+		Into(&cl)
+	if kerrors.IsNotFound(err) {
+		return DeleteBindings(clientset, clusterName, namespace)
+	} else if err != nil {
+		return err
+	}
+
+	backend := GetSecretStoreConfig().Backend
+	if backend != SECRET_STORE_KUBERNETES {
+		return setBindingStatus(restclient, &cl, crv1.BINDING_SKIPPED, "binding secrets are not written with the "+backend+" secret store", namespace)
+	}
+
+	userList := crv1.PguserList{}
+	err = restclient.Get().
+		Resource(crv1.PguserResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&userList)
+	if err != nil {
+		return err
+	}
+
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+	credentials, err := store.List(clusterName)
+	if err != nil {
+		return err
+	}
+	secrets := make(map[string]*Credential)
+	for i := range credentials {
+		secrets[credentials[i].Name] = &credentials[i]
+	}
+
+	_, err = clientset.Core().Services(namespace).Get(clusterName+"-replica", meta_v1.GetOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		return err
+	}
+	replicas := err == nil
+
+	bindings := make(map[string]map[string]string)
+	if c, ok := secrets[clusterName+crv1.PGUSER_SECRET_SUFFIX]; ok {
+		bindings[GetBindingSecretName(clusterName, c.Username)] = GetBindingData(&cl, namespace, c.Username, c.Password, GetUserDatabase(&cl, c.Username), replicas)
+	}
+	for _, u := range userList.Items {
+		if u.Spec.Group {
+			continue
+		}
+		for _, cs := range u.Status.Clusters {
+			c, ok := secrets[cs.SecretName]
+			if cs.Cluster != clusterName || !ok {
+				continue
+			}
+			database := "postgres"
+			if len(cs.Databases) > 0 {
+				database = cs.Databases[0]
+			}
+			bindings[GetBindingSecretName(clusterName, c.Username)] = GetBindingData(&cl, namespace, c.Username, c.Password, database, replicas)
+		}
+	}
+
+	var applyErr error
+	for name, data := range bindings {
+		err = applyBinding(clientset, name, clusterName, data, namespace)
+		if err != nil {
+			log.Error("error applying binding secret " + name + " " + err.Error())
+			applyErr = err
+		}
+	}
+
+	lo := meta_v1.ListOptions{LabelSelector: crv1.BINDING_LABEL + "=" + clusterName}
+	existing, err := clientset.Core().Secrets(namespace).List(lo)
+	if err != nil {
+		return err
+	}
+	for _, s := range existing.Items {
+		if _, ok := bindings[s.ObjectMeta.Name]; ok {
+			continue
+		}
+		err = clientset.Core().Secrets(namespace).Delete(s.ObjectMeta.Name, &meta_v1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			log.Error("error deleting binding secret " + s.ObjectMeta.Name + " " + err.Error())
+			applyErr = err
+			continue
+		}
+		log.Info("deleted binding secret " + s.ObjectMeta.Name)
+	}
+	if applyErr != nil {
+		return applyErr
+	}
+
+	return setBindingStatus(restclient, &cl, crv1.BINDING_APPLIED, "binding secrets written", namespace)
+}
+
+// setBindingStatus records the binding state of a cluster in its
+// pgcluster status, the status is only updated when the state changes
+// so the periodic refresh does not rewrite the pgcluster every time
+func setBindingStatus(restclient *rest.RESTClient, cl *crv1.Pgcluster, state, message, namespace string) error {
+	if cl.Status.Bindings != nil && cl.Status.Bindings.State == state {
+		return nil
+	}
+	if state == crv1.BINDING_SKIPPED {
+		log.Warn(cl.Spec.Name + " " + message)
+	}
+
+	cl.Status.Bindings = &crv1.PgBindingStatus{
+		State:      state,
+		Message:    message,
+		UpdateDate: time.Now().Format(time.RFC3339),
+	}
+	err := restclient.Put().
+		Resource(crv1.PgclusterResourcePlural).
+		Namespace(namespace).
+		Name(cl.Spec.Name).
+		Body(cl).
+		Do().
+		Error()
+	if err != nil {
+		log.Error("error updating the binding status of " + cl.Spec.Name + " " + err.Error())
+	}
+	return err
+}
+
+// applyBinding creates or updates a binding secret, entries added to
+// the secret by others are kept and it is only updated when one of its
+// entries changed
+func applyBinding(clientset *kubernetes.Clientset, name, clusterName string, data map[string]string, namespace string) error {
+	secret, err := clientset.Core().Secrets(namespace).Get(name, meta_v1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		secret = &v1.Secret{}
+		secret.Name = name
+		secret.ObjectMeta.Labels = map[string]string{crv1.BINDING_LABEL: clusterName}
+		secret.Type = v1.SecretType(crv1.BINDING_SECRET_TYPE)
+		secret.Data = make(map[string][]byte)
+		for k, v := range data {
+			secret.Data[k] = []byte(v)
+		}
+		_, err = clientset.Core().Secrets(namespace).Create(secret)
+		if err == nil {
+			log.Info("created binding secret " + name)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	changed := false
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	for _, k := range bindingReplicaKeys {
+		if _, ok := data[k]; ok {
+			continue
+		}
+		if _, ok := secret.Data[k]; ok {
+			delete(secret.Data, k)
+			changed = true
+		}
+	}
+	for k, v := range data {
+		if string(secret.Data[k]) != v {
+			secret.Data[k] = []byte(v)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	_, err = clientset.Core().Secrets(namespace).Update(secret)
+	if err == nil {
+		log.Info("updated binding secret " + name)
+	}
+	return err
+}
+
+// DeleteBindings removes the binding secrets of a cluster
+func DeleteBindings(clientset *kubernetes.Clientset, clusterName, namespace string) error {
+	lo := meta_v1.ListOptions{LabelSelector: crv1.BINDING_LABEL + "=" + clusterName}
+	secrets, err := clientset.Core().Secrets(namespace).List(lo)
+	if err != nil {
+		return err
+	}
+	for _, s := range secrets.Items {
+		err = clientset.Core().Secrets(namespace).Delete(s.ObjectMeta.Name, &meta_v1.DeleteOptions{})
+		if err != nil && !kerrors.IsNotFound(err) {
+			return err
+		}
+		log.Info("deleted binding secret " + s.ObjectMeta.Name)
+	}
+	return nil
+}
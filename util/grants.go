@@ -0,0 +1,360 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+)
+
+// DatabaseExistsQuery tells whether the database named by its
+// parameter exists
+const DatabaseExistsQuery = "select exists (select 1 from pg_database where datname = $1)"
+
+// the roles of a cluster, whether they can log in or are superusers,
+// when their password expires, their connection limit and settings and
+// the group roles they are members of
+const rolesQuery = "select r.rolname, r.rolcanlogin, r.rolsuper, coalesce(r.rolvaliduntil::text, ''), " +
+	"r.rolconnlimit, coalesce(array_to_string(r.rolconfig, ', '), ''), " +
+	"coalesce(array_to_string(array(select g.rolname from pg_auth_members m join pg_roles g on g.oid = m.roleid " +
+	"where m.member = r.oid order by 1), ','), '') " +
+	"from pg_roles r where r.rolname not like 'pg\\_%' order by 1"
+
+// the privileges granted on the databases of a cluster, those of their
+// owners and of PUBLIC are left out
+const databaseGrantsQuery = "select 'database', d.datname, pg_get_userbyid(a.grantee), " +
+	"string_agg(a.privilege_type, ',' order by a.privilege_type) " +
+	"from pg_database d, aclexplode(d.datacl) a " +
+	"where d.datallowconn and not d.datistemplate and a.grantee <> 0 and a.grantee <> d.datdba " +
+	"group by 1, 2, 3"
+
+// the privileges granted on the schemas, tables, sequences and
+// functions of the database the query runs in and the default
+// privileges given there, those of owners and of PUBLIC are left out
+const objectGrantsQuery = "select 'schema', n.nspname, pg_get_userbyid(a.grantee), " +
+	"string_agg(a.privilege_type, ',' order by a.privilege_type) " +
+	"from pg_namespace n, aclexplode(n.nspacl) a " +
+	"where a.grantee <> 0 and a.grantee <> n.nspowner and n.nspname not like 'pg\\_%' and n.nspname <> 'information_schema' " +
+	"group by 1, 2, 3 " +
+	"union all " +
+	"select case c.relkind when 'S' then 'sequence' else 'table' end, n.nspname || '.' || c.relname, pg_get_userbyid(a.grantee), " +
+	"string_agg(a.privilege_type, ',' order by a.privilege_type) " +
+	"from pg_class c join pg_namespace n on n.oid = c.relnamespace, aclexplode(c.relacl) a " +
+	"where c.relkind in ('r', 'v', 'm', 'f', 'p', 'S') and a.grantee <> 0 and a.grantee <> c.relowner " +
+	"and n.nspname not like 'pg\\_%' and n.nspname <> 'information_schema' " +
+	"group by 1, 2, 3 " +
+	"union all " +
+	"select 'function', n.nspname || '.' || p.proname || '(' || pg_get_function_identity_arguments(p.oid) || ')', " +
+	"pg_get_userbyid(a.grantee), string_agg(a.privilege_type, ',' order by a.privilege_type) " +
+	"from pg_proc p join pg_namespace n on n.oid = p.pronamespace, aclexplode(p.proacl) a " +
+	"where a.grantee <> 0 and a.grantee <> p.proowner and n.nspname not like 'pg\\_%' and n.nspname <> 'information_schema' " +
+	"group by 1, 2, 3 " +
+	"union all " +
+	"select 'default ' || case d.defaclobjtype when 'r' then 'tables' when 'S' then 'sequences' when 'f' then 'functions' " +
+	"when 'T' then 'types' else 'schemas' end, coalesce(n.nspname, '*') || ' created by ' || pg_get_userbyid(d.defaclrole), " +
+	"pg_get_userbyid(a.grantee), string_agg(a.privilege_type, ',' order by a.privilege_type) " +
+	"from pg_default_acl d left join pg_namespace n on n.oid = d.defaclnamespace, aclexplode(d.defaclacl) a " +
+	"where a.grantee <> 0 " +
+	"group by 1, 2, 3"
+
+// GetPguserGrants returns the grants of a pguser on each of its
+// databases, those of its template followed by its own
+func GetPguserGrants(spec *crv1.PguserSpec) []crv1.PgGrantSpec {
+	grants := []crv1.PgGrantSpec{}
+	for _, d := range spec.Databases {
+		grants = append(grants, getTemplateGrants(spec, d)...)
+		for _, g := range spec.Grants {
+			if g.Database != "" && g.Database != d {
+				continue
+			}
+			grant := g
+			grant.Database = d
+			grant.Privileges = []string{}
+			for _, p := range g.Privileges {
+				grant.Privileges = append(grant.Privileges, strings.ToUpper(p))
+			}
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// getTemplateGrants returns the grants the template of a pguser gives
+// on the schemas of a database, usage of each schema and access to its
+// tables and sequences, including those created later
+func getTemplateGrants(spec *crv1.PguserSpec, database string) []crv1.PgGrantSpec {
+	grants := []crv1.PgGrantSpec{}
+	var tables, sequences []string
+	switch spec.Template {
+	case crv1.PGUSER_TEMPLATE_READONLY:
+		tables = []string{"SELECT"}
+		sequences = []string{"SELECT"}
+	case crv1.PGUSER_TEMPLATE_READWRITE:
+		tables = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+		sequences = []string{"USAGE", "SELECT", "UPDATE"}
+	default:
+		return grants
+	}
+
+	schemas := spec.Schemas
+	if len(schemas) == 0 {
+		schemas = []string{crv1.PGUSER_DEFAULT_SCHEMA}
+	}
+	for _, s := range schemas {
+		grants = append(grants,
+			crv1.PgGrantSpec{Database: database, Schema: s, On: sqlutil.OBJECT_SCHEMA, Privileges: []string{"USAGE"}},
+			crv1.PgGrantSpec{Database: database, Schema: s, On: sqlutil.OBJECT_TABLES, Privileges: tables, Default: true},
+			crv1.PgGrantSpec{Database: database, Schema: s, On: sqlutil.OBJECT_SEQUENCES, Privileges: sequences, Default: true})
+	}
+	return grants
+}
+
+// GetRevokedGrants returns the grants of previous that are not in
+// current, a grant of default privileges is also revoked when the
+// owner they were given for has changed
+func GetRevokedGrants(previous, current []crv1.PgGrantSpec, previousOwner, owner string) []crv1.PgGrantSpec {
+	revoked := []crv1.PgGrantSpec{}
+	for _, p := range previous {
+		kept := false
+		for _, c := range current {
+			if reflect.DeepEqual(p, c) {
+				kept = !p.Default || previousOwner == owner
+				break
+			}
+		}
+		if !kept {
+			revoked = append(revoked, p)
+		}
+	}
+	return revoked
+}
+
+// GetGrantSQL returns the script to run in each database to revoke
+// the grants a role no longer has, then give it its grants, default
+// privileges are revoked for previousOwner and given for owner
+func GetGrantSQL(role string, grants, revoke []crv1.PgGrantSpec, owner, previousOwner string) (map[string]string, error) {
+	statements := make(map[string][]string)
+	for _, g := range revoke {
+		stmt, err := sqlutil.RevokeObjects(role, g.On, g.Schema, g.Objects, g.Privileges)
+		if err != nil {
+			return nil, err
+		}
+		statements[g.Database] = append(statements[g.Database], stmt)
+		if g.Default {
+			stmt, err = sqlutil.RevokeDefaultPrivileges(previousOwner, role, g.On, g.Schema, g.Privileges)
+			if err != nil {
+				return nil, err
+			}
+			statements[g.Database] = append(statements[g.Database], stmt)
+		}
+	}
+	for _, g := range grants {
+		stmt, err := sqlutil.GrantObjects(role, g.On, g.Schema, g.Objects, g.Privileges)
+		if err != nil {
+			return nil, err
+		}
+		statements[g.Database] = append(statements[g.Database], stmt)
+		if g.Default {
+			stmt, err = sqlutil.GrantDefaultPrivileges(owner, role, g.On, g.Schema, g.Privileges)
+			if err != nil {
+				return nil, err
+			}
+			statements[g.Database] = append(statements[g.Database], stmt)
+		}
+	}
+
+	scripts := make(map[string]string)
+	for d, s := range statements {
+		scripts[d] = sqlutil.Script(s)
+	}
+	return scripts, nil
+}
+
+// GetGrantLine returns a grant written the way ParseGrant reads it
+func GetGrantLine(g *crv1.PgGrantSpec) string {
+	fields := []string{strings.Join(g.Privileges, ","), "on", g.On}
+	if g.On == sqlutil.OBJECT_SCHEMA {
+		fields = append(fields, g.Schema)
+	} else {
+		if len(g.Objects) > 0 {
+			fields = append(fields, strings.Join(g.Objects, ","))
+		}
+		fields = append(fields, "in", g.Schema)
+	}
+	if g.Database != "" {
+		fields = append(fields, "database", g.Database)
+	}
+	if g.Default {
+		fields = append(fields, "default")
+	}
+	return strings.Join(fields, " ")
+}
+
+// ParseGrant reads a grant such as
+// SELECT,INSERT on tables orders,items in sales database userdb or
+// USAGE on schema sales, a grant on every table, sequence or function
+// of a schema names no objects and may end with default to also grant
+// the privileges on those created later
+func ParseGrant(line string) (crv1.PgGrantSpec, error) {
+	g := crv1.PgGrantSpec{}
+	format := errors.New("grant " + line + " must be written as privileges on schema name, or privileges on tables|sequences|functions [objects] in schema, followed by an optional database name and default")
+
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[1] != "on" {
+		return g, format
+	}
+	g.Privileges = strings.Split(fields[0], ",")
+	g.On = fields[2]
+	rest := fields[3:]
+	if g.On == sqlutil.OBJECT_SCHEMA {
+		g.Schema = rest[0]
+		rest = rest[1:]
+	} else {
+		if rest[0] != "in" {
+			g.Objects = strings.Split(rest[0], ",")
+			rest = rest[1:]
+		}
+		if len(rest) < 2 || rest[0] != "in" {
+			return g, format
+		}
+		g.Schema = rest[1]
+		rest = rest[2:]
+	}
+	if len(rest) >= 2 && rest[0] == "database" {
+		g.Database = rest[1]
+		rest = rest[2:]
+	}
+	if len(rest) == 1 && rest[0] == "default" {
+		g.Default = true
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		return g, format
+	}
+	return g, nil
+}
+
+// GetRoleGrants reads the roles of a cluster from its catalogs along
+// with their group roles and the privileges granted to them in each
+// database that accepts connections
+func GetRoleGrants(target SQLTarget) ([]crv1.PgRoleGrants, error) {
+	roles := []crv1.PgRoleGrants{}
+
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return roles, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(rolesQuery)
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r := crv1.PgRoleGrants{}
+		var memberOf string
+		err = rows.Scan(&r.Name, &r.Login, &r.Superuser, &r.ValidUntil, &r.ConnectionLimit, &r.Settings, &memberOf)
+		if err != nil {
+			return roles, err
+		}
+		if memberOf != "" {
+			r.MemberOf = strings.Split(memberOf, ",")
+		}
+		roles = append(roles, r)
+	}
+	err = rows.Err()
+	if err != nil {
+		return roles, err
+	}
+
+	privileges := make(map[string][]crv1.PgCatalogPrivilege)
+	err = readPrivileges(db, "", databaseGrantsQuery, privileges)
+	if err != nil {
+		return roles, err
+	}
+
+	dbRows, err := db.Query("select datname from pg_database where datallowconn and not datistemplate order by 1")
+	if err != nil {
+		return roles, err
+	}
+	defer dbRows.Close()
+	databases := []string{}
+	for dbRows.Next() {
+		var d string
+		err = dbRows.Scan(&d)
+		if err != nil {
+			return roles, err
+		}
+		databases = append(databases, d)
+	}
+	err = dbRows.Err()
+	if err != nil {
+		return roles, err
+	}
+
+	for _, d := range databases {
+		t := target
+		t.Database = d
+		err = readDatabasePrivileges(t, privileges)
+		if err != nil {
+			return roles, errors.New("could not read the privileges in " + d + " " + err.Error())
+		}
+	}
+
+	for i := range roles {
+		roles[i].Privileges = privileges[roles[i].Name]
+	}
+	return roles, nil
+}
+
+func readDatabasePrivileges(target SQLTarget, privileges map[string][]crv1.PgCatalogPrivilege) error {
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return readPrivileges(db, target.Database, objectGrantsQuery, privileges)
+}
+
+// readPrivileges adds the privileges returned by a query to those of
+// each grantee, database is the database the query runs in, a query
+// on the databases themselves passes an empty one
+func readPrivileges(db *sql.DB, database, query string, privileges map[string][]crv1.PgCatalogPrivilege) error {
+	rows, err := db.Query(query + " order by 3, 1, 2")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p := crv1.PgCatalogPrivilege{Database: database}
+		var grantee string
+		err = rows.Scan(&p.Kind, &p.Object, &grantee, &p.Privileges)
+		if err != nil {
+			return err
+		}
+		if database == "" {
+			p.Database = p.Object
+		}
+		privileges[grantee] = append(privileges[grantee], p)
+	}
+	return rows.Err()
+}
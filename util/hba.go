@@ -0,0 +1,364 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// key of the pg_hba.conf in the pgconf ConfigMap of a cluster and the
+// path the ConfigMap puts it at in the database containers
+const HBA_CONF_KEY = "pg_hba.conf"
+const HBA_CONF_PATH = "/pgconf/" + HBA_CONF_KEY
+
+// how long the kubelet may take to put an updated ConfigMap in the
+// volumes of the pods
+const HBA_SYNC_TIMEOUT = 2 * time.Minute
+
+// HbaFileRulesQuery returns each rule of the pg_hba.conf a server
+// would load on a line, as GetHbaFileRules writes them
+const HbaFileRulesQuery = "select coalesce(string_agg(concat_ws(' ', type, array_to_string(database, ','), " +
+	"array_to_string(user_name, ','), address, netmask, auth_method), E'\\n' order by line_number), '') " +
+	"from pg_hba_file_rules"
+
+// the first PostgreSQL version with SCRAM-SHA-256 and pg_hba_file_rules
+const SCRAM_SERVER_VERSION = 100000
+
+var hbaTypes = []string{"local", "host", "hostssl", "hostnossl"}
+var hbaMethods = []string{"trust", "reject", "md5", sqlutil.PASSWORD_ENCRYPTION_SCRAM, "cert", "peer"}
+var hbaAddresses = []string{"all", "samehost", "samenet"}
+
+// a database or user of a pg_hba.conf rule, a user may name a group
+// role with a + prefix
+var hbaNameFormat = regexp.MustCompile(`^\+?[A-Za-z_][A-Za-z0-9_$.-]*$`)
+
+// GetAuthConfigMapName returns the name of the ConfigMap holding the
+// pg_hba.conf of a cluster
+func GetAuthConfigMapName(clusterName string) string {
+	return clusterName + crv1.PGCONF_CONFIGMAP_SUFFIX
+}
+
+// GetPasswordEncryption returns the password encryption of a cluster,
+// md5 when it does not say
+func GetPasswordEncryption(spec *crv1.PgAuthSpec) string {
+	if spec.PasswordEncryption == "" {
+		return sqlutil.PASSWORD_ENCRYPTION_MD5
+	}
+	return spec.PasswordEncryption
+}
+
+// ValidateAuthSpec checks the password encryption of a cluster is known
+// and each of its pg_hba rules can be written to pg_hba.conf, a rule
+// asking for scram-sha-256 needs passwords encrypted with it
+func ValidateAuthSpec(spec *crv1.PgAuthSpec) error {
+	err := sqlutil.ValidatePasswordEncryption(spec.PasswordEncryption)
+	if err != nil {
+		return err
+	}
+	for i, r := range spec.HbaRules {
+		err = validateHbaRule(&r, GetPasswordEncryption(spec))
+		if err != nil {
+			return errors.New("pg_hba rule " + GetHbaRuleLine(&r) + " (" + strconv.Itoa(i+1) + ") " + err.Error())
+		}
+	}
+	return nil
+}
+
+func validateHbaRule(r *crv1.PgHbaRule, encryption string) error {
+	if !containsWord(hbaTypes, r.Type) {
+		return errors.New("has an invalid type, must be one of " + strings.Join(hbaTypes, ", "))
+	}
+	if !containsWord(hbaMethods, r.Method) {
+		return errors.New("has an invalid method, must be one of " + strings.Join(hbaMethods, ", "))
+	}
+	if r.Method == sqlutil.PASSWORD_ENCRYPTION_SCRAM && encryption != sqlutil.PASSWORD_ENCRYPTION_SCRAM {
+		return errors.New("needs the passwords to be encrypted with " + sqlutil.PASSWORD_ENCRYPTION_SCRAM)
+	}
+	if r.Method == "peer" && r.Type != "local" {
+		return errors.New("can only use peer for local connections")
+	}
+	if r.Method == "cert" && r.Type != "hostssl" {
+		return errors.New("can only use cert for hostssl connections")
+	}
+	for _, names := range []string{r.Database, r.User} {
+		if names == "" {
+			return errors.New("needs a database and a user")
+		}
+		for _, n := range strings.Split(names, ",") {
+			if !hbaNameFormat.MatchString(n) || len(n) > sqlutil.MAX_IDENTIFIER_LENGTH+1 {
+				return errors.New("has an invalid database or user " + n)
+			}
+		}
+	}
+	if r.Type == "local" {
+		if r.Address != "" {
+			return errors.New("can not have an address for local connections")
+		}
+		return nil
+	}
+	if containsWord(hbaAddresses, r.Address) {
+		return nil
+	}
+	_, _, err := net.ParseCIDR(r.Address)
+	if err != nil {
+		return errors.New("needs an address written as a CIDR such as 10.0.0.0/8")
+	}
+	return nil
+}
+
+// GetHbaRuleLine returns a pg_hba rule as a pg_hba.conf line
+func GetHbaRuleLine(r *crv1.PgHbaRule) string {
+	fields := []string{r.Type, r.Database, r.User}
+	if r.Type != "local" {
+		fields = append(fields, r.Address)
+	}
+	fields = append(fields, r.Method)
+	return strings.Join(fields, " ")
+}
+
+// ParseHbaRule reads a pg_hba.conf line such as
+// host all app 10.0.0.0/8 scram-sha-256 into a pg_hba rule
+func ParseHbaRule(line string) (crv1.PgHbaRule, error) {
+	r := crv1.PgHbaRule{}
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 4 && fields[0] == "local":
+		r = crv1.PgHbaRule{Type: fields[0], Database: fields[1], User: fields[2], Method: fields[3]}
+	case len(fields) == 5 && fields[0] != "local":
+		r = crv1.PgHbaRule{Type: fields[0], Database: fields[1], User: fields[2], Address: fields[3], Method: fields[4]}
+	default:
+		return r, errors.New("pg_hba rule " + line + " must be written as type database user address method, local rules have no address")
+	}
+	return r, nil
+}
+
+// GetHbaConf returns the pg_hba.conf of a cluster, the postgres user
+// the operator connects as and the replication user the replicas
+// stream with are always let in, then come the rules of the cluster or,
+// when it has none, a rule letting every user in with a password
+func GetHbaConf(spec *crv1.PgclusterSpec) string {
+	method := GetPasswordEncryption(&spec.Auth)
+
+	lines := []string{
+		"# maintained by the operator from the auth settings of pgcluster " + spec.Name,
+		"local all postgres trust",
+		"host all postgres 0.0.0.0/0 " + method,
+		"host replication " + spec.PG_MASTER_USER + " 0.0.0.0/0 " + method,
+	}
+	for i := range spec.Auth.HbaRules {
+		lines = append(lines, GetHbaRuleLine(&spec.Auth.HbaRules[i]))
+	}
+	if len(spec.Auth.HbaRules) == 0 {
+		lines = append(lines, "host all all 0.0.0.0/0 "+method)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// GetAuthHash identifies the pg_hba.conf and password encryption the
+// auth settings of a cluster amount to, it is recorded in the status
+// of the cluster when they are applied so a change can be told
+func GetAuthHash(spec *crv1.PgclusterSpec) string {
+	sum := sha256.Sum256([]byte(GetPasswordEncryption(&spec.Auth) + "\n" + GetHbaConf(spec)))
+	return hex.EncodeToString(sum[:])
+}
+
+// ApplyAuthConfigMap creates the ConfigMap holding the pg_hba.conf of
+// a cluster or updates it in place, the database containers mount it
+// at /pgconf
+func ApplyAuthConfigMap(clientset *kubernetes.Clientset, cl *crv1.Pgcluster, namespace string) error {
+	_, err := SetAuthConfigMap(clientset, cl.Spec.Name, GetHbaConf(&cl.Spec), namespace)
+	return err
+}
+
+// SetAuthConfigMap puts conf in the pgconf ConfigMap of a cluster and
+// returns the pg_hba.conf it held before, empty when it was created
+func SetAuthConfigMap(clientset *kubernetes.Clientset, clusterName, conf, namespace string) (string, error) {
+	name := GetAuthConfigMapName(clusterName)
+
+	cm, err := clientset.Core().ConfigMaps(namespace).Get(name, meta_v1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		cm = &v1.ConfigMap{}
+		cm.Name = name
+		cm.ObjectMeta.Labels = make(map[string]string)
+		cm.ObjectMeta.Labels["pg-cluster"] = clusterName
+		cm.Data = make(map[string]string)
+		cm.Data[HBA_CONF_KEY] = conf
+		_, err = clientset.Core().ConfigMaps(namespace).Create(cm)
+		if err == nil {
+			log.Debug("created configmap " + name)
+		}
+		return "", err
+	} else if err != nil {
+		return "", err
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	previous := cm.Data[HBA_CONF_KEY]
+	cm.Data[HBA_CONF_KEY] = conf
+	_, err = clientset.Core().ConfigMaps(namespace).Update(cm)
+	if err == nil {
+		log.Debug("updated configmap " + name)
+	}
+	return previous, err
+}
+
+// DeleteAuthConfigMap deletes the pgconf ConfigMap of a cluster
+func DeleteAuthConfigMap(clientset *kubernetes.Clientset, clusterName, namespace string) {
+	name := GetAuthConfigMapName(clusterName)
+	err := clientset.Core().ConfigMaps(namespace).Delete(name, &meta_v1.DeleteOptions{})
+	if err != nil && !kerrors.IsNotFound(err) {
+		log.Error("error deleting configmap " + name + " " + err.Error())
+		return
+	}
+	log.Info("deleted configmap " + name)
+}
+
+// GetServerVersion returns the server_version_num of a database
+func GetServerVersion(target SQLTarget) (int, error) {
+	var version int
+	err := queryRow(target, "select current_setting('server_version_num')::int", &version)
+	return version, err
+}
+
+// PointHbaFile sets hba_file to the pg_hba.conf of the pgconf
+// ConfigMap, the server only reads hba_file when it starts so true is
+// returned when it reads another file until its next restart
+func PointHbaFile(target SQLTarget) (bool, error) {
+	var path string
+	err := queryRow(target, "select current_setting('hba_file')", &path)
+	if err != nil {
+		return false, err
+	}
+	if path == HBA_CONF_PATH {
+		return false, nil
+	}
+
+	value, err := sqlutil.QuoteLiteral(HBA_CONF_PATH)
+	if err != nil {
+		return false, err
+	}
+	_, err = ExecSQL(target, "ALTER SYSTEM SET hba_file = "+value, SQL_MODE_STATEMENTS)
+	if err != nil {
+		return false, err
+	}
+	log.Info("hba_file of " + target.Host + " set to " + HBA_CONF_PATH + ", it is read once the server restarts")
+	return true, nil
+}
+
+// ReloadHbaConf reloads the pg_hba.conf of a server reading it from
+// the pgconf ConfigMap once the kubelet has put conf in the mounted
+// file, which it does within its sync period.  On PostgreSQL 10 and
+// later pg_hba_file_rules shows when the file holds conf and the file
+// is not reloaded when any of its lines is refused, earlier versions
+// are reloaded once deadline has passed.
+func ReloadHbaConf(target SQLTarget, conf string, deadline time.Time) error {
+	version, err := GetServerVersion(target)
+	if err != nil {
+		return err
+	}
+
+	if version < SCRAM_SERVER_VERSION {
+		time.Sleep(deadline.Sub(time.Now()))
+	} else {
+		want, err := GetHbaFileRules(conf)
+		if err != nil {
+			return err
+		}
+		for {
+			var rules, refused string
+			err = queryRow(target, HbaFileRulesQuery, &rules)
+			if err != nil {
+				return err
+			}
+			if rules == want {
+				break
+			}
+			err = queryRow(target, "select coalesce(string_agg(line_number || ': ' || error, ', '), '') "+
+				"from pg_hba_file_rules where error is not null", &refused)
+			if err != nil {
+				return err
+			}
+			//a refused line is shown without its rule, the file holds
+			//conf when it has as many lines as conf has rules
+			if refused != "" && strings.Count(rules, "\n") == strings.Count(want, "\n") {
+				return errors.New("pg_hba.conf lines refused " + refused)
+			}
+			if time.Now().After(deadline) {
+				return errors.New(HBA_CONF_PATH + " does not hold the pg_hba.conf of the configmap after " + HBA_SYNC_TIMEOUT.String())
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}
+
+	_, err = ExecSQL(target, "select pg_reload_conf()", SQL_MODE_STATEMENTS)
+	return err
+}
+
+// GetHbaFileRules returns the rules of a pg_hba.conf as
+// HbaFileRulesQuery shows them once a server reads it, the address of
+// a rule is split into its address and netmask
+func GetHbaFileRules(conf string) (string, error) {
+	rules := []string{}
+	for _, line := range strings.Split(conf, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := ParseHbaRule(line)
+		if err != nil {
+			return "", err
+		}
+		fields := []string{r.Type, r.Database, r.User}
+		if r.Type != "local" {
+			ip, ipnet, err := net.ParseCIDR(r.Address)
+			if err == nil {
+				fields = append(fields, ip.String(), net.IP(ipnet.Mask).String())
+			} else {
+				fields = append(fields, r.Address)
+			}
+		}
+		fields = append(fields, r.Method)
+		rules = append(rules, strings.Join(fields, " "))
+	}
+	return strings.Join(rules, "\n"), nil
+}
+
+func queryRow(target SQLTarget, query string, dest interface{}) error {
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.QueryRow(query).Scan(dest)
+}
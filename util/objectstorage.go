@@ -0,0 +1,85 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+)
+
+// ValidateBackupTarget checks that a backup target is known and, for
+// object storage, that enough of the bucket definition is present
+func ValidateBackupTarget(target string, spec *crv1.PgObjectStorageSpec) error {
+	switch target {
+	case "", crv1.BACKUP_TARGET_PVC:
+		return nil
+	case crv1.BACKUP_TARGET_S3:
+		if spec.Endpoint == "" {
+			return errors.New("object storage endpoint is required for the s3 backup target")
+		}
+		if spec.Bucket == "" {
+			return errors.New("object storage bucket is required for the s3 backup target")
+		}
+		if spec.SecretName == "" {
+			return errors.New("object storage secret name is required for the s3 backup target")
+		}
+		return nil
+	}
+	return errors.New("invalid backup target " + target + ", must be " + crv1.BACKUP_TARGET_PVC + " or " + crv1.BACKUP_TARGET_S3)
+}
+
+// CreateObjectStorageEnv returns the container env entries the
+// backupagent needs to reach an S3 bucket, each entry is prefixed
+// with a comma so the snippet can follow an existing env list
+func CreateObjectStorageEnv(target string, spec *crv1.PgObjectStorageSpec) string {
+	var sc bytes.Buffer
+
+	if target != crv1.BACKUP_TARGET_S3 {
+		return ""
+	}
+
+	writeEnv(&sc, "S3_ENDPOINT", spec.Endpoint)
+	writeEnv(&sc, "S3_BUCKET", spec.Bucket)
+	writeEnv(&sc, "S3_PREFIX", spec.Prefix)
+	writeEnv(&sc, "S3_REGION", spec.Region)
+	writeEnv(&sc, "S3_INSECURE", strconv.FormatBool(spec.Insecure))
+	writeSecretEnv(&sc, "AWS_ACCESS_KEY_ID", spec.SecretName, crv1.S3_ACCESS_KEY_ID)
+	writeSecretEnv(&sc, "AWS_SECRET_ACCESS_KEY", spec.SecretName, crv1.S3_SECRET_ACCESS_KEY)
+
+	return sc.String()
+}
+
+func writeEnv(sc *bytes.Buffer, name, value string) {
+	sc.WriteString(", {\n")
+	sc.WriteString("\t \"name\": " + QuoteJSON(name) + ",\n")
+	sc.WriteString("\t \"value\": " + QuoteJSON(value) + "\n")
+	sc.WriteString("}")
+}
+
+func writeSecretEnv(sc *bytes.Buffer, name, secretName, key string) {
+	sc.WriteString(", {\n")
+	sc.WriteString("\t \"name\": " + QuoteJSON(name) + ",\n")
+	sc.WriteString("\t \"valueFrom\": {\n")
+	sc.WriteString("\t\t \"secretKeyRef\": {\n")
+	sc.WriteString("\t\t\t \"name\": " + QuoteJSON(secretName) + ",\n")
+	sc.WriteString("\t\t\t \"key\": " + QuoteJSON(key) + "\n")
+	sc.WriteString("\t\t }\n")
+	sc.WriteString("\t }\n")
+	sc.WriteString("}")
+}
@@ -0,0 +1,179 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+)
+
+// no password policy can accept passwords shorter than this
+const PASSWORD_LOWEST_MIN_LENGTH = 8
+
+// characters easily mistaken for one another, left out of generated
+// passwords unless the policy allows them
+const ambiguousCharacters = "Il1O0"
+
+// the symbols are limited to those that need no quoting in the JSON
+// templates, environment and connection strings passwords end up in
+var passwordClasses = map[string]string{
+	crv1.PASSWORD_CLASS_LOWER:  "abcdefghijklmnopqrstuvwxyz",
+	crv1.PASSWORD_CLASS_UPPER:  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	crv1.PASSWORD_CLASS_DIGIT:  "0123456789",
+	crv1.PASSWORD_CLASS_SYMBOL: "!#+-.=?@^_~",
+}
+
+var defaultPasswordClasses = []string{crv1.PASSWORD_CLASS_LOWER, crv1.PASSWORD_CLASS_UPPER, crv1.PASSWORD_CLASS_DIGIT}
+
+// GetPasswordPolicy returns a password policy with its unset fields
+// given their default values, policy may be nil
+func GetPasswordPolicy(policy *crv1.PgPasswordPolicySpec) crv1.PgPasswordPolicySpec {
+	p := crv1.PgPasswordPolicySpec{}
+	if policy != nil {
+		p = *policy
+	}
+	if p.MinLength <= 0 {
+		p.MinLength = crv1.PASSWORD_DEFAULT_MIN_LENGTH
+	}
+	if p.Length <= 0 {
+		p.Length = crv1.PASSWORD_DEFAULT_LENGTH
+	}
+	if p.Length < p.MinLength {
+		p.Length = p.MinLength
+	}
+	if len(p.Classes) == 0 {
+		p.Classes = defaultPasswordClasses
+	}
+	return p
+}
+
+// ValidatePasswordPolicy checks a password policy only requires known
+// character classes and does not accept passwords shorter than
+// PASSWORD_LOWEST_MIN_LENGTH
+func ValidatePasswordPolicy(policy *crv1.PgPasswordPolicySpec) error {
+	p := GetPasswordPolicy(policy)
+	if p.MinLength < PASSWORD_LOWEST_MIN_LENGTH {
+		return errors.New("password minimum length " + strconv.Itoa(p.MinLength) + " is below " + strconv.Itoa(PASSWORD_LOWEST_MIN_LENGTH))
+	}
+	seen := make(map[string]bool)
+	for _, c := range p.Classes {
+		if _, ok := passwordClasses[c]; !ok {
+			return errors.New("invalid password character class " + c + ", must be " + crv1.PASSWORD_CLASS_LOWER + ", " +
+				crv1.PASSWORD_CLASS_UPPER + ", " + crv1.PASSWORD_CLASS_DIGIT + " or " + crv1.PASSWORD_CLASS_SYMBOL)
+		}
+		if seen[c] {
+			return errors.New("password character class " + c + " is given more than once")
+		}
+		seen[c] = true
+	}
+	return nil
+}
+
+// GeneratePassword returns a password following a policy drawn from
+// crypto/rand, it holds a character of each class the policy requires,
+// a length of 0 takes the length of the policy and a length below the
+// policy minimum is raised to it, policy may be nil
+func GeneratePassword(policy *crv1.PgPasswordPolicySpec, length int) (string, error) {
+	err := ValidatePasswordPolicy(policy)
+	if err != nil {
+		return "", err
+	}
+	p := GetPasswordPolicy(policy)
+	if length <= 0 {
+		length = p.Length
+	}
+	if length < p.MinLength {
+		length = p.MinLength
+	}
+
+	password := make([]byte, 0, length)
+	all := ""
+	for _, c := range p.Classes {
+		chars := passwordCharacters(c, p.AllowAmbiguous)
+		all = all + chars
+		ch, err := randomCharacter(chars)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, ch)
+	}
+	for len(password) < length {
+		ch, err := randomCharacter(all)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, ch)
+	}
+
+	// the required characters lead, move them to random places
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := randomInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// CheckPassword checks a password given by a user is long enough and
+// holds a character of each class the policy requires, policy may be nil
+func CheckPassword(policy *crv1.PgPasswordPolicySpec, password string) error {
+	p := GetPasswordPolicy(policy)
+	if len(password) < p.MinLength {
+		return errors.New("the password is shorter than " + strconv.Itoa(p.MinLength) + " characters")
+	}
+	for _, c := range p.Classes {
+		if !strings.ContainsAny(password, passwordClasses[c]) {
+			return errors.New("the password holds no " + c + " character")
+		}
+	}
+	return nil
+}
+
+func passwordCharacters(class string, allowAmbiguous bool) string {
+	chars := passwordClasses[class]
+	if allowAmbiguous {
+		return chars
+	}
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(ambiguousCharacters, r) {
+			return -1
+		}
+		return r
+	}, chars)
+}
+
+func randomCharacter(chars string) (byte, error) {
+	i, err := randomInt(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+func randomInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
@@ -21,87 +21,222 @@ import (
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	log "github.com/Sirupsen/logrus"
-	"io/ioutil"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/pkg/api/v1"
 	//"k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
-	"net/http"
+	"strconv"
+	"time"
 )
 
-// execute a sql policy against a cluster
-func ExecPolicy(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string, policyName string, clusterName string) error {
-	//fetch the policy sql
-	sqlString, err := GetPolicySQL(restclient, namespace, policyName)
+// ExecPolicy runs the policy SQL a pgpolicylog spec asks for as the
+// postgres user on the pods of the policy scope and records the
+// outcome, the policy version the cluster reached and its checksum in
+// the spec along with when it ran, for how long and on which pods
+func ExecPolicy(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string, spec *crv1.PgpolicylogSpec) error {
+	start := time.Now()
+	defer func() {
+		spec.Duration = time.Since(start).String()
+	}()
+
+	spec.Status = crv1.POLICY_FAILED_STATUS
+	spec.ApplyDate = start.Format(time.RFC3339)
+	spec.Version = spec.FromVersion
+
+	run, err := GetPolicyRun(restclient, namespace, spec.PolicyName, spec.Action, spec.FromVersion)
 	if err != nil {
+		spec.Result = GetPolicyResult(nil, err)
 		return err
 	}
-	secretName := clusterName + "-pgroot-secret"
-	//get the postgres user password
-	var password string
-	password, err = GetPasswordFromSecret(clientset, namespace, secretName)
+
+	//only a dry run counts the policies run along with it as done
+	var batch []string
+	if spec.DryRun {
+		batch = spec.DryRunBatch
+	}
+	switch spec.Action {
+	case crv1.POLICY_ACTION_REVERT:
+	case crv1.POLICY_ACTION_REMOVE:
+		err = CheckPolicyDependents(restclient, namespace, spec.PolicyName, spec.ClusterName, batch)
+	default:
+		err = CheckPolicyDependencies(restclient, namespace, run.Policy, spec.ClusterName, batch)
+	}
 	if err != nil {
+		spec.Result = GetPolicyResult(nil, err)
 		return err
 	}
-	//get the host ip address
-	var service *v1.Service
-	options := meta_v1.GetOptions{}
-	service, err = clientset.Core().Services(namespace).Get(clusterName, options)
+
+	if len(run.Policy.Spec.Parameters) > 0 {
+		spec.RenderedParameters, err = renderPolicyRun(restclient, namespace, run, spec)
+		if err != nil {
+			spec.Result = GetPolicyResult(nil, err)
+			return err
+		}
+	}
+
+	//statements a standby refuses are rejected before anything runs
+	scope := run.Policy.Spec.Scope
+	if PolicyScopeIncludesReplicas(scope) {
+		for _, step := range run.Steps {
+			err = CheckReadOnlySQL(step.Sql)
+			if err != nil {
+				spec.Result = GetPolicyResult(nil, err)
+				return err
+			}
+		}
+	}
+
+	targets, err := GetPolicyTargets(clientset, namespace, spec.ClusterName, scope)
 	if err != nil {
-		log.Error(err)
+		spec.Result = GetPolicyResult(nil, err)
 		return err
 	}
 
-	//lastly, run the psql script
-	log.Debugf("running psql password=%s ip=%s sql=[%s]\n", password, service.Spec.ClusterIP, sqlString)
-	RunPsql(password, service.Spec.ClusterIP, sqlString)
-	//labels[v] = "pgpolicy"
+	mode := GetPolicyMode(spec)
+	log.Debugf("running policy %s %s from version %d to %d on %s mode %s", spec.PolicyName, spec.Action, run.FromVersion, run.ToVersion, spec.ClusterName, mode)
+	result, reached, err := execPolicyTargets(targets, run, mode, spec)
+	spec.Result = GetPolicyResult(result, err)
+	if reached != run.FromVersion {
+		spec.Version = reached
+		spec.Checksum = run.Checksums[reached]
+	}
+	if err != nil {
+		log.Error("policy " + spec.PolicyName + " failed on " + spec.ClusterName + " " + err.Error())
+		return err
+	}
+	log.Debugf("policy %s ran %d statements on %s, %d rows affected", spec.PolicyName, len(result.Statements), spec.ClusterName, result.RowsAffected)
 
+	spec.Status = crv1.UPGRADE_COMPLETED_STATUS
 	return nil
-
 }
 
-func GetPolicySQL(restclient *rest.RESTClient, namespace, policyName string) (string, error) {
-	p := crv1.Pgpolicy{}
+// renderPolicyRun substitutes the parameter values for the cluster
+// into the SQL of each step and returns the quoted values used
+func renderPolicyRun(restclient *rest.RESTClient, namespace string, run *PolicyRun, spec *crv1.PgpolicylogSpec) (map[string]string, error) {
+	cl := crv1.Pgcluster{}
 	err := restclient.Get().
-		Name(policyName).
+		Resource(crv1.PgclusterResourcePlural).
 		Namespace(namespace).
-		Resource(crv1.PgpolicyResourcePlural).
+		Name(spec.ClusterName).
 		Do().
-		Into(&p)
-	if err == nil {
-		if p.Spec.Url != "" {
-			return readSQLFromURL(p.Spec.Url)
-		} else {
-			return p.Spec.Sql, err
-		}
-	} else if kerrors.IsNotFound(err) {
-		log.Error("getPolicySQL policy not found using " + policyName + " in namespace " + namespace)
-		return "", err
-	} else {
-		log.Error(err)
-		return "", err
+		Into(&cl)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := ResolvePolicyParameters(run.Policy, &cl, spec.Parameters)
+	if err != nil {
+		return nil, err
 	}
+
+	for i := range run.Steps {
+		run.Steps[i].Sql = RenderPolicySQL(run.Steps[i].Sql, rendered)
+	}
+	return rendered, nil
 }
 
-func readSQLFromURL(urlstring string) (string, error) {
-	var bodyBytes []byte
-	response, err := http.Get(urlstring)
+// GetPolicyTarget returns the postgres connection to the service of
+// a cluster that policies are run against
+func GetPolicyTarget(clientset *kubernetes.Clientset, namespace string, clusterName string) (SQLTarget, error) {
+	target := SQLTarget{User: "postgres", Database: "postgres"}
+
+	//get the postgres user password
+	password, err := GetPasswordFromSecret(clientset, namespace, clusterName+"-pgroot-secret")
+	if err != nil {
+		return target, err
+	}
+	target.Password = password
+
+	//get the host ip address
+	var service *v1.Service
+	options := meta_v1.GetOptions{}
+	service, err = clientset.Core().Services(namespace).Get(clusterName, options)
 	if err != nil {
 		log.Error(err)
-		return "", err
-	} else {
-		bodyBytes, err = ioutil.ReadAll(response.Body)
-		if err != nil {
-			log.Error(err)
-			return "", err
+		return target, err
+	}
+	target.Host = service.Spec.ClusterIP
+	target.Port = "5432"
+	if len(service.Spec.Ports) > 0 {
+		target.Port = strconv.Itoa(int(service.Spec.Ports[0].Port))
+	}
+
+	return target, nil
+}
+
+// GetPolicyMode returns the SQL_MODE a pgpolicylog asks for
+func GetPolicyMode(spec *crv1.PgpolicylogSpec) string {
+	if spec.DryRun {
+		return SQL_MODE_DRYRUN
+	}
+	if spec.Atomic {
+		return SQL_MODE_ATOMIC
+	}
+	return SQL_MODE_STATEMENTS
+}
+
+// GetPolicyResult converts the outcome of ExecPolicy into the result
+// recorded on a pgpolicylog
+func GetPolicyResult(result *SQLResult, err error) crv1.PgpolicyResult {
+	pr := crv1.PgpolicyResult{}
+	if result != nil {
+		pr.Statements = len(result.Statements)
+		pr.RowsAffected = result.RowsAffected
+		pr.Notices = result.Notices
+		pr.RolledBack = result.RolledBack
+	}
+	if err != nil {
+		pr.Error = err.Error()
+		if sqlerr, ok := err.(*SQLError); ok {
+			pr.SqlState = sqlerr.Code
+			pr.Position = sqlerr.Position
 		}
+	}
+	return pr
+}
 
-		defer response.Body.Close()
+// GetPolicylogName returns the name of the pgpolicylog recording a
+// policy applied to a cluster
+func GetPolicylogName(policyName, clusterName string, dryRun bool) string {
+	if dryRun {
+		return policyName + clusterName + crv1.POLICYLOG_DRYRUN_SUFFIX
 	}
+	return policyName + clusterName
+}
 
-	return string(bodyBytes), err
+// WaitForPolicylogs waits until the operator has recorded the outcome
+// of each of a set of pgpolicylogs, the outcomes are returned by name
+// and those not recorded before the timeout are left out
+func WaitForPolicylogs(restclient *rest.RESTClient, names []string, namespace string, timeout time.Duration) (map[string]*crv1.Pgpolicylog, error) {
+	results := make(map[string]*crv1.Pgpolicylog)
+	deadline := time.Now().Add(timeout)
+
+	for len(results) < len(names) {
+		for _, name := range names {
+			if results[name] != nil {
+				continue
+			}
+			policylog := crv1.Pgpolicylog{}
+			err := restclient.Get().
+				Resource(crv1.PgpolicylogResourcePlural).
+				Namespace(namespace).
+				Name(name).
+				Do().
+				Into(&policylog)
+			if err != nil {
+				return results, err
+			}
+			if policylog.Spec.Status != "" {
+				results[name] = &policylog
+			}
+		}
+		if len(results) == len(names) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
 
+	return results, nil
 }
 
 func ValidatePolicy(restclient *rest.RESTClient, namespace string, policyName string) error {
@@ -0,0 +1,145 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+// layout of the apply date of pgpolicylogs written before the audit
+// trail used RFC3339
+const POLICYLOG_LEGACY_DATE = "2006-01-02-15:04:05"
+
+// PolicylogFilter selects the runs of policies shown from the audit
+// trail, empty fields and zero times match every run
+type PolicylogFilter struct {
+	Cluster string
+	Policy  string
+	User    string
+	Since   time.Time
+	Until   time.Time
+}
+
+// GetPolicylogEntry returns the run a pgpolicylog spec records
+func GetPolicylogEntry(spec *crv1.PgpolicylogSpec) crv1.PgpolicylogEntry {
+	return crv1.PgpolicylogEntry{
+		Action:             spec.Action,
+		Status:             spec.Status,
+		Username:           spec.Username,
+		RequestedFrom:      spec.RequestedFrom,
+		RequestDate:        spec.RequestDate,
+		ApplyDate:          spec.ApplyDate,
+		Duration:           spec.Duration,
+		TargetPod:          spec.TargetPod,
+		Atomic:             spec.Atomic,
+		FromVersion:        spec.FromVersion,
+		Version:            spec.Version,
+		Checksum:           spec.Checksum,
+		RenderedParameters: spec.RenderedParameters,
+		Result:             spec.Result,
+		Instances:          spec.Instances,
+	}
+}
+
+// GetPolicylogHistory returns the history a pgpolicylog replacing
+// existing carries, the runs existing kept followed by its own run,
+// existing is nil when the policy never ran on the cluster
+func GetPolicylogHistory(existing *crv1.PgpolicylogSpec) []crv1.PgpolicylogEntry {
+	if existing == nil {
+		return nil
+	}
+	history := append([]crv1.PgpolicylogEntry{}, existing.History...)
+	if existing.ApplyDate == "" {
+		return history
+	}
+	return append(history, GetPolicylogEntry(existing))
+}
+
+// GetPolicylogEntries returns every run a pgpolicylog records,
+// oldest first
+func GetPolicylogEntries(spec *crv1.PgpolicylogSpec) []crv1.PgpolicylogEntry {
+	entries := append([]crv1.PgpolicylogEntry{}, spec.History...)
+	return append(entries, GetPolicylogEntry(spec))
+}
+
+// ParsePolicylogDate parses a date given to filter the audit trail
+// or recorded on it, RFC3339, a day as 2006-01-02 and the apply date
+// layout of older pgpolicylogs are accepted
+func ParsePolicylogDate(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02", POLICYLOG_LEGACY_DATE} {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date " + value + ", use 2006-01-02 or RFC3339")
+}
+
+// Match reports whether a run of a policy on a cluster is selected,
+// a run is dated by when it was applied or, if it never ran, by when
+// it was requested
+func (f PolicylogFilter) Match(spec *crv1.PgpolicylogSpec, entry *crv1.PgpolicylogEntry) bool {
+	if f.Cluster != "" && spec.ClusterName != f.Cluster {
+		return false
+	}
+	if f.Policy != "" && spec.PolicyName != f.Policy {
+		return false
+	}
+	if f.User != "" && entry.Username != f.User {
+		return false
+	}
+	if f.Since.IsZero() && f.Until.IsZero() {
+		return true
+	}
+
+	date := entry.ApplyDate
+	if date == "" {
+		date = entry.RequestDate
+	}
+	t, err := ParsePolicylogDate(date)
+	if err != nil {
+		return false
+	}
+	if !f.Since.IsZero() && t.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !t.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// GetPolicyTargetPod returns the name of the running master pod of a
+// cluster, the pod the cluster service sends policy SQL to
+func GetPolicyTargetPod(clientset *kubernetes.Clientset, namespace, clusterName string) string {
+	lo := meta_v1.ListOptions{LabelSelector: "pg-cluster=" + clusterName + ",master"}
+	pods, err := clientset.Core().Pods(namespace).List(lo)
+	if err != nil {
+		return ""
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == v1.PodRunning {
+			return pod.Name
+		}
+	}
+	return ""
+}
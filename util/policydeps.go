@@ -0,0 +1,207 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"strings"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/rest"
+)
+
+// ValidatePolicyDependencies checks that the policies a policy depends
+// on exist and that depending on them does not create a cycle
+func ValidatePolicyDependencies(restclient *rest.RESTClient, namespace, name string, dependsOn []string) error {
+	policyList := crv1.PgpolicyList{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&policyList)
+	if err != nil {
+		return err
+	}
+
+	graph := make(map[string][]string)
+	for _, p := range policyList.Items {
+		graph[p.Spec.Name] = p.Spec.DependsOn
+	}
+	for _, dep := range dependsOn {
+		if _, ok := graph[dep]; !ok {
+			return errors.New("policy " + name + " depends on policy " + dep + " which does not exist")
+		}
+	}
+	graph[name] = dependsOn
+
+	return findCycle(graph, name, []string{})
+}
+
+func findCycle(graph map[string][]string, name string, path []string) error {
+	for _, p := range path {
+		if p == name {
+			return errors.New("policy dependency cycle " + strings.Join(append(path, name), " -> "))
+		}
+	}
+	for _, dep := range graph[name] {
+		err := findCycle(graph, dep, append(path, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortPolicies orders policies so each follows the policies it depends
+// on, policies otherwise keep the order they were given in
+func SortPolicies(policies []string, catalog map[string]*crv1.Pgpolicy) ([]string, error) {
+	sorted := []string{}
+	placed := make(map[string]bool)
+	requested := make(map[string]bool)
+	for _, p := range policies {
+		requested[p] = true
+	}
+
+	for len(sorted) < len(policies) {
+		progress := false
+		for _, p := range policies {
+			if placed[p] || !dependenciesPlaced(catalog[p], requested, placed) {
+				continue
+			}
+			sorted = append(sorted, p)
+			placed[p] = true
+			progress = true
+		}
+		if !progress {
+			return sorted, errors.New("policy dependency cycle among " + strings.Join(policies, ","))
+		}
+	}
+
+	return sorted, nil
+}
+
+// ReversePolicies returns policies in reverse order, policies sorted
+// to be applied are removed in the reverse order so a policy is
+// removed before the policies it depends on
+func ReversePolicies(policies []string) []string {
+	reversed := make([]string, 0, len(policies))
+	for i := len(policies) - 1; i >= 0; i-- {
+		reversed = append(reversed, policies[i])
+	}
+	return reversed
+}
+
+// dependenciesPlaced reports whether every requested dependency of a
+// policy is already ordered, dependencies not requested are expected
+// to be applied already
+func dependenciesPlaced(policy *crv1.Pgpolicy, requested, placed map[string]bool) bool {
+	if policy == nil {
+		return true
+	}
+	for _, dep := range policy.Spec.DependsOn {
+		if requested[dep] && !placed[dep] {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckPolicyDependencies checks that each policy a policy depends on
+// is applied to the cluster, a dependency whose pgpolicylog records a
+// failure that left it unapplied stops its dependents from running,
+// the policies of batch count as applied
+func CheckPolicyDependencies(restclient *rest.RESTClient, namespace string, policy *crv1.Pgpolicy, clusterName string, batch []string) error {
+	for _, dep := range policy.Spec.DependsOn {
+		if containsPolicy(batch, dep) {
+			continue
+		}
+		policylog := crv1.Pgpolicylog{}
+		err := restclient.Get().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(namespace).
+			Name(GetPolicylogName(dep, clusterName, false)).
+			Do().
+			Into(&policylog)
+		if kerrors.IsNotFound(err) {
+			return errors.New("policy " + policy.Spec.Name + " skipped, it depends on policy " + dep + " which is not applied to " + clusterName)
+		} else if err != nil {
+			return err
+		}
+		if policylog.Spec.Action == crv1.POLICY_ACTION_REMOVE && policylog.Spec.Status == crv1.UPGRADE_COMPLETED_STATUS {
+			return errors.New("policy " + policy.Spec.Name + " skipped, it depends on policy " + dep + " which was removed from " + clusterName)
+		}
+		if AppliedPolicyVersion(&policylog.Spec) == 0 {
+			return errors.New("policy " + policy.Spec.Name + " skipped, it depends on policy " + dep + " which failed on " + clusterName)
+		}
+	}
+	return nil
+}
+
+// CheckPolicyDependents checks that no policy applied to a cluster
+// depends on a policy that is to be removed from it, the policies of
+// batch count as removed
+func CheckPolicyDependents(restclient *rest.RESTClient, namespace string, policyName string, clusterName string, batch []string) error {
+	policyList := crv1.PgpolicyList{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(namespace).
+		Do().
+		Into(&policyList)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range policyList.Items {
+		if !policyDependsOn(&p, policyName) || containsPolicy(batch, p.Spec.Name) {
+			continue
+		}
+		policylog := crv1.Pgpolicylog{}
+		err = restclient.Get().
+			Resource(crv1.PgpolicylogResourcePlural).
+			Namespace(namespace).
+			Name(GetPolicylogName(p.Spec.Name, clusterName, false)).
+			Do().
+			Into(&policylog)
+		if kerrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if AppliedPolicyVersion(&policylog.Spec) > 0 {
+			return errors.New("policy " + policyName + " can not be removed from " + clusterName + ", policy " + p.Spec.Name + " depends on it, remove " + p.Spec.Name + " first")
+		}
+	}
+	return nil
+}
+
+func policyDependsOn(policy *crv1.Pgpolicy, name string) bool {
+	for _, dep := range policy.Spec.DependsOn {
+		if dep == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPolicy(list []string, name string) bool {
+	for _, p := range list {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
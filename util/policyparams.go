@@ -0,0 +1,280 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+)
+
+// prefixes of the sources a policy parameter can take its value from
+const POLICY_PARAM_FROM_LABEL = "label:"
+const POLICY_PARAM_FROM_CLUSTER = "cluster:"
+
+// a number parameter is written into the SQL as given, so it must be a
+// plain decimal number, ParseFloat would also take Inf, NaN, hex and
+// underscores
+var policyParamNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// the pgcluster fields a policy parameter can take its value from
+var policyParamFields = map[string]func(*crv1.PgclusterSpec) string{
+	"Name":           func(s *crv1.PgclusterSpec) string { return s.Name },
+	"ClusterName":    func(s *crv1.PgclusterSpec) string { return s.ClusterName },
+	"Port":           func(s *crv1.PgclusterSpec) string { return s.Port },
+	"PG_DATABASE":    func(s *crv1.PgclusterSpec) string { return s.PG_DATABASE },
+	"PG_USER":        func(s *crv1.PgclusterSpec) string { return s.PG_USER },
+	"PG_MASTER_USER": func(s *crv1.PgclusterSpec) string { return s.PG_MASTER_USER },
+	"CCP_IMAGE_TAG":  func(s *crv1.PgclusterSpec) string { return s.CCP_IMAGE_TAG },
+}
+
+// ParsePolicyParameter parses a parameter declaration written as
+// name=owner,kind=identifier,from=cluster:PG_USER,default=app, the
+// default comes last and may itself hold commas
+func ParsePolicyParameter(declaration string) (crv1.PgpolicyParameter, error) {
+	param := crv1.PgpolicyParameter{Kind: crv1.POLICY_PARAM_IDENTIFIER}
+
+	rest := declaration
+	for rest != "" {
+		field := rest
+		if strings.HasPrefix(rest, "default=") {
+			rest = ""
+		} else if i := strings.Index(rest, ","); i >= 0 {
+			field = rest[:i]
+			rest = rest[i+1:]
+		} else {
+			rest = ""
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return param, errors.New("invalid policy parameter " + declaration + ", fields are written as key=value")
+		}
+		switch kv[0] {
+		case "name":
+			param.Name = kv[1]
+		case "kind":
+			param.Kind = kv[1]
+		case "from":
+			param.From = kv[1]
+		case "default":
+			param.Default = kv[1]
+		default:
+			return param, errors.New("invalid policy parameter field " + kv[0] + ", must be name, kind, from or default")
+		}
+	}
+
+	return param, validatePolicyParameter(param)
+}
+
+// ValidatePolicyParameters checks the parameters declared by a policy
+func ValidatePolicyParameters(params []crv1.PgpolicyParameter) error {
+	names := make(map[string]bool)
+	for _, p := range params {
+		err := validatePolicyParameter(p)
+		if err != nil {
+			return err
+		}
+		if names[p.Name] {
+			return errors.New("policy parameter " + p.Name + " is declared more than once")
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+func validatePolicyParameter(p crv1.PgpolicyParameter) error {
+	if p.Name == "" {
+		return errors.New("a policy parameter needs a name")
+	}
+	for i, c := range []byte(p.Name) {
+		if !isIdentChar(c) || (i == 0 && c >= '0' && c <= '9') {
+			return errors.New("invalid policy parameter name " + p.Name + ", use letters, digits and underscores")
+		}
+	}
+
+	switch p.Kind {
+	case crv1.POLICY_PARAM_IDENTIFIER, crv1.POLICY_PARAM_LITERAL, crv1.POLICY_PARAM_NUMBER:
+	default:
+		return errors.New("invalid kind " + p.Kind + " for policy parameter " + p.Name + ", must be " +
+			crv1.POLICY_PARAM_IDENTIFIER + ", " + crv1.POLICY_PARAM_LITERAL + " or " + crv1.POLICY_PARAM_NUMBER)
+	}
+
+	if strings.HasPrefix(p.From, POLICY_PARAM_FROM_CLUSTER) {
+		field := strings.TrimPrefix(p.From, POLICY_PARAM_FROM_CLUSTER)
+		if _, ok := policyParamFields[field]; !ok {
+			return errors.New("policy parameter " + p.Name + " can not be taken from cluster field " + field)
+		}
+	} else if p.From != "" && !strings.HasPrefix(p.From, POLICY_PARAM_FROM_LABEL) {
+		return errors.New("invalid source " + p.From + " for policy parameter " + p.Name + ", must be label:<key> or cluster:<field>")
+	}
+
+	if p.Default != "" {
+		_, err := quoteParameter(p.Kind, p.Default)
+		if err != nil {
+			return errors.New("invalid default for policy parameter " + p.Name + " " + err.Error())
+		}
+	}
+	return nil
+}
+
+// ParsePolicyParams parses the key=value parameter values given when
+// a policy is applied
+func ParsePolicyParams(values []string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return params, errors.New("invalid policy parameter value " + v + ", must be key=value")
+		}
+		params[kv[0]] = kv[1]
+	}
+	return params, nil
+}
+
+// ResolvePolicyParameters returns the quoted value of each parameter of
+// a policy for a cluster, a value given when applying the policy comes
+// first, then the parameter source and last the parameter default
+func ResolvePolicyParameters(policy *crv1.Pgpolicy, cl *crv1.Pgcluster, supplied map[string]string) (map[string]string, error) {
+	rendered := make(map[string]string)
+
+	declared := make(map[string]bool)
+	for _, p := range policy.Spec.Parameters {
+		declared[p.Name] = true
+	}
+	for name := range supplied {
+		if !declared[name] {
+			return rendered, errors.New("policy " + policy.Spec.Name + " has no parameter " + name)
+		}
+	}
+
+	for _, p := range policy.Spec.Parameters {
+		value, ok := supplied[p.Name]
+		if !ok {
+			value, ok = parameterFromCluster(p, cl)
+		}
+		if !ok && p.Default != "" {
+			value, ok = p.Default, true
+		}
+		if !ok {
+			return rendered, errors.New("policy parameter " + p.Name + " has no value for cluster " + cl.Spec.Name)
+		}
+
+		quoted, err := quoteParameter(p.Kind, value)
+		if err != nil {
+			return rendered, errors.New("policy parameter " + p.Name + " " + err.Error())
+		}
+		rendered[p.Name] = quoted
+	}
+
+	return rendered, nil
+}
+
+func parameterFromCluster(p crv1.PgpolicyParameter, cl *crv1.Pgcluster) (string, bool) {
+	if strings.HasPrefix(p.From, POLICY_PARAM_FROM_LABEL) {
+		key := strings.TrimPrefix(p.From, POLICY_PARAM_FROM_LABEL)
+		if value, ok := cl.Spec.UserLabels[key]; ok {
+			return value, true
+		}
+		value, ok := cl.ObjectMeta.Labels[key]
+		return value, ok
+	}
+	if strings.HasPrefix(p.From, POLICY_PARAM_FROM_CLUSTER) {
+		field, ok := policyParamFields[strings.TrimPrefix(p.From, POLICY_PARAM_FROM_CLUSTER)]
+		if ok && field(&cl.Spec) != "" {
+			return field(&cl.Spec), true
+		}
+	}
+	return "", false
+}
+
+func quoteParameter(kind, value string) (string, error) {
+	switch kind {
+	case crv1.POLICY_PARAM_LITERAL:
+		return sqlutil.QuoteLiteral(value)
+	case crv1.POLICY_PARAM_NUMBER:
+		if !policyParamNumber.MatchString(value) {
+			return "", errors.New("value " + value + " is not a number")
+		}
+		return value, nil
+	}
+	return sqlutil.QuoteIdentifier(value)
+}
+
+// RenderPolicySQL substitutes the quoted parameter values for the
+// {{.name}} placeholders of the parameters in the SQL of a policy, the
+// rest of the SQL is left as written so braces in array literals or
+// strings are not taken for placeholders
+func RenderPolicySQL(sql string, rendered map[string]string) string {
+	if len(rendered) == 0 {
+		return sql
+	}
+
+	pairs := []string{}
+	for name, value := range rendered {
+		pairs = append(pairs, "{{."+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(sql)
+}
+
+// FormatPolicyParams writes parameter values as sorted key=value pairs
+func FormatPolicyParams(params map[string]string) string {
+	pairs := []string{}
+	for k, v := range params {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// GetPolicylogParams returns the parameter values a pgpolicylog passes
+// to a policy, the values given now that the policy declares override
+// those recorded when the policy was last applied to the cluster
+func GetPolicylogParams(policy *crv1.Pgpolicy, applied *crv1.PgpolicylogSpec, params map[string]string) map[string]string {
+	values := make(map[string]string)
+	if applied != nil {
+		for k, v := range applied.Parameters {
+			values[k] = v
+		}
+	}
+	for _, p := range policy.Spec.Parameters {
+		if v, ok := params[p.Name]; ok {
+			values[p.Name] = v
+		}
+	}
+	return values
+}
+
+// CheckPolicyParams checks that each parameter value given is declared
+// by at least one of the policies being applied
+func CheckPolicyParams(catalog map[string]*crv1.Pgpolicy, params map[string]string) error {
+	for name := range params {
+		declared := false
+		for _, policy := range catalog {
+			for _, p := range policy.Spec.Parameters {
+				declared = declared || p.Name == name
+			}
+		}
+		if !declared {
+			return errors.New("no policy being applied has a parameter " + name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+	"k8s.io/client-go/rest"
+)
+
+// PolicyTarget is a database pod a policy runs on
+type PolicyTarget struct {
+	Pod  string
+	Role string
+	SQL  SQLTarget
+}
+
+// ValidatePolicyScope checks that a policy scope is known
+func ValidatePolicyScope(scope string) error {
+	switch scope {
+	case "", crv1.POLICY_SCOPE_MASTER, crv1.POLICY_SCOPE_REPLICAS, crv1.POLICY_SCOPE_ALL:
+		return nil
+	}
+	return errors.New("invalid policy scope " + scope + ", must be " + crv1.POLICY_SCOPE_MASTER + ", " + crv1.POLICY_SCOPE_REPLICAS + " or " + crv1.POLICY_SCOPE_ALL)
+}
+
+// PolicyScopeIncludesReplicas reports whether a policy runs on replicas
+func PolicyScopeIncludesReplicas(scope string) bool {
+	return scope == crv1.POLICY_SCOPE_REPLICAS || scope == crv1.POLICY_SCOPE_ALL
+}
+
+// CheckPolicyScope refuses a policy run on replicas whose SQL, down
+// SQL or removal SQL of any version holds a statement a hot standby
+// does not allow
+func CheckPolicyScope(policy *crv1.Pgpolicy) error {
+	err := ValidatePolicyScope(policy.Spec.Scope)
+	if err != nil || !PolicyScopeIncludesReplicas(policy.Spec.Scope) {
+		return err
+	}
+	for _, v := range GetPolicyVersions(policy) {
+		for _, sql := range []string{v.Sql, v.DownSql, v.RemoveSql} {
+			err = CheckReadOnlySQL(sql)
+			if err != nil {
+				return errors.New("policy " + policy.Spec.Name + " version " + strconv.Itoa(v.Version) + " can not run on replicas, " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// GetPolicyTargets returns the database pods of a cluster a policy
+// with the given scope runs on, the master is reached through the
+// cluster service and each running replica through its pod address
+func GetPolicyTargets(clientset *kubernetes.Clientset, namespace, clusterName, scope string) ([]PolicyTarget, error) {
+	master, err := GetPolicyTarget(clientset, namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []PolicyTarget{}
+	if !PolicyScopeIncludesReplicas(scope) || scope == crv1.POLICY_SCOPE_ALL {
+		targets = append(targets, PolicyTarget{
+			Pod:  GetPolicyTargetPod(clientset, namespace, clusterName),
+			Role: crv1.POLICY_ROLE_MASTER,
+			SQL:  master,
+		})
+	}
+	if !PolicyScopeIncludesReplicas(scope) {
+		return targets, nil
+	}
+
+	port, err := getDatabasePort(clientset, namespace, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	lo := meta_v1.ListOptions{LabelSelector: "pg-cluster=" + clusterName + ",replica"}
+	pods, err := clientset.Core().Pods(namespace).List(lo)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" {
+			continue
+		}
+		targets = append(targets, getReplicaTarget(master, port, pod))
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("cluster " + clusterName + " has no running replicas")
+	}
+	return targets, nil
+}
+
+func getReplicaTarget(master SQLTarget, port string, pod *v1.Pod) PolicyTarget {
+	target := master
+	target.Host = pod.Status.PodIP
+	target.Port = port
+	return PolicyTarget{Pod: pod.Name, Role: crv1.POLICY_ROLE_REPLICA, SQL: target}
+}
+
+// getDatabasePort returns the port postgres listens on in the pods of
+// a cluster, the target port of the cluster service
+func getDatabasePort(clientset *kubernetes.Clientset, namespace, clusterName string) (string, error) {
+	service, err := clientset.Core().Services(namespace).Get(clusterName, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if len(service.Spec.Ports) == 0 {
+		return "5432", nil
+	}
+	if port := service.Spec.Ports[0].TargetPort.IntValue(); port > 0 {
+		return strconv.Itoa(port), nil
+	}
+	return strconv.Itoa(int(service.Spec.Ports[0].Port)), nil
+}
+
+// execPolicyTargets runs a policy run on each target and records the
+// outcome on each pod in the spec, the cluster is at the version the
+// first failing pod reached or, when every pod succeeded, the version
+// the run moves to
+func execPolicyTargets(targets []PolicyTarget, run *PolicyRun, mode string, spec *crv1.PgpolicylogSpec) (*SQLResult, int, error) {
+	total := &SQLResult{}
+	reached := run.ToVersion
+	if mode == SQL_MODE_DRYRUN {
+		reached = run.FromVersion
+	}
+	var failure error
+	pods := []string{}
+	spec.Instances = nil
+
+	for _, t := range targets {
+		log.Debugf("running policy %s on %s pod %s", spec.PolicyName, t.Role, t.Pod)
+		result, r, err := ExecPolicyRun(t.SQL, run, mode)
+		spec.Instances = append(spec.Instances, getPolicyInstance(t, result, err))
+		pods = append(pods, t.Pod)
+		if result != nil {
+			total.Statements = append(total.Statements, result.Statements...)
+			total.RowsAffected += result.RowsAffected
+			total.Notices = append(total.Notices, result.Notices...)
+			total.RolledBack = total.RolledBack || result.RolledBack
+		}
+		if err != nil && failure == nil {
+			failure = err
+			reached = r
+			if result != nil {
+				total.Error = result.Error
+			}
+		}
+	}
+
+	spec.TargetPod = strings.Join(pods, ",")
+	return total, reached, failure
+}
+
+func getPolicyInstance(t PolicyTarget, result *SQLResult, err error) crv1.PgpolicyInstance {
+	instance := crv1.PgpolicyInstance{
+		Pod:       t.Pod,
+		Role:      t.Role,
+		Status:    crv1.UPGRADE_COMPLETED_STATUS,
+		ApplyDate: time.Now().Format(time.RFC3339),
+		Result:    GetPolicyResult(result, err),
+	}
+	if err != nil {
+		instance.Status = crv1.POLICY_FAILED_STATUS
+	}
+	return instance
+}
+
+// ExecPolicyOnReplica runs the policy a pgpolicylog records as applied
+// on a replica pod that started after the policy was applied, the SQL
+// of each version up to the applied version runs with the parameter
+// values the policy was applied with and the outcome is recorded with
+// the other pods of the spec
+func ExecPolicyOnReplica(clientset *kubernetes.Clientset, restclient *rest.RESTClient, namespace string, spec *crv1.PgpolicylogSpec, pod *v1.Pod) error {
+	version := AppliedPolicyVersion(spec)
+	run, err := GetPolicyRun(restclient, namespace, spec.PolicyName, crv1.POLICY_ACTION_APPLY, 0)
+	if err != nil {
+		return err
+	}
+	if !PolicyScopeIncludesReplicas(run.Policy.Spec.Scope) {
+		return nil
+	}
+
+	steps := []PolicyStep{}
+	for _, step := range run.Steps {
+		if step.Version > version {
+			break
+		}
+		step.Sql = RenderPolicySQL(step.Sql, spec.RenderedParameters)
+		err = CheckReadOnlySQL(step.Sql)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, step)
+		run.ToVersion = step.Version
+	}
+	run.Steps = steps
+
+	master, err := GetPolicyTarget(clientset, namespace, spec.ClusterName)
+	if err != nil {
+		return err
+	}
+	port, err := getDatabasePort(clientset, namespace, spec.ClusterName)
+	if err != nil {
+		return err
+	}
+	target := getReplicaTarget(master, port, pod)
+
+	mode := SQL_MODE_STATEMENTS
+	if spec.Atomic {
+		mode = SQL_MODE_ATOMIC
+	}
+	result, _, err := ExecPolicyRun(target.SQL, run, mode)
+
+	instance := getPolicyInstance(target, result, err)
+	for i := range spec.Instances {
+		if spec.Instances[i].Pod == pod.Name {
+			spec.Instances[i] = instance
+			return err
+		}
+	}
+	spec.Instances = append(spec.Instances, instance)
+	return err
+}
@@ -0,0 +1,217 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// how long fetching the SQL of a policy url may take
+const POLICY_FETCH_TIMEOUT = 30 * time.Second
+
+// the largest policy SQL that is fetched
+const POLICY_FETCH_MAX_SIZE = 4 * 1024 * 1024
+
+// policy url schemes besides http and https, a configmap url is
+// written as configmap://name/key and is read from the namespace
+// of the policy
+const POLICY_SOURCE_FILE = "file"
+const POLICY_SOURCE_CONFIGMAP = "configmap"
+
+// the content types a policy url may return, a url returning an html
+// error page or anything else is refused
+var policyContentTypes = []string{
+	"text/plain",
+	"text/x-sql",
+	"application/sql",
+	"application/x-sql",
+	"application/octet-stream",
+}
+
+// FetchPolicySource fetches the SQL of a policy defined by a url and
+// stores it in the spec with the date it was fetched, the stored SQL
+// is what every later apply runs and what the checksum is taken of
+func FetchPolicySource(clientset *kubernetes.Clientset, namespace string, spec *crv1.PgpolicySpec) error {
+	if spec.Url == "" {
+		return nil
+	}
+	if spec.Sql != "" {
+		return errors.New("policy " + spec.Name + " has both a url and SQL, use one or the other")
+	}
+
+	sql, err := FetchPolicySQL(clientset, namespace, spec.Url, spec.UrlSecret)
+	if err != nil {
+		return err
+	}
+	spec.Sql = sql
+	spec.FetchDate = time.Now().Format(time.RFC3339)
+	return nil
+}
+
+// FetchPolicySQL reads the SQL a policy url points to, http and https
+// urls may be authenticated with the keys of secretName
+func FetchPolicySQL(clientset *kubernetes.Clientset, namespace, source, secretName string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", errors.New("invalid policy url " + source + " " + err.Error())
+	}
+
+	var sql string
+	switch u.Scheme {
+	case "http", "https":
+		sql, err = fetchHTTP(clientset, namespace, source, secretName)
+	case POLICY_SOURCE_FILE:
+		sql, err = fetchFile(u.Path)
+	case POLICY_SOURCE_CONFIGMAP:
+		sql, err = fetchConfigMap(clientset, namespace, u.Host, strings.TrimPrefix(u.Path, "/"))
+	default:
+		return "", errors.New("invalid policy url " + source + ", the scheme must be http, https, file or configmap")
+	}
+	if err != nil {
+		log.Error("error fetching policy url " + source + " " + err.Error())
+		return "", err
+	}
+	if strings.TrimSpace(sql) == "" {
+		return "", errors.New("policy url " + source + " returned no SQL")
+	}
+
+	log.Debug("fetched policy url " + source)
+	return sql, nil
+}
+
+func fetchHTTP(clientset *kubernetes.Clientset, namespace, source, secretName string) (string, error) {
+	req, err := http.NewRequest("GET", source, nil)
+	if err != nil {
+		return "", err
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if secretName != "" {
+		secret, err := clientset.Core().Secrets(namespace).Get(secretName, meta_v1.GetOptions{})
+		if err != nil {
+			return "", errors.New("could not read policy url secret " + secretName + " " + err.Error())
+		}
+		for key, value := range secret.Data {
+			switch {
+			case key == crv1.POLICY_URL_AUTHORIZATION:
+				req.Header.Set("Authorization", string(value))
+			case strings.HasPrefix(key, crv1.POLICY_URL_HEADER_PREFIX):
+				req.Header.Set(strings.TrimPrefix(key, crv1.POLICY_URL_HEADER_PREFIX), string(value))
+			}
+		}
+		if username, ok := secret.Data[crv1.POLICY_URL_USERNAME]; ok {
+			req.SetBasicAuth(string(username), string(secret.Data[crv1.POLICY_URL_PASSWORD]))
+		}
+		if ca, ok := secret.Data[crv1.POLICY_URL_CA]; ok {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return "", errors.New("policy url secret " + secretName + " " + crv1.POLICY_URL_CA + " holds no PEM certificates")
+			}
+			transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		}
+	}
+
+	client := &http.Client{Transport: transport, Timeout: POLICY_FETCH_TIMEOUT}
+	response, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("policy url %s returned %s", source, response.Status)
+	}
+	err = checkContentType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return "", errors.New("policy url " + source + " " + err.Error())
+	}
+
+	return readPolicySQL(response.Body)
+}
+
+func checkContentType(contentType string) error {
+	if contentType == "" {
+		return errors.New("returned no content type")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return errors.New("returned an invalid content type " + contentType)
+	}
+	for _, t := range policyContentTypes {
+		if mediaType == t {
+			return nil
+		}
+	}
+	return errors.New("returned content type " + mediaType + ", expected one of " + strings.Join(policyContentTypes, ", "))
+}
+
+func fetchFile(path string) (string, error) {
+	if path == "" {
+		return "", errors.New("a file url needs an absolute path, for example file:///tmp/policy.sql")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return readPolicySQL(f)
+}
+
+func fetchConfigMap(clientset *kubernetes.Clientset, namespace, name, key string) (string, error) {
+	if name == "" || key == "" {
+		return "", errors.New("a configmap url is written as configmap://name/key")
+	}
+	cm, err := clientset.Core().ConfigMaps(namespace).Get(name, meta_v1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	sql, ok := cm.Data[key]
+	if !ok {
+		return "", errors.New("configmap " + name + " has no key " + key)
+	}
+	if len(sql) > POLICY_FETCH_MAX_SIZE {
+		return "", fmt.Errorf("policy SQL is larger than %d bytes", POLICY_FETCH_MAX_SIZE)
+	}
+	return sql, nil
+}
+
+// readPolicySQL reads at most POLICY_FETCH_MAX_SIZE bytes of SQL
+func readPolicySQL(r io.Reader) (string, error) {
+	buf, err := ioutil.ReadAll(io.LimitReader(r, POLICY_FETCH_MAX_SIZE+1))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) > POLICY_FETCH_MAX_SIZE {
+		return "", fmt.Errorf("policy SQL is larger than %d bytes", POLICY_FETCH_MAX_SIZE)
+	}
+	return string(buf), nil
+}
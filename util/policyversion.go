@@ -0,0 +1,336 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// PolicyStep is the SQL that moves a cluster to Version of a policy
+type PolicyStep struct {
+	Version int
+	Sql     string
+}
+
+// PolicyRun is the SQL that moves a cluster from one version of a
+// policy to another, Checksums holds the checksum of every version
+type PolicyRun struct {
+	Policy      *crv1.Pgpolicy
+	PolicyName  string
+	FromVersion int
+	ToVersion   int
+	Steps       []PolicyStep
+	Checksums   map[int]string
+}
+
+// PolicyChecksum returns the checksum recorded for the SQL of a policy
+// version
+func PolicyChecksum(sql string) string {
+	sum := sha256.Sum256([]byte(sql))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetPolicyVersions returns every version of a policy oldest first,
+// a policy created before policies were versioned is version 1
+func GetPolicyVersions(policy *crv1.Pgpolicy) []crv1.PgpolicyVersion {
+	versions := append([]crv1.PgpolicyVersion{}, policy.Spec.PreviousVersions...)
+	current := crv1.PgpolicyVersion{
+		Version:   policy.Spec.Version,
+		Url:       policy.Spec.Url,
+		FetchDate: policy.Spec.FetchDate,
+		Sql:       policy.Spec.Sql,
+		Checksum:  policy.Spec.Checksum,
+		DownSql:   policy.Spec.DownSql,
+		RemoveSql: policy.Spec.RemoveSql,
+		VerifySql: policy.Spec.VerifySql,
+	}
+	if current.Version == 0 {
+		current.Version = 1
+	}
+	return append(versions, current)
+}
+
+// GetPolicyVersion returns the named version of a policy
+func GetPolicyVersion(policy *crv1.Pgpolicy, version int) (crv1.PgpolicyVersion, bool) {
+	for _, v := range GetPolicyVersions(policy) {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return crv1.PgpolicyVersion{}, false
+}
+
+// AppliedPolicyVersion returns the policy version a pgpolicylog
+// records for its cluster, pgpolicylogs written before policies were
+// versioned record a completed apply of version 1
+func AppliedPolicyVersion(spec *crv1.PgpolicylogSpec) int {
+	if spec.Action == "" && spec.Version == 0 && spec.Status == crv1.UPGRADE_COMPLETED_STATUS {
+		return 1
+	}
+	return spec.Version
+}
+
+// NewPolicyVersion replaces the SQL of a policy with the SQL of spec
+// as a new version and keeps the version it replaces so clusters can
+// be upgraded from it, the SQL of a url policy must already be fetched
+func NewPolicyVersion(policy *crv1.Pgpolicy, spec *crv1.PgpolicySpec) error {
+	checksum := PolicyChecksum(spec.Sql)
+
+	versions := GetPolicyVersions(policy)
+	current := versions[len(versions)-1]
+	if checksum == current.Checksum && spec.DownSql == current.DownSql && spec.RemoveSql == current.RemoveSql && spec.VerifySql == current.VerifySql {
+		return errors.New("policy " + policy.Spec.Name + " version " + fmt.Sprint(current.Version) + " already has this SQL")
+	}
+
+	policy.Spec.PreviousVersions = versions
+	policy.Spec.Version = current.Version + 1
+	policy.Spec.Url = spec.Url
+	policy.Spec.UrlSecret = spec.UrlSecret
+	policy.Spec.FetchDate = spec.FetchDate
+	policy.Spec.Sql = spec.Sql
+	policy.Spec.DownSql = spec.DownSql
+	policy.Spec.RemoveSql = spec.RemoveSql
+	policy.Spec.VerifySql = spec.VerifySql
+	policy.Spec.Checksum = checksum
+	return nil
+}
+
+// RefreshPolicy fetches the url of a policy again and, if the SQL it
+// returns has changed, adds it as a new version keeping the down,
+// removal and verify SQL of the current version
+func RefreshPolicy(clientset *kubernetes.Clientset, namespace string, policy *crv1.Pgpolicy) error {
+	if policy.Spec.Url == "" {
+		return errors.New("policy " + policy.Spec.Name + " was not created from a url and cannot be refreshed")
+	}
+
+	spec := crv1.PgpolicySpec{}
+	spec.Name = policy.Spec.Name
+	spec.Url = policy.Spec.Url
+	spec.UrlSecret = policy.Spec.UrlSecret
+	spec.DownSql = policy.Spec.DownSql
+	spec.RemoveSql = policy.Spec.RemoveSql
+	spec.VerifySql = policy.Spec.VerifySql
+	err := FetchPolicySource(clientset, namespace, &spec)
+	if err != nil {
+		return err
+	}
+
+	err = NewPolicyVersion(policy, &spec)
+	if err != nil {
+		return err
+	}
+	return CheckPolicyScope(policy)
+}
+
+// SetPolicyChecksum sets the version and checksum of a new policy
+func SetPolicyChecksum(spec *crv1.PgpolicySpec) {
+	spec.Version = 1
+	spec.Checksum = PolicyChecksum(spec.Sql)
+}
+
+// GetPolicyRun returns the SQL a pgpolicylog action needs, apply runs
+// every version in order, upgrade runs the versions after fromVersion,
+// revert runs the down SQL of fromVersion and remove runs the removal
+// SQL of fromVersion, if it has any, the SQL of each version
+// must still match the checksum recorded when the version was created
+func GetPolicyRun(restclient *rest.RESTClient, namespace, policyName, action string, fromVersion int) (*PolicyRun, error) {
+	policy := crv1.Pgpolicy{}
+	err := restclient.Get().
+		Resource(crv1.PgpolicyResourcePlural).
+		Namespace(namespace).
+		Name(policyName).
+		Do().
+		Into(&policy)
+	if kerrors.IsNotFound(err) {
+		log.Error("GetPolicyRun policy not found using " + policyName + " in namespace " + namespace)
+		return nil, err
+	} else if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	versions := GetPolicyVersions(&policy)
+	run := &PolicyRun{Policy: &policy, PolicyName: policyName, FromVersion: fromVersion}
+	run.Checksums = make(map[int]string)
+	for _, v := range versions {
+		run.Checksums[v.Version] = v.Checksum
+	}
+
+	switch action {
+	case "", crv1.POLICY_ACTION_APPLY, crv1.POLICY_ACTION_UPGRADE:
+		if action != crv1.POLICY_ACTION_UPGRADE {
+			run.FromVersion = 0
+		}
+		for _, v := range versions {
+			if v.Version <= run.FromVersion {
+				continue
+			}
+			sql, err := getVersionSQL(policyName, v)
+			if err != nil {
+				return nil, err
+			}
+			run.Steps = append(run.Steps, PolicyStep{Version: v.Version, Sql: sql})
+			run.ToVersion = v.Version
+		}
+		if len(run.Steps) == 0 {
+			return nil, fmt.Errorf("policy %s has no version after %d to upgrade to", policyName, run.FromVersion)
+		}
+	case crv1.POLICY_ACTION_REVERT:
+		for _, v := range versions {
+			if v.Version != fromVersion {
+				continue
+			}
+			if v.DownSql == "" {
+				return nil, fmt.Errorf("policy %s version %d has no down SQL to revert with", policyName, v.Version)
+			}
+			if fromVersion <= 1 {
+				return nil, fmt.Errorf("policy %s version %d is the first version and cannot be reverted", policyName, v.Version)
+			}
+			run.ToVersion = fromVersion - 1
+			run.Steps = append(run.Steps, PolicyStep{Version: run.ToVersion, Sql: v.DownSql})
+		}
+		if len(run.Steps) == 0 {
+			return nil, fmt.Errorf("policy %s has no version %d to revert", policyName, fromVersion)
+		}
+	case crv1.POLICY_ACTION_REMOVE:
+		v, ok := GetPolicyVersion(&policy, fromVersion)
+		if !ok {
+			return nil, fmt.Errorf("policy %s has no version %d to remove", policyName, fromVersion)
+		}
+		run.ToVersion = 0
+		if v.RemoveSql != "" {
+			run.Steps = append(run.Steps, PolicyStep{Version: 0, Sql: v.RemoveSql})
+		}
+	default:
+		return nil, errors.New("invalid policy action " + action)
+	}
+
+	return run, nil
+}
+
+// getVersionSQL returns the stored SQL of a policy version, refusing
+// SQL that no longer matches the recorded checksum, a url is never
+// fetched here so a policy runs the SQL that was reviewed when its
+// version was created
+func getVersionSQL(policyName string, v crv1.PgpolicyVersion) (string, error) {
+	sql := v.Sql
+	if sql == "" && v.Url != "" {
+		return "", fmt.Errorf("policy %s version %d has no stored SQL for %s, run pgo create policy %s --refresh to fetch it", policyName, v.Version, v.Url, policyName)
+	}
+	if v.Checksum != "" && PolicyChecksum(sql) != v.Checksum {
+		return "", fmt.Errorf("policy %s version %d SQL does not match its checksum %s, create a new version instead of changing it", policyName, v.Version, v.Checksum)
+	}
+	return sql, nil
+}
+
+// ExecPolicyRun runs the steps of a policy run and returns the policy
+// version the cluster reached, in the atomic and dryrun modes every
+// step runs in one transaction so a failure leaves the cluster on the
+// version it started from
+func ExecPolicyRun(target SQLTarget, run *PolicyRun, mode string) (*SQLResult, int, error) {
+	//removing a policy without removal SQL only takes its label off
+	if len(run.Steps) == 0 {
+		if mode == SQL_MODE_DRYRUN {
+			return &SQLResult{}, run.FromVersion, nil
+		}
+		return &SQLResult{}, run.ToVersion, nil
+	}
+
+	if mode != SQL_MODE_STATEMENTS {
+		scripts := []string{}
+		for _, step := range run.Steps {
+			scripts = append(scripts, step.Sql)
+		}
+		result, err := ExecSQL(target, strings.Join(scripts, ";\n"), mode)
+		if err != nil || mode == SQL_MODE_DRYRUN {
+			return result, run.FromVersion, err
+		}
+		return result, run.ToVersion, nil
+	}
+
+	total := &SQLResult{}
+	reached := run.FromVersion
+	for _, step := range run.Steps {
+		result, err := ExecSQL(target, step.Sql, mode)
+		total.Statements = append(total.Statements, result.Statements...)
+		total.RowsAffected += result.RowsAffected
+		total.Notices = append(total.Notices, result.Notices...)
+		total.Error = result.Error
+		if err != nil {
+			return total, reached, err
+		}
+		reached = step.Version
+	}
+	return total, reached, nil
+}
+
+// GetPolicyAction checks a requested action against the pgpolicylog
+// already recorded for a cluster, applied is nil when the policy was
+// never applied, and returns the action and the version it starts from
+// or an error saying why there is nothing to run
+func GetPolicyAction(policy *crv1.Pgpolicy, clusterName string, applied *crv1.PgpolicylogSpec, action string) (string, int, error) {
+	versions := GetPolicyVersions(policy)
+	latest := versions[len(versions)-1].Version
+	version := 0
+	if applied != nil {
+		version = AppliedPolicyVersion(applied)
+	}
+	name := policy.Spec.Name
+
+	switch action {
+	case "", crv1.POLICY_ACTION_APPLY:
+		if version == 0 {
+			return crv1.POLICY_ACTION_APPLY, 0, nil
+		}
+		if version < latest {
+			return "", version, fmt.Errorf("%s version %d already applied to %s, use --upgrade to apply version %d", name, version, clusterName, latest)
+		}
+		return "", version, errors.New(name + " already applied to " + clusterName)
+	case crv1.POLICY_ACTION_UPGRADE:
+		if version == 0 {
+			return "", version, errors.New(name + " is not applied to " + clusterName)
+		}
+		if version >= latest {
+			return "", version, fmt.Errorf("%s version %d applied to %s is the latest version", name, version, clusterName)
+		}
+		return action, version, nil
+	case crv1.POLICY_ACTION_REVERT:
+		if version == 0 {
+			return "", version, errors.New(name + " is not applied to " + clusterName)
+		}
+		if version == 1 {
+			return "", version, errors.New(name + " version 1 applied to " + clusterName + " cannot be reverted")
+		}
+		return action, version, nil
+	case crv1.POLICY_ACTION_REMOVE:
+		if version == 0 {
+			return "", version, errors.New(name + " is not applied to " + clusterName)
+		}
+		return action, version, nil
+	}
+
+	return "", version, errors.New("invalid policy action " + action)
+}
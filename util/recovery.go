@@ -0,0 +1,84 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"regexp"
+	"time"
+)
+
+const RECOVERY_TARGET_TIME = "RECOVERY_TARGET_TIME"
+const RECOVERY_TARGET_NAME = "RECOVERY_TARGET_NAME"
+const RECOVERY_TARGET_LSN = "RECOVERY_TARGET_LSN"
+
+// the time formats accepted for a point-in-time recovery target,
+// these are the formats PostgreSQL accepts for recovery_target_time
+var recoveryTimeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05-07",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05 MST",
+	time.RFC3339,
+}
+
+var lsnRegexp = regexp.MustCompile(`^[0-9A-Fa-f]{1,8}/[0-9A-Fa-f]{1,8}$`)
+
+// ParseRecoveryTarget works out whether a point-in-time recovery
+// target is a timestamp, an LSN or a named restore point and returns
+// the recovery env var that carries it
+func ParseRecoveryTarget(target string) (string, error) {
+	if target == "" {
+		return "", errors.New("recovery target is empty")
+	}
+
+	for _, layout := range recoveryTimeLayouts {
+		if _, err := time.Parse(layout, target); err == nil {
+			return RECOVERY_TARGET_TIME, nil
+		}
+	}
+
+	if lsnRegexp.MatchString(target) {
+		return RECOVERY_TARGET_LSN, nil
+	}
+
+	//restore point names are limited to NAMEDATALEN
+	if len(target) > 63 {
+		return "", errors.New("recovery target " + target + " is not a timestamp or LSN and is too long for a restore point name")
+	}
+
+	return RECOVERY_TARGET_NAME, nil
+}
+
+// CreateRecoveryEnv returns the database container env entries for
+// a point-in-time recovery target, each prefixed with a comma
+func CreateRecoveryEnv(target string) (string, error) {
+	var sc bytes.Buffer
+
+	if target == "" {
+		return "", nil
+	}
+
+	envName, err := ParseRecoveryTarget(target)
+	if err != nil {
+		return "", err
+	}
+
+	writeEnv(&sc, envName, target)
+
+	return sc.String(), nil
+}
@@ -0,0 +1,75 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+)
+
+// settings only a superuser can change that a pguser may still be
+// given, the operator sets them on the role as the postgres user
+var superuserRoleSettings = []string{"log_min_duration_statement", "log_statement", "log_duration"}
+
+// the connections left to roles that are not superusers
+const availableConnectionsQuery = "select (select setting::int from pg_settings where name = 'max_connections') - " +
+	"(select setting::int from pg_settings where name = 'superuser_reserved_connections')"
+
+// ValidateRoleSettings checks the connection limit and the settings of
+// a pguser against the server of a cluster, the limit can not be more
+// than the connections left to roles that are not superusers and each
+// setting must be one a user can change, PostgreSQL itself checks the
+// value of a setting is within its bounds when it is set
+func ValidateRoleSettings(target SQLTarget, spec *crv1.PguserSpec) error {
+	if spec.ConnectionLimit == 0 && len(spec.Settings) == 0 {
+		return nil
+	}
+
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if spec.ConnectionLimit > 0 {
+		var available int
+		err = db.QueryRow(availableConnectionsQuery).Scan(&available)
+		if err != nil {
+			return err
+		}
+		if spec.ConnectionLimit > available {
+			return errors.New("connection limit " + strconv.Itoa(spec.ConnectionLimit) + " of user " + spec.Name +
+				" is more than the " + strconv.Itoa(available) + " connections the server allows")
+		}
+	}
+
+	for _, name := range GetSettingNames(spec.Settings) {
+		var context string
+		err = db.QueryRow("select context from pg_settings where name = $1", name).Scan(&context)
+		if err == sql.ErrNoRows {
+			return errors.New("setting " + name + " of user " + spec.Name + " is not known to the server")
+		} else if err != nil {
+			return err
+		}
+		if context != "user" && !containsWord(superuserRoleSettings, name) {
+			return errors.New("setting " + name + " of user " + spec.Name + " can not be set for a role, its context is " + context)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"database/sql"
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// annotation set on the pod template of a deployment restarted after
+// the password of a secret it reads was rotated
+const ROTATED_ANNOTATION = "pg-secret-rotated"
+
+// ExpiringRole is a login role whose password expires
+type ExpiringRole struct {
+	Name       string
+	ValidUntil time.Time
+}
+
+// GetExpiringRoles returns the login roles of a cluster whose password
+// has an expiry date
+func GetExpiringRoles(target SQLTarget) ([]ExpiringRole, error) {
+	roles := []ExpiringRole{}
+
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return roles, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select rolname, rolvaliduntil from pg_roles where rolcanlogin " +
+		"and rolvaliduntil is not null and rolvaliduntil < 'infinity'")
+	if err != nil {
+		return roles, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := ExpiringRole{}
+		err = rows.Scan(&r.Name, &r.ValidUntil)
+		if err != nil {
+			return roles, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// GetRotationSettings returns the password rotation settings of a role
+// on a cluster, those of its pguser when it has its own, otherwise
+// those of the cluster, user is nil for a role that is not a pguser
+func GetRotationSettings(cluster *crv1.PgclusterSpec, user *crv1.PguserSpec) crv1.PgPasswordRotationSpec {
+	settings := cluster.PasswordRotation
+	if user != nil && user.Rotation.Enabled {
+		settings = user.Rotation
+	}
+	if settings.WindowDays <= 0 {
+		settings.WindowDays = crv1.ROTATION_DEFAULT_WINDOW_DAYS
+	}
+	return settings
+}
+
+// RotateRolePassword sets a new password on a role and writes it to
+// the secret holding the old one, if the secret can not be updated the
+// old password and expiry are put back so the role and its secret never
+// disagree, encryption is the password encryption of the cluster
+func RotateRolePassword(clientset *kubernetes.Clientset, target SQLTarget, secret *Credential, role, password, validUntil, oldValidUntil, encryption, namespace string) error {
+	oldPassword := secret.Password
+
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	stmt, err := sqlutil.AlterRolePassword(role, password, validUntil, encryption)
+	if err != nil {
+		return err
+	}
+	_, err = ExecSQL(target, sqlutil.Script([]string{stmt}), SQL_MODE_ATOMIC)
+	if err != nil {
+		return err
+	}
+
+	secret.Password = password
+	err = store.Put(secret)
+	if err == nil {
+		return nil
+	}
+
+	log.Error("error updating secret " + secret.Name + ", restoring the old password of " + role + " " + err.Error())
+	revertTarget := target
+	if role == target.User {
+		revertTarget.Password = password
+	}
+	stmt, revertErr := sqlutil.AlterRolePassword(role, oldPassword, oldValidUntil, encryption)
+	if revertErr == nil {
+		_, revertErr = ExecSQL(revertTarget, sqlutil.Script([]string{stmt}), SQL_MODE_ATOMIC)
+	}
+	if revertErr != nil {
+		return errors.New("the secret " + secret.Name + " could not be updated and the old password could not be restored " + revertErr.Error())
+	}
+	return errors.New("the secret " + secret.Name + " could not be updated, the old password was restored " + err.Error())
+}
+
+// RestartSecretConsumers restarts the deployments labelled as reading
+// a secret by changing an annotation of their pod template, returning
+// the names of the deployments restarted
+func RestartSecretConsumers(clientset *kubernetes.Clientset, secretName, namespace string) ([]string, error) {
+	restarted := []string{}
+
+	lo := meta_v1.ListOptions{LabelSelector: crv1.SECRET_CONSUMER_LABEL + "=" + secretName}
+	deployments, err := clientset.ExtensionsV1beta1().Deployments(namespace).List(lo)
+	if err != nil {
+		return restarted, err
+	}
+
+	patch := []byte(`{"spec":{"template":{"metadata":{"annotations":{"` + ROTATED_ANNOTATION + `":"` + time.Now().Format(time.RFC3339) + `"}}}}}`)
+	for _, d := range deployments.Items {
+		_, err = clientset.ExtensionsV1beta1().Deployments(namespace).Patch(d.ObjectMeta.Name, types.StrategicMergePatchType, patch)
+		if err != nil {
+			return restarted, err
+		}
+		log.Info("restarted deployment " + d.ObjectMeta.Name + " after rotating " + secretName)
+		restarted = append(restarted, d.ObjectMeta.Name)
+	}
+	return restarted, nil
+}
@@ -0,0 +1,132 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	authv1 "k8s.io/client-go/pkg/apis/authorization/v1"
+	"k8s.io/client-go/rest"
+)
+
+// REDACTED_PASSWORD is shown in place of a password that was not
+// asked to be revealed
+const REDACTED_PASSWORD = "********"
+
+// CanRevealSecrets asks the Kubernetes authorizer whether a user may
+// get the secrets of a namespace, an empty username asks about the user
+// the clientset authenticates as, the username and groups must come
+// from an authenticated request, the review is made whatever the secret
+// store as the passwords are read with the credentials of the operator
+func CanRevealSecrets(clientset *kubernetes.Clientset, username string, groups []string, namespace string) (bool, string, error) {
+	attributes := &authv1.ResourceAttributes{
+		Namespace: namespace,
+		Verb:      "get",
+		Resource:  "secrets",
+	}
+
+	if username == "" {
+		review := &authv1.SelfSubjectAccessReview{
+			Spec: authv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: attributes,
+			},
+		}
+		result, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			return false, "", err
+		}
+		return result.Status.Allowed, result.Status.Reason, nil
+	}
+
+	review := &authv1.SubjectAccessReview{
+		Spec: authv1.SubjectAccessReviewSpec{
+			ResourceAttributes: attributes,
+			User:               username,
+			Groups:             groups,
+		},
+	}
+	result, err := clientset.AuthorizationV1().SubjectAccessReviews().Create(review)
+	if err != nil {
+		return false, "", err
+	}
+	return result.Status.Allowed, result.Status.Reason, nil
+}
+
+// GetConnectionString returns the postgresql:// URI of a role, the
+// username and password are escaped as the URI requires except for a
+// redacted password which is kept readable
+func GetConnectionString(host, port, username, password, database string) string {
+	u := url.URL{
+		Scheme: "postgresql",
+		User:   url.UserPassword(username, password),
+		Host:   host + ":" + port,
+		Path:   "/" + database,
+	}
+	if password != REDACTED_PASSWORD {
+		return u.String()
+	}
+	u.User = url.User(username)
+	return strings.Replace(u.String(), "@", ":"+REDACTED_PASSWORD+"@", 1)
+}
+
+// GetClusterConnectionString returns the connection string of a
+// cluster role through the service of the cluster master
+func GetClusterConnectionString(cl *crv1.Pgcluster, namespace, username, password string) string {
+	return GetConnectionString(GetServiceHost(cl.Spec.Name, namespace), cl.Spec.Port, username, password, GetUserDatabase(cl, username))
+}
+
+// GetUserDatabase returns the database a cluster role connects to, the
+// database of the cluster for PG_USER and postgres for the others
+func GetUserDatabase(cl *crv1.Pgcluster, username string) string {
+	if username == cl.Spec.PG_USER {
+		return cl.Spec.PG_DATABASE
+	}
+	return "postgres"
+}
+
+// RecordSecretReveal creates the pgsecretlog auditing that passwords
+// were revealed, it is written before the passwords are so a reveal
+// that could not be audited does not happen
+func RecordSecretReveal(restclient *rest.RESTClient, spec *crv1.PgsecretlogSpec, namespace string) error {
+	spec.RequestDate = time.Now().Format(time.RFC3339)
+
+	secretlogLabels := make(map[string]string)
+	secretlogLabels["pg-cluster"] = spec.ClusterName
+
+	newInstance := &crv1.Pgsecretlog{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: spec.ClusterName + "-",
+			Labels:       secretlogLabels,
+		},
+		Spec: *spec,
+		Status: crv1.PgsecretlogStatus{
+			State:   crv1.PgsecretlogStateCreated,
+			Message: "Created",
+		},
+	}
+
+	return restclient.Post().
+		Resource(crv1.PgsecretlogResourcePlural).
+		Namespace(namespace).
+		Body(newInstance).
+		Do().
+		Error()
+}
@@ -16,29 +16,16 @@
 package util
 
 import (
-	//"encoding/base64"
+	"errors"
 	log "github.com/Sirupsen/logrus"
 	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
-	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
-	"k8s.io/client-go/pkg/api/v1"
-	//"k8s.io/api/core/v1"
-
-	"math/rand"
 	"strings"
-	"time"
 )
 
-const charset = "abcdefghijklmnopqrstuvwxyz" +
-	"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-var seededRand *rand.Rand = rand.New(
-	rand.NewSource(time.Now().UnixNano()))
-
 //create pgroot, pgmaster, and pguser secrets
 func CreateDatabaseSecrets(clientset *kubernetes.Clientset, restclient *rest.RESTClient, cl *crv1.Pgcluster, namespace string) error {
 
@@ -50,7 +37,7 @@ func CreateDatabaseSecrets(clientset *kubernetes.Clientset, restclient *rest.RES
 	var err error
 
 	secretName = cl.Spec.Name + suffix
-	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_ROOT_PASSWORD, namespace)
+	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_ROOT_PASSWORD, &cl.Spec.PasswordPolicy, namespace)
 	if err != nil {
 		log.Error("error creating secret" + err.Error())
 	}
@@ -66,7 +53,7 @@ func CreateDatabaseSecrets(clientset *kubernetes.Clientset, restclient *rest.RES
 	suffix = crv1.PGMASTER_SECRET_SUFFIX
 
 	secretName = cl.Spec.Name + suffix
-	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_MASTER_PASSWORD, namespace)
+	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_MASTER_PASSWORD, &cl.Spec.PasswordPolicy, namespace)
 	if err != nil {
 		log.Error("error creating secret2" + err.Error())
 	}
@@ -82,7 +69,7 @@ func CreateDatabaseSecrets(clientset *kubernetes.Clientset, restclient *rest.RES
 	suffix = crv1.PGUSER_SECRET_SUFFIX
 
 	secretName = cl.Spec.Name + suffix
-	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_PASSWORD, namespace)
+	err = CreateSecret(clientset, cl.Spec.Name, secretName, username, cl.Spec.PG_PASSWORD, &cl.Spec.PasswordPolicy, namespace)
 	if err != nil {
 		log.Error("error creating secret " + err.Error())
 	}
@@ -96,75 +83,94 @@ func CreateDatabaseSecrets(clientset *kubernetes.Clientset, restclient *rest.RES
 	return err
 }
 
-//create the secret, user, and master secrets
-func CreateSecret(clientset *kubernetes.Clientset, db, secretName, username, password, namespace string) error {
+//create the secret, user, and master secrets, a password is generated
+//following the password policy when none is given
+func CreateSecret(clientset *kubernetes.Clientset, db, secretName, username, password string, policy *crv1.PgPasswordPolicySpec, namespace string) error {
 
-	//var enUsername = base64.StdEncoding.EncodeToString([]byte(username))
-	var enUsername = username
-	//var enPassword = base64.StdEncoding.EncodeToString([]byte(generatePassword(10)))
-	var enPassword = GeneratePassword(10)
+	var enPassword = password
 	if password != "" {
 		log.Debug("using user specified password for secret " + secretName)
-		enPassword = password
+	} else {
+		var err error
+		enPassword, err = GeneratePassword(policy, 0)
+		if err != nil {
+			log.Error("error generating password for secret " + secretName + " " + err.Error())
+			return err
+		}
 	}
 
-	secret := v1.Secret{}
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		log.Error("error getting secret store " + err.Error())
+		return err
+	}
+
+	_, err = store.Get(secretName)
+	if err == nil {
+		err = errors.New("secret " + secretName + " already exists")
+		log.Error("error creating secret " + err.Error())
+		return err
+	} else if err != ErrSecretNotFound {
+		log.Error("error creating secret " + err.Error())
+		return err
+	}
 
-	secret.Name = secretName
-	secret.ObjectMeta.Labels = make(map[string]string)
-	secret.ObjectMeta.Labels["pg-database"] = db
-	secret.Data = make(map[string][]byte)
-	secret.Data["username"] = []byte(enUsername)
-	secret.Data["password"] = []byte(enPassword)
+	c := Credential{}
+	c.Name = secretName
+	c.Labels = make(map[string]string)
+	c.Labels["pg-database"] = db
+	c.Username = username
+	c.Password = enPassword
 
-	_, err := clientset.Core().Secrets(namespace).Create(&secret)
+	err = store.Put(&c)
 	if err != nil {
 		log.Error("error creating secret" + err.Error())
 	} else {
-		log.Debug("created secret " + secret.Name)
+		log.Debug("created secret " + secretName)
 	}
 
 	return err
 
 }
 
-func stringWithCharset(length int, charset string) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
+// StripClusterPasswords removes the cleartext passwords from a
+// pgcluster once they are held by the secret store
+func StripClusterPasswords(restclient *rest.RESTClient, cl *crv1.Pgcluster, namespace string) {
+	paths := map[string]*string{
+		"/spec/pgrootpassword":   &cl.Spec.PG_ROOT_PASSWORD,
+		"/spec/pgmasterpassword": &cl.Spec.PG_MASTER_PASSWORD,
+		"/spec/pgpassword":       &cl.Spec.PG_PASSWORD,
+	}
+	for path, password := range paths {
+		if *password == "" {
+			continue
+		}
+		*password = ""
+		err := Patch(restclient, path, "", crv1.PgclusterResourcePlural, cl.Spec.Name, namespace)
+		if err != nil {
+			log.Error("error removing " + path + " from cluster " + cl.Spec.Name + " " + err.Error())
+		}
 	}
-	return string(b)
-}
-
-//generate a password of a given length
-func GeneratePassword(length int) string {
-	return stringWithCharset(length, charset)
 }
 
 //delete pgroot, pgmaster, and pguser secrets
 func DeleteDatabaseSecrets(clientset *kubernetes.Clientset, db, namespace string) {
 
-	options := meta_v1.DeleteOptions{}
-	secretName := db + crv1.PGMASTER_SECRET_SUFFIX
-	err := clientset.Core().Secrets(namespace).Delete(secretName, &options)
-	if err != nil {
-		log.Error("error deleting secret" + err.Error())
-	} else {
-		log.Info("deleted secret " + secretName)
-	}
-	secretName = db + crv1.PGROOT_SECRET_SUFFIX
-	err = clientset.Core().Secrets(namespace).Delete(secretName, &options)
+	store, err := GetSecretStore(clientset, namespace)
 	if err != nil {
-		log.Error("error deleting secret" + err.Error())
-	} else {
-		log.Info("deleted secret " + secretName)
+		log.Error("error getting secret store " + err.Error())
+		return
 	}
-	secretName = db + crv1.PGUSER_SECRET_SUFFIX
-	err = clientset.Core().Secrets(namespace).Delete(secretName, &options)
-	if err != nil {
-		log.Error("error deleting secret" + err.Error())
-	} else {
-		log.Info("deleted secret " + secretName)
+
+	suffixes := []string{crv1.PGMASTER_SECRET_SUFFIX, crv1.PGROOT_SECRET_SUFFIX, crv1.PGUSER_SECRET_SUFFIX}
+	for _, suffix := range suffixes {
+		secretName := db + suffix
+		err = store.Delete(secretName)
+		if err != nil {
+			log.Error("error deleting secret " + secretName + " " + err.Error())
+		} else {
+			log.Info("deleted secret " + secretName)
+		}
 	}
 }
 
@@ -176,42 +182,52 @@ func GetPasswordFromSecret(clientset *kubernetes.Clientset, namespace string, se
 	log.Infoln("namespace=" + namespace)
 	log.Infoln("secretName=" + secretName)
 
-	options := meta_v1.GetOptions{}
-	secret, err := clientset.Core().Secrets(namespace).Get(secretName, options)
-	if errors.IsNotFound(err) {
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := store.Get(secretName)
+	if err == ErrSecretNotFound {
 		log.Error("not found error secret " + secretName)
 		return "", err
+	} else if err != nil {
+		return "", err
 	}
 
-	return string(secret.Data["password"][:]), err
+	return c.Password, nil
 
 }
 
 func CopySecrets(clientset *kubernetes.Clientset, namespace string, fromCluster, toCluster string) error {
 
 	log.Debug("CopySecrets " + fromCluster + " to " + toCluster)
-	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + fromCluster}
-	secrets, err := clientset.Core().Secrets(namespace).List(lo)
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		log.Error("error getting secret store " + err.Error())
+		return err
+	}
+
+	credentials, err := store.List(fromCluster)
 	if err != nil {
 		log.Error("error getting list of secrets" + err.Error())
 		return err
 	}
 
-	for _, s := range secrets.Items {
-		log.Debug("found secret : " + s.ObjectMeta.Name)
-		secret := v1.Secret{}
-		secret.Name = strings.Replace(s.ObjectMeta.Name, fromCluster, toCluster, 1)
-		secret.ObjectMeta.Labels = make(map[string]string)
-		secret.ObjectMeta.Labels["pg-database"] = toCluster
-		secret.Data = make(map[string][]byte)
-		secret.Data["username"] = s.Data["username"][:]
-		secret.Data["password"] = s.Data["password"][:]
-
-		_, err := clientset.Core().Secrets(namespace).Create(&secret)
+	for _, s := range credentials {
+		log.Debug("found secret : " + s.Name)
+		c := Credential{}
+		c.Name = strings.Replace(s.Name, fromCluster, toCluster, 1)
+		c.Labels = make(map[string]string)
+		c.Labels["pg-database"] = toCluster
+		c.Username = s.Username
+		c.Password = s.Password
+
+		err = store.Put(&c)
 		if err != nil {
 			log.Error("error creating secret" + err.Error())
 		} else {
-			log.Debug("created secret " + secret.Name)
+			log.Debug("created secret " + c.Name)
 		}
 
 	}
@@ -224,7 +240,7 @@ func CreateUserSecret(clientset *kubernetes.Clientset, clustername, username, pa
 	var err error
 
 	secretName := clustername + "-" + username + "-secret"
-	err = CreateSecret(clientset, clustername, secretName, username, password, namespace)
+	err = CreateSecret(clientset, clustername, secretName, username, password, nil, namespace)
 	if err != nil {
 		log.Error("error creating secret" + err.Error())
 	}
@@ -239,13 +255,9 @@ func UpdateUserSecret(clientset *kubernetes.Clientset, clustername, username, pa
 	secretName := clustername + "-" + username + "-secret"
 
 	//delete current secret
-	options := meta_v1.DeleteOptions{}
-	err = clientset.Core().Secrets(namespace).Delete(secretName, &options)
+	err = DeleteUserSecret(clientset, clustername, username, namespace)
 	if err != nil {
-		log.Error("error deleting secret" + err.Error())
 		return err
-	} else {
-		log.Debug("deleted secret " + secretName)
 	}
 	//create secret with updated password
 	err = CreateUserSecret(clientset, clustername, username, password, namespace)
@@ -263,8 +275,13 @@ func DeleteUserSecret(clientset *kubernetes.Clientset, clustername, username, na
 	//delete current secret
 	secretName := clustername + "-" + username + "-secret"
 
-	options := meta_v1.DeleteOptions{}
-	err := clientset.Core().Secrets(namespace).Delete(secretName, &options)
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		log.Error("error getting secret store " + err.Error())
+		return err
+	}
+
+	err = store.Delete(secretName)
 	if err != nil {
 		log.Error("error deleting secret" + err.Error())
 		return err
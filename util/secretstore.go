@@ -0,0 +1,225 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	log "github.com/Sirupsen/logrus"
+	"os"
+
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/pkg/api/v1"
+)
+
+const SECRET_STORE_KUBERNETES = "kubernetes"
+const SECRET_STORE_VAULT = "vault"
+
+// ErrSecretNotFound is returned by a secret store asked for a secret
+// it does not hold
+var ErrSecretNotFound = errors.New("secret not found")
+
+// Credential is the username and password of a database role as held
+// by a secret store, Labels are the labels of its Kubernetes secret,
+// pg-database naming the cluster of the role
+type Credential struct {
+	Name     string
+	Labels   map[string]string
+	Username string
+	Password string
+}
+
+// SecretStore holds the credentials of the database roles of the
+// clusters, Put creates a credential or replaces the username and
+// password of an existing one keeping its other labels and keys
+type SecretStore interface {
+	Get(name string) (*Credential, error)
+	Put(c *Credential) error
+	Delete(name string) error
+	List(clusterName string) ([]Credential, error)
+}
+
+// SecretStoreConfig selects the secret store backend and describes how
+// the operator and the database pods reach Vault
+type SecretStoreConfig struct {
+	Backend           string
+	VaultAddr         string
+	VaultToken        string
+	VaultRole         string
+	VaultAuthPath     string
+	VaultKVMount      string
+	VaultKVPrefix     string
+	VaultDatabaseRole string
+	VaultImage        string
+}
+
+// GetSecretStoreConfig returns the secret store settings of the
+// operator, read from its environment
+func GetSecretStoreConfig() *SecretStoreConfig {
+	config := &SecretStoreConfig{
+		Backend:           os.Getenv("SECRET_STORE"),
+		VaultAddr:         os.Getenv("VAULT_ADDR"),
+		VaultToken:        os.Getenv("VAULT_TOKEN"),
+		VaultRole:         os.Getenv("VAULT_ROLE"),
+		VaultAuthPath:     os.Getenv("VAULT_AUTH_PATH"),
+		VaultKVMount:      os.Getenv("VAULT_KV_MOUNT"),
+		VaultKVPrefix:     os.Getenv("VAULT_KV_PREFIX"),
+		VaultDatabaseRole: os.Getenv("VAULT_DATABASE_ROLE"),
+		VaultImage:        os.Getenv("VAULT_IMAGE"),
+	}
+	config.SetDefaults()
+	return config
+}
+
+// SetDefaults fills in the settings that were not given
+func (c *SecretStoreConfig) SetDefaults() {
+	if c.Backend == "" {
+		c.Backend = SECRET_STORE_KUBERNETES
+	}
+	if c.VaultAuthPath == "" {
+		c.VaultAuthPath = "kubernetes"
+	}
+	if c.VaultKVMount == "" {
+		c.VaultKVMount = "secret"
+	}
+	if c.VaultKVPrefix == "" {
+		c.VaultKVPrefix = "postgres-operator"
+	}
+	if c.VaultImage == "" {
+		c.VaultImage = "vault:1.0.3"
+	}
+}
+
+// Validate checks the backend is known and Vault can be reached and
+// authenticated against
+func (c *SecretStoreConfig) Validate() error {
+	switch c.Backend {
+	case SECRET_STORE_KUBERNETES:
+		return nil
+	case SECRET_STORE_VAULT:
+		if c.VaultAddr == "" {
+			return errors.New("VAULT_ADDR is required for the vault secret store")
+		}
+		if c.VaultToken == "" && c.VaultRole == "" {
+			return errors.New("VAULT_TOKEN or VAULT_ROLE is required for the vault secret store")
+		}
+		return nil
+	}
+	return errors.New("invalid secret store " + c.Backend + ", must be " + SECRET_STORE_KUBERNETES + " or " + SECRET_STORE_VAULT)
+}
+
+// GetSecretStore returns the secret store of the operator for a
+// namespace
+func GetSecretStore(clientset *kubernetes.Clientset, namespace string) (SecretStore, error) {
+	return NewSecretStore(clientset, GetSecretStoreConfig(), namespace)
+}
+
+// NewSecretStore returns the secret store a config selects
+func NewSecretStore(clientset *kubernetes.Clientset, config *SecretStoreConfig, namespace string) (SecretStore, error) {
+	err := config.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if config.Backend == SECRET_STORE_VAULT {
+		return &VaultSecretStore{Config: config, Namespace: namespace}, nil
+	}
+	return &KubernetesSecretStore{Clientset: clientset, Namespace: namespace}, nil
+}
+
+// KubernetesSecretStore holds credentials in Kubernetes secrets, the
+// username and password keys of a secret named after the credential
+type KubernetesSecretStore struct {
+	Clientset *kubernetes.Clientset
+	Namespace string
+}
+
+func (s *KubernetesSecretStore) Get(name string) (*Credential, error) {
+	secret, err := s.Clientset.Core().Secrets(s.Namespace).Get(name, meta_v1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		return nil, ErrSecretNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return secretCredential(secret), nil
+}
+
+func (s *KubernetesSecretStore) Put(c *Credential) error {
+	secret, err := s.Clientset.Core().Secrets(s.Namespace).Get(c.Name, meta_v1.GetOptions{})
+	if kerrors.IsNotFound(err) {
+		secret = &v1.Secret{}
+		secret.Name = c.Name
+		secret.ObjectMeta.Labels = c.Labels
+		secret.Data = make(map[string][]byte)
+		secret.Data["username"] = []byte(c.Username)
+		secret.Data["password"] = []byte(c.Password)
+		_, err = s.Clientset.Core().Secrets(s.Namespace).Create(secret)
+		if err == nil {
+			log.Debug("created secret " + c.Name)
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	if secret.ObjectMeta.Labels == nil {
+		secret.ObjectMeta.Labels = make(map[string]string)
+	}
+	for k, v := range c.Labels {
+		secret.ObjectMeta.Labels[k] = v
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data["username"] = []byte(c.Username)
+	secret.Data["password"] = []byte(c.Password)
+	_, err = s.Clientset.Core().Secrets(s.Namespace).Update(secret)
+	if err == nil {
+		log.Debug("updated secret " + c.Name)
+	}
+	return err
+}
+
+func (s *KubernetesSecretStore) Delete(name string) error {
+	err := s.Clientset.Core().Secrets(s.Namespace).Delete(name, &meta_v1.DeleteOptions{})
+	if kerrors.IsNotFound(err) {
+		return ErrSecretNotFound
+	}
+	return err
+}
+
+func (s *KubernetesSecretStore) List(clusterName string) ([]Credential, error) {
+	credentials := []Credential{}
+
+	lo := meta_v1.ListOptions{LabelSelector: "pg-database=" + clusterName}
+	secrets, err := s.Clientset.Core().Secrets(s.Namespace).List(lo)
+	if err != nil {
+		return credentials, err
+	}
+	for i := range secrets.Items {
+		credentials = append(credentials, *secretCredential(&secrets.Items[i]))
+	}
+	return credentials, nil
+}
+
+func secretCredential(secret *v1.Secret) *Credential {
+	return &Credential{
+		Name:     secret.Name,
+		Labels:   secret.ObjectMeta.Labels,
+		Username: string(secret.Data["username"]),
+		Password: string(secret.Data["password"]),
+	}
+}
@@ -0,0 +1,438 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strings"
+	"time"
+
+	"github.com/crunchydata/kraken/sqlutil"
+	"github.com/lib/pq"
+)
+
+// how often and how long to wait for a database to accept connections
+// before running SQL against it
+const SQL_CONNECT_ATTEMPTS = 7
+const SQL_CONNECT_INTERVAL = 2 * time.Second
+
+// how the statements of a script are run, SQL_MODE_STATEMENTS commits
+// each statement on its own, SQL_MODE_ATOMIC runs the script in one
+// transaction and SQL_MODE_DRYRUN runs it in a transaction that is
+// always rolled back
+const SQL_MODE_STATEMENTS = "statements"
+const SQL_MODE_ATOMIC = "atomic"
+const SQL_MODE_DRYRUN = "dryrun"
+
+// SQLTarget is the database a SQL script is run against
+type SQLTarget struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Database string
+}
+
+// StatementResult is the outcome of one statement of a script
+type StatementResult struct {
+	SQL          string `json:"sql"`
+	RowsAffected int64  `json:"rowsaffected"`
+}
+
+// SQLError describes the statement of a script that failed, Position
+// is the character offset within that statement reported by postgres
+type SQLError struct {
+	Statement int    `json:"statement"`
+	SQL       string `json:"sql"`
+	Code      string `json:"code"`
+	Severity  string `json:"severity"`
+	Message   string `json:"message"`
+	Detail    string `json:"detail"`
+	Hint      string `json:"hint"`
+	Position  string `json:"position"`
+}
+
+func (e *SQLError) Error() string {
+	msg := fmt.Sprintf("statement %d failed: %s", e.Statement, e.Message)
+	if e.Code != "" {
+		msg = msg + " (SQLSTATE " + e.Code + ")"
+	}
+	if e.Position != "" {
+		msg = msg + " at position " + e.Position
+	}
+	return msg
+}
+
+// SQLResult is the outcome of running a SQL script, Statements holds
+// every statement that completed before Error, if any, was raised
+type SQLResult struct {
+	Statements   []StatementResult `json:"statements"`
+	RowsAffected int64             `json:"rowsaffected"`
+	Notices      []string          `json:"notices"`
+	RolledBack   bool              `json:"rolledback"`
+	Error        *SQLError         `json:"error"`
+}
+
+// sqlExecer runs a statement on the pinned connection of a script or
+// on the transaction opened on it
+type sqlExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ExecSQL runs each statement of a SQL script in order on a single
+// connection to the target and stops at the first failing statement,
+// the returned error is the same *SQLError recorded in the result,
+// in the atomic and dryrun modes the script runs in a transaction on
+// that connection and a failure rolls back every statement
+func ExecSQL(target SQLTarget, script string, mode string) (*SQLResult, error) {
+	result := &SQLResult{}
+
+	statements := SplitSQL(script)
+	transactional := mode == SQL_MODE_ATOMIC || mode == SQL_MODE_DRYRUN
+	if transactional {
+		err := checkTransactionControl(statements)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	base, err := pq.NewConnector(target.connString())
+	if err != nil {
+		return result, err
+	}
+	connector := pq.ConnectorWithNoticeHandler(base, func(notice *pq.Error) {
+		result.Notices = append(result.Notices, string(notice.Severity)+": "+notice.Message)
+	})
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	err = waitForDatabase(db, target)
+	if err != nil {
+		return result, err
+	}
+
+	// the script runs on one connection so statements such as SET see
+	// the session of earlier statements and a dropped connection fails
+	// the script instead of going on in a new session
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	var execer sqlExecer = conn
+	var tx *sql.Tx
+	if transactional {
+		tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return result, err
+		}
+		execer = tx
+	}
+
+	for i, stmt := range statements {
+		res, err := execer.ExecContext(ctx, stmt)
+		if err != nil {
+			result.Error = newSQLError(i+1, stmt, err)
+			if transactional {
+				rollback(tx, result)
+			}
+			return result, result.Error
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			rows = 0
+		}
+		result.Statements = append(result.Statements, StatementResult{SQL: stmt, RowsAffected: rows})
+		result.RowsAffected += rows
+	}
+
+	switch mode {
+	case SQL_MODE_DRYRUN:
+		rollback(tx, result)
+	case SQL_MODE_ATOMIC:
+		err = tx.Commit()
+		if err != nil {
+			result.Error = newSQLError(len(statements)+1, "COMMIT", err)
+			result.RolledBack = true
+			return result, result.Error
+		}
+	}
+
+	return result, nil
+}
+
+// QueryBool runs a query returning a single boolean, such as a policy
+// verification query, in a read only transaction that is rolled back,
+// args are the values of the query parameters
+func QueryBool(target SQLTarget, query string, args ...interface{}) (bool, error) {
+	var value bool
+
+	db, err := sql.Open("postgres", target.connString())
+	if err != nil {
+		return value, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return value, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("SET TRANSACTION READ ONLY")
+	if err != nil {
+		return value, err
+	}
+
+	err = tx.QueryRow(query, args...).Scan(&value)
+	return value, err
+}
+
+func rollback(tx *sql.Tx, result *SQLResult) {
+	err := tx.Rollback()
+	if err != nil {
+		log.Error("error rolling back policy transaction " + err.Error())
+		return
+	}
+	result.RolledBack = true
+}
+
+// transaction control statements in a script would end the transaction
+// an atomic or dry run is wrapped in, so such scripts are refused,
+// PREPARE is only refused as PREPARE TRANSACTION so prepared
+// statements can still be used
+var transactionControl = []string{"BEGIN", "START", "COMMIT", "END", "ROLLBACK", "ABORT"}
+
+func checkTransactionControl(statements []string) error {
+	for i, stmt := range statements {
+		keyword, rest := splitKeyword(stmt)
+		keyword = strings.ToUpper(keyword)
+		if keyword == "PREPARE" && strings.ToUpper(firstKeyword(rest)) == "TRANSACTION" {
+			keyword = "PREPARE TRANSACTION"
+		} else if !isTransactionControl(keyword) {
+			continue
+		}
+		return &SQLError{Statement: i + 1, SQL: stmt, Message: keyword + " is not allowed in a script run in a single transaction"}
+	}
+	return nil
+}
+
+func isTransactionControl(keyword string) bool {
+	for _, tc := range transactionControl {
+		if keyword == tc {
+			return true
+		}
+	}
+	return false
+}
+
+// firstKeyword returns the first word of a statement after any
+// leading comments
+func firstKeyword(stmt string) string {
+	keyword, _ := splitKeyword(stmt)
+	return keyword
+}
+
+// splitKeyword returns the first word of a statement after any leading
+// comments and the text following it
+func splitKeyword(stmt string) (string, string) {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		if strings.HasPrefix(stmt, "--") {
+			end := strings.Index(stmt, "\n")
+			if end < 0 {
+				return "", ""
+			}
+			stmt = stmt[end+1:]
+		} else if strings.HasPrefix(stmt, "/*") {
+			stmt = stmt[skipBlockComment(stmt, 0):]
+		} else {
+			break
+		}
+	}
+	end := 0
+	for end < len(stmt) && isIdentChar(stmt[end]) {
+		end++
+	}
+	return stmt[:end], stmt[end:]
+}
+
+func waitForDatabase(db *sql.DB, target SQLTarget) error {
+	var err error
+	for i := 0; i < SQL_CONNECT_ATTEMPTS; i++ {
+		err = db.Ping()
+		if err == nil {
+			return nil
+		}
+		log.Debug("could not reach " + target.Host + ":" + target.Port + " " + err.Error())
+		time.Sleep(SQL_CONNECT_INTERVAL)
+	}
+	return fmt.Errorf("could not connect to %s:%s after %d attempts: %s", target.Host, target.Port, SQL_CONNECT_ATTEMPTS, err.Error())
+}
+
+func newSQLError(statement int, stmt string, err error) *SQLError {
+	e := &SQLError{Statement: statement, SQL: stmt, Message: err.Error()}
+	if pqerr, ok := err.(*pq.Error); ok {
+		e.Code = string(pqerr.Code)
+		e.Severity = pqerr.Severity
+		e.Message = pqerr.Message
+		e.Detail = pqerr.Detail
+		e.Hint = pqerr.Hint
+		e.Position = pqerr.Position
+	}
+	return e
+}
+
+func (t SQLTarget) connString() string {
+	return sqlutil.ConnString(t.Host, t.Port, t.User, t.Password, t.Database)
+}
+
+// SplitSQL splits a script into its statements on semicolons that are
+// not inside quotes, dollar quoted bodies or comments, comments are
+// kept with the statement they precede and empty statements dropped
+func SplitSQL(script string) []string {
+	statements := []string{}
+	start := 0
+	i := 0
+
+	for i < len(script) {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(script, i, c, c == '\'' && isEscapeString(script, i))
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.Index(script[i:], "\n")
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end + 1
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			i = skipBlockComment(script, i)
+		case c == '$':
+			i = skipDollarQuoted(script, i)
+		case c == ';':
+			statements = appendStatement(statements, script[start:i])
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	statements = appendStatement(statements, script[start:])
+
+	return statements
+}
+
+func appendStatement(statements []string, stmt string) []string {
+	stmt = strings.TrimSpace(stmt)
+	if stmt == "" || onlyComments(stmt) {
+		return statements
+	}
+	return append(statements, stmt)
+}
+
+func onlyComments(stmt string) bool {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") {
+			return false
+		}
+	}
+	return true
+}
+
+// isEscapeString reports whether the quote at i opens an escape string
+// such as E'a\'b' in which a backslash escapes the next character
+func isEscapeString(script string, i int) bool {
+	if i == 0 || (script[i-1] != 'E' && script[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !isIdentChar(script[i-2])
+}
+
+func skipQuoted(script string, i int, quote byte, backslash bool) int {
+	i++
+	for i < len(script) {
+		switch {
+		case backslash && script[i] == '\\':
+			i += 2
+		case script[i] == quote && i+1 < len(script) && script[i+1] == quote:
+			i += 2
+		case script[i] == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+func skipBlockComment(script string, i int) int {
+	depth := 0
+	for i < len(script) {
+		switch {
+		case strings.HasPrefix(script[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(script[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipDollarQuoted skips a $tag$ ... $tag$ body, a $ that does not
+// start a dollar quote, such as a $1 parameter, is skipped on its own
+func skipDollarQuoted(script string, i int) int {
+	if i > 0 && isIdentChar(script[i-1]) {
+		return i + 1
+	}
+	end := strings.Index(script[i+1:], "$")
+	if end < 0 {
+		return i + 1
+	}
+	tag := script[i : i+end+2]
+	for _, c := range []byte(tag[1 : len(tag)-1]) {
+		if !isIdentChar(c) {
+			return i + 1
+		}
+	}
+	if len(tag) > 2 && tag[1] >= '0' && tag[1] <= '9' {
+		return i + 1
+	}
+	close := strings.Index(script[i+len(tag):], tag)
+	if close < 0 {
+		return len(script)
+	}
+	return i + len(tag) + close + len(tag)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,103 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"strings"
+)
+
+// statements a hot standby runs, anything else writes and is refused
+// by a replica
+var readOnlyStatements = []string{
+	"SELECT", "VALUES", "TABLE", "WITH", "SHOW", "SET", "RESET", "EXPLAIN",
+	"DISCARD", "LOAD", "CHECKPOINT", "UNLISTEN", "DEALLOCATE",
+	"DECLARE", "FETCH", "MOVE", "CLOSE",
+}
+
+// words that make a query write, SELECT INTO creates a table and a
+// locking clause needs a writable transaction
+var writingWords = []string{"INSERT", "UPDATE", "DELETE", "MERGE", "INTO"}
+
+// CheckReadOnlySQL refuses a script holding a statement a hot standby
+// does not allow, ALTER SYSTEM is allowed as it only writes the
+// configuration file of the pod
+func CheckReadOnlySQL(script string) error {
+	for i, stmt := range SplitSQL(script) {
+		words := sqlWords(stmt)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) > 1 && words[0] == "ALTER" && words[1] == "SYSTEM" {
+			continue
+		}
+		if !containsWord(readOnlyStatements, words[0]) {
+			return &SQLError{Statement: i + 1, SQL: stmt, Message: words[0] + " writes and is not allowed in a policy run on replicas"}
+		}
+		if words[0] == "SET" || words[0] == "RESET" || words[0] == "SHOW" {
+			continue
+		}
+		for j, w := range words[1:] {
+			if containsWord(writingWords, w) || (w == "FOR" && j+2 < len(words) && (words[j+2] == "UPDATE" || words[j+2] == "SHARE" || words[j+2] == "NO" || words[j+2] == "KEY")) {
+				return &SQLError{Statement: i + 1, SQL: stmt, Message: words[0] + " ... " + w + " writes and is not allowed in a policy run on replicas"}
+			}
+		}
+	}
+	return nil
+}
+
+// sqlWords returns the upper cased keywords and unquoted identifiers
+// of a statement, skipping comments, quoted strings and identifiers and
+// dollar quoted bodies
+func sqlWords(stmt string) []string {
+	words := []string{}
+	i := 0
+	for i < len(stmt) {
+		c := stmt[i]
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(stmt, i, c, c == '\'' && isEscapeString(stmt, i))
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			end := strings.Index(stmt[i:], "\n")
+			if end < 0 {
+				i = len(stmt)
+			} else {
+				i += end + 1
+			}
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			i = skipBlockComment(stmt, i)
+		case c == '$':
+			i = skipDollarQuoted(stmt, i)
+		case isIdentChar(c):
+			start := i
+			for i < len(stmt) && (isIdentChar(stmt[i]) || stmt[i] == '$') {
+				i++
+			}
+			words = append(words, strings.ToUpper(stmt[start:i]))
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,318 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	crv1 "github.com/crunchydata/kraken/apis/cr/v1"
+	"github.com/crunchydata/kraken/sqlutil"
+	"k8s.io/client-go/kubernetes"
+)
+
+// a pguser name is also part of the name of its secrets
+var pguserNameFormat = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// GetUserSecretName returns the name of the secret holding the
+// password of a user on a cluster
+func GetUserSecretName(clusterName, username string) string {
+	return clusterName + "-" + username + crv1.PGUSER_USER_SECRET_SUFFIX
+}
+
+// ValidatePguserSpec checks a pguser names a valid role and at least
+// one cluster and only grants known database privileges, the role name
+// must also be usable in the name of a secret and can not be postgres
+// whose password the operator keeps for itself
+func ValidatePguserSpec(spec *crv1.PguserSpec) error {
+	err := sqlutil.ValidateRoleName(spec.Name)
+	if err != nil {
+		return err
+	}
+	if !pguserNameFormat.MatchString(spec.Name) {
+		return errors.New("user name " + spec.Name + " must be lower case letters, digits and dashes, it is part of the name of the user secrets")
+	}
+	if spec.Name == "postgres" {
+		return errors.New("the postgres user is managed by the operator")
+	}
+	if len(spec.Clusters) == 0 {
+		return errors.New("user " + spec.Name + " names no clusters")
+	}
+	if spec.ValidDays < 0 {
+		return errors.New("the password validity of user " + spec.Name + " can not be negative")
+	}
+	if spec.PasswordDate != "" {
+		_, err := time.Parse(time.RFC3339, spec.PasswordDate)
+		if err != nil {
+			return errors.New("invalid password date " + spec.PasswordDate + ", must be RFC3339")
+		}
+	}
+	if spec.Group && spec.Rotation.Enabled {
+		return errors.New("group role " + spec.Name + " has no password to rotate")
+	}
+	err = validatePguserSettings(spec)
+	if err != nil {
+		return err
+	}
+	err = sqlutil.ValidateDatabasePrivileges(spec.Privileges)
+	if err != nil {
+		return err
+	}
+	return validatePguserGrants(spec)
+}
+
+// validatePguserSettings checks the connection limit and the server
+// settings of a pguser can be written in a statement, whether the
+// server accepts them is checked on each cluster by
+// ValidateRoleSettings
+func validatePguserSettings(spec *crv1.PguserSpec) error {
+	if spec.ConnectionLimit < 0 {
+		return errors.New("the connection limit of user " + spec.Name + " can not be negative, 0 means no limit")
+	}
+	if spec.Group && (spec.ConnectionLimit != 0 || len(spec.Settings) > 0) {
+		return errors.New("group role " + spec.Name + " does not log in, it can not have a connection limit or settings")
+	}
+	for name, value := range spec.Settings {
+		if value == "" {
+			return errors.New("setting " + name + " of user " + spec.Name + " has no value")
+		}
+		_, err := sqlutil.AlterRoleSet(spec.Name, name, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePguserGrants checks the group roles, template and grants of
+// a pguser, each grant must be on one of the databases of the pguser
+// so its privileges are dropped along with the role
+func validatePguserGrants(spec *crv1.PguserSpec) error {
+	for _, g := range spec.MemberOf {
+		if g == spec.Name {
+			return errors.New("user " + spec.Name + " can not be a member of itself")
+		}
+		_, err := sqlutil.GrantRole(g, spec.Name)
+		if err != nil {
+			return err
+		}
+	}
+	switch spec.Template {
+	case "", crv1.PGUSER_TEMPLATE_READONLY, crv1.PGUSER_TEMPLATE_READWRITE:
+	default:
+		return errors.New("invalid template " + spec.Template + ", must be " + crv1.PGUSER_TEMPLATE_READONLY + " or " + crv1.PGUSER_TEMPLATE_READWRITE)
+	}
+	for _, s := range spec.Schemas {
+		_, err := sqlutil.QuoteIdentifier(s)
+		if err != nil {
+			return errors.New("invalid schema " + s + " " + err.Error())
+		}
+	}
+	if spec.DefaultOwner != "" {
+		_, err := sqlutil.QuoteIdentifier(spec.DefaultOwner)
+		if err != nil {
+			return errors.New("invalid default owner " + spec.DefaultOwner + " " + err.Error())
+		}
+	}
+	for i := range spec.Grants {
+		g := &spec.Grants[i]
+		if g.Database != "" && !containsWord(spec.Databases, g.Database) {
+			return errors.New("grant " + GetGrantLine(g) + " is on database " + g.Database + " which is not one of the databases of user " + spec.Name)
+		}
+		if g.Schema == "" {
+			return errors.New("grant " + GetGrantLine(g) + " names no schema")
+		}
+		_, err := sqlutil.GrantObjects(spec.Name, g.On, g.Schema, g.Objects, g.Privileges)
+		if err != nil {
+			return errors.New("invalid grant " + GetGrantLine(g) + ", " + err.Error())
+		}
+		if g.Default {
+			if len(g.Objects) > 0 {
+				return errors.New("grant " + GetGrantLine(g) + " names objects, default privileges apply to every new object of the schema")
+			}
+			_, err = sqlutil.GrantDefaultPrivileges(spec.DefaultOwner, spec.Name, g.On, g.Schema, g.Privileges)
+			if err != nil {
+				return errors.New("invalid grant " + GetGrantLine(g) + ", " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// GetPguserPrivileges returns the privileges a pguser is granted on
+// its databases, when it names none those of its template or ALL
+func GetPguserPrivileges(spec *crv1.PguserSpec) []string {
+	if len(spec.Privileges) == 0 {
+		switch spec.Template {
+		case crv1.PGUSER_TEMPLATE_READONLY:
+			return []string{"CONNECT"}
+		case crv1.PGUSER_TEMPLATE_READWRITE:
+			return []string{"CONNECT", "TEMPORARY"}
+		}
+		return []string{crv1.PGUSER_DEFAULT_PRIVILEGE}
+	}
+	privileges := []string{}
+	for _, p := range spec.Privileges {
+		privileges = append(privileges, strings.ToUpper(p))
+	}
+	return privileges
+}
+
+// GetSettingNames returns the names of role settings in order
+func GetSettingNames(settings map[string]string) []string {
+	names := []string{}
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPasswordValidUntil returns when a password set at passwordDate
+// expires, infinity when validDays is 0
+func GetPasswordValidUntil(passwordDate time.Time, validDays int) string {
+	if validDays == 0 {
+		return "infinity"
+	}
+	return passwordDate.AddDate(0, 0, validDays).Format(time.RFC3339)
+}
+
+// GetUserSQL returns the script creating the role of a pguser if it
+// does not exist, setting its password and expiry, granting it its
+// databases and making it a member of its group roles, revoke lists
+// the databases the role no longer has access to and revokeGroups the
+// group roles it is no longer a member of, the password is encrypted
+// with the password encryption of the cluster, a group role has no
+// password, connection limit or settings, reset lists the settings
+// the role no longer has
+func GetUserSQL(spec *crv1.PguserSpec, exists bool, password, validUntil, encryption string, revoke, revokeGroups, reset []string) (string, error) {
+	statements := []string{}
+	var stmt string
+	var err error
+	if !exists {
+		if spec.Group {
+			stmt, err = sqlutil.CreateGroupRole(spec.Name)
+		} else {
+			stmt, err = sqlutil.CreateRole(spec.Name)
+		}
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+	if !spec.Group {
+		stmt, err = sqlutil.AlterRolePassword(spec.Name, password, validUntil, encryption)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+
+		limit := spec.ConnectionLimit
+		if limit == 0 {
+			limit = -1
+		}
+		stmt, err = sqlutil.AlterRoleConnectionLimit(spec.Name, limit)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+
+		for _, name := range GetSettingNames(spec.Settings) {
+			stmt, err = sqlutil.AlterRoleSet(spec.Name, name, spec.Settings[name])
+			if err != nil {
+				return "", err
+			}
+			statements = append(statements, stmt)
+		}
+	}
+	for _, name := range reset {
+		stmt, err = sqlutil.AlterRoleReset(spec.Name, name)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+
+	for _, d := range spec.Databases {
+		stmt, err = sqlutil.GrantDatabase(spec.Name, d, GetPguserPrivileges(spec))
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+	for _, d := range revoke {
+		stmt, err = sqlutil.RevokeDatabase(spec.Name, d)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+
+	for _, g := range spec.MemberOf {
+		stmt, err = sqlutil.GrantRole(g, spec.Name)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+	for _, g := range revokeGroups {
+		stmt, err = sqlutil.RevokeRole(g, spec.Name)
+		if err != nil {
+			return "", err
+		}
+		statements = append(statements, stmt)
+	}
+
+	return sqlutil.Script(statements), nil
+}
+
+// ApplyUserSecret writes the password of a user on a cluster to its
+// secret, the secret is updated in place when it already exists
+func ApplyUserSecret(clientset *kubernetes.Clientset, clusterName, username, password, namespace string) error {
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		return err
+	}
+
+	c := Credential{}
+	c.Name = GetUserSecretName(clusterName, username)
+	c.Labels = make(map[string]string)
+	c.Labels["pg-database"] = clusterName
+	c.Labels["pguser"] = username
+	c.Username = username
+	c.Password = password
+	return store.Put(&c)
+}
+
+// GetUserSecretPassword returns the password held by the secret of a
+// user on a cluster, or an empty string when there is no such secret
+func GetUserSecretPassword(clientset *kubernetes.Clientset, clusterName, username, namespace string) (string, error) {
+	store, err := GetSecretStore(clientset, namespace)
+	if err != nil {
+		return "", err
+	}
+
+	c, err := store.Get(GetUserSecretName(clusterName, username))
+	if err == ErrSecretNotFound {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return c.Password, nil
+}
@@ -67,6 +67,13 @@ func CreateSecContext(FS_GROUP string, SUPP string) string {
 	return sc.String()
 }
 
+// QuoteJSON returns a string as a JSON string literal, for values
+// written into the JSON snippets passed to the templates
+func QuoteJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
 func LoadTemplate(path string) *template.Template {
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -0,0 +1,234 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the token a pod is given by Kubernetes, used to log in to Vault,
+// tests point it at a token of their own
+var SERVICE_ACCOUNT_TOKEN = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+var vaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// the token the operator logged in to Vault with, kept until shortly
+// before its lease ends
+var vaultLogin struct {
+	sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// VaultSecretStore holds credentials in a Vault KV version 2 secrets
+// engine, each under <mount>/<prefix>/<namespace>/<name> with its
+// username, password and labels
+type VaultSecretStore struct {
+	Config    *SecretStoreConfig
+	Namespace string
+}
+
+type vaultCredential struct {
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// GetVaultPath returns the path of the credentials of a namespace as
+// given to the vault kv command
+func GetVaultPath(config *SecretStoreConfig, namespace string) string {
+	return config.VaultKVMount + "/" + config.VaultKVPrefix + "/" + namespace
+}
+
+func (s *VaultSecretStore) Get(name string) (*Credential, error) {
+	result := struct {
+		Data struct {
+			Data vaultCredential `json:"data"`
+		} `json:"data"`
+	}{}
+	err := s.request("GET", s.path("data", name), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+	return &Credential{
+		Name:     name,
+		Labels:   result.Data.Data.Labels,
+		Username: result.Data.Data.Username,
+		Password: result.Data.Data.Password,
+	}, nil
+}
+
+func (s *VaultSecretStore) Put(c *Credential) error {
+	labels := make(map[string]string)
+	existing, err := s.Get(c.Name)
+	if err == nil {
+		for k, v := range existing.Labels {
+			labels[k] = v
+		}
+	} else if err != ErrSecretNotFound {
+		return err
+	}
+	for k, v := range c.Labels {
+		labels[k] = v
+	}
+
+	body := map[string]interface{}{
+		"data": vaultCredential{Username: c.Username, Password: c.Password, Labels: labels},
+	}
+	return s.request("POST", s.path("data", c.Name), body, nil)
+}
+
+// Delete removes every version of a credential
+func (s *VaultSecretStore) Delete(name string) error {
+	_, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	return s.request("DELETE", s.path("metadata", name), nil, nil)
+}
+
+// List reads each credential of the namespace, Vault can not select
+// them by label
+func (s *VaultSecretStore) List(clusterName string) ([]Credential, error) {
+	credentials := []Credential{}
+
+	result := struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}{}
+	err := s.request("LIST", s.path("metadata", ""), nil, &result)
+	if err == ErrSecretNotFound {
+		return credentials, nil
+	} else if err != nil {
+		return credentials, err
+	}
+
+	for _, key := range result.Data.Keys {
+		if strings.HasSuffix(key, "/") {
+			continue
+		}
+		c, err := s.Get(key)
+		if err == ErrSecretNotFound {
+			continue
+		} else if err != nil {
+			return credentials, err
+		}
+		if c.Labels["pg-database"] == clusterName {
+			credentials = append(credentials, *c)
+		}
+	}
+	return credentials, nil
+}
+
+func (s *VaultSecretStore) path(kind, name string) string {
+	return "/v1/" + s.Config.VaultKVMount + "/" + kind + "/" + s.Config.VaultKVPrefix + "/" + s.Namespace + "/" + name
+}
+
+// request sends a request to Vault and decodes its response into
+// result, a 404 is returned as ErrSecretNotFound
+func (s *VaultSecretStore) request(method, path string, body interface{}, result interface{}) error {
+	token, err := s.token()
+	if err != nil {
+		return err
+	}
+	return vaultRequest(s.Config.VaultAddr, token, method, path, body, result)
+}
+
+// token returns the configured token, or logs in to Vault with the
+// Kubernetes auth method using the service account of the operator
+func (s *VaultSecretStore) token() (string, error) {
+	if s.Config.VaultToken != "" {
+		return s.Config.VaultToken, nil
+	}
+
+	vaultLogin.Lock()
+	defer vaultLogin.Unlock()
+	if vaultLogin.token != "" && time.Now().Before(vaultLogin.expires) {
+		return vaultLogin.token, nil
+	}
+
+	jwt, err := ioutil.ReadFile(SERVICE_ACCOUNT_TOKEN)
+	if err != nil {
+		return "", err
+	}
+	result := struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}{}
+	body := map[string]string{"role": s.Config.VaultRole, "jwt": string(jwt)}
+	err = vaultRequest(s.Config.VaultAddr, "", "POST", "/v1/auth/"+s.Config.VaultAuthPath+"/login", body, &result)
+	if err != nil {
+		return "", errors.New("vault login failed " + err.Error())
+	}
+
+	vaultLogin.token = result.Auth.ClientToken
+	vaultLogin.expires = time.Now().Add(time.Duration(result.Auth.LeaseDuration) * time.Second * 9 / 10)
+	return vaultLogin.token, nil
+}
+
+func vaultRequest(addr, token, method, path string, body interface{}, result interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&payload).Encode(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(addr, "/")+path, &payload)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := vaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrSecretNotFound
+	}
+	if resp.StatusCode >= 300 {
+		vaultErr := struct {
+			Errors []string `json:"errors"`
+		}{}
+		json.Unmarshal(data, &vaultErr)
+		return errors.New("vault " + method + " " + path + " returned " + resp.Status + " " + strings.Join(vaultErr.Errors, ", "))
+	}
+
+	if result == nil || len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,402 @@
+/*
+ Copyright 2017 Crunchy Data Solutions, Inc.
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+      http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testNamespace = "demo"
+
+// fakeVault serves the Kubernetes auth login and the KV version 2 API
+// of a secrets engine mounted at secret, keeping each secret by its
+// path under the mount
+type fakeVault struct {
+	sync.Mutex
+	jwt     string
+	token   string
+	logins  int
+	secrets map[string]map[string]interface{}
+}
+
+func newFakeVault() (*fakeVault, *httptest.Server) {
+	v := &fakeVault{
+		jwt:     "service-account-jwt",
+		token:   "s.operator",
+		secrets: make(map[string]map[string]interface{}),
+	}
+	return v, httptest.NewServer(v)
+}
+
+func (v *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.Lock()
+	defer v.Unlock()
+
+	if r.URL.Path == "/v1/auth/kubernetes/login" {
+		login := struct {
+			Role string `json:"role"`
+			JWT  string `json:"jwt"`
+		}{}
+		json.NewDecoder(r.Body).Decode(&login)
+		if r.Method != "POST" || login.Role != "operator" || login.JWT != v.jwt {
+			writeVault(w, http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		v.logins++
+		writeVault(w, http.StatusOK, map[string]interface{}{
+			"auth": map[string]interface{}{"client_token": v.token, "lease_duration": 3600},
+		})
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != v.token {
+		writeVault(w, http.StatusForbidden, map[string][]string{"errors": {"permission denied"}})
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/data/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/secret/data/")
+		switch r.Method {
+		case "GET":
+			data, ok := v.secrets[key]
+			if !ok {
+				writeVault(w, http.StatusNotFound, map[string][]string{"errors": {}})
+				return
+			}
+			writeVault(w, http.StatusOK, map[string]interface{}{
+				"data": map[string]interface{}{"data": data, "metadata": map[string]int{"version": 1}},
+			})
+		case "POST", "PUT":
+			body := struct {
+				Data map[string]interface{} `json:"data"`
+			}{}
+			json.NewDecoder(r.Body).Decode(&body)
+			v.secrets[key] = body.Data
+			writeVault(w, http.StatusOK, map[string]interface{}{"data": map[string]int{"version": 1}})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/secret/metadata/"):
+		key := strings.TrimPrefix(r.URL.Path, "/v1/secret/metadata/")
+		switch r.Method {
+		case "LIST":
+			keys := v.list(key)
+			if len(keys) == 0 {
+				writeVault(w, http.StatusNotFound, map[string][]string{"errors": {}})
+				return
+			}
+			writeVault(w, http.StatusOK, map[string]interface{}{"data": map[string][]string{"keys": keys}})
+		case "DELETE":
+			delete(v.secrets, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		writeVault(w, http.StatusNotFound, map[string][]string{"errors": {}})
+	}
+}
+
+// list returns the keys directly under a folder, nested folders with a
+// trailing slash as Vault does
+func (v *fakeVault) list(folder string) []string {
+	seen := make(map[string]bool)
+	keys := []string{}
+	for path := range v.secrets {
+		if !strings.HasPrefix(path, folder) {
+			continue
+		}
+		key := strings.TrimPrefix(path, folder)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeVault(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newVaultStore returns the store of testNamespace on a fake Vault,
+// logging in with the Kubernetes auth method when token is empty
+func newVaultStore(t *testing.T, addr, token string) *VaultSecretStore {
+	config := &SecretStoreConfig{
+		Backend:    SECRET_STORE_VAULT,
+		VaultAddr:  addr,
+		VaultToken: token,
+		VaultRole:  "operator",
+	}
+	config.SetDefaults()
+	store, err := NewSecretStore(nil, config, testNamespace)
+	if err != nil {
+		t.Fatalf("NewSecretStore: %v", err)
+	}
+	return store.(*VaultSecretStore)
+}
+
+// useServiceAccountToken writes a service account token for the login
+// and clears the token of an earlier login, the returned func restores
+// the token path
+func useServiceAccountToken(t *testing.T, jwt string) func() {
+	dir, err := ioutil.TempDir("", "vault-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "token")
+	err = ioutil.WriteFile(path, []byte(jwt), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := SERVICE_ACCOUNT_TOKEN
+	SERVICE_ACCOUNT_TOKEN = path
+	resetVaultLogin()
+	return func() {
+		SERVICE_ACCOUNT_TOKEN = previous
+		resetVaultLogin()
+		os.RemoveAll(dir)
+	}
+}
+
+func resetVaultLogin() {
+	vaultLogin.Lock()
+	vaultLogin.token = ""
+	vaultLogin.Unlock()
+}
+
+func TestVaultSecretStoreLogin(t *testing.T) {
+	vault, server := newFakeVault()
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		jwt     string
+		logins  int
+		wantErr bool
+	}{
+		{"service account token", vault.jwt, 1, false},
+		{"rejected token", "other-jwt", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer useServiceAccountToken(t, tt.jwt)()
+			vault.logins = 0
+			store := newVaultStore(t, server.URL, "")
+
+			//the token of the login is kept for the following requests
+			for i := 0; i < 2; i++ {
+				_, err := store.Get("mycluster-pgroot-secret")
+				if tt.wantErr {
+					if err == nil || !strings.Contains(err.Error(), "vault login failed") {
+						t.Fatalf("Get: got %v, want a failed login", err)
+					}
+				} else if err != ErrSecretNotFound {
+					t.Fatalf("Get: got %v, want %v", err, ErrSecretNotFound)
+				}
+			}
+			if vault.logins != tt.logins {
+				t.Errorf("logged in %d times, want %d", vault.logins, tt.logins)
+			}
+		})
+	}
+}
+
+func TestVaultSecretStoreToken(t *testing.T) {
+	vault, server := newFakeVault()
+	defer server.Close()
+	defer useServiceAccountToken(t, "")()
+
+	store := newVaultStore(t, server.URL, vault.token)
+	err := store.Put(&Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if vault.logins != 0 {
+		t.Errorf("logged in %d times with a configured token", vault.logins)
+	}
+
+	store = newVaultStore(t, server.URL, "s.wrong")
+	_, err = store.Get("mycluster-pgroot-secret")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Get with a wrong token: got %v, want a 403", err)
+	}
+}
+
+func TestVaultSecretStorePutGet(t *testing.T) {
+	vault, server := newFakeVault()
+	defer server.Close()
+	store := newVaultStore(t, server.URL, vault.token)
+
+	tests := []struct {
+		name string
+		put  Credential
+		want Credential
+	}{
+		{
+			name: "create",
+			put: Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "first",
+				Labels: map[string]string{"pg-database": "mycluster"}},
+			want: Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "first",
+				Labels: map[string]string{"pg-database": "mycluster"}},
+		},
+		{
+			name: "replace keeps labels",
+			put: Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "second",
+				Labels: map[string]string{"pg-secret-consumer": "app"}},
+			want: Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "second",
+				Labels: map[string]string{"pg-database": "mycluster", "pg-secret-consumer": "app"}},
+		},
+		{
+			name: "quotes and backslashes",
+			put:  Credential{Name: "mycluster-app-secret", Username: "app", Password: `a"b\c'd`},
+			want: Credential{Name: "mycluster-app-secret", Username: "app", Password: `a"b\c'd`, Labels: map[string]string{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.Put(&tt.put)
+			if err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+			got, err := store.Get(tt.put.Name)
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("Get: got %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	//the credential is kept under the prefix and namespace of the store
+	data, ok := vault.secrets["postgres-operator/"+testNamespace+"/mycluster-pgroot-secret"]
+	if !ok {
+		t.Fatalf("no secret at postgres-operator/%s/mycluster-pgroot-secret, have %v", testNamespace, vault.secrets)
+	}
+	if data["username"] != "postgres" || data["password"] != "second" {
+		t.Errorf("stored %v", data)
+	}
+
+	_, err := store.Get("missing")
+	if err != ErrSecretNotFound {
+		t.Errorf("Get missing: got %v, want %v", err, ErrSecretNotFound)
+	}
+}
+
+func TestVaultSecretStoreList(t *testing.T) {
+	vault, server := newFakeVault()
+	defer server.Close()
+	store := newVaultStore(t, server.URL, vault.token)
+
+	credentials, err := store.List("mycluster")
+	if err != nil || len(credentials) != 0 {
+		t.Fatalf("List of an empty namespace: got %v %v, want no credentials", credentials, err)
+	}
+
+	for _, c := range []Credential{
+		{Name: "mycluster-pgroot-secret", Labels: map[string]string{"pg-database": "mycluster"}},
+		{Name: "mycluster-pguser-secret", Labels: map[string]string{"pg-database": "mycluster"}},
+		{Name: "other-pgroot-secret", Labels: map[string]string{"pg-database": "other"}},
+		{Name: "unlabelled"},
+	} {
+		err = store.Put(&c)
+		if err != nil {
+			t.Fatalf("Put %s: %v", c.Name, err)
+		}
+	}
+	//a nested folder is not a credential of the namespace
+	vault.secrets["postgres-operator/"+testNamespace+"/nested/mycluster-pgmaster-secret"] = map[string]interface{}{
+		"labels": map[string]string{"pg-database": "mycluster"},
+	}
+
+	tests := []struct {
+		cluster string
+		want    []string
+	}{
+		{"mycluster", []string{"mycluster-pgroot-secret", "mycluster-pguser-secret"}},
+		{"other", []string{"other-pgroot-secret"}},
+		{"none", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cluster, func(t *testing.T) {
+			credentials, err := store.List(tt.cluster)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			got := []string{}
+			for _, c := range credentials {
+				got = append(got, c.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("List: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVaultSecretStoreDelete(t *testing.T) {
+	vault, server := newFakeVault()
+	defer server.Close()
+	store := newVaultStore(t, server.URL, vault.token)
+
+	err := store.Put(&Credential{Name: "mycluster-pgroot-secret", Username: "postgres", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want error
+	}{
+		{"mycluster-pgroot-secret", nil},
+		{"mycluster-pgroot-secret", ErrSecretNotFound},
+		{"missing", ErrSecretNotFound},
+	}
+	for _, tt := range tests {
+		err = store.Delete(tt.name)
+		if err != tt.want {
+			t.Errorf("Delete %s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	_, err = store.Get("mycluster-pgroot-secret")
+	if err != ErrSecretNotFound {
+		t.Errorf("Get deleted: got %v, want %v", err, ErrSecretNotFound)
+	}
+	if len(vault.secrets) != 0 {
+		t.Errorf("secrets left in vault %v", vault.secrets)
+	}
+}